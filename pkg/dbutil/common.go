@@ -73,6 +73,9 @@ type DBConfig struct {
 	Schema string `toml:"schema" json:"schema"`
 
 	Snapshot string `toml:"snapshot" json:"snapshot"`
+
+	// TLSName is the name of the TLS config registered by mysql.RegisterTLSConfig, TLS isn't used if it's empty.
+	TLSName string `toml:"-" json:"-"`
 }
 
 // String returns native format of database configuration
@@ -119,6 +122,10 @@ func OpenDB(cfg DBConfig, vars map[string]string) (*sql.DB, error) {
 		dbDSN = fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8mb4", cfg.User, cfg.Password, cfg.Host, cfg.Port)
 	}
 
+	if len(cfg.TLSName) != 0 {
+		dbDSN += fmt.Sprintf("&tls=%s", cfg.TLSName)
+	}
+
 	for key, val := range vars {
 		// key='val'. add single quote for better compatibility.
 		dbDSN += fmt.Sprintf("&%s=%%27%s%%27", key, url.QueryEscape(val))
@@ -17,14 +17,12 @@ import (
 	"container/heap"
 	"context"
 	"encoding/json"
-	"os"
 	"sync"
+	"time"
 
-	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
 
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
-	"github.com/siddontang/go/ioutil2"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -121,6 +119,23 @@ type nodeHeap struct {
 // latest previous exit point (due to error or intention).
 type Checkpoint struct {
 	hp *nodeHeap
+
+	// repaired records the chunks whose fix sql has been applied to the target,
+	// it maps the chunk id to the meta of chunk range and is protected by hp.mu.
+	repaired map[string]string
+	// failed records the chunks that are still different after checking, they are protected by hp.mu.
+	failed []*Node
+	// beginTime, snapshots and tableOrder are saved with the chunk, they are set before the check starts.
+	beginTime  time.Time
+	snapshots  *Snapshots
+	tableOrder []string
+}
+
+// Snapshots are the snapshots of the source instances and the target instance resolved by waiting for
+// the consistency, the check resumed from the checkpoint reuses them instead of waiting again.
+type Snapshots struct {
+	Source []string `json:"source"`
+	Target string   `json:"target"`
 }
 
 // SaveState contains the information of the latest checked chunk and state of `report`
@@ -128,6 +143,21 @@ type Checkpoint struct {
 type SavedState struct {
 	Chunk  *Node          `json:"chunk-info"`
 	Report *report.Report `json:"report-info"`
+	// Repaired records the repaired chunks after `Chunk`, so that
+	// the repair process won't apply the fix sql twice after restarting.
+	Repaired map[string]string `json:"repaired-chunks,omitempty"`
+	// Snapshots are not part of the config hash identifying the checkpoint, since they change every time
+	// the consistency is waited for.
+	Snapshots *Snapshots `json:"snapshots,omitempty"`
+	// TableOrder is the unique IDs of the tables in the order they are checked, the chunks are identified by the
+	// indices of the tables in it, so the check resumed from the checkpoint checks the tables in the same order.
+	TableOrder []string `json:"table-order,omitempty"`
+	// FailedChunks records the failed chunks before `Chunk`, they won't be checked again after restarting,
+	// so they are kept for the recheck state.
+	FailedChunks []*Node `json:"failed-chunks,omitempty"`
+	// BeginTime is the time the check begins before restarting, the rows updated after it will be checked
+	// again in the next recheck.
+	BeginTime time.Time `json:"begin-time"`
 }
 
 // InitCurrentSavedID the method is only used in initialization without lock, be cautious
@@ -189,6 +219,94 @@ func (cp *Checkpoint) Init() {
 	}
 	heap.Init(hp)
 	cp.hp = hp
+	cp.repaired = make(map[string]string)
+}
+
+// MarkRepaired records that the fix sql of the chunk has been applied to the target.
+func (cp *Checkpoint) MarkRepaired(n *Node) {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	cp.repaired[n.GetID().ToString()] = n.ChunkRange.ToMeta()
+}
+
+// SetSnapshots sets the snapshots saved with the chunks.
+func (cp *Checkpoint) SetSnapshots(snapshots *Snapshots) {
+	cp.snapshots = snapshots
+}
+
+// SetTableOrder sets the unique IDs of the tables in the order they are checked.
+func (cp *Checkpoint) SetTableOrder(tableOrder []string) {
+	cp.tableOrder = tableOrder
+}
+
+// SetBeginTime sets the time the check begins, it is saved with the chunks.
+func (cp *Checkpoint) SetBeginTime(beginTime time.Time) {
+	cp.beginTime = beginTime
+}
+
+// GetBeginTime returns the time the check begins, it is loaded
+// from the checkpoint if the check resumes from it.
+func (cp *Checkpoint) GetBeginTime() time.Time {
+	return cp.beginTime
+}
+
+// AddFailedChunk records the chunk that is still different after checking.
+func (cp *Checkpoint) AddFailedChunk(n *Node) {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	cp.failed = append(cp.failed, n)
+}
+
+// GetFailedChunks returns the failed chunks of this process and the process before restarting.
+func (cp *Checkpoint) GetFailedChunks() []*Node {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	return append([]*Node(nil), cp.failed...)
+}
+
+// getFailedSnapshot returns the failed chunks before the node `cur`,
+// the chunks after `cur` will be checked again after restarting.
+func (cp *Checkpoint) getFailedSnapshot(cur *Node) []*Node {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	var failed []*Node
+	for _, n := range cp.failed {
+		if n.GetID().Compare(cur.GetID()) <= 0 {
+			failed = append(failed, n)
+		}
+	}
+	return failed
+}
+
+// IsRepaired returns true if the fix sql of the same chunk has been applied
+// in this process or the process before restarting.
+func (cp *Checkpoint) IsRepaired(n *Node) bool {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	meta, ok := cp.repaired[n.GetID().ToString()]
+	return ok && meta == n.ChunkRange.ToMeta()
+}
+
+// getRepairedSnapshot returns the repaired chunks after the node `cur`,
+// the chunks before `cur` will never be checked again.
+func (cp *Checkpoint) getRepairedSnapshot(cur *Node) map[string]string {
+	cp.hp.mu.Lock()
+	defer cp.hp.mu.Unlock()
+	if len(cp.repaired) == 0 {
+		return nil
+	}
+	repaired := make(map[string]string)
+	for id, meta := range cp.repaired {
+		chunkID := new(chunk.ChunkID)
+		if err := chunkID.FromString(id); err != nil {
+			log.Warn("fail to parse the repaired chunk id", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		if chunkID.Compare(cur.GetID()) > 0 {
+			repaired[id] = meta
+		}
+	}
+	return repaired
 }
 
 // GetChunkSnapshot get the snapshot of the minimum continuous checked chunk
@@ -203,24 +321,29 @@ func (cp *Checkpoint) GetChunkSnapshot() (cur *Node) {
 	return cur
 }
 
-// SaveChunk saves the chunk to file.
-func (cp *Checkpoint) SaveChunk(ctx context.Context, fileName string, cur *Node, reportInfo *report.Report) (*chunk.ChunkID, error) {
+// SaveChunk saves the chunk to the storage with the name.
+func (cp *Checkpoint) SaveChunk(ctx context.Context, storage Storage, name string, cur *Node, reportInfo *report.Report) (*chunk.ChunkID, error) {
 	if cur == nil {
 		return nil, nil
 	}
 
 	savedState := &SavedState{
-		Chunk:  cur,
-		Report: reportInfo,
+		Chunk:        cur,
+		Report:       reportInfo,
+		Repaired:     cp.getRepairedSnapshot(cur),
+		Snapshots:    cp.snapshots,
+		TableOrder:   cp.tableOrder,
+		FailedChunks: cp.getFailedSnapshot(cur),
+		BeginTime:    cp.beginTime,
 	}
 	checkpointData, err := json.Marshal(savedState)
 	if err != nil {
-		log.Warn("fail to save the chunk to the file", zap.Any("chunk index", cur.GetID()), zap.Error(err))
+		log.Warn("fail to save the chunk to the storage", zap.Any("chunk index", cur.GetID()), zap.Error(err))
 		return nil, errors.Trace(err)
 	}
 
-	if err = ioutil2.WriteFileAtomic(fileName, checkpointData, config.LocalFilePerm); err != nil {
-		return nil, err
+	if err = storage.Save(ctx, name, checkpointData); err != nil {
+		return nil, errors.Trace(err)
 	}
 	log.Info("save checkpoint",
 		zap.Any("chunk", cur),
@@ -228,16 +351,98 @@ func (cp *Checkpoint) SaveChunk(ctx context.Context, fileName string, cur *Node,
 	return cur.GetID(), nil
 }
 
-// LoadChunk loads chunk info from file `chunk`
-func (cp *Checkpoint) LoadChunk(fileName string) (*Node, *report.Report, error) {
-	bytes, err := os.ReadFile(fileName)
+// LoadChunk loads chunk info from the storage with the name,
+// it returns a NotFound error if there is no checkpoint.
+func (cp *Checkpoint) LoadChunk(ctx context.Context, storage Storage, name string) (*Node, *report.Report, error) {
+	n, err := LoadSavedState(ctx, storage, name)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	if cp.hp != nil {
+		cp.hp.mu.Lock()
+		for id, meta := range n.Repaired {
+			cp.repaired[id] = meta
+		}
+		cp.failed = append(cp.failed, n.FailedChunks...)
+		cp.hp.mu.Unlock()
+	}
+	cp.beginTime = n.BeginTime
+	return n.Chunk, n.Report, nil
+}
+
+// LoadSavedState loads the saved state from the storage with the name,
+// it returns a NotFound error if there is no checkpoint.
+func LoadSavedState(ctx context.Context, storage Storage, name string) (*SavedState, error) {
+	bytes, err := storage.Load(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	n := &SavedState{}
-	err = json.Unmarshal(bytes, n)
+	if err = json.Unmarshal(bytes, n); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return n, nil
+}
+
+// RecheckState is saved after each data check, the recheck mode
+// only checks the failed chunks and the rows updated since the last check.
+type RecheckState struct {
+	FailedChunks []*Node `json:"failed-chunks"`
+	// CheckTime is the begin time of the last check for each table,
+	// the rows updated after it need to be checked again.
+	CheckTime map[string]time.Time `json:"check-time"`
+	// TableOrder is the unique IDs of the tables in the order of the last check, the failed chunks
+	// are identified by the indices of the tables in it.
+	TableOrder []string `json:"table-order,omitempty"`
+}
+
+// SaveRecheckState saves the recheck state to the storage with the name.
+func SaveRecheckState(ctx context.Context, storage Storage, name string, state *RecheckState) error {
+	data, err := json.Marshal(state)
 	if err != nil {
-		return nil, nil, errors.Trace(err)
+		return errors.Trace(err)
 	}
-	return n.Chunk, n.Report, nil
+	return errors.Trace(storage.Save(ctx, name, data))
+}
+
+// LoadRecheckState loads the recheck state from the storage with the name,
+// it returns a NotFound error if there is no recheck state.
+func LoadRecheckState(ctx context.Context, storage Storage, name string) (*RecheckState, error) {
+	bytes, err := storage.Load(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	state := &RecheckState{}
+	if err = json.Unmarshal(bytes, state); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return state, nil
+}
+
+// LoadHistory loads the check history from the storage with the name, it returns an empty history if there is none.
+func LoadHistory(ctx context.Context, storage Storage, name string) (*report.History, error) {
+	history := &report.History{Tables: make(map[string]time.Time)}
+	bytes, err := storage.Load(ctx, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return history, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(bytes, history); err != nil {
+		return nil, errors.Annotatef(err, "parse %s", name)
+	}
+	if history.Tables == nil {
+		history.Tables = make(map[string]time.Time)
+	}
+	return history, nil
+}
+
+// SaveHistory saves the check history to the storage with the name.
+func SaveHistory(ctx context.Context, storage Storage, name string, history *report.History) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(storage.Save(ctx, name, data))
 }
@@ -15,6 +15,7 @@ package checkpoints
 
 import (
 	"context"
+	"database/sql/driver"
 	"math/rand"
 	"os"
 	"strconv"
@@ -22,6 +23,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
 	"github.com/stretchr/testify/require"
 )
@@ -31,7 +34,8 @@ func TestSaveChunk(t *testing.T) {
 	checker.Init()
 	ctx := context.Background()
 	cur := checker.GetChunkSnapshot()
-	id, err := checker.SaveChunk(ctx, "TestSaveChunk", cur, nil)
+	storage := NewFileStorage(".")
+	id, err := checker.SaveChunk(ctx, storage, "TestSaveChunk", cur, nil)
 	require.NoError(t, err)
 	require.Nil(t, id)
 	wg := &sync.WaitGroup{}
@@ -72,7 +76,7 @@ func TestSaveChunk(t *testing.T) {
 
 	cur = checker.GetChunkSnapshot()
 	require.NotNil(t, cur)
-	id, err = checker.SaveChunk(ctx, "TestSaveChunk", cur, nil)
+	id, err = checker.SaveChunk(ctx, storage, "TestSaveChunk", cur, nil)
 	require.NoError(t, err)
 	require.Equal(t, id.Compare(&chunk.ChunkID{TableIndex: 0, BucketIndexLeft: 9, BucketIndexRight: 9, ChunkIndex: 9}), 0)
 }
@@ -112,9 +116,184 @@ func TestLoadChunk(t *testing.T) {
 	wg.Wait()
 	defer os.Remove("TestLoadChunk")
 	cur := checker.GetChunkSnapshot()
-	id, err := checker.SaveChunk(ctx, "TestLoadChunk", cur, nil)
+	id, err := checker.SaveChunk(ctx, NewFileStorage("."), "TestLoadChunk", cur, nil)
 	require.NoError(t, err)
-	node, _, err := checker.LoadChunk("TestLoadChunk")
+	node, _, err := checker.LoadChunk(ctx, NewFileStorage("."), "TestLoadChunk")
 	require.NoError(t, err)
 	require.Equal(t, node.GetID().Compare(id), 0)
 }
+
+func TestRepairedChunks(t *testing.T) {
+	checker := new(Checkpoint)
+	checker.Init()
+	ctx := context.Background()
+	nodes := make([]*Node, 0, 3)
+	for i := 0; i < 3; i++ {
+		node := &Node{
+			ChunkRange: &chunk.Range{
+				Index: &chunk.ChunkID{
+					TableIndex:       0,
+					BucketIndexLeft:  0,
+					BucketIndexRight: 0,
+					ChunkIndex:       i,
+					ChunkCnt:         3,
+				},
+				Bounds: []*chunk.Bound{
+					{
+						Column:   "a",
+						HasLower: i != 0,
+						Lower:    strconv.Itoa(i * 10),
+						Upper:    strconv.Itoa(i*10 + 10),
+						HasUpper: i != 2,
+					},
+				},
+			},
+			State: SuccessState,
+		}
+		nodes = append(nodes, node)
+		checker.MarkRepaired(node)
+	}
+	require.True(t, checker.IsRepaired(nodes[1]))
+
+	defer os.Remove("TestRepairedChunks")
+	_, err := checker.SaveChunk(ctx, NewFileStorage("."), "TestRepairedChunks", nodes[0], nil)
+	require.NoError(t, err)
+
+	newChecker := new(Checkpoint)
+	newChecker.Init()
+	node, _, err := newChecker.LoadChunk(ctx, NewFileStorage("."), "TestRepairedChunks")
+	require.NoError(t, err)
+	require.Equal(t, node.GetID().Compare(nodes[0].GetID()), 0)
+	// the chunks before checkpoint will never be checked again
+	require.False(t, newChecker.IsRepaired(nodes[0]))
+	require.True(t, newChecker.IsRepaired(nodes[1]))
+	require.True(t, newChecker.IsRepaired(nodes[2]))
+
+	// the chunk has the same id but different range
+	nodes[2].ChunkRange.Bounds[0].Lower = "25"
+	require.False(t, newChecker.IsRepaired(nodes[2]))
+}
+
+func TestFailedChunks(t *testing.T) {
+	checker := new(Checkpoint)
+	checker.Init()
+	ctx := context.Background()
+	nodes := make([]*Node, 0, 3)
+	for i := 0; i < 3; i++ {
+		node := &Node{
+			ChunkRange: &chunk.Range{
+				Index: &chunk.ChunkID{
+					TableIndex:       0,
+					BucketIndexLeft:  0,
+					BucketIndexRight: 0,
+					ChunkIndex:       i,
+					ChunkCnt:         3,
+				},
+			},
+			State: FailedState,
+		}
+		nodes = append(nodes, node)
+	}
+	checker.AddFailedChunk(nodes[0])
+	checker.AddFailedChunk(nodes[2])
+	beginTime := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	checker.SetBeginTime(beginTime)
+
+	defer os.Remove("TestFailedChunks")
+	_, err := checker.SaveChunk(ctx, NewFileStorage("."), "TestFailedChunks", nodes[1], nil)
+	require.NoError(t, err)
+
+	newChecker := new(Checkpoint)
+	newChecker.Init()
+	_, _, err = newChecker.LoadChunk(ctx, NewFileStorage("."), "TestFailedChunks")
+	require.NoError(t, err)
+	// the chunks after checkpoint will be checked again
+	failed := newChecker.GetFailedChunks()
+	require.Len(t, failed, 1)
+	require.Equal(t, 0, failed[0].GetID().Compare(nodes[0].GetID()))
+	require.True(t, beginTime.Equal(newChecker.GetBeginTime()))
+}
+
+func TestRecheckState(t *testing.T) {
+	ctx := context.Background()
+	checkTime := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	state := &RecheckState{
+		FailedChunks: []*Node{
+			{
+				ChunkRange: &chunk.Range{
+					Index: &chunk.ChunkID{
+						TableIndex:       1,
+						BucketIndexLeft:  2,
+						BucketIndexRight: 2,
+						ChunkIndex:       0,
+						ChunkCnt:         1,
+					},
+					Bounds: []*chunk.Bound{
+						{
+							Column:   "a",
+							HasLower: true,
+							Lower:    "10",
+							Upper:    "20",
+							HasUpper: true,
+						},
+					},
+				},
+				State: FailedState,
+			},
+		},
+		CheckTime: map[string]time.Time{"`test`.`t`": checkTime},
+	}
+	defer os.Remove("TestRecheckState")
+	require.NoError(t, SaveRecheckState(ctx, NewFileStorage("."), "TestRecheckState", state))
+
+	newState, err := LoadRecheckState(ctx, NewFileStorage("."), "TestRecheckState")
+	require.NoError(t, err)
+	require.Len(t, newState.FailedChunks, 1)
+	require.Equal(t, newState.FailedChunks[0].GetID().Compare(state.FailedChunks[0].GetID()), 0)
+	require.Equal(t, newState.FailedChunks[0].ChunkRange.ToMeta(), state.FailedChunks[0].ChunkRange.ToMeta())
+	require.True(t, newState.CheckTime["`test`.`t`"].Equal(checkTime))
+
+	_, err = LoadRecheckState(ctx, NewFileStorage("."), "TestRecheckStateNotExist")
+	require.True(t, errors.IsNotFound(err))
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileStorage := NewFileStorage(dir)
+	_, err := fileStorage.Load(ctx, "TestStorage")
+	require.True(t, errors.IsNotFound(err))
+	require.NoError(t, fileStorage.Save(ctx, "TestStorage", []byte("data")))
+	data, err := fileStorage.Load(ctx, "TestStorage")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+	require.NoError(t, fileStorage.Remove(ctx, "TestStorage"))
+	_, err = fileStorage.Load(ctx, "TestStorage")
+	require.True(t, errors.IsNotFound(err))
+	// remove the data not exists
+	require.NoError(t, fileStorage.Remove(ctx, "TestStorage"))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec("CREATE DATABASE IF NOT EXISTS `sync_diff_inspector`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS `sync_diff_inspector`.`checkpoint`").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbStorage, err := NewDBStorage(ctx, db, "sync_diff_inspector", "checkpoint", "hash")
+	require.NoError(t, err)
+	mock.ExpectQuery("SELECT data FROM `sync_diff_inspector`.`checkpoint` WHERE task = \\? AND name = \\?").
+		WithArgs("hash", "TestStorage").WillReturnRows(sqlmock.NewRows([]string{"data"}))
+	_, err = dbStorage.Load(ctx, "TestStorage")
+	require.True(t, errors.IsNotFound(err))
+	mock.ExpectExec("REPLACE INTO `sync_diff_inspector`.`checkpoint`").
+		WithArgs("hash", "TestStorage", []byte("data")).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, dbStorage.Save(ctx, "TestStorage", []byte("data")))
+	mock.ExpectQuery("SELECT data FROM `sync_diff_inspector`.`checkpoint`").
+		WithArgs("hash", "TestStorage").WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("data")))
+	data, err = dbStorage.Load(ctx, "TestStorage")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+	mock.ExpectExec("DELETE FROM `sync_diff_inspector`.`checkpoint`").
+		WithArgs("hash", "TestStorage").WillReturnResult(driver.RowsAffected(1))
+	require.NoError(t, dbStorage.Remove(ctx, "TestStorage"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/pkg/etcd"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/siddontang/go/ioutil2"
+)
+
+const (
+	defaultEtcdRoot = "/sync_diff_inspector/checkpoint"
+	etcdDialTimeout = 5 * time.Second
+)
+
+// Storage saves the checkpoint data by name.
+type Storage interface {
+	// Load returns a NotFound error if the data doesn't exist.
+	Load(ctx context.Context, name string) ([]byte, error)
+	Save(ctx context.Context, name string, data []byte) error
+	Remove(ctx context.Context, name string) error
+	Close() error
+}
+
+// NewStorage returns the storage of the checkpoint, targetDB is used by the db storage.
+// The checkpoints in the db storage and the etcd storage are identified by the config hash
+// of the task, so that the same check resumes on any machine.
+func NewStorage(ctx context.Context, cfg *config.Config, targetDB *sql.DB) (Storage, error) {
+	storageCfg := cfg.CheckpointStorage
+	if storageCfg == nil || storageCfg.Type == "" || storageCfg.Type == config.CheckpointStorageFile {
+		return NewFileStorage(cfg.Task.CheckpointDir), nil
+	}
+	hash, err := cfg.Task.ComputeConfigHash()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch storageCfg.Type {
+	case config.CheckpointStorageDB:
+		schema, table, _ := cfg.CheckpointTable()
+		return NewDBStorage(ctx, targetDB, schema, table, hash)
+	case config.CheckpointStorageEtcd:
+		root := storageCfg.EtcdRoot
+		if len(root) == 0 {
+			root = defaultEtcdRoot
+		}
+		cli, err := etcd.NewClientFromCfg(storageCfg.EtcdEndpoints, etcdDialTimeout, path.Join(root, hash), nil)
+		if err != nil {
+			return nil, errors.Annotate(err, "fail to connect etcd")
+		}
+		return NewEtcdStorage(cli), nil
+	default:
+		return nil, errors.NotSupportedf("checkpoint storage %s", storageCfg.Type)
+	}
+}
+
+// FileStorage saves the data in the files of a local dir.
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage returns a storage saving the data in the dir.
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{dir: dir}
+}
+
+// Load implements Storage interface.
+func (s *FileStorage) Load(_ context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("file %s", filepath.Join(s.dir, name))
+	}
+	return data, errors.Trace(err)
+}
+
+// Save implements Storage interface.
+func (s *FileStorage) Save(_ context.Context, name string, data []byte) error {
+	return errors.Trace(ioutil2.WriteFileAtomic(filepath.Join(s.dir, name), data, config.LocalFilePerm))
+}
+
+// Remove implements Storage interface.
+func (s *FileStorage) Remove(_ context.Context, name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close implements Storage interface.
+func (s *FileStorage) Close() error { return nil }
+
+// DBStorage saves the data in a table, the rows are identified by the task and the name.
+type DBStorage struct {
+	db        *sql.DB
+	tableName string
+	task      string
+}
+
+// NewDBStorage creates the table if not exists and returns a storage saving the data of the task in it.
+func NewDBStorage(ctx context.Context, db *sql.DB, schema, table, task string) (*DBStorage, error) {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbutil.ColumnName(schema))); err != nil {
+		return nil, errors.Annotatef(err, "fail to create the checkpoint schema %s", schema)
+	}
+	tableName := dbutil.TableName(schema, table)
+	createTable := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	task VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	data LONGBLOB NOT NULL,
+	update_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (task, name)
+)`, tableName)
+	if _, err := db.ExecContext(ctx, createTable); err != nil {
+		return nil, errors.Annotatef(err, "fail to create the checkpoint table %s", tableName)
+	}
+	return &DBStorage{db: db, tableName: tableName, task: task}, nil
+}
+
+// Load implements Storage interface.
+func (s *DBStorage) Load(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT data FROM %s WHERE task = ? AND name = ?", s.tableName), s.task, name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errors.NotFoundf("%s of task %s in %s", name, s.task, s.tableName)
+	}
+	return data, errors.Trace(err)
+}
+
+// Save implements Storage interface.
+func (s *DBStorage) Save(ctx context.Context, name string, data []byte) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("REPLACE INTO %s (task, name, data) VALUES (?, ?, ?)", s.tableName), s.task, name, data)
+	return errors.Trace(err)
+}
+
+// Remove implements Storage interface.
+func (s *DBStorage) Remove(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE task = ? AND name = ?", s.tableName), s.task, name)
+	return errors.Trace(err)
+}
+
+// Close implements Storage interface, the db is closed by its owner.
+func (s *DBStorage) Close() error { return nil }
+
+// EtcdStorage saves the data in the keys under the root of the etcd client.
+type EtcdStorage struct {
+	cli *etcd.Client
+}
+
+// NewEtcdStorage returns a storage saving the data in etcd, it closes the client when closing.
+func NewEtcdStorage(cli *etcd.Client) *EtcdStorage {
+	return &EtcdStorage{cli: cli}
+}
+
+// Load implements Storage interface.
+func (s *EtcdStorage) Load(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := s.cli.Get(ctx, name)
+	return data, errors.Trace(err)
+}
+
+// Save implements Storage interface.
+func (s *EtcdStorage) Save(ctx context.Context, name string, data []byte) error {
+	return errors.Trace(s.cli.UpdateOrCreate(ctx, name, string(data), 0))
+}
+
+// Remove implements Storage interface.
+func (s *EtcdStorage) Remove(ctx context.Context, name string) error {
+	return errors.Trace(s.cli.Delete(ctx, name, false))
+}
+
+// Close implements Storage interface.
+func (s *EtcdStorage) Close() error {
+	return errors.Trace(s.cli.Close())
+}
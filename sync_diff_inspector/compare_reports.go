@@ -0,0 +1,103 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
+	flag "github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
+)
+
+// compareReportsCommand is the subcommand comparing the results of two checks.
+const compareReportsCommand = "compare-reports"
+
+// runCompareReports compares the reports in the output dirs of two checks and returns the exit code,
+// which is 1 if some tables are newly broken in the new check.
+func runCompareReports(args []string) int {
+	fs := flag.NewFlagSet(compareReportsCommand, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sync_diff_inspector %s [flags] <old-output-dir> <new-output-dir>\n", compareReportsCommand)
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "text", "the format of the delta report, text or json")
+	output := fs.String("output", "", "the file to write the delta report, print to stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		if errors.Cause(err) == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 2 || (*format != "text" && *format != config.ReportFormatJSON) {
+		fs.Usage()
+		return 2
+	}
+
+	// the delta report may be printed to stdout, so print the logs to stderr.
+	lg, p, err := log.InitLoggerWithWriteSyncer(&log.Config{Level: "warn"}, zapcore.AddSync(os.Stderr))
+	if err == nil {
+		log.ReplaceGlobals(lg, p)
+	}
+
+	delta, err := compareReports(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*output) != 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			return 2
+		}
+		defer file.Close()
+		w = file
+	}
+	if *format == config.ReportFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(delta)
+	} else {
+		err = delta.Print(w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return 2
+	}
+	if len(delta.NewlyBroken) != 0 {
+		return 1
+	}
+	return 0
+}
+
+func compareReports(oldDir, newDir string) (*report.ReportDelta, error) {
+	oldReport, err := report.LoadJSONReport(oldDir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "load the old report")
+	}
+	newReport, err := report.LoadJSONReport(newDir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "load the new report")
+	}
+	return report.CompareJSONReports(oldReport, newReport), nil
+}
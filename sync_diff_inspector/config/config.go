@@ -22,13 +22,16 @@ import (
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	column "github.com/pingcap/tidb-tools/pkg/column-mapping"
 	"github.com/pingcap/tidb-tools/pkg/dbutil"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	router "github.com/pingcap/tidb-tools/pkg/table-router"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/pingcap/tidb/parser/model"
 	flag "github.com/spf13/pflag"
 	"go.uber.org/zap"
@@ -39,6 +42,16 @@ const (
 	LocalFilePerm os.FileMode = 0o644
 
 	LogFileName = "sync_diff.log"
+
+	// ReportFormatJSON writes the report in json to the output dir.
+	ReportFormatJSON = "json"
+	// ReportFormatJUnit writes the report in junit xml to the output dir.
+	ReportFormatJUnit = "junit"
+
+	// DiffRowsFormatCSV writes the different rows in csv, one line for each different column.
+	DiffRowsFormatCSV = "csv"
+	// DiffRowsFormatJSON writes the different rows in json lines, one line for each different row.
+	DiffRowsFormatJSON = "json"
 )
 
 // TableConfig is the config of table.
@@ -54,19 +67,71 @@ type TableConfig struct {
 	HasMatched bool
 
 	// columns be ignored, will not check this column's data
-	IgnoreColumns []string `toml:"ignore-columns"`
+	IgnoreColumns []string `toml:"ignore-columns" json:"ignore-columns"`
 	// field should be the primary key, unique key or field with index
-	Fields []string `toml:"index-fields"`
+	Fields []string `toml:"index-fields" json:"index-fields"`
 	// select range, for example: "age > 10 AND age < 20"
-	Range string `toml:"range"`
+	Range string `toml:"range" json:"range"`
 
 	TargetTableInfo *model.TableInfo
 
 	// collation config in mysql/tidb
-	Collation string `toml:"collation"`
+	Collation string `toml:"collation" json:"collation"`
 
 	// specify the chunksize for the table
 	ChunkSize int64 `toml:"chunk-size" json:"chunk-size"`
+
+	// the column records the update time of the row, for example "updated_at".
+	// The recheck mode only checks the rows updated since the last check of the table.
+	UpdateTimeColumn string `toml:"update-time-column" json:"update-time-column,omitempty"`
+
+	// the sql expressions applied to the upstream columns before comparing, for example
+	// {name = "TRIM(name)"}, so that the intentional transformations won't be reported.
+	ColumnTransforms map[string]string `toml:"column-transforms" json:"column-transforms,omitempty"`
+	// the column mapping rules of DM applied to the upstream columns before comparing,
+	// the schema and table patterns match the upstream tables.
+	ColumnMappings []*column.Rule `toml:"column-mappings" json:"column-mappings,omitempty"`
+
+	// the tolerances of the numeric columns, the values within the tolerance are considered equal.
+	Tolerances []*ToleranceConfig `toml:"tolerances" json:"tolerances,omitempty"`
+
+	// the strategy to locate the different rows in a chunk whose checksum is different, see LocateStrategyBinarySearch
+	// and LocateStrategyGroupedChecksum, default is "binary-search".
+	LocateStrategy string `toml:"locate-strategy" json:"locate-strategy,omitempty"`
+
+	// the algorithm to calculate the checksum of the chunks, see utils.ChecksumCRC32, utils.ChecksumHash64
+	// and utils.ChecksumInProcess, default is "crc32".
+	ChecksumAlgorithm string `toml:"checksum-algorithm" json:"checksum-algorithm,omitempty"`
+
+	// the priority of the table, the tables with higher priority are checked first, default is 0.
+	Priority int `toml:"priority" json:"priority,omitempty"`
+
+	// the condition of the rows excluded from the comparison, for example "deleted_at IS NOT NULL" for the
+	// soft-deleted rows. `upstream-exclude` and `downstream-exclude` only exclude the rows from one side.
+	Exclude           string `toml:"exclude" json:"exclude,omitempty"`
+	UpstreamExclude   string `toml:"upstream-exclude" json:"upstream-exclude,omitempty"`
+	DownstreamExclude string `toml:"downstream-exclude" json:"downstream-exclude,omitempty"`
+}
+
+// the strategies to locate the different rows in a chunk.
+const (
+	// LocateStrategyBinarySearch splits the chunk into halves by the index and checks them recursively.
+	LocateStrategyBinarySearch = "binary-search"
+	// LocateStrategyGroupedChecksum hashes the rows into buckets by the key and checks the checksums of
+	// all the buckets in one query per level, then only checks the different buckets in the next level.
+	LocateStrategyGroupedChecksum = "grouped-checksum"
+)
+
+// ToleranceConfig is the tolerance of the numeric columns. The columns with tolerance are
+// excluded from the checksum and always compared by rows.
+type ToleranceConfig struct {
+	// the columns the tolerance applies to.
+	Columns []string `toml:"columns" json:"columns,omitempty"`
+	// the column types the tolerance applies to, `float`, `double` and `decimal` are supported.
+	Types []string `toml:"types" json:"types,omitempty"`
+	// the values are equal if |a-b| <= absolute or |a-b| <= relative * max(|a|, |b|).
+	Absolute float64 `toml:"absolute" json:"absolute,omitempty"`
+	Relative float64 `toml:"relative" json:"relative,omitempty"`
 }
 
 // Valid returns true if table's config is valide.
@@ -88,11 +153,43 @@ type DataSource struct {
 	SqlMode  string `toml:"sql-mode" json:"sql-mode"`
 	Snapshot string `toml:"snapshot" json:"snapshot"`
 
+	// read the password from the environment variable, the file or the base64-encoded value
+	// instead of the plaintext password, only one of them can be set.
+	PasswordEnv    string `toml:"password-env" json:"password-env,omitempty"`
+	PasswordFile   string `toml:"password-file" json:"password-file,omitempty"`
+	PasswordBase64 string `toml:"password-base64" json:"password-base64,omitempty"`
+	// the TLS settings of the connection, TLS isn't used if it's not set.
+	Security *Security `toml:"security" json:"security,omitempty"`
+	// the name of the TLS config registered to the mysql driver.
+	tlsName string
+	// the password is read from the secret sources, so the config can be initialized again.
+	passwordResolved bool
+
 	RouteRules []string `toml:"route-rules" json:"route-rules"`
 	Router     *router.Table
 
 	Conn *sql.DB
 	// SourceType string `toml:"source-type" json:"source-type"`
+
+	// Dir is the directory of the files exported by dumpling or mydumper.
+	// If it is set, the data source reads the schema files and the data files
+	// in the directory instead of connecting to the database.
+	Dir string `toml:"dir" json:"dir,omitempty"`
+	// CSV is the format of the csv data files in Dir.
+	CSV *CSVConfig `toml:"csv" json:"csv,omitempty"`
+	// MaxTableSize is the max total size in bytes of the data files of a table in Dir. The rows of a table
+	// are loaded into memory to be sorted, so the tables larger than it are refused, default is 1GiB.
+	MaxTableSize int64 `toml:"max-table-size" json:"max-table-size,omitempty"`
+}
+
+// CSVConfig is the format of the csv data files.
+type CSVConfig struct {
+	// the separator between fields, default is ",".
+	Separator string `toml:"separator" json:"separator"`
+	// the delimiter quoting the fields, default is "\"".
+	Delimiter string `toml:"delimiter" json:"delimiter"`
+	// the representation of NULL, default is "\\N".
+	Null string `toml:"null" json:"null"`
 }
 
 func (d *DataSource) ToDBConfig() *dbutil.DBConfig {
@@ -102,9 +199,83 @@ func (d *DataSource) ToDBConfig() *dbutil.DBConfig {
 		User:     d.User,
 		Password: d.Password,
 		Snapshot: d.Snapshot,
+		TLSName:  d.tlsName,
 	}
 }
 
+// the storages of the checkpoint.
+const (
+	// CheckpointStorageFile saves the checkpoint in the output dir.
+	CheckpointStorageFile = "file"
+	// CheckpointStorageDB saves the checkpoint in a table of the target instance.
+	CheckpointStorageDB = "db"
+	// CheckpointStorageEtcd saves the checkpoint in etcd.
+	CheckpointStorageEtcd = "etcd"
+
+	defaultCheckpointSchema = "sync_diff_inspector"
+	defaultCheckpointTable  = "checkpoint"
+)
+
+// CheckpointStorageConfig is where the checkpoint is saved, the checkpoint saved in the target instance
+// or etcd can be resumed on another machine, e.g. the check runs in a rescheduled pod.
+type CheckpointStorageConfig struct {
+	// file, db or etcd, default is file.
+	Type string `toml:"type" json:"type"`
+	// the table saving the checkpoint in the target instance, default is `sync_diff_inspector`.`checkpoint`.
+	Schema string `toml:"schema" json:"schema,omitempty"`
+	Table  string `toml:"table" json:"table,omitempty"`
+	// the etcd endpoints, for example ["127.0.0.1:2379"].
+	EtcdEndpoints []string `toml:"etcd-endpoints" json:"etcd-endpoints,omitempty"`
+	// the key prefix of the checkpoint in etcd, default is "/sync_diff_inspector/checkpoint".
+	EtcdRoot string `toml:"etcd-root" json:"etcd-root,omitempty"`
+}
+
+// ThrottleConfig limits the load of the check on the source and target instances. The concurrency of
+// the check is reduced down to 1 and then paused while some of the thresholds is exceeded, and it
+// increases back to `check-thread-count` after the instances recover.
+type ThrottleConfig struct {
+	// the max `Threads_running` of the instances.
+	MaxThreadsRunning int64 `toml:"max-threads-running" json:"max-threads-running,omitempty"`
+	// the max replication lag of the instances which are replicas, for example "10s".
+	MaxReplicationLag string `toml:"max-replication-lag" json:"max-replication-lag,omitempty"`
+	// the max latency of the checksum statements, for example "5s".
+	MaxChecksumLatency string `toml:"max-checksum-latency" json:"max-checksum-latency,omitempty"`
+	// the max rows and bytes checked per second, they are not limited if 0.
+	MaxRowsPerSecond  int64 `toml:"max-rows-per-second" json:"max-rows-per-second,omitempty"`
+	MaxBytesPerSecond int64 `toml:"max-bytes-per-second" json:"max-bytes-per-second,omitempty"`
+	// the interval to check the thresholds, default is "5s".
+	CheckInterval string `toml:"check-interval" json:"check-interval,omitempty"`
+}
+
+// the replication tools whose consistency markers are read before comparing.
+const (
+	// ConsistencySyncpoint reads the syncpoint table written by TiCDC in the target instance.
+	ConsistencySyncpoint = "syncpoint"
+	// ConsistencyDMCheckpoint reads the syncer checkpoint table written by DM in the target instance.
+	ConsistencyDMCheckpoint = "dm-checkpoint"
+)
+
+// ConsistencyConfig waits for the replication tool to bring the target instance to the same logical point
+// as the source instances before comparing. TiCDC's syncpoint gives a pair of the upstream and downstream TSOs,
+// which are set as the snapshots of the source and target instances. DM's checkpoint only tells the target
+// instance has caught up with the binlog positions of the source instances when the wait begins.
+type ConsistencyConfig struct {
+	// syncpoint or dm-checkpoint.
+	Type string `toml:"type" json:"type"`
+	// the schema of the syncpoint table or the checkpoint table in the target instance,
+	// default is `tidb_cdc` for syncpoint and `dm_meta` for dm-checkpoint.
+	Schema string `toml:"schema" json:"schema,omitempty"`
+	// the changefeed of TiCDC, the syncpoints of all the changefeeds are read if it's empty.
+	Changefeed string `toml:"changefeed" json:"changefeed,omitempty"`
+	// the task of DM whose checkpoint table is `<task>_syncer_checkpoint`, default is `dm-task`.
+	// the names of the source instances should be the source ids of DM.
+	DMTask string `toml:"dm-task" json:"dm-task,omitempty"`
+	// the max duration to wait for a consistent point newer than the beginning of the wait, default is "10m".
+	Timeout string `toml:"timeout" json:"timeout,omitempty"`
+	// the interval to read the consistency marker, default is "5s".
+	CheckInterval string `toml:"check-interval" json:"check-interval,omitempty"`
+}
+
 type TaskConfig struct {
 	Source       []string `toml:"source-instances" json:"source-instances"`
 	Routes       []string `toml:"source-routes" json:"source-routes"`
@@ -118,6 +289,15 @@ type TaskConfig struct {
 	// 4. sync diff log file
 	// 5. fix
 	OutputDir string `toml:"output-dir" json:"output-dir"`
+	// the machine-readable reports written to the OutputDir besides summary.txt,
+	// support "json" and "junit".
+	ReportFormats []string `toml:"report-formats" json:"report-formats,omitempty"`
+	// the wall-clock budget of the check, for example "4h". The check stops gracefully when the budget
+	// expires, the checkpoint is kept and the tables not checked are reported as skipped.
+	TimeBudget string `toml:"time-budget" json:"time-budget,omitempty"`
+	// check the tables with the same priority in the order of their last verified time in the previous checks,
+	// so that the least recently verified tables are checked first.
+	PrioritizeStaleTables bool `toml:"prioritize-stale-tables" json:"prioritize-stale-tables,omitempty"`
 
 	SourceInstances    []*DataSource
 	TargetInstance     *DataSource
@@ -152,6 +332,13 @@ func (t *TaskConfig) Init(
 	}
 	t.TargetInstance = ts
 
+	for _, format := range t.ReportFormats {
+		if format != ReportFormatJSON && format != ReportFormatJUnit {
+			log.Error("unsupported report format, please correct the config", zap.String("format", format))
+			return errors.Errorf("unsupported report format `%s`, only support `%s` and `%s`", format, ReportFormatJSON, ReportFormatJUnit)
+		}
+	}
+
 	t.TargetCheckTables, err = filter.Parse(t.CheckTables)
 	if err != nil {
 		log.Error("parse check tables failed", zap.Error(err))
@@ -246,7 +433,7 @@ func (t *TaskConfig) ComputeConfigHash() (string, error) {
 	hash = append(hash, configBytes...)
 	// compute check-tables and table config
 	for _, c := range t.TargetTableConfigs {
-		configBytes, err = json.Marshal(c)
+		configBytes, err = json.Marshal(hashTableConfig(*c))
 		if err != nil {
 			return "", errors.Trace(err)
 		}
@@ -256,10 +443,45 @@ func (t *TaskConfig) ComputeConfigHash() (string, error) {
 	for _, c := range targetCheckTables {
 		hash = append(hash, []byte(c)...)
 	}
+	// the order of the tables changes with it.
+	if t.PrioritizeStaleTables {
+		hash = append(hash, []byte("prioritize-stale-tables")...)
+	}
 
 	return fmt.Sprintf("%x", sha256.Sum256(hash)), nil
 }
 
+// hashTableConfig is TableConfig with the json keys used before `ignore-columns`, `index-fields`, `range`
+// and `collation` had the json tags, the table configs are hashed by it to keep the hash of the existing
+// checkpoints. It should have the same fields as TableConfig.
+type hashTableConfig struct {
+	TargetTables []string `json:"target-tables"`
+	Schema       string
+	Table        string
+	ConfigIndex  int
+	HasMatched   bool
+
+	IgnoreColumns []string
+	Fields        []string
+	Range         string
+
+	TargetTableInfo *model.TableInfo
+
+	Collation string
+
+	ChunkSize         int64              `json:"chunk-size"`
+	UpdateTimeColumn  string             `json:"update-time-column,omitempty"`
+	ColumnTransforms  map[string]string  `json:"column-transforms,omitempty"`
+	ColumnMappings    []*column.Rule     `json:"column-mappings,omitempty"`
+	Tolerances        []*ToleranceConfig `json:"tolerances,omitempty"`
+	LocateStrategy    string             `json:"locate-strategy,omitempty"`
+	ChecksumAlgorithm string             `json:"checksum-algorithm,omitempty"`
+	Priority          int                `json:"priority,omitempty"`
+	Exclude           string             `json:"exclude,omitempty"`
+	UpstreamExclude   string             `json:"upstream-exclude,omitempty"`
+	DownstreamExclude string             `json:"downstream-exclude,omitempty"`
+}
+
 // Config is the configuration.
 type Config struct {
 	*flag.FlagSet `json:"-"`
@@ -271,8 +493,48 @@ type Config struct {
 	// set true if want to compare rows
 	// set false won't compare rows.
 	ExportFixSQL bool `toml:"export-fix-sql" json:"export-fix-sql"`
+	// write the different rows to structured files besides the fix sql, support "csv" and "json".
+	DiffRowsFormat string `toml:"diff-rows-format" json:"diff-rows-format,omitempty"`
 	// only check table struct without table data.
 	CheckStructOnly bool `toml:"check-struct-only" json:"check-struct-only"`
+	// compare every attribute of the columns, the indices and the partitions of the tables.
+	CheckStructDetail bool `toml:"check-struct-detail" json:"check-struct-detail,omitempty"`
+	// write the ALTER TABLE statements fixing the struct differences to the fix sql dir.
+	ExportStructFixSQL bool `toml:"export-struct-fix-sql" json:"export-struct-fix-sql,omitempty"`
+	// don't compare the `ADMIN CHECKSUM TABLE` results before checking the chunks when both sides are TiDB.
+	SkipTableChecksum bool `toml:"skip-table-checksum" json:"skip-table-checksum,omitempty"`
+	// apply the fix sql to the target instance in per-chunk transactions.
+	Repair bool `toml:"repair" json:"repair,omitempty"`
+	// only print the fix sql that would be applied by repair.
+	RepairDryRun bool `toml:"repair-dry-run" json:"repair-dry-run,omitempty"`
+	// only print the tables that would be checked and how they would be split, don't compare any data.
+	DryRun bool `toml:"dry-run" json:"dry-run,omitempty"`
+	// only recheck the failed chunks of the last run and the rows updated since the last run.
+	Recheck bool `toml:"recheck" json:"recheck,omitempty"`
+	// a chunk is inconsistent only when it is still different after rechecking so many times.
+	RecheckTimes int `toml:"recheck-times" json:"recheck-times,omitempty"`
+	// the interval between two rechecks of a chunk, it doubles after each recheck.
+	RecheckInterval string `toml:"recheck-interval" json:"recheck-interval,omitempty"`
+	// the max duration to recheck a chunk, it should be longer than the replication lag.
+	RecheckWindow string `toml:"recheck-window" json:"recheck-window,omitempty"`
+	// the probability to check a chunk in the sampling mode, all the chunks are checked if it is 0.
+	SampleRate float64 `toml:"sample-rate" json:"sample-rate,omitempty"`
+	// the confidence level of the estimated inconsistency rate of the chunks in the sampling mode.
+	SampleConfidence float64 `toml:"sample-confidence" json:"sample-confidence,omitempty"`
+	// stop the sampling once the upper bound of the inconsistency rate of the chunks is below it.
+	SampleMaxInconsistencyRate float64 `toml:"sample-max-inconsistency-rate" json:"sample-max-inconsistency-rate,omitempty"`
+	// the seed to pick the chunks in the sampling mode, a random seed is used if it is 0.
+	SampleSeed int64 `toml:"sample-seed" json:"sample-seed,omitempty"`
+	// the address of the http server serving the prometheus metrics and the check status.
+	StatusAddr string `toml:"status-addr" json:"status-addr,omitempty"`
+	// the address to serve the workers, the chunks are compared by the workers instead of this process if it is set.
+	CoordinatorAddr string `toml:"coordinator-addr" json:"coordinator-addr,omitempty"`
+	// limits the load of the check on the instances, it isn't limited if not set.
+	Throttle *ThrottleConfig `toml:"throttle" json:"throttle,omitempty"`
+	// where the checkpoint is saved, it is saved in the output dir if not set.
+	CheckpointStorage *CheckpointStorageConfig `toml:"checkpoint-storage" json:"checkpoint-storage,omitempty"`
+	// waits for the replication tool to reach a consistent point before comparing, it doesn't wait if not set.
+	Consistency *ConsistencyConfig `toml:"consistency" json:"consistency,omitempty"`
 	// DMAddr is dm-master's address, the format should like "http://127.0.0.1:8261"
 	DMAddr string `toml:"dm-addr" json:"dm-addr"`
 	// DMTask string `toml:"dm-task" json:"dm-task"`
@@ -305,7 +567,17 @@ func NewConfig() *Config {
 	fs.StringVar(&cfg.DMTask, "dm-task", "", "identifier of dm task")
 	fs.IntVar(&cfg.CheckThreadCount, "check-thread-count", 1, "how many goroutines are created to check data")
 	fs.BoolVar(&cfg.ExportFixSQL, "export-fix-sql", true, "set true if want to compare rows or set to false will only compare checksum")
+	fs.StringVar(&cfg.DiffRowsFormat, "diff-rows-format", "", "write the different rows to structured files besides the fix sql, support csv and json")
 	fs.BoolVar(&cfg.CheckStructOnly, "check-struct-only", false, "ignore check table's data")
+	fs.BoolVar(&cfg.CheckStructDetail, "check-struct-detail", false, "compare every attribute of the columns, the indices and the partitions of the tables")
+	fs.BoolVar(&cfg.ExportStructFixSQL, "export-struct-fix-sql", false, "write the ALTER TABLE statements fixing the struct differences, need check-struct-detail")
+	fs.BoolVar(&cfg.Repair, "repair", false, "apply the fix sql to the target instance and verify the repaired chunks again")
+	fs.BoolVar(&cfg.RepairDryRun, "repair-dry-run", false, "only print the fix sql that would be applied by repair")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "only print the tables that would be checked with their source tables, split index and estimated chunks, then exit without comparing data")
+	fs.StringVar(&cfg.StatusAddr, "status-addr", "", "the address to serve the prometheus metrics on /metrics and the check status on /status, e.g. 127.0.0.1:8080")
+	fs.StringVar(&cfg.CoordinatorAddr, "coordinator-addr", "", "the address to serve the workers, the chunks are compared by the workers if it is set, e.g. 0.0.0.0:8342")
+	fs.BoolVar(&cfg.Recheck, "recheck", false, "only recheck the failed chunks of the last run and the rows updated since the last run")
+	fs.Float64Var(&cfg.SampleRate, "sample-rate", 0, "the probability to check a chunk, check all the chunks if it is 0")
 
 	fs.SortFlags = false
 	return cfg
@@ -345,8 +617,19 @@ func (c *Config) Parse(arguments []string) error {
 	return nil
 }
 
+// String returns the config in json, the secrets of the data sources are redacted.
 func (c *Config) String() string {
-	cfg, err := json.Marshal(c)
+	redacted := *c
+	redacted.DataSources = make(map[string]*DataSource, len(c.DataSources))
+	for id, d := range c.DataSources {
+		redacted.DataSources[id] = d.redacted()
+	}
+	redacted.Task.SourceInstances = make([]*DataSource, 0, len(c.Task.SourceInstances))
+	for _, d := range c.Task.SourceInstances {
+		redacted.Task.SourceInstances = append(redacted.Task.SourceInstances, d.redacted())
+	}
+	redacted.Task.TargetInstance = c.Task.TargetInstance.redacted()
+	cfg, err := json.Marshal(&redacted)
 	if err != nil {
 		return "<nil>"
 	}
@@ -383,6 +666,7 @@ func (c *Config) adjustConfigByDMSubTasks() (err error) {
 		User:     subTaskCfgs[0].To.User,
 		Password: subTaskCfgs[0].To.Password,
 		SqlMode:  sqlMode,
+		Security: toSecurity(subTaskCfgs[0].To.Security),
 	}
 	for _, subTaskCfg := range subTaskCfgs {
 		tableRouter, err := router.NewTableRouter(subTaskCfg.CaseSensitive, []*router.TableRule{})
@@ -402,6 +686,7 @@ func (c *Config) adjustConfigByDMSubTasks() (err error) {
 			Password: subTaskCfg.From.Password,
 			SqlMode:  sqlMode,
 			Router:   tableRouter,
+			Security: toSecurity(subTaskCfg.From.Security),
 		}
 	}
 	c.DataSources = dataSources
@@ -421,13 +706,21 @@ func (c *Config) Init() (err error) {
 		if err != nil {
 			return errors.Annotate(err, "failed to init Task")
 		}
+		for id, d := range c.DataSources {
+			if err = d.initSecurity(); err != nil {
+				return errors.Annotatef(err, "failed to init data source %s", id)
+			}
+		}
 		err = c.Task.Init(c.DataSources, c.TableConfigs)
 		if err != nil {
 			return errors.Annotate(err, "failed to init Task")
 		}
 		return nil
 	}
-	for _, d := range c.DataSources {
+	for id, d := range c.DataSources {
+		if err = d.initSecurity(); err != nil {
+			return errors.Annotatef(err, "failed to init data source %s", id)
+		}
 		routeRuleList := make([]*router.TableRule, 0, len(c.Routes))
 		// if we had rules
 		for _, r := range d.RouteRules {
@@ -468,9 +761,187 @@ func (c *Config) CheckConfig() bool {
 			return false
 		}
 	}
+	if c.Repair || c.RepairDryRun {
+		if !c.ExportFixSQL || c.CheckStructOnly {
+			log.Error("`repair` needs `export-fix-sql` to generate the fix sql and can't work with `check-struct-only`")
+			return false
+		}
+		if c.targetHasSnapshot() {
+			log.Error("`repair` can't write to the target instance with snapshot")
+			return false
+		}
+	}
+	if len(c.DiffRowsFormat) != 0 {
+		if c.DiffRowsFormat != DiffRowsFormatCSV && c.DiffRowsFormat != DiffRowsFormatJSON {
+			log.Error("diff-rows-format only support `csv` and `json`", zap.String("format", c.DiffRowsFormat))
+			return false
+		}
+		if !c.ExportFixSQL || c.CheckStructOnly {
+			log.Error("`diff-rows-format` needs `export-fix-sql` to compare the rows and can't work with `check-struct-only`")
+			return false
+		}
+	}
+	if c.ExportStructFixSQL && !c.CheckStructDetail {
+		log.Error("`export-struct-fix-sql` needs `check-struct-detail` to compare the struct")
+		return false
+	}
+	if c.Task.TargetInstance != nil && len(c.Task.TargetInstance.Dir) != 0 {
+		log.Error("the target instance can't be the dump files")
+		return false
+	}
+	for _, source := range c.Task.SourceInstances {
+		if len(source.Dir) != 0 && len(c.Task.SourceInstances) > 1 {
+			log.Error("the dump files can't be checked with other source instances")
+			return false
+		}
+	}
+	if c.RecheckTimes < 0 {
+		log.Error("recheck-times can't be negative")
+		return false
+	}
+	for _, d := range []string{c.RecheckInterval, c.RecheckWindow} {
+		if len(d) == 0 {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			log.Error("recheck-interval and recheck-window should be durations like '10s'", zap.String("duration", d))
+			return false
+		}
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 || c.SampleMaxInconsistencyRate < 0 || c.SampleMaxInconsistencyRate >= 1 {
+		log.Error("sample-rate should be in [0, 1] and sample-max-inconsistency-rate should be in [0, 1)")
+		return false
+	}
+	if c.SampleConfidence < 0 || c.SampleConfidence >= 1 {
+		log.Error("sample-confidence should be in (0, 1), such as 0.99")
+		return false
+	}
+	if c.IsSampling() && c.Recheck {
+		log.Error("the sampling mode can't work with `recheck`")
+		return false
+	}
+	for name, table := range c.TableConfigs {
+		switch table.LocateStrategy {
+		case "", LocateStrategyBinarySearch, LocateStrategyGroupedChecksum:
+		default:
+			log.Error("locate-strategy should be binary-search or grouped-checksum", zap.String("table config", name), zap.String("locate-strategy", table.LocateStrategy))
+			return false
+		}
+		switch table.ChecksumAlgorithm {
+		case "", utils.ChecksumCRC32, utils.ChecksumHash64, utils.ChecksumInProcess:
+		default:
+			log.Error("checksum-algorithm should be crc32, hash64 or in-process", zap.String("table config", name), zap.String("checksum-algorithm", table.ChecksumAlgorithm))
+			return false
+		}
+	}
+	if len(c.Task.TimeBudget) != 0 {
+		if budget, err := time.ParseDuration(c.Task.TimeBudget); err != nil || budget <= 0 {
+			log.Error("time-budget should be a positive duration like '4h'", zap.String("time-budget", c.Task.TimeBudget))
+			return false
+		}
+	}
+	if throttle := c.Throttle; throttle != nil {
+		if throttle.MaxThreadsRunning < 0 || throttle.MaxRowsPerSecond < 0 || throttle.MaxBytesPerSecond < 0 {
+			log.Error("throttle.max-threads-running, throttle.max-rows-per-second and throttle.max-bytes-per-second can't be negative")
+			return false
+		}
+		for _, d := range []string{throttle.MaxReplicationLag, throttle.MaxChecksumLatency, throttle.CheckInterval} {
+			if len(d) == 0 {
+				continue
+			}
+			if duration, err := time.ParseDuration(d); err != nil || duration <= 0 {
+				log.Error("the durations of throttle should be positive durations like '10s'", zap.String("duration", d))
+				return false
+			}
+		}
+	}
+	if storage := c.CheckpointStorage; storage != nil {
+		switch storage.Type {
+		case "", CheckpointStorageFile:
+		case CheckpointStorageDB:
+			if c.targetHasSnapshot() {
+				log.Error("the checkpoint can't be saved in the target instance with snapshot")
+				return false
+			}
+		case CheckpointStorageEtcd:
+			if len(storage.EtcdEndpoints) == 0 {
+				log.Error("checkpoint-storage.etcd-endpoints can't be empty for the etcd storage")
+				return false
+			}
+		default:
+			log.Error("checkpoint-storage.type should be file, db or etcd", zap.String("type", storage.Type))
+			return false
+		}
+	}
+	if consistency := c.Consistency; consistency != nil {
+		switch consistency.Type {
+		case ConsistencySyncpoint:
+			if len(c.Task.SourceInstances) != 1 || len(c.Task.SourceInstances[0].Dir) != 0 {
+				log.Error("the syncpoint of TiCDC needs exactly one source instance which is TiDB")
+				return false
+			}
+			if len(c.Task.SourceInstances[0].Snapshot) != 0 || c.Task.TargetInstance != nil && len(c.Task.TargetInstance.Snapshot) != 0 {
+				log.Error("the snapshots can't be set with the syncpoint of TiCDC, they are read from the syncpoint")
+				return false
+			}
+		case ConsistencyDMCheckpoint:
+			if len(consistency.DMTask) == 0 && len(c.DMTask) == 0 {
+				log.Error("consistency.dm-task or dm-task is required to read the checkpoint of DM")
+				return false
+			}
+			for _, source := range c.Task.SourceInstances {
+				if len(source.Dir) != 0 {
+					log.Error("the dump files can't be checked with the checkpoint of DM")
+					return false
+				}
+			}
+		default:
+			log.Error("consistency.type should be syncpoint or dm-checkpoint", zap.String("type", consistency.Type))
+			return false
+		}
+		for _, d := range []string{consistency.Timeout, consistency.CheckInterval} {
+			if len(d) == 0 {
+				continue
+			}
+			if duration, err := time.ParseDuration(d); err != nil || duration <= 0 {
+				log.Error("the durations of consistency should be positive durations like '10s'", zap.String("duration", d))
+				return false
+			}
+		}
+	}
 	return true
 }
 
+// targetHasSnapshot returns true if the target instance is read with a snapshot, which is set in the config
+// or read from the syncpoint of TiCDC.
+func (c *Config) targetHasSnapshot() bool {
+	if c.Consistency != nil && c.Consistency.Type == ConsistencySyncpoint {
+		return true
+	}
+	return c.Task.TargetInstance != nil && len(c.Task.TargetInstance.Snapshot) != 0
+}
+
+// CheckpointTable returns the table saving the checkpoint in the target instance, ok is false if the checkpoint
+// isn't saved in the target instance.
+func (c *Config) CheckpointTable() (schema, table string, ok bool) {
+	if c.CheckpointStorage == nil || c.CheckpointStorage.Type != CheckpointStorageDB {
+		return "", "", false
+	}
+	schema, table = c.CheckpointStorage.Schema, c.CheckpointStorage.Table
+	if len(schema) == 0 {
+		schema = defaultCheckpointSchema
+	}
+	if len(table) == 0 {
+		table = defaultCheckpointTable
+	}
+	return schema, table, true
+}
+
+// IsSampling returns true if only a random subset of the chunks is checked.
+func (c *Config) IsSampling() bool {
+	return c.SampleRate > 0 && c.SampleRate < 1 || c.SampleMaxInconsistencyRate > 0
+}
+
 func pathExists(_path string) (bool, error) {
 	_, err := os.Stat(_path)
 	if err != nil {
@@ -15,8 +15,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -48,7 +50,7 @@ func TestParseConfig(t *testing.T) {
 	require.True(t, cfg.CheckConfig())
 
 	// we might not use the same config to run this test. e.g. MYSQL_PORT can be 4000
-	require.Equal(t, cfg.String(), "{\"check-thread-count\":4,\"export-fix-sql\":true,\"check-struct-only\":false,\"dm-addr\":\"\",\"dm-task\":\"\",\"data-sources\":{\"mysql1\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"mysql2\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"mysql3\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule3\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"tidb0\":{\"host\":\"127.0.0.1\",\"port\":4000,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":null,\"Router\":{\"Selector\":{}},\"Conn\":null}},\"routes\":{\"rule1\":{\"schema-pattern\":\"test_*\",\"table-pattern\":\"t_*\",\"target-schema\":\"test\",\"target-table\":\"t\"},\"rule2\":{\"schema-pattern\":\"test2_*\",\"table-pattern\":\"t2_*\",\"target-schema\":\"test2\",\"target-table\":\"t2\"},\"rule3\":{\"schema-pattern\":\"test2_*\",\"table-pattern\":\"t2_*\",\"target-schema\":\"test\",\"target-table\":\"t\"}},\"table-configs\":{\"config1\":{\"target-tables\":[\"schema*.table*\",\"test2.t2\"],\"Schema\":\"\",\"Table\":\"\",\"ConfigIndex\":0,\"HasMatched\":false,\"IgnoreColumns\":[\"\",\"\"],\"Fields\":[\"\"],\"Range\":\"age \\u003e 10 AND age \\u003c 20\",\"TargetTableInfo\":null,\"Collation\":\"\",\"chunk-size\":0}},\"task\":{\"source-instances\":[\"mysql1\",\"mysql2\",\"mysql3\"],\"source-routes\":null,\"target-instance\":\"tidb0\",\"target-check-tables\":[\"schema*.table*\",\"!c.*\",\"test2.t2\"],\"target-configs\":[\"config1\"],\"output-dir\":\"/tmp/output/config\",\"SourceInstances\":[{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule3\"],\"Router\":{\"Selector\":{}},\"Conn\":null}],\"TargetInstance\":{\"host\":\"127.0.0.1\",\"port\":4000,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":null,\"Router\":{\"Selector\":{}},\"Conn\":null},\"TargetTableConfigs\":[{\"target-tables\":[\"schema*.table*\",\"test2.t2\"],\"Schema\":\"\",\"Table\":\"\",\"ConfigIndex\":0,\"HasMatched\":false,\"IgnoreColumns\":[\"\",\"\"],\"Fields\":[\"\"],\"Range\":\"age \\u003e 10 AND age \\u003c 20\",\"TargetTableInfo\":null,\"Collation\":\"\",\"chunk-size\":0}],\"TargetCheckTables\":[{},{},{}],\"FixDir\":\"/tmp/output/config/fix-on-tidb0\",\"CheckpointDir\":\"/tmp/output/config/checkpoint\",\"HashFile\":\"\"},\"ConfigFile\":\"config_sharding.toml\",\"PrintVersion\":false}")
+	require.Equal(t, cfg.String(), "{\"check-thread-count\":4,\"export-fix-sql\":true,\"check-struct-only\":false,\"dm-addr\":\"\",\"dm-task\":\"\",\"data-sources\":{\"mysql1\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"mysql2\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"mysql3\":{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule3\"],\"Router\":{\"Selector\":{}},\"Conn\":null},\"tidb0\":{\"host\":\"127.0.0.1\",\"port\":4000,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":null,\"Router\":{\"Selector\":{}},\"Conn\":null}},\"routes\":{\"rule1\":{\"schema-pattern\":\"test_*\",\"table-pattern\":\"t_*\",\"target-schema\":\"test\",\"target-table\":\"t\"},\"rule2\":{\"schema-pattern\":\"test2_*\",\"table-pattern\":\"t2_*\",\"target-schema\":\"test2\",\"target-table\":\"t2\"},\"rule3\":{\"schema-pattern\":\"test2_*\",\"table-pattern\":\"t2_*\",\"target-schema\":\"test\",\"target-table\":\"t\"}},\"table-configs\":{\"config1\":{\"target-tables\":[\"schema*.table*\",\"test2.t2\"],\"Schema\":\"\",\"Table\":\"\",\"ConfigIndex\":0,\"HasMatched\":false,\"ignore-columns\":[\"\",\"\"],\"index-fields\":[\"\"],\"range\":\"age \\u003e 10 AND age \\u003c 20\",\"TargetTableInfo\":null,\"collation\":\"\",\"chunk-size\":0}},\"task\":{\"source-instances\":[\"mysql1\",\"mysql2\",\"mysql3\"],\"source-routes\":null,\"target-instance\":\"tidb0\",\"target-check-tables\":[\"schema*.table*\",\"!c.*\",\"test2.t2\"],\"target-configs\":[\"config1\"],\"output-dir\":\"/tmp/output/config\",\"SourceInstances\":[{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule2\"],\"Router\":{\"Selector\":{}},\"Conn\":null},{\"host\":\"127.0.0.1\",\"port\":3306,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":[\"rule1\",\"rule3\"],\"Router\":{\"Selector\":{}},\"Conn\":null}],\"TargetInstance\":{\"host\":\"127.0.0.1\",\"port\":4000,\"user\":\"root\",\"password\":\"\",\"sql-mode\":\"\",\"snapshot\":\"\",\"route-rules\":null,\"Router\":{\"Selector\":{}},\"Conn\":null},\"TargetTableConfigs\":[{\"target-tables\":[\"schema*.table*\",\"test2.t2\"],\"Schema\":\"\",\"Table\":\"\",\"ConfigIndex\":0,\"HasMatched\":false,\"ignore-columns\":[\"\",\"\"],\"index-fields\":[\"\"],\"range\":\"age \\u003e 10 AND age \\u003c 20\",\"TargetTableInfo\":null,\"collation\":\"\",\"chunk-size\":0}],\"TargetCheckTables\":[{},{},{}],\"FixDir\":\"/tmp/output/config/fix-on-tidb0\",\"CheckpointDir\":\"/tmp/output/config/checkpoint\",\"HashFile\":\"\"},\"ConfigFile\":\"config_sharding.toml\",\"PrintVersion\":false}")
 	hash, err := cfg.Task.ComputeConfigHash()
 	require.NoError(t, err)
 	require.Equal(t, hash, "e03a88f9270c3906739d3f51b54d5011d7f04d55f8e14f4a3add59c93b3e877f")
@@ -74,6 +76,51 @@ func TestError(t *testing.T) {
 	require.False(t, cfg.CheckConfig())
 	cfg.CheckThreadCount = 1
 	require.True(t, cfg.CheckConfig())
+	cfg.DiffRowsFormat = "xml"
+	require.False(t, cfg.CheckConfig())
+	cfg.DiffRowsFormat = DiffRowsFormatCSV
+	cfg.ExportFixSQL = false
+	require.False(t, cfg.CheckConfig())
+	cfg.ExportFixSQL = true
+	require.True(t, cfg.CheckConfig())
+	cfg.DiffRowsFormat = ""
+	cfg.ExportStructFixSQL = true
+	require.False(t, cfg.CheckConfig())
+	cfg.CheckStructDetail = true
+	require.True(t, cfg.CheckConfig())
+	cfg.CheckStructDetail, cfg.ExportStructFixSQL = false, false
+	cfg.Consistency = &ConsistencyConfig{Type: "binlog"}
+	require.False(t, cfg.CheckConfig())
+	cfg.Consistency.Type = ConsistencyDMCheckpoint
+	require.False(t, cfg.CheckConfig())
+	cfg.Consistency.DMTask = "task1"
+	require.True(t, cfg.CheckConfig())
+	cfg.Consistency.Timeout = "-1s"
+	require.False(t, cfg.CheckConfig())
+	cfg.Consistency.Timeout = ""
+	cfg.Consistency.Type = ConsistencySyncpoint
+	cfg.Task.SourceInstances = []*DataSource{{}}
+	cfg.Task.TargetInstance = &DataSource{Snapshot: "430000000000000000"}
+	require.False(t, cfg.CheckConfig())
+	cfg.Task.TargetInstance.Snapshot = ""
+	require.True(t, cfg.CheckConfig())
+	// the target instance is read with the snapshot of the syncpoint
+	cfg.Repair = true
+	require.False(t, cfg.CheckConfig())
+	cfg.Repair, cfg.Consistency, cfg.Task.SourceInstances, cfg.Task.TargetInstance = false, nil, nil, nil
+	cfg.Task.TimeBudget = "4 hours"
+	require.False(t, cfg.CheckConfig())
+	cfg.Task.TimeBudget = "0s"
+	require.False(t, cfg.CheckConfig())
+	cfg.Task.TimeBudget = "4h"
+	require.True(t, cfg.CheckConfig())
+	cfg.Task.TimeBudget = ""
+	tableConfigs := cfg.TableConfigs
+	cfg.TableConfigs = map[string]*TableConfig{"config1": {ChecksumAlgorithm: "sha256"}}
+	require.False(t, cfg.CheckConfig())
+	cfg.TableConfigs["config1"].ChecksumAlgorithm = utils.ChecksumHash64
+	require.True(t, cfg.CheckConfig())
+	cfg.TableConfigs = tableConfigs
 
 	// Init
 	cfg.DataSources = make(map[string]*DataSource)
@@ -83,3 +130,62 @@ func TestError(t *testing.T) {
 	err := cfg.Init()
 	require.Contains(t, err.Error(), "not found source routes for rule 111, please correct the config")
 }
+
+func TestDataSourceSecurity(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("file-secret\n"), LocalFilePerm))
+	require.NoError(t, os.Setenv("SYNC_DIFF_TEST_PASSWORD", "env-secret"))
+	defer os.Unsetenv("SYNC_DIFF_TEST_PASSWORD")
+
+	for _, c := range []struct {
+		ds       *DataSource
+		password string
+		err      string
+	}{
+		{ds: &DataSource{Password: "plain"}, password: "plain"},
+		{ds: &DataSource{PasswordEnv: "SYNC_DIFF_TEST_PASSWORD"}, password: "env-secret"},
+		{ds: &DataSource{PasswordFile: passwordFile}, password: "file-secret"},
+		{ds: &DataSource{PasswordBase64: "YjY0LXNlY3JldA=="}, password: "b64-secret"},
+		{ds: &DataSource{Password: "plain", PasswordEnv: "SYNC_DIFF_TEST_PASSWORD"}, err: "only one of password"},
+		{ds: &DataSource{PasswordEnv: "SYNC_DIFF_TEST_NOT_EXIST"}, err: "not found"},
+		{ds: &DataSource{PasswordBase64: "%%%"}, err: "failed to decode password-base64"},
+		{ds: &DataSource{Security: &Security{}}, err: "ssl-ca is required"},
+		{ds: &DataSource{Security: &Security{CAPath: filepath.Join(dir, "ca.pem")}}, err: "could not read ca certificate"},
+		{ds: &DataSource{Security: &Security{CAPath: passwordFile}}, err: "failed to append ca certs"},
+		{ds: &DataSource{Security: &Security{CAPath: passwordFile, VerifyMode: "unknown"}}, err: "unknown verify-mode"},
+		{ds: &DataSource{Security: &Security{CertPath: passwordFile, VerifyMode: VerifyModeSkip}}, err: "ssl-cert and ssl-key should be set together"},
+	} {
+		err := c.ds.initSecurity()
+		if len(c.err) != 0 {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, c.password, c.ds.Password)
+		// the config may be initialized again.
+		require.NoError(t, c.ds.initSecurity())
+		require.Equal(t, c.password, c.ds.ToDBConfig().Password)
+	}
+
+	// the connection is encrypted without verifying the server.
+	ds := &DataSource{Host: "127.0.0.1", Port: 4000, Security: &Security{VerifyMode: VerifyModeSkip}}
+	require.NoError(t, ds.initSecurity())
+	require.NotEmpty(t, ds.ToDBConfig().TLSName)
+	tlsCfg, err := ds.Security.ToTLSConfig(ds.Host)
+	require.NoError(t, err)
+	require.True(t, tlsCfg.InsecureSkipVerify)
+
+	// the secrets are redacted in the config dump.
+	cfg := NewConfig()
+	require.Nil(t, cfg.Parse([]string{"--config", "config.toml"}))
+	cfg.DataSources["mysql1"].Password = "plain-secret"
+	cfg.DataSources["tidb0"].PasswordBase64 = "YjY0LXNlY3JldA=="
+	cfg.Task.SourceInstances = []*DataSource{cfg.DataSources["mysql1"]}
+	cfg.Task.TargetInstance = cfg.DataSources["tidb0"]
+	require.NotContains(t, cfg.String(), "plain-secret")
+	require.NotContains(t, cfg.String(), "YjY0LXNlY3JldA==")
+	require.Contains(t, cfg.String(), redactedSecret)
+	require.Equal(t, "plain-secret", cfg.DataSources["mysql1"].Password)
+}
@@ -82,3 +82,16 @@ func getDMTaskCfg(dmAddr, task string) ([]*config.SubTaskConfig, error) {
 	log.Info("dm sub task configs", zap.Reflect("cfgs", subTaskCfgs))
 	return subTaskCfgs, nil
 }
+
+// toSecurity converts the TLS settings of the DM data source, TLS isn't used if the CA isn't set.
+func toSecurity(s *config.Security) *Security {
+	if s == nil || len(s.SSLCA) == 0 {
+		return nil
+	}
+	return &Security{
+		CAPath:        s.SSLCA,
+		CertPath:      s.SSLCert,
+		KeyPath:       s.SSLKey,
+		CertAllowedCN: s.CertAllowedCN,
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/utils"
+)
+
+// the modes to verify the certificate of the database server.
+const (
+	// VerifyModeFull verifies the certificate chain by the CA and the host name, it's the default mode.
+	VerifyModeFull = "verify-full"
+	// VerifyModeCA only verifies the certificate chain by the CA.
+	VerifyModeCA = "verify-ca"
+	// VerifyModeSkip doesn't verify the certificate of the server, the connection is encrypted only.
+	VerifyModeSkip = "skip-verify"
+
+	// redactedSecret replaces the secrets in the config dump.
+	redactedSecret = "******"
+)
+
+// Security is the TLS settings of the connection to a data source.
+type Security struct {
+	// the path of the CA, it's required unless the verify mode is skip-verify.
+	CAPath string `toml:"ssl-ca" json:"ssl-ca"`
+	// the paths of the client certificate and key.
+	CertPath string `toml:"ssl-cert" json:"ssl-cert"`
+	KeyPath  string `toml:"ssl-key" json:"ssl-key"`
+	// the allowed common names of the server certificate, any name is allowed if it's empty.
+	CertAllowedCN []string `toml:"cert-allowed-cn" json:"cert-allowed-cn,omitempty"`
+	// support verify-full, verify-ca and skip-verify, the default is verify-full.
+	VerifyMode string `toml:"verify-mode" json:"verify-mode,omitempty"`
+}
+
+// ToTLSConfig builds the TLS config to connect the host.
+func (s *Security) ToTLSConfig(host string) (*tls.Config, error) {
+	verifyMode := s.VerifyMode
+	if len(verifyMode) == 0 {
+		verifyMode = VerifyModeFull
+	}
+	if verifyMode != VerifyModeFull && verifyMode != VerifyModeCA && verifyMode != VerifyModeSkip {
+		return nil, errors.Errorf("unknown verify-mode %s, support %s, %s and %s", verifyMode, VerifyModeFull, VerifyModeCA, VerifyModeSkip)
+	}
+	if len(s.CAPath) == 0 && verifyMode != VerifyModeSkip {
+		return nil, errors.Errorf("ssl-ca is required to verify the server certificate in %s mode", verifyMode)
+	}
+	if (len(s.CertPath) == 0) != (len(s.KeyPath) == 0) {
+		return nil, errors.New("ssl-cert and ssl-key should be set together")
+	}
+
+	tlsCfg, err := utils.ToTLSConfigWithVerify(s.CAPath, s.CertPath, s.KeyPath, s.CertAllowedCN)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch verifyMode {
+	case VerifyModeFull:
+		tlsCfg.ServerName = host
+	case VerifyModeCA:
+		// the host name isn't verified by the handshake, so verify the chain by the CA here.
+		roots, checkCN := tlsCfg.RootCAs, tlsCfg.VerifyPeerCertificate
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, rawCert := range rawCerts {
+				cert, err := x509.ParseCertificate(rawCert)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return errors.New("the server doesn't provide any certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			chains, err := certs[0].Verify(opts)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if checkCN != nil {
+				return checkCN(rawCerts, chains)
+			}
+			return nil
+		}
+	case VerifyModeSkip:
+		if tlsCfg == nil {
+			// no CA is given.
+			tlsCfg = &tls.Config{}
+			if len(s.CertPath) != 0 {
+				cert, err := tls.LoadX509KeyPair(s.CertPath, s.KeyPath)
+				if err != nil {
+					return nil, errors.Annotate(err, "could not load client key pair")
+				}
+				tlsCfg.Certificates = []tls.Certificate{cert}
+			}
+		}
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = nil
+	}
+	return tlsCfg, nil
+}
+
+// initSecurity resolves the password from the secret sources and registers the TLS config to the mysql driver.
+func (d *DataSource) initSecurity() error {
+	if err := d.resolvePassword(); err != nil {
+		return errors.Trace(err)
+	}
+	if d.Security == nil || len(d.Dir) != 0 {
+		return nil
+	}
+	tlsCfg, err := d.Security.ToTLSConfig(d.Host)
+	if err != nil {
+		return errors.Annotatef(err, "failed to build the TLS config of %s:%d", d.Host, d.Port)
+	}
+	// the same settings get the same name, so the tasks in the server mode don't register too many configs.
+	settings, err := json.Marshal(d.Security)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.tlsName = fmt.Sprintf("sync-diff-%x", sha256.Sum256([]byte(fmt.Sprintf("%s:%d/%s", d.Host, d.Port, settings))))[:26]
+	return errors.Trace(mysql.RegisterTLSConfig(d.tlsName, tlsCfg))
+}
+
+// resolvePassword reads the password from the environment variable, the file or the base64-encoded value.
+func (d *DataSource) resolvePassword() error {
+	if d.passwordResolved {
+		return nil
+	}
+	sources := 0
+	for _, s := range []string{d.Password, d.PasswordEnv, d.PasswordFile, d.PasswordBase64} {
+		if len(s) != 0 {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.Errorf("only one of password, password-env, password-file and password-base64 can be set for %s:%d", d.Host, d.Port)
+	}
+	switch {
+	case len(d.PasswordEnv) != 0:
+		password, ok := os.LookupEnv(d.PasswordEnv)
+		if !ok {
+			return errors.NotFoundf("environment variable %s of the password", d.PasswordEnv)
+		}
+		d.Password = password
+	case len(d.PasswordFile) != 0:
+		password, err := ioutil.ReadFile(d.PasswordFile)
+		if err != nil {
+			return errors.Annotate(err, "failed to read the password file")
+		}
+		d.Password = strings.TrimRight(string(password), "\r\n")
+	case len(d.PasswordBase64) != 0:
+		password, err := base64.StdEncoding.DecodeString(d.PasswordBase64)
+		if err != nil {
+			return errors.Annotate(err, "failed to decode password-base64")
+		}
+		d.Password = string(password)
+	}
+	d.passwordResolved = true
+	return nil
+}
+
+// redacted returns a copy of the data source without the secrets, it's used to print the config.
+func (d *DataSource) redacted() *DataSource {
+	if d == nil {
+		return nil
+	}
+	ds := *d
+	if len(ds.Password) != 0 {
+		ds.Password = redactedSecret
+	}
+	if len(ds.PasswordBase64) != 0 {
+		ds.PasswordBase64 = redactedSecret
+	}
+	return &ds
+}
@@ -0,0 +1,420 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/progress"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"go.uber.org/zap"
+)
+
+const (
+	// leaseTTL is the time a worker holds a chunk without heartbeat, the chunk is
+	// reassigned to other workers after it expires.
+	leaseTTL = 30 * time.Second
+	// heartbeatInterval is the interval the workers extend their leases.
+	heartbeatInterval = leaseTTL / 3
+	// leasePollTimeout is the max time a lease request waits for a chunk.
+	leasePollTimeout = 5 * time.Second
+
+	coordinatorAPIPrefix = "/api/v1/coordinator/"
+)
+
+// leaseRequest is sent by the workers to get a chunk.
+type leaseRequest struct {
+	Worker string `json:"worker"`
+	// ConfigHash makes sure the workers check the same tables in the same order.
+	ConfigHash string `json:"config-hash"`
+}
+
+// snapshotsRequest is sent by the workers to get the snapshots read from the syncpoint of TiCDC
+// by the coordinator, so the workers read the same data.
+type snapshotsRequest struct {
+	Worker string `json:"worker"`
+}
+
+// snapshotsResponse is the snapshots of the source instances and the target instance.
+type snapshotsResponse struct {
+	SourceSnapshots []string `json:"source-snapshots"`
+	TargetSnapshot  string   `json:"target-snapshot"`
+}
+
+// tablesRequest is sent by the workers to get the order of the tables checked by the coordinator,
+// because the chunks are identified by the indices of the tables.
+type tablesRequest struct {
+	Worker string `json:"worker"`
+}
+
+// tablesResponse is the unique IDs of the tables in the order they are checked.
+type tablesResponse struct {
+	Tables []string `json:"tables"`
+}
+
+// chunkLease is a chunk held by a worker until the deadline.
+type chunkLease struct {
+	ID    int64               `json:"id"`
+	Range *splitter.RangeInfo `json:"range"`
+
+	worker   string
+	deadline time.Time
+}
+
+// heartbeatRequest extends the leases held by the worker.
+type heartbeatRequest struct {
+	Worker   string  `json:"worker"`
+	LeaseIDs []int64 `json:"lease-ids"`
+}
+
+// resultRequest sends the result of the chunk held by the worker.
+type resultRequest struct {
+	Worker  string       `json:"worker"`
+	LeaseID int64        `json:"lease-id"`
+	Result  *chunkResult `json:"result"`
+}
+
+// coordinator hands the chunks to the workers and records their results in the report and the checkpoint.
+type coordinator struct {
+	sync.Mutex
+	df         *Diff
+	configHash string
+	snapshots  *snapshotsResponse
+	ctx        context.Context
+
+	// chunkCh are the chunks waiting for the workers, retryChunks are the chunks of the expired leases.
+	chunkCh     chan *splitter.RangeInfo
+	retryChunks []*splitter.RangeInfo
+	leases      map[int64]*chunkLease
+	nextLeaseID int64
+	// pending is the number of the dispatched chunks whose results are not recorded.
+	pending int
+	// allDispatched is true if all the chunks have been dispatched.
+	allDispatched bool
+	// finishCh is closed when all the chunks are finished or the check stops, the workers exit then.
+	finishCh   chan struct{}
+	finishOnce sync.Once
+
+	// resultMu makes sure no result is recorded after the check stops.
+	resultMu sync.RWMutex
+	stopped  bool
+
+	server *http.Server
+}
+
+// leaseConfigHash returns the config hash compared by the coordinator and the workers. The order of the tables
+// is hashed too, it may be restored from the checkpoint or depend on the check history.
+func leaseConfigHash(cfg *config.Config, tables []*common.TableDiff) (string, error) {
+	hash, err := cfg.Task.ComputeConfigHash()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	order := []byte(hash)
+	for _, id := range tableIDs(tables) {
+		order = append(order, []byte(id)...)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(order)), nil
+}
+
+func newCoordinator(df *Diff, configHash string, snapshots *snapshotsResponse) *coordinator {
+	return &coordinator{
+		df:         df,
+		configHash: configHash,
+		snapshots:  snapshots,
+		ctx:        context.Background(),
+		chunkCh:    make(chan *splitter.RangeInfo, splitter.DefaultChannelBuffer),
+		leases:     make(map[int64]*chunkLease),
+		finishCh:   make(chan struct{}),
+	}
+}
+
+// startServer serves the workers on the address.
+func (c *coordinator) startServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Annotatef(err, "fail to listen on the coordinator address %s", addr)
+	}
+	c.server = &http.Server{Handler: c.handler()}
+	go func() {
+		log.Info("start coordinator", zap.String("address", addr))
+		if err := c.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Warn("coordinator exits", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// handler serves the api of the coordinator.
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(coordinatorAPIPrefix+"lease", c.handleLease)
+	mux.HandleFunc(coordinatorAPIPrefix+"heartbeat", c.handleHeartbeat)
+	mux.HandleFunc(coordinatorAPIPrefix+"result", c.handleResult)
+	mux.HandleFunc(coordinatorAPIPrefix+"snapshots", c.handleSnapshots)
+	mux.HandleFunc(coordinatorAPIPrefix+"tables", c.handleTables)
+	return mux
+}
+
+// start begins to dispatch the chunks, the expired leases are reassigned until the context is done.
+func (c *coordinator) start(ctx context.Context) {
+	c.Lock()
+	c.ctx = ctx
+	c.Unlock()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.finishCh:
+				return
+			case <-ticker.C:
+				c.expireLeases()
+			}
+		}
+	}()
+}
+
+// dispatch queues the chunk for the workers, it blocks if there are too many chunks waiting.
+func (c *coordinator) dispatch(ctx context.Context, rangeInfo *splitter.RangeInfo) {
+	if rangeInfo.ChunkRange.Type == chunk.Empty {
+		c.df.consume(ctx, rangeInfo)
+		progress.Inc(rangeInfo.ProgressID)
+		return
+	}
+	c.Lock()
+	c.pending++
+	c.Unlock()
+	select {
+	case <-ctx.Done():
+	case c.chunkCh <- rangeInfo:
+	}
+}
+
+// wait waits for the results of all the dispatched chunks, or the context is done.
+func (c *coordinator) wait(ctx context.Context) {
+	c.Lock()
+	c.allDispatched = true
+	c.checkFinished()
+	c.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-c.finishCh:
+	}
+	c.stop()
+}
+
+// stop rejects the results of the chunks, and lets the workers exit.
+func (c *coordinator) stop() {
+	c.resultMu.Lock()
+	c.stopped = true
+	c.resultMu.Unlock()
+	c.finishOnce.Do(func() { close(c.finishCh) })
+}
+
+func (c *coordinator) close() {
+	c.stop()
+	if c.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.server.Shutdown(ctx); err != nil {
+		log.Warn("fail to shutdown the coordinator", zap.Error(err))
+	}
+}
+
+// checkFinished closes finishCh if all the chunks are finished, it should be called with the lock.
+func (c *coordinator) checkFinished() {
+	if c.allDispatched && c.pending == 0 {
+		c.finishOnce.Do(func() { close(c.finishCh) })
+	}
+}
+
+func (c *coordinator) expireLeases() {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for id, lease := range c.leases {
+		if now.After(lease.deadline) {
+			log.Warn("the lease of the chunk expires, reassign it to other workers",
+				zap.String("worker", lease.worker), zap.Any("chunk id", lease.Range.ChunkRange.Index))
+			delete(c.leases, id)
+			c.retryChunks = append(c.retryChunks, lease.Range)
+		}
+	}
+}
+
+// lease returns a chunk for the worker, or nil if there is no chunk now.
+func (c *coordinator) lease(ctx context.Context, worker string) *chunkLease {
+	var rangeInfo *splitter.RangeInfo
+	c.Lock()
+	if len(c.retryChunks) > 0 {
+		rangeInfo = c.retryChunks[0]
+		c.retryChunks = c.retryChunks[1:]
+	}
+	c.Unlock()
+	if rangeInfo == nil {
+		select {
+		case rangeInfo = <-c.chunkCh:
+		case <-time.After(leasePollTimeout):
+			return nil
+		case <-c.finishCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.nextLeaseID++
+	lease := &chunkLease{
+		ID:       c.nextLeaseID,
+		Range:    rangeInfo,
+		worker:   worker,
+		deadline: time.Now().Add(leaseTTL),
+	}
+	c.leases[lease.ID] = lease
+	return lease
+}
+
+func (c *coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	req := &leaseRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if req.ConfigHash != c.configHash {
+		writeError(w, http.StatusConflict, errors.Errorf("the config of worker %s is different from the coordinator", req.Worker))
+		return
+	}
+	select {
+	case <-c.finishCh:
+		// all the chunks are finished, the worker can exit.
+		w.WriteHeader(http.StatusGone)
+		return
+	default:
+	}
+	lease := c.lease(r.Context(), req.Worker)
+	if lease == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Debug("lease chunk", zap.String("worker", req.Worker), zap.Int64("lease", lease.ID), zap.Any("chunk id", lease.Range.ChunkRange.Index))
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func (c *coordinator) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	req := &heartbeatRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	c.Lock()
+	deadline := time.Now().Add(leaseTTL)
+	for _, id := range req.LeaseIDs {
+		if lease, ok := c.leases[id]; ok && lease.worker == req.Worker {
+			lease.deadline = deadline
+		}
+	}
+	c.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *coordinator) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	req := &snapshotsRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, c.snapshots)
+}
+
+func (c *coordinator) handleTables(w http.ResponseWriter, r *http.Request) {
+	req := &tablesRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, &tablesResponse{Tables: tableIDs(c.df.downstream.GetTables())})
+}
+
+func (c *coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	req := &resultRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	if req.Result == nil {
+		writeError(w, http.StatusBadRequest, errors.New("the result is empty"))
+		return
+	}
+	c.resultMu.RLock()
+	defer c.resultMu.RUnlock()
+	if c.stopped {
+		writeError(w, http.StatusGone, errors.New("the check has stopped"))
+		return
+	}
+	c.Lock()
+	lease, ok := c.leases[req.LeaseID]
+	if ok && lease.worker == req.Worker {
+		delete(c.leases, req.LeaseID)
+	}
+	ctx := c.ctx
+	c.Unlock()
+	if !ok || lease.worker != req.Worker {
+		// the lease expired and the chunk has been reassigned.
+		writeError(w, http.StatusConflict, errors.Errorf("lease %d of worker %s is not found", req.LeaseID, req.Worker))
+		return
+	}
+
+	result := req.Result
+	if len(result.ErrMsg) != 0 {
+		result.err = errors.Errorf("worker %s: %s", req.Worker, result.ErrMsg)
+	}
+	rangeInfo := lease.Range
+	isEqual := c.df.finishChunk(ctx, rangeInfo, result)
+	if !isEqual {
+		progress.FailTable(rangeInfo.ProgressID)
+	}
+	progress.Inc(rangeInfo.ProgressID)
+	c.df.status.finishChunk(isEqual)
+
+	c.Lock()
+	c.pending--
+	c.checkFinished()
+	c.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Annotate(err, "parse the request"))
+		return false
+	}
+	return true
+}
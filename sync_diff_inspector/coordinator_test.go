@@ -0,0 +1,143 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerTableOrder(t *testing.T) {
+	// the tables are checked in the order restored from the checkpoint of the coordinator.
+	df := &Diff{downstream: &mockSource{tables: []*common.TableDiff{{Schema: "test", Table: "t2"}, {Schema: "test", Table: "t1"}}}}
+	c := newCoordinator(df, "hash", &snapshotsResponse{})
+	server := httptest.NewServer(http.HandlerFunc(c.handleTables))
+	defer server.Close()
+
+	w := &worker{name: "w1", coordinator: server.URL, client: server.Client()}
+	order, err := w.getTableOrder(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &source.TableOrder{Tables: []string{"test:t2", "test:t1"}}, order)
+}
+
+func TestCoordinatorLease(t *testing.T) {
+	ctx := context.Background()
+	tables := []*common.TableDiff{{Schema: "test", Table: "t", Range: "TRUE"}}
+	df, _ := newRecheckDiff(tables, []int64{1})
+	c := newCoordinator(df, "hash", &snapshotsResponse{})
+	server := httptest.NewServer(c.handler())
+	defer server.Close()
+	w1 := &worker{name: "w1", coordinator: server.URL, configHash: "hash", client: server.Client()}
+	w2 := &worker{name: "w2", coordinator: server.URL, configHash: "hash", client: server.Client()}
+	expire := func(id int64) {
+		c.Lock()
+		c.leases[id].deadline = time.Now().Add(-time.Second)
+		c.Unlock()
+	}
+
+	c.dispatch(ctx, failedRange(newChunkNode(0, 0), tables[0]))
+	lease1, finished, err := w1.lease(ctx)
+	require.NoError(t, err)
+	require.False(t, finished)
+	require.Equal(t, int64(1), lease1.ID)
+
+	// the heartbeat of another worker doesn't extend the lease, so it expires and the chunk is requeued.
+	expire(lease1.ID)
+	_, err = w2.post(ctx, "heartbeat", &heartbeatRequest{Worker: "w2", LeaseIDs: []int64{lease1.ID}}, nil)
+	require.NoError(t, err)
+	c.expireLeases()
+	require.Len(t, c.retryChunks, 1)
+	lease2, _, err := w2.lease(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), lease2.ID)
+	require.Equal(t, lease1.Range.ChunkRange.Index, lease2.Range.ChunkRange.Index)
+
+	// the heartbeat of the worker holding the lease extends it.
+	expire(lease2.ID)
+	_, err = w2.post(ctx, "heartbeat", &heartbeatRequest{Worker: "w2", LeaseIDs: []int64{lease2.ID}}, nil)
+	require.NoError(t, err)
+	c.expireLeases()
+	require.Empty(t, c.retryChunks)
+
+	// the late result of the expired lease is rejected.
+	code, err := w1.post(ctx, "result", &resultRequest{Worker: "w1", LeaseID: lease1.ID, Result: &chunkResult{Equal: true}}, nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusConflict, code)
+	require.Empty(t, df.sqlCh)
+
+	code, err = w2.post(ctx, "result", &resultRequest{Worker: "w2", LeaseID: lease2.ID, Result: &chunkResult{Equal: true}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, df.sqlCh, 1)
+	require.Equal(t, checkpoints.SuccessState, (<-df.sqlCh).node.GetState())
+	require.Equal(t, int64(1), df.status.CheckedChunks)
+
+	// all the chunks are finished, so the workers exit.
+	c.wait(ctx)
+	_, finished, err = w1.lease(ctx)
+	require.NoError(t, err)
+	require.True(t, finished)
+}
+
+func TestCoordinatorConfigHash(t *testing.T) {
+	df := &Diff{downstream: &mockSource{tables: []*common.TableDiff{{Schema: "test", Table: "t"}}}}
+	c := newCoordinator(df, "hash", &snapshotsResponse{})
+	server := httptest.NewServer(c.handler())
+	defer server.Close()
+
+	// the worker checking the different tables doesn't get any chunk.
+	w := &worker{name: "w1", coordinator: server.URL, configHash: "other", client: server.Client()}
+	_, _, err := w.lease(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "409")
+	require.Contains(t, err.Error(), "different from the coordinator")
+}
+
+func TestRestoreRange(t *testing.T) {
+	chunkRange := chunk.NewChunkRange()
+	chunkRange.Update("a", "1", "10", true, true)
+	chunkRange.Update("b", "x", "y", true, true)
+	chunkRange.Type = chunk.Bucket
+	chunkRange.Index = &chunk.ChunkID{TableIndex: 1, BucketIndexLeft: 2, BucketIndexRight: 3, ChunkIndex: 4, ChunkCnt: 5}
+	chunkRange.Where, chunkRange.Args = chunkRange.ToString("")
+	data, err := json.Marshal(&chunkLease{ID: 1, Range: &splitter.RangeInfo{ChunkRange: chunkRange, IndexID: 2, ProgressID: "`test`.`t`"}})
+	require.NoError(t, err)
+	lease := &chunkLease{}
+	require.NoError(t, json.Unmarshal(data, lease))
+
+	rangeInfo := restoreRange(lease.Range)
+	require.Equal(t, int64(2), rangeInfo.IndexID)
+	require.Equal(t, "`test`.`t`", rangeInfo.ProgressID)
+	require.Equal(t, chunkRange.Index, rangeInfo.ChunkRange.Index)
+	require.Equal(t, chunk.Bucket, rangeInfo.ChunkRange.Type)
+	require.Equal(t, chunkRange.Bounds, rangeInfo.ChunkRange.Bounds)
+	require.Equal(t, chunkRange.Where, rangeInfo.ChunkRange.Where)
+	require.Equal(t, chunkRange.Args, rangeInfo.ChunkRange.Args)
+
+	// the bounds are updated in place by BinGenerate.
+	rangeInfo.ChunkRange.Update("b", "", "z", false, true)
+	require.Len(t, rangeInfo.ChunkRange.Bounds, 2)
+	require.Equal(t, "z", rangeInfo.ChunkRange.Bounds[1].Upper)
+}
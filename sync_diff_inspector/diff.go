@@ -19,8 +19,13 @@ import (
 	"database/sql"
 	"fmt"
 	"io/fs"
+	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -33,11 +38,13 @@ import (
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/metrics"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/progress"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/throttle"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	tidbconfig "github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/parser/model"
@@ -48,6 +55,12 @@ import (
 const (
 	// checkpointFile represents the checkpoints' file name which used for save and loads chunks
 	checkpointFile = "sync_diff_checkpoints.pb"
+
+	// defaultSampleConfidence is the confidence level of the estimated inconsistency rate in the sampling mode.
+	defaultSampleConfidence = 0.95
+
+	// checkpointFlushTimeout limits saving the last checkpoint after the check stops.
+	checkpointFlushTimeout = 30 * time.Second
 )
 
 // ChunkDML SQL struct for each chunk
@@ -56,8 +69,19 @@ type ChunkDML struct {
 	sqls      []string
 	rowAdd    int
 	rowDelete int
+	// rowDiffs are the different rows written in diffRowsFormat.
+	rowDiffs []*utils.RowDiff
+	// keyCollisions are the keys existing in multiple upstream shards, keyCollisionCount counts all of them.
+	keyCollisions     []*utils.KeyCollision
+	keyCollisionCount int64
+	// applied is true if the sqls have been applied to the downstream by repair,
+	// they are written to the applied sql file instead of the fix sql file.
+	applied bool
 }
 
+// appliedSQLFileSuffix is the suffix of the files of the fix sql applied by repair.
+const appliedSQLFileSuffix = ".applied"
+
 // Diff contains two sql DB, used for comparing.
 type Diff struct {
 	// we may have multiple sources in dm sharding sync.
@@ -67,35 +91,100 @@ type Diff struct {
 	// workSource is one of upstream/downstream by some policy in #pickSource.
 	workSource source.Source
 
-	sample           int
 	checkThreadCount int
 	exportFixSQL     bool
-	useCheckpoint    bool
-	ignoreDataCheck  bool
-	sqlWg            sync.WaitGroup
-	checkpointWg     sync.WaitGroup
+	diffRowsFormat   string
+	// checkStructDetail compares every attribute of the table struct, and exportStructFixSQL
+	// writes the ALTER TABLE statements fixing the differences.
+	checkStructDetail  bool
+	exportStructFixSQL bool
+	useCheckpoint      bool
+	ignoreDataCheck    bool
+	repair             bool
+	repairDryRun       bool
+	recheck            bool
+	recheckTimes       int
+	recheckInterval    time.Duration
+	recheckWindow      time.Duration
+	sqlWg              sync.WaitGroup
+	checkpointWg       sync.WaitGroup
+	// writeSQLErr is the first error of writing the fix sql or the different rows, the chunk
+	// isn't saved in the checkpoint if its files fail to be written. It's read after sqlWg is done.
+	writeSQLErr error
+
+	// tableChecksum compares the table checksums of the tables before checking their chunks if both sides support it.
+	tableChecksum bool
+	// structEqualTables are the indices of the tables whose struct are equal.
+	structEqualTables map[int]struct{}
 
 	FixSQLDir     string
 	CheckpointDir string
+	// outputDir is where the rows of the keyless tables are spilled when comparing them.
+	outputDir string
 
 	sqlCh      chan *ChunkDML
 	cp         *checkpoints.Checkpoint
+	cpStorage  checkpoints.Storage // saves the checkpoint and the recheck state
 	startRange *splitter.RangeInfo
 	report     *report.Report
+	// cpDB is the connection of the db storage, it doesn't read the snapshot so that it can write.
+	cpDB *sql.DB
+
+	// beginTime is the time the data check begins, the rows updated
+	// after it will be checked again in the next recheck.
+	beginTime     time.Time
+	lastCheckTime map[string]time.Time
+	failedMu      sync.Mutex
+	failedTables  map[int]struct{}
+
+	// sampleRand picks the chunks to check with the probability sampleRate in the sampling mode,
+	// it is nil if all the chunks are checked.
+	sampleRand *rand.Rand
+	sampleRate float64
+
+	status       *checkStatus
+	statusServer *http.Server
+	// coordinator hands the chunks to the workers in the distributed mode, it is nil
+	// if the chunks are compared by this process.
+	coordinator *coordinator
+
+	// throttler limits the load of the check on the instances, it is nil if the load isn't limited.
+	throttler *throttle.Throttler
+	// avgRowLengths caches the average row length of the tables to estimate the bytes checked.
+	avgRowLengths sync.Map
+
+	// budgetDeadline is when the time budget of the check expires, it is zero if there is no budget.
+	// budgetExpired is true if the check stops because of it, the checkpoint is kept then.
+	budgetDeadline time.Time
+	budgetExpired  bool
 }
 
 // NewDiff returns a Diff instance.
 func NewDiff(ctx context.Context, cfg *config.Config) (diff *Diff, err error) {
 	diff = &Diff{
-		checkThreadCount: cfg.CheckThreadCount,
-		exportFixSQL:     cfg.ExportFixSQL,
-		ignoreDataCheck:  cfg.CheckStructOnly,
-		sqlCh:            make(chan *ChunkDML, splitter.DefaultChannelBuffer),
-		cp:               new(checkpoints.Checkpoint),
-		report:           report.NewReport(&cfg.Task),
+		checkThreadCount:   cfg.CheckThreadCount,
+		exportFixSQL:       cfg.ExportFixSQL,
+		diffRowsFormat:     cfg.DiffRowsFormat,
+		checkStructDetail:  cfg.CheckStructDetail,
+		exportStructFixSQL: cfg.ExportStructFixSQL,
+		ignoreDataCheck:    cfg.CheckStructOnly,
+		tableChecksum:      !cfg.SkipTableChecksum,
+		structEqualTables:  make(map[int]struct{}),
+		repair:             cfg.Repair || cfg.RepairDryRun,
+		repairDryRun:       cfg.RepairDryRun,
+		recheck:            cfg.Recheck,
+		recheckTimes:       defaultRecheckTimes,
+		recheckInterval:    defaultRecheckInterval,
+		recheckWindow:      defaultRecheckWindow,
+		failedTables:       make(map[int]struct{}),
+		status:             &checkStatus{StartTime: time.Now()},
+		sqlCh:              make(chan *ChunkDML, splitter.DefaultChannelBuffer),
+		cp:                 new(checkpoints.Checkpoint),
+		report:             report.NewReport(&cfg.Task),
 	}
 	if err = diff.init(ctx, cfg); err != nil {
-		diff.Close()
+		// keep the checkpoint of the last check.
+		diff.release()
 		return nil, errors.Trace(err)
 	}
 
@@ -105,30 +194,58 @@ func NewDiff(ctx context.Context, cfg *config.Config) (diff *Diff, err error) {
 func (df *Diff) PrintSummary(ctx context.Context) bool {
 	// Stop updating progress bar so that summary won't be flushed.
 	progress.Close()
-	df.report.CalculateTotalSize(ctx, df.downstream.GetDB())
-	err := df.report.CommitSummary()
-	if err != nil {
+	if err := df.commitSummary(ctx); err != nil {
 		log.Fatal("failed to commit report", zap.Error(err))
 	}
 	df.report.Print(os.Stdout)
 	return df.report.Result == report.Pass
 }
 
-func (df *Diff) Close() {
-	if df.upstream != nil {
-		df.upstream.Close()
-	}
-	if df.downstream != nil {
-		df.downstream.Close()
+// commitSummary writes the summary and the reports to the output dir.
+func (df *Diff) commitSummary(ctx context.Context) error {
+	df.report.CalculateTotalSize(ctx, df.downstream.GetDB())
+	return errors.Trace(df.report.CommitSummary())
+}
+
+// Close releases the resources and removes the checkpoint after the check finishes,
+// the checkpoint is kept if the check stops because the time budget expires.
+func (df *Diff) Close() error {
+	// the checkpoint may be saved in the target instance, so remove it before closing the sources.
+	defer df.release()
+
+	if df.budgetExpired {
+		log.Info("the time budget expired, keep the checkpoint to continue the check next time")
+		return nil
 	}
 
 	failpoint.Inject("wait-for-checkpoint", func() {
 		log.Info("failpoint wait-for-checkpoint injected, skip delete checkpoint file.")
-		failpoint.Return()
+		failpoint.Return(nil)
 	})
 
-	if err := os.Remove(filepath.Join(df.CheckpointDir, checkpointFile)); err != nil && !os.IsNotExist(err) {
-		log.Fatal("fail to remove the checkpoint file", zap.String("error", err.Error()))
+	return errors.Annotate(df.cpStorage.Remove(context.Background(), checkpointFile), "fail to remove the checkpoint")
+}
+
+// release closes the sources and the status server, the checkpoint is kept so that
+// the check can be resumed.
+func (df *Diff) release() {
+	df.stopStatusServer()
+	if df.coordinator != nil {
+		df.coordinator.close()
+	}
+	if df.cpStorage != nil {
+		if err := df.cpStorage.Close(); err != nil {
+			log.Warn("fail to close the checkpoint storage", zap.Error(err))
+		}
+	}
+	if df.cpDB != nil {
+		df.cpDB.Close()
+	}
+	if df.upstream != nil {
+		df.upstream.Close()
+	}
+	if df.downstream != nil {
+		df.downstream.Close()
 	}
 }
 
@@ -136,43 +253,227 @@ func (df *Diff) init(ctx context.Context, cfg *config.Config) (err error) {
 	// TODO adjust config
 	setTiDBCfg()
 
-	df.downstream, df.upstream, err = source.NewSources(ctx, cfg)
+	if len(cfg.Task.TimeBudget) != 0 {
+		budget, err := time.ParseDuration(cfg.Task.TimeBudget)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		df.budgetDeadline = time.Now().Add(budget)
+	}
+	// the checkpoint is identified by the configured snapshots, so the storage
+	// is created before the snapshots are resolved by waiting for the consistency.
+	if df.cpStorage, df.cpDB, err = openCheckpointStorage(ctx, cfg); err != nil {
+		return errors.Trace(err)
+	}
+	if err = df.waitForConsistency(ctx, cfg); err != nil {
+		return errors.Trace(err)
+	}
+	order, err := loadTableOrder(ctx, cfg, df.cpStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	df.downstream, df.upstream, err = source.NewSources(ctx, cfg, order)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	df.cp.SetTableOrder(tableIDs(df.downstream.GetTables()))
 
 	df.workSource = df.pickSource(ctx)
 	df.FixSQLDir = cfg.Task.FixDir
 	df.CheckpointDir = cfg.Task.CheckpointDir
+	df.outputDir = cfg.Task.OutputDir
+	if cfg.RecheckTimes > 0 {
+		df.recheckTimes = cfg.RecheckTimes
+	}
+	if len(cfg.RecheckInterval) != 0 {
+		if df.recheckInterval, err = time.ParseDuration(cfg.RecheckInterval); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(cfg.RecheckWindow) != 0 {
+		if df.recheckWindow, err = time.ParseDuration(cfg.RecheckWindow); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	sourceConfigs, targetConfig, err := getConfigsForReport(cfg)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	df.report.Init(df.downstream.GetTables(), sourceConfigs, targetConfig)
-	if err := df.initCheckpoint(); err != nil {
+	df.report.SetTableOrder(df.downstream.GetTables())
+	if cfg.IsSampling() {
+		df.initSample(cfg)
+	}
+	if err := df.initThrottler(ctx, cfg); err != nil {
 		return errors.Trace(err)
 	}
+	if err := df.initCheckpoint(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.StatusAddr) != 0 {
+		if err := df.startStatusServer(cfg.StatusAddr); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(cfg.CoordinatorAddr) != 0 {
+		hash, err := leaseConfigHash(cfg, df.downstream.GetTables())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		snapshots := &snapshotsResponse{TargetSnapshot: cfg.Task.TargetInstance.Snapshot}
+		for _, source := range cfg.Task.SourceInstances {
+			snapshots.SourceSnapshots = append(snapshots.SourceSnapshots, source.Snapshot)
+		}
+		df.coordinator = newCoordinator(df, hash, snapshots)
+		if err := df.coordinator.startServer(cfg.CoordinatorAddr); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
 
-func (df *Diff) initCheckpoint() error {
-	df.cp.Init()
+// openCheckpointStorage creates the storage of the checkpoint, the recheck state and the check history.
+// The connection of the db storage is returned if it's used, it should be closed after the storage.
+func openCheckpointStorage(ctx context.Context, cfg *config.Config) (checkpoints.Storage, *sql.DB, error) {
+	var cpDB *sql.DB
+	if cfg.CheckpointStorage != nil && cfg.CheckpointStorage.Type == config.CheckpointStorageDB {
+		var err error
+		if cpDB, err = common.CreateDBForCP(ctx, *cfg.Task.TargetInstance.ToDBConfig()); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+	}
+	storage, err := checkpoints.NewStorage(ctx, cfg, cpDB)
+	if err != nil {
+		if cpDB != nil {
+			cpDB.Close()
+		}
+		return nil, nil, errors.Trace(err)
+	}
+	return storage, cpDB, nil
+}
 
-	finishTableNums := 0
-	path := filepath.Join(df.CheckpointDir, checkpointFile)
-	if ioutil2.FileExists(path) {
-		node, reportInfo, err := df.cp.LoadChunk(path)
+// loadTableOrder returns the order to check the tables. The chunks in the checkpoint and the failed chunks in
+// the recheck state are identified by the indices of the tables, so the check resumed from the checkpoint and
+// the recheck keep the order of the last check. Otherwise the history is used if `prioritize-stale-tables` is set.
+func loadTableOrder(ctx context.Context, cfg *config.Config, storage checkpoints.Storage) (*source.TableOrder, error) {
+	order := &source.TableOrder{}
+	savedState, err := checkpoints.LoadSavedState(ctx, storage, checkpointFile)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, errors.Annotate(err, "the checkpoint load process failed")
+	}
+	if savedState != nil && len(savedState.TableOrder) != 0 {
+		order.Tables = savedState.TableOrder
+		return order, nil
+	}
+	if cfg.Recheck {
+		state, err := checkpoints.LoadRecheckState(ctx, storage, recheckStateFile)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, errors.Annotate(err, "the recheck state load process failed")
+		}
+		if state != nil && len(state.TableOrder) != 0 {
+			order.Tables = state.TableOrder
+			return order, nil
+		}
+	}
+	if cfg.Task.PrioritizeStaleTables {
+		history, err := checkpoints.LoadHistory(ctx, storage, historyFile)
 		if err != nil {
-			return errors.Annotate(err, "the checkpoint load process failed")
-		} else {
-			// this need not be synchronized, because at the moment, the is only one thread access the section
-			log.Info("load checkpoint",
-				zap.Any("chunk index", node.GetID()),
-				zap.Reflect("chunk", node),
-				zap.String("state", node.GetState()))
-			df.cp.InitCurrentSavedID(node)
+			return nil, errors.Annotate(err, "failed to load the check history")
+		}
+		order.LastVerified = history.Tables
+	}
+	return order, nil
+}
+
+// tableIDs returns the unique IDs of the tables in order.
+func tableIDs(tables []*common.TableDiff) []string {
+	ids := make([]string, 0, len(tables))
+	for _, table := range tables {
+		ids = append(ids, utils.UniqueID(table.Schema, table.Table))
+	}
+	return ids
+}
+
+// waitForConsistency waits for the instances to be consistent if `consistency` is configured. The snapshots
+// resolved by the wait are saved with the checkpoint, the check resumed from it reuses them instead of waiting again.
+func (df *Diff) waitForConsistency(ctx context.Context, cfg *config.Config) error {
+	if cfg.Consistency == nil {
+		return nil
+	}
+	var snapshots *checkpoints.Snapshots
+	savedState, err := checkpoints.LoadSavedState(ctx, df.cpStorage, checkpointFile)
+	if err != nil && !errors.IsNotFound(err) {
+		return errors.Annotate(err, "the checkpoint load process failed")
+	}
+	if savedState != nil {
+		snapshots = savedState.Snapshots
+	}
+	if snapshots != nil {
+		if len(snapshots.Source) != len(cfg.Task.SourceInstances) {
+			return errors.Errorf("the checkpoint has the snapshots of %d source instances, but there are %d", len(snapshots.Source), len(cfg.Task.SourceInstances))
+		}
+		for i, source := range cfg.Task.SourceInstances {
+			source.Snapshot = snapshots.Source[i]
 		}
+		cfg.Task.TargetInstance.Snapshot = snapshots.Target
+		log.Info("resume with the snapshots saved in the checkpoint", zap.Strings("source snapshots", snapshots.Source), zap.String("target snapshot", snapshots.Target))
+	} else {
+		if err = source.WaitForConsistency(ctx, cfg); err != nil {
+			return errors.Trace(err)
+		}
+		snapshots = &checkpoints.Snapshots{Target: cfg.Task.TargetInstance.Snapshot}
+		for _, source := range cfg.Task.SourceInstances {
+			snapshots.Source = append(snapshots.Source, source.Snapshot)
+		}
+	}
+	df.cp.SetSnapshots(snapshots)
+	return nil
+}
+
+func (df *Diff) initSample(cfg *config.Config) {
+	df.sampleRate = cfg.SampleRate
+	if df.sampleRate == 0 {
+		df.sampleRate = 1
+	}
+	confidence := cfg.SampleConfidence
+	if confidence == 0 {
+		confidence = defaultSampleConfidence
+	}
+	seed := cfg.SampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Info("check the chunks by sampling", zap.Float64("rate", df.sampleRate), zap.Float64("confidence", confidence),
+		zap.Float64("max inconsistency rate", cfg.SampleMaxInconsistencyRate), zap.Int64("seed", seed))
+	df.sampleRand = rand.New(rand.NewSource(seed))
+	df.report.InitSample(df.sampleRate, confidence, cfg.SampleMaxInconsistencyRate)
+}
+
+// initThrottler starts the throttler if the throttle is configured.
+func (df *Diff) initThrottler(ctx context.Context, cfg *config.Config) (err error) {
+	if df.throttler, err = throttle.NewThrottler(cfg); err != nil || df.throttler == nil {
+		return errors.Trace(err)
+	}
+	go df.throttler.Run(ctx)
+	return nil
+}
+
+func (df *Diff) initCheckpoint(ctx context.Context) error {
+	df.cp.Init()
+
+	finishTableNums := 0
+	node, reportInfo, err := df.cp.LoadChunk(ctx, df.cpStorage, checkpointFile)
+	if err != nil && !errors.IsNotFound(err) {
+		return errors.Annotate(err, "the checkpoint load process failed")
+	}
+	if err == nil {
+		// this need not be synchronized, because at the moment, the is only one thread access the section
+		log.Info("load checkpoint",
+			zap.Any("chunk index", node.GetID()),
+			zap.Reflect("chunk", node),
+			zap.String("state", node.GetState()))
+		df.cp.InitCurrentSavedID(node)
 
 		if node != nil {
 			// remove the sql file that ID bigger than node.
@@ -190,7 +491,7 @@ func (df *Diff) initCheckpoint() error {
 			}
 		}
 	} else {
-		log.Info("not found checkpoint file, start from beginning")
+		log.Info("not found checkpoint, start from beginning")
 		id := &chunk.ChunkID{TableIndex: -1, BucketIndexLeft: -1, BucketIndexRight: -1, ChunkIndex: -1, ChunkCnt: 0}
 		err := df.removeSQLFiles(id)
 		if err != nil {
@@ -220,6 +521,7 @@ func getConfigsForReport(cfg *config.Config) ([][]byte, []byte, error) {
 			User:     instance.User,
 			Snapshot: instance.Snapshot,
 			SqlMode:  instance.SqlMode,
+			Dir:      instance.Dir,
 		}
 	}
 	instance := cfg.Task.TargetInstance
@@ -246,7 +548,16 @@ func getConfigsForReport(cfg *config.Config) ([][]byte, []byte, error) {
 }
 
 // Equal tests whether two database have same data and schema.
-func (df *Diff) Equal(ctx context.Context) error {
+func (df *Diff) Equal(ctx context.Context) (err error) {
+	df.beginTime = time.Now()
+	if beginTime := df.cp.GetBeginTime(); df.startRange != nil && !beginTime.IsZero() {
+		// the rows updated before restarting may be in the checked chunks, so the check time begins before it.
+		df.beginTime = beginTime
+	}
+	df.cp.SetBeginTime(df.beginTime)
+	if df.tableChecksum {
+		df.compareTableChecksums(ctx)
+	}
 	chunksIter, err := df.generateChunksIterator(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -260,38 +571,323 @@ func (df *Diff) Equal(ctx context.Context) error {
 	df.sqlWg.Add(1)
 	go df.writeSQLs(ctx)
 
+	if df.coordinator != nil {
+		df.coordinator.start(ctx)
+	}
+
 	defer func() {
 		pool.WaitFinished()
+		if df.coordinator != nil {
+			df.coordinator.wait(ctx)
+		}
 		log.Debug("all consume tasks finished")
 		// close the sql channel
 		close(df.sqlCh)
 		df.sqlWg.Wait()
-		stopCh <- struct{}{}
+		if err == nil && df.writeSQLErr != nil {
+			err = errors.Trace(df.writeSQLErr)
+		}
+		// handleCheckpoints saves the last checkpoint after all the chunks are inserted.
+		close(stopCh)
 		df.checkpointWg.Wait()
 	}()
 
+	// the sampling may stop early, so the chunks of all the tables are sampled in a random order,
+	// otherwise only the first tables are sampled.
+	var shuffled *shuffledChunks
+	if df.report.Sample != nil && df.report.Sample.MaxInconsistencyRate > 0 {
+		if shuffled, err = df.shuffleChunks(ctx, chunksIter); err != nil {
+			return errors.Trace(err)
+		}
+		defer df.setSampleSkipped(ctx, shuffled)
+	}
+	var lastRange *splitter.RangeInfo
 	for {
-		c, err := chunksIter.Next(ctx)
-		if err != nil {
+		if !df.budgetDeadline.IsZero() && time.Now().After(df.budgetDeadline) {
+			log.Warn("the time budget expired, stop checking after the running chunks finish")
+			df.budgetExpired = true
+			if shuffled != nil {
+				df.setShuffledBudgetSkipped(shuffled)
+			} else {
+				df.setBudgetSkipped(lastRange)
+			}
+			break
+		}
+		var c *splitter.RangeInfo
+		if shuffled != nil {
+			c = shuffled.next()
+		} else if c, err = chunksIter.Next(ctx); err != nil {
 			return errors.Trace(err)
 		}
 		if c == nil {
 			// finish read the tables
 			break
 		}
-		log.Info("global consume chunk info", zap.Any("chunk index", c.ChunkRange.Index), zap.Any("chunk bound", c.ChunkRange.Bounds))
-		pool.Apply(func() {
-			isEqual := df.consume(ctx, c)
-			if !isEqual {
-				progress.FailTable(c.ProgressID)
-			}
+		lastRange = c
+		if df.sampleRand != nil && c.ChunkRange.Type != chunk.Empty && df.sampleRand.Float64() >= df.sampleRate {
+			df.skipChunk(c)
 			progress.Inc(c.ProgressID)
-		})
+			continue
+		}
+		if shuffled != nil {
+			shuffled.visit(c)
+		}
+		log.Info("global consume chunk info", zap.Any("chunk index", c.ChunkRange.Index), zap.Any("chunk bound", c.ChunkRange.Bounds))
+		df.status.setCurrentChunk(c)
+		if df.coordinator != nil {
+			// the chunk is compared by a worker.
+			df.coordinator.dispatch(ctx, c)
+		} else {
+			pool.Apply(func() {
+				isEqual := df.consume(ctx, c)
+				if !isEqual {
+					progress.FailTable(c.ProgressID)
+				}
+				progress.Inc(c.ProgressID)
+				df.status.finishChunk(isEqual)
+			})
+		}
+		if df.report.IsSampleReached() {
+			log.Info("the upper bound of the inconsistency rate is below the max inconsistency rate, stop sampling")
+			break
+		}
 	}
 
 	return nil
 }
 
+// setBudgetSkipped marks the tables not fully checked when the time budget expires as skipped,
+// lastRange is the last chunk handed to check.
+func (df *Diff) setBudgetSkipped(lastRange *splitter.RangeInfo) {
+	if lastRange == nil {
+		lastRange = df.startRange
+	}
+	tables := df.downstream.GetTables()
+	tableIndex := 0
+	if lastRange != nil {
+		tableIndex = lastRange.GetTableIndex()
+		if lastRange.ChunkRange.IsLastChunkForTable() {
+			tableIndex++
+		}
+	}
+	df.failedMu.Lock()
+	defer df.failedMu.Unlock()
+	for ; tableIndex < len(tables); tableIndex++ {
+		if tables[tableIndex].IgnoreDataCheck {
+			continue
+		}
+		df.report.SetTableBudgetSkipped(tables[tableIndex].Schema, tables[tableIndex].Table)
+		// keep the check time of the table, it isn't checked in this check.
+		df.failedTables[tableIndex] = struct{}{}
+	}
+}
+
+// shuffledChunks are the chunks of all the tables in a random order for the sampling.
+type shuffledChunks struct {
+	chunks []*splitter.RangeInfo
+	pos    int
+	// tables are the indices of the tables which have chunks to check,
+	// visited are the ones with some chunks checked.
+	tables  map[int]struct{}
+	visited map[int]struct{}
+}
+
+func (s *shuffledChunks) next() *splitter.RangeInfo {
+	if s.pos >= len(s.chunks) {
+		return nil
+	}
+	s.pos++
+	return s.chunks[s.pos-1]
+}
+
+func (s *shuffledChunks) visit(rangeInfo *splitter.RangeInfo) {
+	s.visited[rangeInfo.GetTableIndex()] = struct{}{}
+}
+
+// shuffleChunks splits the chunks of all the tables and shuffles them with the sampling random source.
+func (df *Diff) shuffleChunks(ctx context.Context, chunksIter source.RangeIterator) (*shuffledChunks, error) {
+	shuffled := &shuffledChunks{
+		tables:  make(map[int]struct{}),
+		visited: make(map[int]struct{}),
+	}
+	for {
+		c, err := chunksIter.Next(ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if c == nil {
+			break
+		}
+		shuffled.chunks = append(shuffled.chunks, c)
+		shuffled.tables[c.GetTableIndex()] = struct{}{}
+	}
+	df.sampleRand.Shuffle(len(shuffled.chunks), func(i, j int) {
+		shuffled.chunks[i], shuffled.chunks[j] = shuffled.chunks[j], shuffled.chunks[i]
+	})
+	log.Info("sample the chunks of all the tables in a random order", zap.Int("chunks", len(shuffled.chunks)))
+	return shuffled, nil
+}
+
+// setSampleSkipped marks the chunks not visited when the sampling stops early as skipped, and the tables
+// without any chunk sampled as skipped, so they aren't reported as equal.
+func (df *Diff) setSampleSkipped(ctx context.Context, shuffled *shuffledChunks) {
+	if df.budgetExpired || ctx.Err() != nil {
+		// the chunks not visited are checked when the check continues from the checkpoint.
+		return
+	}
+	for c := shuffled.next(); c != nil; c = shuffled.next() {
+		if c.ChunkRange.Type == chunk.Empty {
+			df.consume(ctx, c)
+			shuffled.visit(c)
+		} else {
+			df.skipChunk(c)
+		}
+		progress.Inc(c.ProgressID)
+	}
+	tables := df.downstream.GetTables()
+	df.failedMu.Lock()
+	defer df.failedMu.Unlock()
+	for tableIndex := range shuffled.tables {
+		if _, ok := shuffled.visited[tableIndex]; ok || tables[tableIndex].IgnoreDataCheck {
+			continue
+		}
+		log.Info("no chunk of the table is sampled", zap.String("table", dbutil.TableName(tables[tableIndex].Schema, tables[tableIndex].Table)))
+		df.report.SetTableSampleSkipped(tables[tableIndex].Schema, tables[tableIndex].Table)
+		df.failedTables[tableIndex] = struct{}{}
+	}
+}
+
+// setShuffledBudgetSkipped marks the tables with the chunks not visited when the time budget expires as skipped.
+func (df *Diff) setShuffledBudgetSkipped(shuffled *shuffledChunks) {
+	tables := df.downstream.GetTables()
+	df.failedMu.Lock()
+	defer df.failedMu.Unlock()
+	for _, c := range shuffled.chunks[shuffled.pos:] {
+		table := tables[c.GetTableIndex()]
+		if table.IgnoreDataCheck {
+			continue
+		}
+		df.report.SetTableBudgetSkipped(table.Schema, table.Table)
+		df.failedTables[c.GetTableIndex()] = struct{}{}
+	}
+}
+
+// compareTableChecksums compares the table checksums of the tables before checking their chunks if both sides are
+// TiDB, the tables with the same physical IDs and the same checksums are equal, so their chunks aren't checked.
+// The checksums can't tell the different rows, so the other tables are still checked by the chunks. The tables
+// restored by BR or replicated by TiCDC have new IDs, so the table checksums are never compared for them, the
+// tables not compared are logged once with the reasons after comparing.
+func (df *Diff) compareTableChecksums(ctx context.Context) {
+	upstream, ok1 := df.upstream.(source.TableChecksumSource)
+	downstream, ok2 := df.downstream.(source.TableChecksumSource)
+	if !ok1 || !ok2 {
+		return
+	}
+	if len(df.upstream.GetSnapshot()) != 0 || len(df.downstream.GetSnapshot()) != 0 {
+		log.Info("skip comparing the table checksums, because they read the latest data instead of the snapshot")
+		return
+	}
+	tables := df.downstream.GetTables()
+	tableIndex := 0
+	if df.startRange != nil {
+		// the chunks of the table in the checkpoint are being checked.
+		tableIndex = df.startRange.GetTableIndex() + 1
+	}
+	// skipped maps the reasons to the tables whose table checksums aren't compared.
+	skipped := make(map[string][]string)
+	matched := 0
+	defer func() {
+		if len(skipped) == 0 {
+			return
+		}
+		fields := []zap.Field{zap.Int("matched", matched)}
+		for reason, names := range skipped {
+			fields = append(fields, zap.Strings(reason, names))
+		}
+		log.Info("the table checksums of some tables are not compared, their chunks are checked instead", fields...)
+	}()
+	for ; tableIndex < len(tables); tableIndex++ {
+		if !df.budgetDeadline.IsZero() && time.Now().After(df.budgetDeadline) {
+			return
+		}
+		table := tables[tableIndex]
+		name := dbutil.TableName(table.Schema, table.Table)
+		if _, ok := df.structEqualTables[tableIndex]; !ok {
+			skipped["different table structures"] = append(skipped["different table structures"], name)
+			continue
+		}
+		if !tableChecksumComparable(table) {
+			skipped["rows transformed or excluded"] = append(skipped["rows transformed or excluded"], name)
+			continue
+		}
+		isEqual, isComparable, err := compareTableChecksum(ctx, upstream, downstream, tableIndex)
+		if err != nil {
+			log.Warn("fail to compare the table checksum, check the chunks instead", zap.String("table", name), zap.Error(err))
+			skipped["failed to compare"] = append(skipped["failed to compare"], name)
+			continue
+		}
+		if !isComparable {
+			// the tables restored by BR or created by TiCDC usually have new IDs.
+			skipped["different table IDs"] = append(skipped["different table IDs"], name)
+			continue
+		}
+		if isEqual {
+			log.Info("the table checksums are equal, skip checking the chunks", zap.String("table", name))
+			table.TableChecksumMatched = true
+			df.report.SetTableChecksumMatched(table.Schema, table.Table)
+			matched++
+		}
+	}
+}
+
+// tableChecksumComparable returns true if the data of the table is equal when its table checksums are equal,
+// it's false if the rows are transformed or excluded on one side only before comparing.
+func tableChecksumComparable(table *common.TableDiff) bool {
+	return !table.HasColumnTransforms() && len(table.UpstreamExclude) == 0 && len(table.DownstreamExclude) == 0
+}
+
+// compareTableChecksum returns true if the upstream table and the downstream table have the same table checksum.
+// The checksums are only comparable if the tables have the same physical IDs, it doesn't compute the checksums
+// and returns isComparable false if the IDs are different.
+func compareTableChecksum(ctx context.Context, upstream, downstream source.TableChecksumSource, tableIndex int) (isEqual bool, isComparable bool, err error) {
+	upstreamIDs, err := upstream.GetTablePhysicalIDs(ctx, tableIndex)
+	if err != nil {
+		return false, false, errors.Trace(err)
+	}
+	downstreamIDs, err := downstream.GetTablePhysicalIDs(ctx, tableIndex)
+	if err != nil {
+		return false, false, errors.Trace(err)
+	}
+	if !reflect.DeepEqual(upstreamIDs, downstreamIDs) {
+		return false, false, nil
+	}
+
+	var (
+		wg                 sync.WaitGroup
+		upstreamChecksum   *utils.TableChecksum
+		downstreamChecksum *utils.TableChecksum
+		upstreamErr        error
+		downstreamErr      error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		upstreamChecksum, upstreamErr = upstream.GetTableChecksum(ctx, tableIndex)
+	}()
+	go func() {
+		defer wg.Done()
+		downstreamChecksum, downstreamErr = downstream.GetTableChecksum(ctx, tableIndex)
+	}()
+	wg.Wait()
+	if upstreamErr != nil {
+		return false, true, errors.Trace(upstreamErr)
+	}
+	if downstreamErr != nil {
+		return false, true, errors.Trace(downstreamErr)
+	}
+	return *upstreamChecksum == *downstreamChecksum, true, nil
+}
+
 func (df *Diff) StructEqual(ctx context.Context) error {
 	tables := df.downstream.GetTables()
 	tableIndex := 0
@@ -303,6 +899,9 @@ func (df *Diff) StructEqual(ctx context.Context) error {
 		if err != nil {
 			return errors.Trace(err)
 		}
+		if isEqual {
+			df.structEqualTables[tableIndex] = struct{}{}
+		}
 		progress.RegisterTable(dbutil.TableName(tables[tableIndex].Schema, tables[tableIndex].Table), !isEqual, isSkip)
 		df.report.SetTableStructCheckResult(tables[tableIndex].Schema, tables[tableIndex].Table, isEqual, isSkip)
 	}
@@ -315,11 +914,55 @@ func (df *Diff) compareStruct(ctx context.Context, tableIndex int) (isEqual bool
 		return false, true, errors.Trace(err)
 	}
 	table := df.downstream.GetTables()[tableIndex]
+	// compare the detail first, because CompareStruct removes the different indices from the table infos.
+	var structDiffs []*utils.StructDiff
+	if df.checkStructDetail {
+		structDiffs, err = df.compareStructDetail(sourceTableInfos, table)
+		if err != nil {
+			return false, true, errors.Trace(err)
+		}
+	}
 	isEqual, isSkip = utils.CompareStruct(sourceTableInfos, table.Info)
+	isEqual = isEqual && len(structDiffs) == 0
 	table.IgnoreDataCheck = isSkip
 	return isEqual, isSkip, nil
 }
 
+// compareStructDetail compares every attribute of the struct of the source tables and the target table,
+// and writes the ALTER TABLE statements changing the target table to the first source table.
+func (df *Diff) compareStructDetail(sourceTableInfos []*model.TableInfo, table *common.TableDiff) ([]*utils.StructDiff, error) {
+	structDiffs := make([]*utils.StructDiff, 0)
+	// the shards usually have the same struct, so the same differences are reported once.
+	seen := make(map[string]struct{})
+	for _, sourceTableInfo := range sourceTableInfos {
+		for _, diff := range utils.CompareStructDetail(sourceTableInfo, table.Info) {
+			if _, ok := seen[diff.String()]; !ok {
+				seen[diff.String()] = struct{}{}
+				structDiffs = append(structDiffs, diff)
+			}
+		}
+	}
+	df.report.SetTableStructDiffs(table.Schema, table.Table, structDiffs)
+	for _, diff := range structDiffs {
+		log.Warn("table struct is different", zap.String("table", dbutil.TableName(table.Schema, table.Table)), zap.Stringer("diff", diff))
+	}
+	if !df.exportStructFixSQL || len(structDiffs) == 0 {
+		return structDiffs, nil
+	}
+
+	sqls := utils.GenerateAlterSQLs(table.Schema, sourceTableInfos[0], table.Info)
+	fileName := fmt.Sprintf("%s:%s.alter.sql", table.Schema, table.Table)
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("-- table: %s.%s\n", table.Schema, table.Table))
+	for _, sql := range sqls {
+		content.WriteString(sql + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(df.FixSQLDir, fileName), []byte(content.String()), config.LocalFilePerm); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return structDiffs, nil
+}
+
 func (df *Diff) startGCKeeperForTiDB(ctx context.Context, db *sql.DB, snap string) {
 	pdCli, _ := utils.GetPDClientForGC(ctx, db)
 	if pdCli != nil {
@@ -351,16 +994,36 @@ func (df *Diff) startGCKeeperForTiDB(ctx context.Context, db *sql.DB, snap strin
 
 // pickSource pick one proper source to do some work. e.g. generate chunks
 func (df *Diff) pickSource(ctx context.Context) source.Source {
-	workSource := df.downstream
-	if ok, _ := dbutil.IsTiDB(ctx, df.upstream.GetDB()); ok {
+	return pickWorkSource(ctx, df.upstream, df.downstream, func(s source.Source) {
+		df.startGCKeeperForTiDB(ctx, s.GetDB(), s.GetSnapshot())
+	})
+}
+
+// pickWorkSource picks the source to generate the chunks, onTiDB is called for every TiDB source.
+func pickWorkSource(ctx context.Context, upstream, downstream source.Source, onTiDB func(source.Source)) source.Source {
+	workSource := downstream
+	if upstream.GetDB() == nil {
+		// the upstream reads the dump files, so only the downstream can split the chunks.
+		log.Info("The upstream has no db connection. pick the downstream as work source")
+	} else if ok, _ := dbutil.IsTiDB(ctx, upstream.GetDB()); ok {
 		log.Info("The upstream is TiDB. pick it as work source candidate")
-		df.startGCKeeperForTiDB(ctx, df.upstream.GetDB(), df.upstream.GetSnapshot())
-		workSource = df.upstream
+		onTiDB(upstream)
+		workSource = upstream
 	}
-	if ok, _ := dbutil.IsTiDB(ctx, df.downstream.GetDB()); ok {
+	if ok, _ := dbutil.IsTiDB(ctx, downstream.GetDB()); ok {
 		log.Info("The downstream is TiDB. pick it as work source first")
-		df.startGCKeeperForTiDB(ctx, df.downstream.GetDB(), df.downstream.GetSnapshot())
-		workSource = df.downstream
+		onTiDB(downstream)
+		workSource = downstream
+	}
+	if workSource == upstream {
+		for _, table := range upstream.GetTables() {
+			if table.HasColumnTransforms() {
+				// the chunks should be split by the transformed values.
+				log.Info("The upstream values are transformed. pick the downstream as work source")
+				workSource = downstream
+				break
+			}
+		}
 	}
 	return workSource
 }
@@ -377,7 +1040,7 @@ func (df *Diff) handleCheckpoints(ctx context.Context, stopCh chan struct{}) {
 		log.Info("close handleCheckpoint goroutine")
 		df.checkpointWg.Done()
 	}()
-	flush := func() {
+	flush := func(ctx context.Context) {
 		chunk := df.cp.GetChunkSnapshot()
 		if chunk != nil {
 			tableDiff := df.downstream.GetTables()[chunk.GetTableIndex()]
@@ -386,78 +1049,223 @@ func (df *Diff) handleCheckpoints(ctx context.Context, stopCh chan struct{}) {
 			if err != nil {
 				log.Warn("fail to save the report", zap.Error(err))
 			}
-			_, err = df.cp.SaveChunk(ctx, filepath.Join(df.CheckpointDir, checkpointFile), chunk, r)
+			df.status.setCheckpoint(chunk, r)
+			_, err = df.cp.SaveChunk(ctx, df.cpStorage, checkpointFile, chunk, r)
 			if err != nil {
 				log.Warn("fail to save the chunk", zap.Error(err))
 				// maybe we should panic, because SaveChunk method should not failed.
 			}
 		}
 	}
-	defer flush()
+	defer func() {
+		// the context may be cancelled, so the last checkpoint is saved with a new context,
+		// otherwise the check can't resume from the chunks finished since the last flush.
+		flushCtx, cancel := context.WithTimeout(context.Background(), checkpointFlushTimeout)
+		defer cancel()
+		flush(flushCtx)
+	}()
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info("Stop do checkpoint by context done")
+			// the running chunks are still being inserted into the checkpoint until stopCh is closed.
+			<-stopCh
 			return
 		case <-stopCh:
 			log.Info("Stop do checkpoint")
 			return
 		case <-time.After(10 * time.Second):
-			flush()
+			flush(ctx)
 		}
 	}
 }
 
+// chunkResult is the result of comparing a chunk, the workers send it to the coordinator in the distributed mode.
+type chunkResult struct {
+	Equal bool `json:"equal"`
+	// RowsCompared is true if the rows are compared without error, so the fix sql is complete.
+	RowsCompared bool     `json:"rows-compared,omitempty"`
+	SQLs         []string `json:"sqls,omitempty"`
+	RowsAdd      int      `json:"rows-add"`
+	RowsDelete   int      `json:"rows-delete"`
+	Cost         int64    `json:"cost"` // nanoseconds
+	// LocateQueries is the number of the grouped checksum queries to locate the different rows,
+	// LocateQueriesSaved is the estimated number of the queries saved compared with binary search.
+	LocateQueries      int64            `json:"locate-queries,omitempty"`
+	LocateQueriesSaved int64            `json:"locate-queries-saved,omitempty"`
+	RowDiffs           []*utils.RowDiff `json:"row-diffs,omitempty"`
+	// KeyCollisions are the keys existing in multiple upstream shards, KeyCollisionCount counts all of them.
+	KeyCollisions     []*utils.KeyCollision `json:"key-collisions,omitempty"`
+	KeyCollisionCount int64                 `json:"key-collision-count,omitempty"`
+
+	// err is the last error met when comparing the chunk, ErrMsg is its message sent by the workers.
+	err    error
+	ErrMsg string `json:"error,omitempty"`
+}
+
 func (df *Diff) consume(ctx context.Context, rangeInfo *splitter.RangeInfo) bool {
-	dml := &ChunkDML{
-		node: rangeInfo.ToNode(),
-	}
-	defer func() { df.sqlCh <- dml }()
 	if rangeInfo.ChunkRange.Type == chunk.Empty {
-		dml.node.State = checkpoints.IgnoreState
-		return true
+		return df.finishChunk(ctx, rangeInfo, nil)
 	}
+	return df.finishChunk(ctx, rangeInfo, df.compareChunk(ctx, rangeInfo))
+}
+
+// compareChunk compares the checksum of the chunk, and compares the rows to generate the fix sql if the
+// checksum is different. It only reads the sources, so it can be run by the workers in the distributed mode.
+func (df *Diff) compareChunk(ctx context.Context, rangeInfo *splitter.RangeInfo) *chunkResult {
+	beginTime := time.Now()
+	result := &chunkResult{}
+	defer func() { result.Cost = int64(time.Since(beginTime)) }()
 	tableDiff := df.downstream.GetTables()[rangeInfo.GetTableIndex()]
-	schema, table := tableDiff.Schema, tableDiff.Table
-	var state string = checkpoints.SuccessState
+	if df.throttler != nil {
+		if err := df.throttler.Acquire(ctx); err != nil {
+			result.err = err
+			return result
+		}
+		defer df.throttler.Release()
+	}
 
 	isEqual, count, err := df.compareChecksumAndGetCount(ctx, rangeInfo)
 	if err != nil {
 		// If an error occurs during the checksum phase, skip the data compare phase.
-		state = checkpoints.FailedState
-		df.report.SetTableMeetError(schema, table, err)
-	} else if !isEqual && df.exportFixSQL {
-		state = checkpoints.FailedState
+		result.err = err
+		return result
+	}
+	if df.throttler != nil {
+		if err := df.throttler.WaitRows(ctx, count, count*df.getAvgRowLength(ctx, rangeInfo.GetTableIndex())); err != nil {
+			result.err = err
+			return result
+		}
+	}
+	result.Equal = isEqual
+	if !isEqual && df.exportFixSQL || isEqual && len(tableDiff.Tolerances) != 0 {
+		// the columns with tolerance are excluded from the checksum, so compare the rows even if the checksum is equal.
 		// if the chunk's checksum differ, try to do binary check
 		info := rangeInfo
-		if count > splitter.SplitThreshold {
-			log.Debug("count greater than threshold, start do bingenerate", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int64("chunk size", count))
-			info, err = df.BinGenerate(ctx, df.workSource, rangeInfo, count)
+		if !isEqual && count > splitter.SplitThreshold {
+			// the grouped checksums are calculated by SQL, so it doesn't work with the in-process checksum.
+			if tableDiff.LocateStrategy == config.LocateStrategyGroupedChecksum && tableDiff.ChecksumAlgorithm != utils.ChecksumInProcess && df.supportGroupedChecksum() {
+				log.Debug("count greater than threshold, start to locate by grouped checksums", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int64("chunk size", count))
+				var rows int64
+				info, rows, result.LocateQueries, err = df.locateByGroupedChecksum(ctx, rangeInfo, count)
+				if err == nil {
+					result.LocateQueriesSaved = estimateBinarySearchQueries(count, rows) - result.LocateQueries
+				}
+			} else {
+				log.Debug("count greater than threshold, start do bingenerate", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int64("chunk size", count))
+				info, err = df.BinGenerate(ctx, df.workSource, rangeInfo, count)
+			}
 			if err != nil {
-				log.Error("fail to do binary search.", zap.Error(err))
-				df.report.SetTableMeetError(schema, table, err)
+				log.Error("fail to locate the different rows.", zap.Error(err))
+				result.err = err
 				// reuse rangeInfo to compare data
 				info = rangeInfo
 			} else {
 				log.Debug("bin generate finished", zap.Reflect("chunk", info.ChunkRange), zap.Any("chunk id", info.ChunkRange.Index))
 			}
 		}
-		isDataEqual, err := df.compareRows(ctx, info, dml)
+		dml := &ChunkDML{}
+		isDataEqual, err := df.compareRows(ctx, info, dml, count)
 		if err != nil {
-			df.report.SetTableMeetError(schema, table, err)
+			result.err = err
+		} else {
+			result.RowsCompared = true
+		}
+		result.Equal = isEqual && isDataEqual
+		result.RowsAdd, result.RowsDelete = dml.rowAdd, dml.rowDelete
+		result.KeyCollisions, result.KeyCollisionCount = dml.keyCollisions, dml.keyCollisionCount
+		if df.exportFixSQL {
+			result.SQLs = dml.sqls
+			result.RowDiffs = dml.rowDiffs
+		}
+	}
+	return result
+}
+
+// finishChunk records the result of the chunk in the report and the checkpoint, and repairs the chunk
+// if it is different. The result is nil if the chunk is empty.
+func (df *Diff) finishChunk(ctx context.Context, rangeInfo *splitter.RangeInfo, result *chunkResult) bool {
+	dml := &ChunkDML{
+		node: rangeInfo.ToNode(),
+	}
+	defer func() {
+		if ctx.Err() != nil {
+			// the check is cancelled and the chunk may not be compared completely,
+			// so it isn't saved in the checkpoint and will be checked again after resuming.
+			return
+		}
+		df.sqlCh <- dml
+	}()
+	if result == nil {
+		dml.node.State = checkpoints.IgnoreState
+		return true
+	}
+	tableDiff := df.downstream.GetTables()[rangeInfo.GetTableIndex()]
+	schema, table := tableDiff.Schema, tableDiff.Table
+	df.report.AddTableCheckCost(schema, table, time.Duration(result.Cost))
+	if result.LocateQueries > 0 {
+		df.report.AddTableLocateQueries(schema, table, result.LocateQueries, result.LocateQueriesSaved)
+	}
+	if result.KeyCollisionCount > 0 {
+		log.Warn("the same keys exist in multiple upstream shards", zap.String("table", dbutil.TableName(schema, table)), zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int64("keys", result.KeyCollisionCount))
+		df.report.AddTableKeyCollisions(schema, table, result.KeyCollisions, result.KeyCollisionCount)
+	}
+	isEqual := result.Equal
+	if df.sampleRand != nil {
+		// the repaired chunks are counted as inconsistent too.
+		defer func() { df.report.AddSampledChunk(isEqual) }()
+	}
+	if result.err != nil {
+		df.report.SetTableMeetError(schema, table, result.err)
+	}
+	dml.sqls, dml.rowAdd, dml.rowDelete = result.SQLs, result.RowsAdd, result.RowsDelete
+	dml.rowDiffs = result.RowDiffs
+
+	metrics.ChunksCompared.Inc()
+	var state string = checkpoints.SuccessState
+	if !isEqual {
+		state = checkpoints.FailedState
+		if result.RowsCompared && df.repair {
+			isRepaired, err := df.repairChunk(ctx, rangeInfo, dml)
+			if err != nil {
+				log.Warn("fail to repair the chunk", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Error(err))
+				df.report.SetTableMeetError(schema, table, err)
+			}
+			if isRepaired {
+				state = checkpoints.SuccessState
+				dml.node.State = state
+				df.report.SetTableDataRepairResult(schema, table, dml.rowAdd, dml.rowDelete, rangeInfo.ChunkRange.Index)
+				return true
+			}
 		}
-		isEqual = isEqual && isDataEqual
 	}
 	dml.node.State = state
 	id := rangeInfo.ChunkRange.Index
 	df.report.SetTableDataCheckResult(schema, table, isEqual, dml.rowAdd, dml.rowDelete, id)
+	if !isEqual {
+		metrics.ChunksFailed.Inc()
+		df.report.SetTableDataCheckRange(schema, table, id, rangeInfo.ChunkRange.ToMeta())
+		df.recordFailedChunk(rangeInfo)
+	}
 	return isEqual
 }
 
+// skipChunk marks the chunk isn't picked in the sampling mode, so that the checkpoint can move forward.
+func (df *Diff) skipChunk(rangeInfo *splitter.RangeInfo) {
+	node := rangeInfo.ToNode()
+	node.State = checkpoints.IgnoreState
+	df.report.AddSkippedChunk()
+	df.sqlCh <- &ChunkDML{node: node}
+}
+
 func (df *Diff) BinGenerate(ctx context.Context, targetSource source.Source, tableRange *splitter.RangeInfo, count int64) (*splitter.RangeInfo, error) {
 	if count <= splitter.SplitThreshold {
 		return tableRange, nil
 	}
+	if tableRange.ChunkRange.Type == chunk.Others {
+		// the range isn't built from bounds, so it can't be split.
+		return tableRange, nil
+	}
 	tableDiff := targetSource.GetTables()[tableRange.GetTableIndex()]
 	indices := dbutil.FindAllIndex(tableDiff.Info)
 	// if no index, do not split
@@ -530,10 +1338,8 @@ func (df *Diff) binSearch(ctx context.Context, targetSource source.Source, table
 		return nil, errors.Trace(err)
 	}
 	if count1+count2 != count {
-		log.Fatal("the count is not correct",
-			zap.Int64("count1", count1),
-			zap.Int64("count2", count2),
-			zap.Int64("count", count))
+		// the rows may be changed during the check.
+		return nil, errors.Errorf("the count of the split chunks is not correct, count1: %d, count2: %d, count: %d", count1, count2, count)
 	}
 	log.Info("chunk split successfully",
 		zap.Any("chunk id", tableRange.ChunkRange.Index),
@@ -555,10 +1361,152 @@ func (df *Diff) binSearch(ctx context.Context, targetSource source.Source, table
 		}
 		return c, nil
 	} else {
-		// TODO: handle the error to foreground
-		log.Fatal("the isEqual1 and isEqual2 cannot be both true")
-		return nil, nil
+		// the rows may be changed during the check.
+		return nil, errors.Errorf("both of the split chunks are equal, but the chunk %s is different", tableRange.ChunkRange.ToMeta())
+	}
+}
+
+const (
+	// groupedChecksumFanout is the number of the buckets each bucket is split into at the next level.
+	groupedChecksumFanout = 16
+	// groupedChecksumMaxBuckets limits the buckets in the condition of the located chunk.
+	groupedChecksumMaxBuckets = 256
+	// groupedChecksumMaxModulus is the range of CRC32, the buckets can't be split any more.
+	groupedChecksumMaxModulus = 1 << 32
+)
+
+// supportGroupedChecksum returns true if both the upstream and the downstream can compute the grouped checksums.
+func (df *Diff) supportGroupedChecksum() bool {
+	_, ok1 := df.upstream.(source.GroupedChecksumSource)
+	_, ok2 := df.downstream.(source.GroupedChecksumSource)
+	return ok1 && ok2
+}
+
+// locateByGroupedChecksum hashes the rows of the chunk into buckets by the key, and compares the checksums of
+// the buckets level by level like a merkle tree, each level splits the different buckets of the last level into
+// `groupedChecksumFanout` buckets by one query on each side. It returns the chunk limited to the different buckets,
+// the number of the rows in the different buckets and the number of the queries.
+func (df *Diff) locateByGroupedChecksum(ctx context.Context, tableRange *splitter.RangeInfo, count int64) (*splitter.RangeInfo, int64, int64, error) {
+	tableDiff := df.downstream.GetTables()[tableRange.GetTableIndex()]
+	// use the PK/UK, or all the columns if the table doesn't have one.
+	_, keyColumns := dbutil.SelectUniqueOrderKey(tableDiff.Info)
+	upstream := df.upstream.(source.GroupedChecksumSource)
+	downstream := df.downstream.(source.GroupedChecksumSource)
+
+	located := tableRange
+	rows, queries := count, int64(0)
+	modulus := int64(1)
+	for rows > splitter.SplitThreshold && modulus*groupedChecksumFanout <= groupedChecksumMaxModulus {
+		modulus *= groupedChecksumFanout
+		groupExpr := utils.KeyBucketExpr(keyColumns, modulus)
+		var (
+			wg                         sync.WaitGroup
+			upstreamErr, downstreamErr error
+			upstreamGroups             map[int64]*utils.GroupChecksum
+			downstreamGroups           map[int64]*utils.GroupChecksum
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upstreamGroups, upstreamErr = upstream.GetGroupedCountAndCrc32(ctx, located, groupExpr)
+		}()
+		downstreamGroups, downstreamErr = downstream.GetGroupedCountAndCrc32(ctx, located, groupExpr)
+		wg.Wait()
+		queries += 2
+		if upstreamErr != nil {
+			return nil, 0, queries, errors.Trace(upstreamErr)
+		}
+		if downstreamErr != nil {
+			return nil, 0, queries, errors.Trace(downstreamErr)
+		}
+
+		buckets, bucketRows := diffGroups(upstreamGroups, downstreamGroups)
+		if len(buckets) == 0 {
+			// the data is changed during the check, compare the rows of the last level.
+			break
+		}
+		located = tableRange.Copy()
+		located.ChunkRange.Where = fmt.Sprintf("(%s) AND (%s IN (%s))", tableRange.ChunkRange.Where, groupExpr, joinInt64s(buckets))
+		rows = bucketRows
+		log.Debug("locate by grouped checksums",
+			zap.Any("chunk id", tableRange.ChunkRange.Index),
+			zap.Int64("buckets", modulus),
+			zap.Int("different buckets", len(buckets)),
+			zap.Int64("rows", rows))
+		if len(buckets)*groupedChecksumFanout > groupedChecksumMaxBuckets {
+			break
+		}
+	}
+	return located, rows, queries, nil
+}
+
+// diffGroups returns the sorted groups whose count or checksum is different, and the max number of the rows in them.
+func diffGroups(upstreamGroups, downstreamGroups map[int64]*utils.GroupChecksum) ([]int64, int64) {
+	var (
+		groups []int64
+		rows   int64
+	)
+	for group, up := range upstreamGroups {
+		down, ok := downstreamGroups[group]
+		if !ok {
+			groups = append(groups, group)
+			rows += up.Count
+		} else if up.Count != down.Count || up.Checksum != down.Checksum {
+			groups = append(groups, group)
+			if up.Count > down.Count {
+				rows += up.Count
+			} else {
+				rows += down.Count
+			}
+		}
+	}
+	for group, down := range downstreamGroups {
+		if _, ok := upstreamGroups[group]; !ok {
+			groups = append(groups, group)
+			rows += down.Count
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	return groups, rows
+}
+
+func joinInt64s(values []int64) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, strconv.FormatInt(v, 10))
 	}
+	return strings.Join(strs, ", ")
+}
+
+// estimateBinarySearchQueries estimates the queries of binary search to narrow the chunk of `count` rows down to
+// `rows` rows, each level queries the mid values and the checksums of both halves on both sides.
+func estimateBinarySearchQueries(count, rows int64) int64 {
+	if rows < splitter.SplitThreshold {
+		rows = splitter.SplitThreshold
+	}
+	var levels int64
+	for ; count > rows; count = (count + 1) / 2 {
+		levels++
+	}
+	return levels * 5
+}
+
+// getAvgRowLength returns the average row length of the table in the target instance if the bytes checked per second is limited.
+func (df *Diff) getAvgRowLength(ctx context.Context, tableIndex int) int64 {
+	if !df.throttler.LimitsBytes() {
+		return 0
+	}
+	if avgRowLength, ok := df.avgRowLengths.Load(tableIndex); ok {
+		return avgRowLength.(int64)
+	}
+	tableDiff := df.downstream.GetTables()[tableIndex]
+	avgRowLength, err := utils.GetAvgRowLength(ctx, df.downstream.GetDB(), tableDiff.Schema, tableDiff.Table)
+	if err != nil {
+		log.Warn("fail to get the average row length, the bytes of the table aren't limited",
+			zap.String("table", dbutil.TableName(tableDiff.Schema, tableDiff.Table)), zap.Error(err))
+	}
+	df.avgRowLengths.Store(tableIndex, avgRowLength)
+	return avgRowLength
 }
 
 func (df *Diff) compareChecksumAndGetCount(ctx context.Context, tableRange *splitter.RangeInfo) (bool, int64, error) {
@@ -572,6 +1520,12 @@ func (df *Diff) compareChecksumAndGetCount(ctx context.Context, tableRange *spli
 	downstreamInfo = df.downstream.GetCountAndCrc32(ctx, tableRange)
 	wg.Wait()
 
+	metrics.ChecksumDuration.WithLabelValues(metrics.LabelUpstream).Observe(upstreamInfo.Cost.Seconds())
+	metrics.ChecksumDuration.WithLabelValues(metrics.LabelDownstream).Observe(downstreamInfo.Cost.Seconds())
+	if df.throttler != nil {
+		df.throttler.ObserveLatency(metrics.LabelUpstream, upstreamInfo.Cost)
+		df.throttler.ObserveLatency(metrics.LabelDownstream, downstreamInfo.Cost)
+	}
 	if upstreamInfo.Err != nil {
 		log.Warn("failed to compare upstream checksum")
 		return false, -1, errors.Trace(upstreamInfo.Err)
@@ -581,6 +1535,8 @@ func (df *Diff) compareChecksumAndGetCount(ctx context.Context, tableRange *spli
 		return false, -1, errors.Trace(downstreamInfo.Err)
 
 	}
+	metrics.RowsScanned.WithLabelValues(metrics.LabelUpstream).Add(float64(upstreamInfo.Count))
+	metrics.RowsScanned.WithLabelValues(metrics.LabelDownstream).Add(float64(downstreamInfo.Count))
 	// TODO two counts are not necessary equal
 	if upstreamInfo.Count == downstreamInfo.Count && upstreamInfo.Checksum == downstreamInfo.Checksum {
 		return true, upstreamInfo.Count, nil
@@ -589,7 +1545,11 @@ func (df *Diff) compareChecksumAndGetCount(ctx context.Context, tableRange *spli
 	return false, upstreamInfo.Count, nil
 }
 
-func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo, dml *ChunkDML) (bool, error) {
+func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo, dml *ChunkDML, count int64) (bool, error) {
+	tableDiff := df.workSource.GetTables()[rangeInfo.GetTableIndex()]
+	if utils.IsKeyless(tableDiff.Info) && len(tableDiff.Tolerances) == 0 {
+		return df.compareRowMultisets(ctx, rangeInfo, dml, count)
+	}
 	rowsAdd, rowsDelete := 0, 0
 	upstreamRowsIterator, err := df.upstream.GetRowsIterator(ctx, rangeInfo)
 	if err != nil {
@@ -605,7 +1565,7 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 	var lastUpstreamData, lastDownstreamData map[string]*dbutil.ColumnData
 	equal := true
 
-	tableInfo := df.workSource.GetTables()[rangeInfo.GetTableIndex()].Info
+	tableInfo := tableDiff.Info
 	_, orderKeyCols := dbutil.SelectUniqueOrderKey(tableInfo)
 	for {
 		if lastUpstreamData == nil {
@@ -630,6 +1590,7 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 				log.Debug("[delete]", zap.String("sql", sql))
 
 				dml.sqls = append(dml.sqls, sql)
+				df.appendRowDiff(dml, tableDiff, nil, lastDownstreamData, orderKeyCols)
 				equal = false
 				lastDownstreamData, err = downstreamRowsIterator.Next()
 				if err != nil {
@@ -647,6 +1608,7 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 				log.Debug("[insert]", zap.String("sql", sql))
 
 				dml.sqls = append(dml.sqls, sql)
+				df.appendRowDiff(dml, tableDiff, lastUpstreamData, nil, orderKeyCols)
 				equal = false
 
 				lastUpstreamData, err = upstreamRowsIterator.Next()
@@ -657,7 +1619,7 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 			break
 		}
 
-		eq, cmp, err := utils.CompareData(lastUpstreamData, lastDownstreamData, orderKeyCols, tableInfo.Columns)
+		eq, cmp, err := utils.CompareDataWithTolerance(lastUpstreamData, lastDownstreamData, orderKeyCols, tableInfo.Columns, tableDiff.Tolerances)
 		if err != nil {
 			return false, errors.Trace(err)
 		}
@@ -676,12 +1638,14 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 			sql = df.downstream.GenerateFixSQL(source.Delete, lastUpstreamData, lastDownstreamData, rangeInfo.GetTableIndex())
 			rowsDelete++
 			log.Debug("[delete]", zap.String("sql", sql))
+			df.appendRowDiff(dml, tableDiff, nil, lastDownstreamData, orderKeyCols)
 			lastDownstreamData = nil
 		case -1:
 			// insert
 			sql = df.downstream.GenerateFixSQL(source.Insert, lastUpstreamData, lastDownstreamData, rangeInfo.GetTableIndex())
 			rowsAdd++
 			log.Debug("[insert]", zap.String("sql", sql))
+			df.appendRowDiff(dml, tableDiff, lastUpstreamData, nil, orderKeyCols)
 			lastUpstreamData = nil
 		case 0:
 			// update
@@ -689,6 +1653,7 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 			rowsAdd++
 			rowsDelete++
 			log.Debug("[update]", zap.String("sql", sql))
+			df.appendRowDiff(dml, tableDiff, lastUpstreamData, lastDownstreamData, orderKeyCols)
 			lastUpstreamData = nil
 			lastDownstreamData = nil
 		}
@@ -697,9 +1662,91 @@ func (df *Diff) compareRows(ctx context.Context, rangeInfo *splitter.RangeInfo,
 	}
 	dml.rowAdd = rowsAdd
 	dml.rowDelete = rowsDelete
+	if iter, ok := upstreamRowsIterator.(source.KeyCollisionIterator); ok {
+		dml.keyCollisions, dml.keyCollisionCount = iter.GetKeyCollisions()
+	}
 	return equal, nil
 }
 
+// multisetPartitionRows is about the rows of both sides in a partition when comparing the rows of a keyless table.
+const multisetPartitionRows = 1 << 16
+
+// compareRowMultisets compares the rows of a table without the primary key or any unique key as multisets,
+// the identical rows are counted on both sides, and the missing duplicates are inserted and the extra
+// duplicates are deleted by `DELETE ... LIMIT n`. count is the rows of the chunk in the upstream, the rows are
+// spilled to the partition files in the output dir if there are too many rows to count in memory.
+func (df *Diff) compareRowMultisets(ctx context.Context, rangeInfo *splitter.RangeInfo, dml *ChunkDML, count int64) (bool, error) {
+	upstreamRowsIterator, err := df.upstream.GetRowsIterator(ctx, rangeInfo)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer upstreamRowsIterator.Close()
+	downstreamRowsIterator, err := df.downstream.GetRowsIterator(ctx, rangeInfo)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer downstreamRowsIterator.Close()
+
+	tableDiff := df.workSource.GetTables()[rangeInfo.GetTableIndex()]
+	partitions := int(2*count/multisetPartitionRows) + 1
+	dir := ""
+	if partitions > 1 {
+		if dir, err = os.MkdirTemp(df.outputDir, "multiset-"); err != nil {
+			return false, errors.Trace(err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	err = utils.CompareRowMultisets(dir, partitions, tableDiff.Info.Columns, upstreamRowsIterator.Next, downstreamRowsIterator.Next, func(diff *utils.MultisetDiff) error {
+		if diff.UpstreamCount > diff.DownstreamCount {
+			for i := diff.DownstreamCount; i < diff.UpstreamCount; i++ {
+				sql := utils.GenerateInsertDML(diff.Row, tableDiff.Info, tableDiff.Schema)
+				log.Debug("[insert]", zap.String("sql", sql))
+				dml.sqls = append(dml.sqls, sql)
+				df.appendRowDiff(dml, tableDiff, diff.Row, nil, tableDiff.Info.Columns)
+				dml.rowAdd++
+			}
+			return nil
+		}
+		sql := utils.GenerateDeleteDMLWithLimit(diff.Row, tableDiff.Info, tableDiff.Schema, diff.DownstreamCount-diff.UpstreamCount)
+		log.Debug("[delete]", zap.String("sql", sql))
+		dml.sqls = append(dml.sqls, sql)
+		for i := diff.UpstreamCount; i < diff.DownstreamCount; i++ {
+			df.appendRowDiff(dml, tableDiff, nil, diff.Row, tableDiff.Info.Columns)
+			dml.rowDelete++
+		}
+		return nil
+	})
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return dml.rowAdd == 0 && dml.rowDelete == 0, nil
+}
+
+// appendRowDiff appends the different row to the dml if the different rows are written in structured files.
+func (df *Diff) appendRowDiff(dml *ChunkDML, tableDiff *common.TableDiff, upstreamData, downstreamData map[string]*dbutil.ColumnData, orderKeyCols []*model.ColumnInfo) {
+	if len(df.diffRowsFormat) == 0 {
+		return
+	}
+	dml.rowDiffs = append(dml.rowDiffs, utils.NewRowDiff(upstreamData, downstreamData, tableDiff.Info, tableDiff.Schema, orderKeyCols, tableDiff.Tolerances))
+}
+
+// writeRowDiffs writes the different rows of the chunk to the file next to the fix sql file.
+func (df *Diff) writeRowDiffs(fileName string, rowDiffs []*utils.RowDiff) error {
+	write, ext := utils.WriteRowDiffsCSV, ".csv"
+	if df.diffRowsFormat == config.DiffRowsFormatJSON {
+		write, ext = utils.WriteRowDiffsJSON, ".jsonl"
+	}
+	file, err := os.Create(filepath.Join(df.FixSQLDir, fileName+ext))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = write(file, rowDiffs); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(file.Close())
+}
+
 // WriteSQLs write sqls to file
 func (df *Diff) writeSQLs(ctx context.Context) {
 	log.Info("start writeSQLs goroutine")
@@ -707,48 +1754,73 @@ func (df *Diff) writeSQLs(ctx context.Context) {
 		log.Info("close writeSQLs goroutine")
 		df.sqlWg.Done()
 	}()
+	// don't exit when the context is done, the consumers may be sending to the channel,
+	// it will be closed after all the consumers exit.
 	for {
-		select {
-		case <-ctx.Done():
+		dml, ok := <-df.sqlCh
+		if !ok && dml == nil {
+			log.Info("write sql channel closed")
 			return
-		case dml, ok := <-df.sqlCh:
-			if !ok && dml == nil {
-				log.Info("write sql channel closed")
-				return
-			}
-			if len(dml.sqls) > 0 {
-				tableDiff := df.downstream.GetTables()[dml.node.GetTableIndex()]
-				fileName := fmt.Sprintf("%s:%s:%s.sql", tableDiff.Schema, tableDiff.Table, utils.GetSQLFileName(dml.node.GetID()))
-				fixSQLPath := filepath.Join(df.FixSQLDir, fileName)
-				if ok := ioutil2.FileExists(fixSQLPath); ok {
-					// unreachable
-					log.Fatal("write sql failed: repeat sql happen", zap.Strings("sql", dml.sqls))
-				}
-				fixSQLFile, err := os.Create(fixSQLPath)
-				if err != nil {
-					log.Fatal("write sql failed: cannot create file", zap.Strings("sql", dml.sqls), zap.Error(err))
-					continue
-				}
-				// write chunk meta
-				chunkRange := dml.node.ChunkRange
-				fixSQLFile.WriteString(fmt.Sprintf("-- table: %s.%s\n-- %s\n", tableDiff.Schema, tableDiff.Table, chunkRange.ToMeta()))
-				if tableDiff.NeedUnifiedTimeZone {
-					fixSQLFile.WriteString(fmt.Sprintf("set @@session.time_zone = \"%s\";\n", source.UnifiedTimeZone))
-				}
-				for _, sql := range dml.sqls {
-					_, err = fixSQLFile.WriteString(fmt.Sprintf("%s\n", sql))
-					if err != nil {
-						log.Fatal("write sql failed", zap.String("sql", sql), zap.Error(err))
-					}
-				}
-				fixSQLFile.Close()
+		}
+		if err := df.writeChunkFiles(dml); err != nil {
+			// the chunk isn't saved in the checkpoint, so it will be checked again after resuming.
+			log.Error("fail to write the fix sql of the chunk", zap.Any("chunk id", dml.node.GetID()), zap.Error(err))
+			if df.writeSQLErr == nil {
+				df.writeSQLErr = err
 			}
-			log.Debug("insert node", zap.Any("chunk index", dml.node.GetID()))
-			df.cp.Insert(dml.node)
+			continue
 		}
+		log.Debug("insert node", zap.Any("chunk index", dml.node.GetID()))
+		df.cp.Insert(dml.node)
 	}
 }
 
+// writeChunkFiles writes the fix sql and the different rows of the chunk to the fix sql dir.
+func (df *Diff) writeChunkFiles(dml *ChunkDML) error {
+	tableDiff := df.downstream.GetTables()[dml.node.GetTableIndex()]
+	if len(dml.sqls) > 0 {
+		fileName := fmt.Sprintf("%s:%s:%s", tableDiff.Schema, tableDiff.Table, utils.GetSQLFileName(dml.node.GetID()))
+		if dml.applied {
+			// keep the applied sql for auditing, but don't mix it up with the fix sql to be applied.
+			fileName += appliedSQLFileSuffix
+		}
+		fileName += ".sql"
+		fixSQLPath := filepath.Join(df.FixSQLDir, fileName)
+		if ok := ioutil2.FileExists(fixSQLPath); ok {
+			return errors.AlreadyExistsf("fix sql file %s", fixSQLPath)
+		}
+		fixSQLFile, err := os.Create(fixSQLPath)
+		if err != nil {
+			return errors.Annotate(err, "cannot create fix sql file")
+		}
+		defer fixSQLFile.Close()
+		var sqls strings.Builder
+		// write chunk meta
+		chunkRange := dml.node.ChunkRange
+		sqls.WriteString(fmt.Sprintf("-- table: %s.%s\n-- %s\n", tableDiff.Schema, tableDiff.Table, chunkRange.ToMeta()))
+		if dml.applied {
+			sqls.WriteString("-- the following sql has been applied by repair, don't apply it again\n")
+		}
+		if tableDiff.NeedUnifiedTimeZone {
+			sqls.WriteString(fmt.Sprintf("set @@session.time_zone = \"%s\";\n", source.UnifiedTimeZone))
+		}
+		for _, sql := range dml.sqls {
+			sqls.WriteString(fmt.Sprintf("%s\n", sql))
+		}
+		if _, err = fixSQLFile.WriteString(sqls.String()); err != nil {
+			return errors.Annotatef(err, "write fix sql file %s", fixSQLPath)
+		}
+		metrics.FixSQLFilesWritten.Inc()
+	}
+	if len(dml.rowDiffs) > 0 {
+		fileName := fmt.Sprintf("%s:%s:%s", tableDiff.Schema, tableDiff.Table, utils.GetSQLFileName(dml.node.GetID()))
+		if err := df.writeRowDiffs(fileName, dml.rowDiffs); err != nil {
+			return errors.Annotatef(err, "write different rows %s", fileName)
+		}
+	}
+	return nil
+}
+
 func (df *Diff) removeSQLFiles(checkPointId *chunk.ChunkID) error {
 	ts := time.Now().Format("2006-01-02T15:04:05Z07:00")
 	dirName := fmt.Sprintf(".trash-%s", ts)
@@ -784,8 +1856,9 @@ func (df *Diff) removeSQLFiles(checkPointId *chunk.ChunkID) error {
 			return nil
 		}
 
-		if strings.HasSuffix(name, ".sql") {
-			fileIDStr := strings.TrimRight(name, ".sql")
+		if ext := filepath.Ext(name); ext == ".sql" || ext == ".csv" || ext == ".jsonl" {
+			// the different rows are written next to the fix sql files in csv or json lines.
+			fileIDStr := strings.TrimSuffix(strings.TrimSuffix(name, ext), appliedSQLFileSuffix)
 			fileIDSubstrs := strings.SplitN(fileIDStr, ":", 3)
 			if len(fileIDSubstrs) != 3 {
 				return nil
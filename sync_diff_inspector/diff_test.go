@@ -0,0 +1,373 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSource is a source of the given tables, the methods not overridden panic.
+type mockSource struct {
+	source.Source
+	tables []*common.TableDiff
+	db     *sql.DB
+}
+
+func (s *mockSource) GetTables() []*common.TableDiff { return s.tables }
+
+func (s *mockSource) GetDB() *sql.DB { return s.db }
+
+func newChunkNode(tableIndex, chunkIndex int) *checkpoints.Node {
+	chunkRange := chunk.NewChunkRange()
+	chunkRange.Index = &chunk.ChunkID{TableIndex: tableIndex, BucketIndexLeft: 0, BucketIndexRight: 0, ChunkIndex: chunkIndex, ChunkCnt: 3}
+	return &checkpoints.Node{State: checkpoints.FailedState, ChunkRange: chunkRange}
+}
+
+func TestWriteSQLs(t *testing.T) {
+	dir := t.TempDir()
+	df := &Diff{
+		downstream: &mockSource{tables: []*common.TableDiff{{Schema: "test", Table: "t"}}},
+		FixSQLDir:  dir,
+		sqlCh:      make(chan *ChunkDML, 3),
+		cp:         new(checkpoints.Checkpoint),
+	}
+	df.cp.Init()
+
+	// the file of the third chunk exists, so it fails to be written.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test:t:0:0-0:2.sql"), nil, 0o644))
+	df.sqlCh <- &ChunkDML{node: newChunkNode(0, 0), sqls: []string{"DELETE FROM `test`.`t` WHERE `id` = 1;"}}
+	df.sqlCh <- &ChunkDML{node: newChunkNode(0, 1), sqls: []string{"DELETE FROM `test`.`t` WHERE `id` = 2;"}, applied: true}
+	df.sqlCh <- &ChunkDML{node: newChunkNode(0, 2), sqls: []string{"DELETE FROM `test`.`t` WHERE `id` = 3;"}}
+	close(df.sqlCh)
+	df.sqlWg.Add(1)
+	df.writeSQLs(context.Background())
+
+	require.Error(t, df.writeSQLErr)
+	require.Contains(t, df.writeSQLErr.Error(), "already exists")
+
+	data, err := os.ReadFile(filepath.Join(dir, "test:t:0:0-0:0.sql"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "`id` = 1;")
+	require.NoFileExists(t, filepath.Join(dir, "test:t:0:0-0:1.sql"))
+	data, err = os.ReadFile(filepath.Join(dir, "test:t:0:0-0:1.applied.sql"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "has been applied by repair")
+	require.Contains(t, string(data), "`id` = 2;")
+
+	// the applied sql files are moved to the trash with the fix sql files.
+	require.NoError(t, df.removeSQLFiles(&chunk.ChunkID{TableIndex: 0, BucketIndexLeft: 0, BucketIndexRight: 0, ChunkIndex: 0}))
+	require.FileExists(t, filepath.Join(dir, "test:t:0:0-0:0.sql"))
+	require.NoFileExists(t, filepath.Join(dir, "test:t:0:0-0:1.applied.sql"))
+}
+
+// memStorage saves the checkpoint in memory, it fails if the context is done like the db storage.
+type memStorage struct {
+	data map[string][]byte
+}
+
+func (s *memStorage) Load(ctx context.Context, name string) ([]byte, error) {
+	data, ok := s.data[name]
+	if !ok {
+		return nil, errors.NotFoundf("checkpoint %s", name)
+	}
+	return data, nil
+}
+
+func (s *memStorage) Save(ctx context.Context, name string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	s.data[name] = data
+	return nil
+}
+
+func (s *memStorage) Remove(ctx context.Context, name string) error {
+	delete(s.data, name)
+	return nil
+}
+
+func (s *memStorage) Close() error { return nil }
+
+func TestHandleCheckpointsAfterCancel(t *testing.T) {
+	tables := []*common.TableDiff{{Schema: "test", Table: "t"}}
+	storage := &memStorage{data: make(map[string][]byte)}
+	df := &Diff{
+		downstream: &mockSource{tables: tables},
+		cp:         new(checkpoints.Checkpoint),
+		cpStorage:  storage,
+		report:     report.NewReport(&config.TaskConfig{}),
+		status:     &checkStatus{},
+	}
+	df.cp.Init()
+	df.report.Init(tables, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopCh := make(chan struct{})
+	df.checkpointWg.Add(1)
+	go df.handleCheckpoints(ctx, stopCh)
+
+	// the check is cancelled, and the running chunks finish after it.
+	cancel()
+	for i := 0; i < 2; i++ {
+		node := newChunkNode(0, i)
+		node.State = checkpoints.SuccessState
+		df.cp.Insert(node)
+	}
+	close(stopCh)
+	df.checkpointWg.Wait()
+
+	// the check resumes from the last finished chunk.
+	cp := new(checkpoints.Checkpoint)
+	cp.Init()
+	node, _, err := cp.LoadChunk(context.Background(), storage, checkpointFile)
+	require.NoError(t, err)
+	require.Equal(t, 0, node.GetTableIndex())
+	require.Equal(t, 1, node.GetChunkIndex())
+	require.Equal(t, checkpoints.SuccessState, node.GetState())
+}
+
+func TestWaitForConsistencyResume(t *testing.T) {
+	ctx := context.Background()
+	newConfig := func() *config.Config {
+		cfg := &config.Config{Consistency: &config.ConsistencyConfig{Type: config.ConsistencySyncpoint}}
+		cfg.Task.SourceInstances = []*config.DataSource{{}}
+		cfg.Task.TargetInstance = &config.DataSource{}
+		return cfg
+	}
+	storage := &memStorage{data: make(map[string][]byte)}
+	df := &Diff{cp: new(checkpoints.Checkpoint), cpStorage: storage}
+	df.cp.Init()
+
+	// there is no checkpoint, so it waits for the consistency and fails to connect the instances.
+	err := df.waitForConsistency(ctx, newConfig())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "create db connections")
+
+	// the snapshots are saved with the chunk, and reused after resuming.
+	df.cp.SetSnapshots(&checkpoints.Snapshots{Source: []string{"437000000000000001"}, Target: "437000000000000002"})
+	_, err = df.cp.SaveChunk(ctx, storage, checkpointFile, newChunkNode(0, 0), nil)
+	require.NoError(t, err)
+	cfg := newConfig()
+	df = &Diff{cp: new(checkpoints.Checkpoint), cpStorage: storage}
+	df.cp.Init()
+	require.NoError(t, df.waitForConsistency(ctx, cfg))
+	require.Equal(t, "437000000000000001", cfg.Task.SourceInstances[0].Snapshot)
+	require.Equal(t, "437000000000000002", cfg.Task.TargetInstance.Snapshot)
+	savedState, err := checkpoints.LoadSavedState(ctx, storage, checkpointFile)
+	require.NoError(t, err)
+	require.Equal(t, &checkpoints.Snapshots{Source: []string{"437000000000000001"}, Target: "437000000000000002"}, savedState.Snapshots)
+}
+
+func TestLoadTableOrder(t *testing.T) {
+	ctx := context.Background()
+	storage := &memStorage{data: make(map[string][]byte)}
+	cfg := &config.Config{}
+	cfg.Task.PrioritizeStaleTables = true
+
+	// the history is saved in the storage after a full check.
+	tables := []*common.TableDiff{{Schema: "test", Table: "t1"}, {Schema: "test", Table: "t2"}}
+	verifiedAt := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	df := &Diff{
+		downstream: &mockSource{tables: tables},
+		cpStorage:  storage,
+		report:     report.NewReport(&config.TaskConfig{}),
+		cp:         new(checkpoints.Checkpoint),
+		beginTime:  verifiedAt,
+	}
+	df.cp.Init()
+	df.report.Init(tables, nil, nil)
+	require.NoError(t, df.saveCheckHistory(ctx))
+	order, err := loadTableOrder(ctx, cfg, storage)
+	require.NoError(t, err)
+	require.Equal(t, &source.TableOrder{LastVerified: map[string]time.Time{"test:t1": verifiedAt, "test:t2": verifiedAt}}, order)
+
+	// the recheck keeps the order of the last check.
+	require.NoError(t, df.saveRecheckState(ctx))
+	cfg.Recheck = true
+	order, err = loadTableOrder(ctx, cfg, storage)
+	require.NoError(t, err)
+	require.Equal(t, &source.TableOrder{Tables: []string{"test:t1", "test:t2"}}, order)
+
+	// the check resumed from the checkpoint keeps the order saved in it.
+	cp := new(checkpoints.Checkpoint)
+	cp.Init()
+	cp.SetTableOrder([]string{"test:t2", "test:t1"})
+	_, err = cp.SaveChunk(ctx, storage, checkpointFile, newChunkNode(0, 0), nil)
+	require.NoError(t, err)
+	order, err = loadTableOrder(ctx, cfg, storage)
+	require.NoError(t, err)
+	require.Equal(t, &source.TableOrder{Tables: []string{"test:t2", "test:t1"}}, order)
+}
+
+// mockChecksumSource is a source of the given table IDs and table checksums.
+type mockChecksumSource struct {
+	mockSource
+	snapshot  string
+	ids       map[int][]int64
+	checksums map[int]uint64
+	// checked are the indices of the tables whose checksums are computed.
+	checked []int
+}
+
+func (s *mockChecksumSource) GetSnapshot() string { return s.snapshot }
+
+func (s *mockChecksumSource) GetTablePhysicalIDs(ctx context.Context, tableIndex int) ([]int64, error) {
+	return s.ids[tableIndex], nil
+}
+
+func (s *mockChecksumSource) GetTableChecksum(ctx context.Context, tableIndex int) (*utils.TableChecksum, error) {
+	s.checked = append(s.checked, tableIndex)
+	return &utils.TableChecksum{Checksum: s.checksums[tableIndex]}, nil
+}
+
+func TestCompareTableChecksums(t *testing.T) {
+	newTables := func() []*common.TableDiff {
+		return []*common.TableDiff{
+			{Schema: "test", Table: "t0"},
+			{Schema: "test", Table: "t1"},
+			{Schema: "test", Table: "t2"},
+			{Schema: "test", Table: "t3", ColumnTransforms: map[string]string{"c": "UPPER(c)"}},
+			{Schema: "test", Table: "t4", UpstreamExclude: "id > 10"},
+			{Schema: "test", Table: "t5"},
+			{Schema: "test", Table: "t6"},
+		}
+	}
+	newDiff := func(tables []*common.TableDiff) (*Diff, *mockChecksumSource, *mockChecksumSource) {
+		ids := map[int][]int64{0: {100}, 1: {101}, 2: {102}, 3: {103}, 4: {104}, 5: {105}, 6: {106}}
+		upstream := &mockChecksumSource{
+			mockSource: mockSource{tables: tables},
+			ids:        ids,
+			checksums:  map[int]uint64{0: 1, 1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1},
+		}
+		downstream := &mockChecksumSource{
+			mockSource: mockSource{tables: tables},
+			// the table t5 is restored with a new ID.
+			ids:       map[int][]int64{0: {100}, 1: {101}, 2: {102}, 3: {103}, 4: {104}, 5: {205}, 6: {106}},
+			checksums: map[int]uint64{0: 1, 1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 2},
+		}
+		df := &Diff{
+			upstream:   upstream,
+			downstream: downstream,
+			report:     report.NewReport(&config.TaskConfig{}),
+			// the structures of the table t2 are different.
+			structEqualTables: map[int]struct{}{0: {}, 1: {}, 3: {}, 4: {}, 5: {}, 6: {}},
+		}
+		df.report.Init(tables, nil, nil)
+		return df, upstream, downstream
+	}
+	matchedTables := func(tables []*common.TableDiff) []string {
+		matched := []string{}
+		for _, table := range tables {
+			if table.TableChecksumMatched {
+				matched = append(matched, table.Table)
+			}
+		}
+		return matched
+	}
+
+	tables := newTables()
+	df, upstream, downstream := newDiff(tables)
+	df.compareTableChecksums(context.Background())
+	require.Equal(t, []string{"t0", "t1"}, matchedTables(tables))
+	require.Equal(t, []int{0, 1, 6}, upstream.checked)
+	require.Equal(t, []int{0, 1, 6}, downstream.checked)
+
+	// the tables before the checkpoint are skipped.
+	tables = newTables()
+	df, upstream, _ = newDiff(tables)
+	chunkRange := chunk.NewChunkRange()
+	chunkRange.Index = &chunk.ChunkID{TableIndex: 0}
+	df.startRange = &splitter.RangeInfo{ChunkRange: chunkRange}
+	df.compareTableChecksums(context.Background())
+	require.Equal(t, []string{"t1"}, matchedTables(tables))
+	require.Equal(t, []int{1, 6}, upstream.checked)
+
+	// the table checksums read the latest data, so they aren't compared with a snapshot.
+	tables = newTables()
+	df, upstream, _ = newDiff(tables)
+	upstream.snapshot = "437000000000000001"
+	df.compareTableChecksums(context.Background())
+	require.Empty(t, matchedTables(tables))
+	require.Empty(t, upstream.checked)
+}
+
+// mockRangeIterator returns the given chunks in order.
+type mockRangeIterator struct {
+	chunks []*splitter.RangeInfo
+}
+
+func (it *mockRangeIterator) Next(context.Context) (*splitter.RangeInfo, error) {
+	if len(it.chunks) == 0 {
+		return nil, nil
+	}
+	c := it.chunks[0]
+	it.chunks = it.chunks[1:]
+	return c, nil
+}
+
+func (it *mockRangeIterator) Close() {}
+
+func TestSampleShuffledChunks(t *testing.T) {
+	tables := []*common.TableDiff{
+		{Schema: "test", Table: "t0", Range: "TRUE"},
+		{Schema: "test", Table: "t1", Range: "TRUE"},
+		{Schema: "test", Table: "t2", Range: "TRUE"},
+	}
+	chunks := make([]*splitter.RangeInfo, 0, 6)
+	for tableIndex, cnt := range []int{3, 2, 1} {
+		for i := 0; i < cnt; i++ {
+			chunks = append(chunks, failedRange(newChunkNode(tableIndex, i), tables[tableIndex]))
+		}
+	}
+	df, _ := newRecheckDiff(tables, []int64{1})
+	df.sampleRand = rand.New(rand.NewSource(1))
+	df.report.InitSample(1, 0.95, 0.01)
+
+	shuffled, err := df.shuffleChunks(context.Background(), &mockRangeIterator{chunks: append([]*splitter.RangeInfo{}, chunks...)})
+	require.NoError(t, err)
+	require.ElementsMatch(t, chunks, shuffled.chunks)
+	require.NotEqual(t, chunks, shuffled.chunks)
+	require.Len(t, shuffled.tables, 3)
+
+	// the sampling stops after checking the first chunk, the others are skipped.
+	first := shuffled.next()
+	shuffled.visit(first)
+	df.setSampleSkipped(context.Background(), shuffled)
+	require.Equal(t, int64(5), df.report.Sample.SkippedChunks)
+	require.Len(t, df.sqlCh, 5)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, checkpoints.IgnoreState, (<-df.sqlCh).node.GetState())
+	}
+	for tableIndex, table := range tables {
+		// only the table of the checked chunk isn't skipped.
+		require.Equal(t, tableIndex != first.GetTableIndex(), df.report.TableResults["test"][table.Table].SampleSkipped)
+	}
+}
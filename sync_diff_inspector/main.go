@@ -47,6 +47,17 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case compareReportsCommand:
+			os.Exit(runCompareReports(os.Args[2:]))
+		case serverCommand:
+			os.Exit(runServer(os.Args[2:]))
+		case workerCommand:
+			os.Exit(runWorker(os.Args[2:]))
+		}
+	}
+
 	cfg := config.NewConfig()
 	err := cfg.Parse(os.Args[1:])
 	switch errors.Cause(err) {
@@ -93,6 +104,12 @@ func main() {
 	log.Info("", zap.Stringer("config", cfg))
 
 	ctx := context.Background()
+	if cfg.DryRun {
+		if !printCheckPlan(ctx, cfg, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 	if !checkSyncState(ctx, cfg) {
 		log.Warn("check failed!!!")
 		os.Exit(1)
@@ -112,7 +129,11 @@ func checkSyncState(ctx context.Context, cfg *config.Config) bool {
 		log.Fatal("failed to initialize diff process", zap.Error(err))
 		return false
 	}
-	defer d.Close()
+	defer func() {
+		if err := d.Close(); err != nil {
+			log.Fatal("failed to close diff process", zap.Error(err))
+		}
+	}()
 
 	err = d.StructEqual(ctx)
 	if err != nil {
@@ -121,12 +142,22 @@ func checkSyncState(ctx context.Context, cfg *config.Config) bool {
 		return false
 	}
 	if !d.ignoreDataCheck {
-		err = d.Equal(ctx)
+		if d.recheck {
+			err = d.Recheck(ctx)
+		} else {
+			err = d.Equal(ctx)
+		}
 		if err != nil {
 			fmt.Printf("There is something error when compare data of table, please check log info in %s\n", filepath.Join(cfg.Task.OutputDir, config.LogFileName))
 			log.Fatal("failed to check data difference", zap.Error(err))
 			return false
 		}
+		if err = d.saveRecheckState(ctx); err != nil {
+			log.Warn("fail to save the recheck state", zap.Error(err))
+		}
+		if err = d.saveCheckHistory(ctx); err != nil {
+			log.Warn("fail to save the check history", zap.Error(err))
+		}
 	} else {
 		fmt.Printf("Check table struct only, skip data check\n")
 	}
@@ -0,0 +1,90 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "sync_diff_inspector"
+
+	// LabelUpstream is the label value of the upstream source.
+	LabelUpstream = "upstream"
+	// LabelDownstream is the label value of the downstream source.
+	LabelDownstream = "downstream"
+)
+
+var (
+	// ChunksCompared counts the chunks that have been compared.
+	ChunksCompared = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "chunks_compared_total",
+			Help:      "Total number of the chunks that have been compared.",
+		})
+
+	// ChunksFailed counts the chunks whose data are not equal or meet error.
+	ChunksFailed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "chunks_failed_total",
+			Help:      "Total number of the chunks whose data are not equal or meet error.",
+		})
+
+	// RowsScanned counts the rows scanned by the checksum queries.
+	RowsScanned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rows_scanned_total",
+			Help:      "Total number of the rows scanned by the checksum queries.",
+		}, []string{"source"})
+
+	// ChecksumDuration observes the latency of the checksum queries.
+	ChecksumDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "checksum_duration_seconds",
+			Help:      "Bucketed histogram of the latency of the checksum queries.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20),
+		}, []string{"source"})
+
+	// FixSQLFilesWritten counts the fix sql files written to the output dir.
+	FixSQLFilesWritten = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "fix_sql_files_written_total",
+			Help:      "Total number of the fix sql files written to the output dir.",
+		})
+
+	// ThrottleConcurrency is the concurrency of the check allowed by the throttle, 0 means paused.
+	ThrottleConcurrency = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "throttle_concurrency",
+			Help:      "The concurrency of the check allowed by the throttle, 0 means paused.",
+		})
+)
+
+// RegisterMetrics registers the metrics of sync_diff_inspector to the registry, they are served on `/metrics`
+// by the status server. It panics if the metrics are already registered, so it should be called only once,
+// the callers guard it by registerMetricsOnce.
+func RegisterMetrics(registry prometheus.Registerer) {
+	registry.MustRegister(ChunksCompared)
+	registry.MustRegister(ChunksFailed)
+	registry.MustRegister(RowsScanned)
+	registry.MustRegister(ChecksumDuration)
+	registry.MustRegister(FixSQLFilesWritten)
+	registry.MustRegister(ThrottleConcurrency)
+}
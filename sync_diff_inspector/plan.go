@@ -0,0 +1,175 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"go.uber.org/zap"
+)
+
+// loadPlanTableOrder returns the order the tables would be checked in, which may be saved by the last check.
+func loadPlanTableOrder(ctx context.Context, cfg *config.Config) (*source.TableOrder, error) {
+	storage, cpDB, err := openCheckpointStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer func() {
+		storage.Close()
+		if cpDB != nil {
+			cpDB.Close()
+		}
+	}()
+	return loadTableOrder(ctx, cfg, storage)
+}
+
+// tablePlan is how a target table would be checked.
+type tablePlan struct {
+	table        *common.TableDiff
+	sourceTables []string
+	// splitBy is the splitter and the index or the columns used to split the chunks.
+	splitBy   string
+	chunkSize int64
+	chunks    int
+	err       error
+}
+
+// printCheckPlan resolves the data sources, the routes and the filters, splits the chunks of every target table
+// as the check does and prints the plan to w without comparing any data.
+// It returns false if the plan of any table fails.
+func printCheckPlan(ctx context.Context, cfg *config.Config, w io.Writer) bool {
+	order, err := loadPlanTableOrder(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(w, "Fail to load the order of the tables.\n%s\n", err.Error())
+		log.Error("failed to load the order of the tables", zap.Error(err))
+		return false
+	}
+	downstream, upstream, err := source.NewSources(ctx, cfg, order)
+	if err != nil {
+		fmt.Fprintf(w, "Fail to resolve the tables to check.\n%s\n", err.Error())
+		log.Error("failed to build the sources", zap.Error(err))
+		return false
+	}
+	defer downstream.Close()
+	defer upstream.Close()
+
+	// the GC isn't kept because no data is read with the snapshot.
+	workSource := pickWorkSource(ctx, upstream, downstream, func(source.Source) {})
+	return printTablePlans(ctx, upstream, downstream, workSource.GetTableAnalyzer(), w)
+}
+
+// printTablePlans splits the chunks of every target table by the analyzer and prints the plans to w.
+// It returns false if the plan of any table fails.
+func printTablePlans(ctx context.Context, upstream, downstream source.Source, analyzer source.TableAnalyzer, w io.Writer) bool {
+	plans := make([]*tablePlan, 0, len(downstream.GetTables()))
+	for i, table := range downstream.GetTables() {
+		plan := &tablePlan{
+			table:        table,
+			sourceTables: upstream.GetSourceTables(i),
+		}
+		if err := plan.split(ctx, upstream, analyzer, i); err != nil {
+			log.Error("failed to split the chunks", zap.String("table", dbutil.TableName(table.Schema, table.Table)), zap.Error(err))
+			plan.err = err
+		}
+		plans = append(plans, plan)
+	}
+
+	ok := true
+	tableString := &strings.Builder{}
+	tableWriter := tablewriter.NewWriter(tableString)
+	tableWriter.SetHeader([]string{"Target table", "Source tables", "Split by", "Chunk size", "Chunks", "Range", "Ignore columns"})
+	tableWriter.SetAutoWrapText(false)
+	for _, plan := range plans {
+		row := []string{
+			dbutil.TableName(plan.table.Schema, plan.table.Table),
+			strings.Join(plan.sourceTables, "\n"),
+			plan.splitBy,
+			strconv.FormatInt(plan.chunkSize, 10),
+			strconv.Itoa(plan.chunks),
+			plan.table.Range,
+			strings.Join(plan.table.IgnoreColumns, ", "),
+		}
+		if plan.err != nil {
+			ok = false
+			row[2], row[3], row[4] = fmt.Sprintf("error: %s", plan.err.Error()), "", ""
+		}
+		tableWriter.Append(row)
+	}
+	tableWriter.Render()
+	fmt.Fprintf(w, "The following %d tables would be checked\n\n%s", len(plans), tableString.String())
+	return ok
+}
+
+// split splits the chunks of the table with the splitter picked by the analyzer and counts them.
+func (p *tablePlan) split(ctx context.Context, upstream source.Source, analyzer source.TableAnalyzer, tableIndex int) error {
+	sourceTableInfos, err := upstream.GetSourceStructInfo(ctx, tableIndex)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the indices only in one side are removed from the table info as the struct check does,
+	// so the chunks are split by the same index.
+	if _, isSkip := utils.CompareStruct(sourceTableInfos, p.table.Info); isSkip {
+		p.splitBy = "skipped, the columns of the tables are different"
+		return nil
+	}
+
+	iter, err := analyzer.AnalyzeSplitter(ctx, p.table, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer iter.Close()
+
+	switch it := iter.(type) {
+	case *splitter.BucketIterator:
+		p.splitBy = "bucket"
+		for _, index := range p.table.Info.Indices {
+			if index.ID == it.GetIndexID() {
+				p.splitBy = fmt.Sprintf("bucket, index %s", dbutil.ColumnName(index.Name.O))
+				break
+			}
+		}
+		p.chunkSize = it.GetChunkSize()
+	case *splitter.RandomIterator:
+		columns := make([]string, 0, len(it.GetSplitFields()))
+		for _, col := range it.GetSplitFields() {
+			columns = append(columns, dbutil.ColumnName(col.Name.O))
+		}
+		p.splitBy = fmt.Sprintf("random, columns %s", strings.Join(columns, ", "))
+		p.chunkSize = it.GetChunkSize()
+	}
+
+	for {
+		c, err := iter.Next()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if c == nil {
+			return nil
+		}
+		p.chunks++
+	}
+}
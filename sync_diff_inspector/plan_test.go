@@ -0,0 +1,100 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/stretchr/testify/require"
+)
+
+// mockPlanSource is the upstream of the given source tables, it fails to get the struct of the tables without infos.
+type mockPlanSource struct {
+	mockSource
+	sourceTables map[int][]string
+	infos        map[int][]*model.TableInfo
+}
+
+func (s *mockPlanSource) GetSourceTables(tableIndex int) []string { return s.sourceTables[tableIndex] }
+
+func (s *mockPlanSource) GetSourceStructInfo(ctx context.Context, tableIndex int) ([]*model.TableInfo, error) {
+	infos, ok := s.infos[tableIndex]
+	if !ok {
+		return nil, errors.Errorf("table %d doesn't exist", tableIndex)
+	}
+	return infos, nil
+}
+
+// mockRandomAnalyzer splits the chunks by the random splitter.
+type mockRandomAnalyzer struct {
+	db *sql.DB
+}
+
+func (a *mockRandomAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.TableDiff, _ *splitter.RangeInfo) (splitter.ChunkIterator, error) {
+	return splitter.NewRandomIterator(ctx, "", table, a.db)
+}
+
+func TestPrintTablePlans(t *testing.T) {
+	newTableInfo := func(sql string) *model.TableInfo {
+		tableInfo, err := dbutil.GetTableInfoBySQL(sql, parser.New())
+		require.NoError(t, err)
+		return tableInfo
+	}
+	tableInfo := newTableInfo("create table t(a int, b varchar(10), c float, primary key(a))")
+	tables := []*common.TableDiff{
+		{Schema: "test", Table: "t1", Info: tableInfo, Range: "a > 0", IgnoreColumns: []string{"c"}, ChunkSize: 5},
+		{Schema: "test", Table: "t2", Info: tableInfo},
+		{Schema: "test", Table: "t3", Info: tableInfo},
+	}
+	upstream := &mockPlanSource{
+		mockSource:   mockSource{tables: tables},
+		sourceTables: map[int][]string{0: {"`shard1`.`t1`", "`shard2`.`t1`"}, 1: {"`shard1`.`t2`"}, 2: {"`shard1`.`t3`"}},
+		// the struct of the table t2 fails to be got, and the columns of the table t3 are different.
+		infos: map[int][]*model.TableInfo{0: {tableInfo, tableInfo}, 2: {newTableInfo("create table t(a int, primary key(a))")}},
+	}
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	// the table t1 has 10 rows, so it's split into 2 chunks of 5 rows.
+	mock.ExpectQuery("SELECT COUNT.*").WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(10))
+	mock.ExpectQuery("ORDER BY rand_value").WillReturnRows(sqlmock.NewRows([]string{"a"}).AddRow(5))
+
+	output := &strings.Builder{}
+	require.False(t, printTablePlans(context.Background(), upstream, &mockSource{tables: tables}, &mockRandomAnalyzer{db: db}, output))
+	require.NoError(t, mock.ExpectationsWereMet())
+	// the error of the table t2 is printed in the split by column.
+	require.Equal(t, strings.Join([]string{
+		"The following 3 tables would be checked",
+		"",
+		"+--------------+---------------+--------------------------------------------------+------------+--------+-------+----------------+",
+		"| TARGET TABLE | SOURCE TABLES |                     SPLIT BY                     | CHUNK SIZE | CHUNKS | RANGE | IGNORE COLUMNS |",
+		"+--------------+---------------+--------------------------------------------------+------------+--------+-------+----------------+",
+		"| `test`.`t1`  | `shard1`.`t1` | random, columns `a`                              |          5 |      2 | a > 0 | c              |",
+		"|              | `shard2`.`t1` |                                                  |            |        |       |                |",
+		"| `test`.`t2`  | `shard1`.`t2` | error: table 1 doesn't exist                     |            |        |       |                |",
+		"| `test`.`t3`  | `shard1`.`t3` | skipped, the columns of the tables are different |          0 |      0 |       |                |",
+		"+--------------+---------------+--------------------------------------------------+------------+--------+-------+----------------+",
+		"",
+	}, "\n"), output.String())
+}
@@ -392,9 +392,21 @@ func (tpp *TableProgressPrinter) flush(stateIsChanged bool) {
 	fmt.Fprintf(tpp.output, "Progress [%s>%s] %d%% %d/%d\n", strings.Repeat("=", numLeft), strings.Repeat("-", 60-numLeft), percent, tpp.progress, tpp.total)
 }
 
-var progress_ *TableProgressPrinter = nil
+var (
+	progress_ *TableProgressPrinter = nil
+	disabled  bool
+)
+
+// Disable stops printing the progress, it should be called before Init. The progress is printed
+// by a global printer, so it should be disabled if multiple checks run at the same time.
+func Disable() {
+	disabled = true
+}
 
 func Init(tableNums, finishTableNums int) {
+	if disabled {
+		return
+	}
 	progress_ = NewTableProgressPrinter(tableNums, finishTableNums)
 }
 
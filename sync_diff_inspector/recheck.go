@@ -0,0 +1,260 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/progress"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// recheckStateFile saves the failed chunks and the check time of the tables for the recheck mode.
+	recheckStateFile = "sync_diff_recheck.json"
+	// historyFile saves the last time the tables are verified for `prioritize-stale-tables`.
+	historyFile = "sync_diff_history.json"
+
+	// incrementalBucketIndex marks the chunk of the rows updated since the last check,
+	// so that it never conflicts with the chunks generated by the splitters.
+	incrementalBucketIndex = math.MaxInt32
+
+	defaultRecheckTimes    = 3
+	defaultRecheckInterval = 10 * time.Second
+	defaultRecheckWindow   = 5 * time.Minute
+)
+
+// recordFailedChunk records the chunk that is still different after checking,
+// it will be checked again in the next recheck.
+func (df *Diff) recordFailedChunk(rangeInfo *splitter.RangeInfo) {
+	df.failedMu.Lock()
+	defer df.failedMu.Unlock()
+	if rangeInfo.GetBucketIndexLeft() == incrementalBucketIndex {
+		// keep the check time of the table, so that these rows will be checked again.
+		df.failedTables[rangeInfo.GetTableIndex()] = struct{}{}
+		return
+	}
+	df.cp.AddFailedChunk(rangeInfo.ToNode())
+}
+
+// saveRecheckState saves the failed chunks and the check time of the tables. It's skipped if
+// not all the chunks are checked, otherwise the recheck would miss the chunks that are not checked.
+func (df *Diff) saveRecheckState(ctx context.Context) error {
+	if df.sampleRand != nil || df.budgetExpired {
+		log.Info("not all the chunks are checked, skip saving the recheck state")
+		return nil
+	}
+	df.failedMu.Lock()
+	defer df.failedMu.Unlock()
+	state := &checkpoints.RecheckState{
+		FailedChunks: df.cp.GetFailedChunks(),
+		CheckTime:    make(map[string]time.Time),
+		TableOrder:   tableIDs(df.downstream.GetTables()),
+	}
+	for i, table := range df.downstream.GetTables() {
+		id := utils.UniqueID(table.Schema, table.Table)
+		if _, ok := df.failedTables[i]; ok {
+			if checkTime, ok := df.lastCheckTime[id]; ok {
+				state.CheckTime[id] = checkTime
+			}
+			continue
+		}
+		state.CheckTime[id] = df.beginTime
+	}
+	return errors.Trace(checkpoints.SaveRecheckState(ctx, df.cpStorage, recheckStateFile, state))
+}
+
+// saveCheckHistory records the time the tables are verified after a full check, so that the least recently
+// verified tables can be checked first. It's saved in the checkpoint storage, so that the check rescheduled
+// to another machine uses it too.
+func (df *Diff) saveCheckHistory(ctx context.Context) error {
+	if df.recheck || df.sampleRand != nil || df.budgetExpired {
+		return nil
+	}
+	history, err := checkpoints.LoadHistory(ctx, df.cpStorage, historyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	df.report.UpdateHistory(history, df.beginTime)
+	return errors.Trace(checkpoints.SaveHistory(ctx, df.cpStorage, historyFile, history))
+}
+
+// Recheck only checks the failed chunks of the last check and the rows updated since the last check.
+// A chunk is inconsistent only when it is still different after rechecking `recheck-times` times
+// within `recheck-window`, so that the rows that haven't been replicated won't be reported.
+func (df *Diff) Recheck(ctx context.Context) error {
+	if df.startRange != nil {
+		log.Info("found checkpoint, continue the last check instead of rechecking")
+		return df.Equal(ctx)
+	}
+	state, err := checkpoints.LoadRecheckState(ctx, df.cpStorage, recheckStateFile)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("not found recheck state, check all the tables")
+			return df.Equal(ctx)
+		}
+		return errors.Annotate(err, "the recheck state load process failed")
+	}
+	df.beginTime = time.Now()
+	df.lastCheckTime = state.CheckTime
+
+	tables := df.downstream.GetTables()
+	ranges := make([]*splitter.RangeInfo, 0, len(state.FailedChunks)+len(tables))
+	for _, node := range state.FailedChunks {
+		if node.GetTableIndex() >= len(tables) {
+			return errors.Errorf("the recheck state doesn't match the tables, table index %d", node.GetTableIndex())
+		}
+		table := tables[node.GetTableIndex()]
+		if table.IgnoreDataCheck {
+			continue
+		}
+		ranges = append(ranges, failedRange(node, table))
+	}
+	for i, table := range tables {
+		if table.IgnoreDataCheck || len(table.UpdateTimeColumn) == 0 {
+			continue
+		}
+		checkTime, ok := state.CheckTime[utils.UniqueID(table.Schema, table.Table)]
+		if !ok {
+			log.Warn("not found the last check time of the table, skip checking the updated rows",
+				zap.String("table", dbutil.TableName(table.Schema, table.Table)))
+			continue
+		}
+		ranges = append(ranges, incrementalRange(i, table, checkTime))
+	}
+
+	chunkCnt := make(map[string]int)
+	for _, rangeInfo := range ranges {
+		chunkCnt[rangeInfo.ProgressID]++
+	}
+	for _, table := range tables {
+		progressID := dbutil.TableName(table.Schema, table.Table)
+		if cnt := chunkCnt[progressID]; cnt > 0 {
+			progress.StartTable(progressID, cnt, true)
+		} else {
+			progress.StartTable(progressID, 1, true)
+			progress.Inc(progressID)
+		}
+	}
+
+	pool := utils.NewWorkerPool(uint(df.checkThreadCount), "recheck")
+	df.sqlWg.Add(1)
+	go df.writeSQLs(ctx)
+	for _, rangeInfo := range ranges {
+		r := rangeInfo
+		log.Info("recheck chunk", zap.Any("chunk index", r.ChunkRange.Index), zap.String("where", r.ChunkRange.Where))
+		df.status.setCurrentChunk(r)
+		pool.Apply(func() {
+			isEqual := df.recheckChunk(ctx, r)
+			if !isEqual {
+				progress.FailTable(r.ProgressID)
+			}
+			progress.Inc(r.ProgressID)
+			df.status.finishChunk(isEqual)
+		})
+	}
+	pool.WaitFinished()
+	close(df.sqlCh)
+	df.sqlWg.Wait()
+	return errors.Trace(df.writeSQLErr)
+}
+
+// recheckChunk compares the checksum of the chunk with backoff until it is equal,
+// and compares the rows only if it is still different at last.
+func (df *Diff) recheckChunk(ctx context.Context, rangeInfo *splitter.RangeInfo) bool {
+	if len(df.downstream.GetTables()[rangeInfo.GetTableIndex()].Tolerances) != 0 {
+		// the columns with tolerance are excluded from the checksum, the rows should be compared.
+		return df.consume(ctx, rangeInfo)
+	}
+	deadline := time.Now().Add(df.recheckWindow)
+	interval := df.recheckInterval
+	for i := 1; i <= df.recheckTimes; i++ {
+		isEqual, _, err := df.compareChecksumAndGetCount(ctx, rangeInfo)
+		if err != nil {
+			log.Warn("fail to recheck the chunk", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Error(err))
+		} else if isEqual {
+			log.Info("the chunk is equal after rechecking", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int("times", i))
+			return true
+		}
+		wait := interval
+		if remain := time.Until(deadline); wait > remain {
+			wait = remain
+		}
+		if i == df.recheckTimes || wait <= 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+		interval *= 2
+	}
+	// the chunk is still different, compare the rows and generate the fix sql.
+	return df.consume(ctx, rangeInfo)
+}
+
+// failedRange rebuilds the chunk saved in the recheck state with the current range of the table.
+func failedRange(node *checkpoints.Node, table *common.TableDiff) *splitter.RangeInfo {
+	chunkRange := chunk.NewChunkRange()
+	for _, bound := range node.ChunkRange.Bounds {
+		chunkRange.Update(bound.Column, bound.Lower, bound.Upper, bound.HasLower, bound.HasUpper)
+	}
+	chunkRange.Type = node.ChunkRange.Type
+	chunkRange.Index = node.ChunkRange.Index
+	chunkRange.IsFirst = node.ChunkRange.IsFirst
+	chunkRange.IsLast = node.ChunkRange.IsLast
+	conditions, args := chunkRange.ToString(table.Collation)
+	chunkRange.Where = fmt.Sprintf("((%s) AND (%s))", conditions, table.Range)
+	chunkRange.Args = args
+	return &splitter.RangeInfo{
+		ChunkRange: chunkRange,
+		IndexID:    node.IndexID,
+		ProgressID: dbutil.TableName(table.Schema, table.Table),
+	}
+}
+
+// incrementalRange returns the chunk of the rows updated since the last check of the table.
+// The update time column is a TIMESTAMP column, so it is compared in UTC because the session time zone is UTC.
+func incrementalRange(tableIndex int, table *common.TableDiff, checkTime time.Time) *splitter.RangeInfo {
+	chunkRange := chunk.NewChunkRange()
+	// the range isn't built from bounds, so it can't be split by BinGenerate.
+	chunkRange.Type = chunk.Others
+	chunkRange.Index = &chunk.ChunkID{
+		TableIndex:       tableIndex,
+		BucketIndexLeft:  incrementalBucketIndex,
+		BucketIndexRight: incrementalBucketIndex,
+		ChunkIndex:       0,
+		ChunkCnt:         1,
+	}
+	chunkRange.IsFirst = true
+	chunkRange.IsLast = true
+	chunkRange.Where = fmt.Sprintf("((%s) AND (%s >= ?))", table.Range, dbutil.ColumnName(table.UpdateTimeColumn))
+	chunkRange.Args = []interface{}{checkTime.UTC().Format("2006-01-02 15:04:05")}
+	return &splitter.RangeInfo{
+		ChunkRange: chunkRange,
+		ProgressID: dbutil.TableName(table.Schema, table.Table),
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/stretchr/testify/require"
+)
+
+// mockCountSource returns the checksums in order for the successive checks, the last one is repeated.
+type mockCountSource struct {
+	mockSource
+	checksums []int64
+
+	mu     sync.Mutex
+	calls  []time.Time
+	ranges []*splitter.RangeInfo
+}
+
+func (s *mockCountSource) GetCountAndCrc32(ctx context.Context, rangeInfo *splitter.RangeInfo) *source.ChecksumInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, time.Now())
+	s.ranges = append(s.ranges, rangeInfo)
+	i := len(s.calls) - 1
+	if i >= len(s.checksums) {
+		i = len(s.checksums) - 1
+	}
+	return &source.ChecksumInfo{Checksum: s.checksums[i], Count: 1}
+}
+
+func newRecheckDiff(tables []*common.TableDiff, downstreamChecksums []int64) (*Diff, *mockCountSource) {
+	downstream := &mockCountSource{mockSource: mockSource{tables: tables}, checksums: downstreamChecksums}
+	df := &Diff{
+		upstream:         &mockCountSource{mockSource: mockSource{tables: tables}, checksums: []int64{1}},
+		downstream:       downstream,
+		workSource:       downstream,
+		checkThreadCount: 1,
+		report:           report.NewReport(&config.TaskConfig{}),
+		status:           &checkStatus{},
+		cp:               new(checkpoints.Checkpoint),
+		cpStorage:        &memStorage{data: make(map[string][]byte)},
+		sqlCh:            make(chan *ChunkDML, 10),
+		failedTables:     make(map[int]struct{}),
+		recheckTimes:     3,
+		recheckInterval:  20 * time.Millisecond,
+		recheckWindow:    time.Minute,
+	}
+	df.cp.Init()
+	df.report.Init(tables, nil, nil)
+	return df, downstream
+}
+
+func TestRecheckChunk(t *testing.T) {
+	ctx := context.Background()
+	tables := []*common.TableDiff{{Schema: "test", Table: "t", Range: "TRUE"}}
+	rangeInfo := failedRange(newChunkNode(0, 0), tables[0])
+
+	// the chunk is equal at the third time, the interval doubles after each recheck.
+	df, downstream := newRecheckDiff(tables, []int64{2, 2, 1})
+	require.True(t, df.recheckChunk(ctx, rangeInfo))
+	require.Len(t, downstream.calls, 3)
+	require.GreaterOrEqual(t, downstream.calls[1].Sub(downstream.calls[0]), 20*time.Millisecond)
+	require.GreaterOrEqual(t, downstream.calls[2].Sub(downstream.calls[1]), 40*time.Millisecond)
+	require.Empty(t, df.sqlCh)
+
+	// the chunk is still different after rechecking `recheck-times` times, so it's compared and reported at last.
+	df, downstream = newRecheckDiff(tables, []int64{2})
+	require.False(t, df.recheckChunk(ctx, rangeInfo))
+	require.Len(t, downstream.calls, 4)
+	require.Len(t, df.sqlCh, 1)
+	require.Equal(t, checkpoints.FailedState, (<-df.sqlCh).node.GetState())
+
+	// the chunk isn't rechecked after the window expires.
+	df, downstream = newRecheckDiff(tables, []int64{2})
+	df.recheckTimes = 5
+	df.recheckWindow = 30 * time.Millisecond
+	require.False(t, df.recheckChunk(ctx, rangeInfo))
+	require.Len(t, downstream.calls, 4)
+
+	// the cancelled recheck doesn't report the chunk.
+	df, downstream = newRecheckDiff(tables, []int64{2})
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.False(t, df.recheckChunk(cancelCtx, rangeInfo))
+	require.Len(t, downstream.calls, 1)
+	require.Empty(t, df.sqlCh)
+}
+
+func TestRecheck(t *testing.T) {
+	ctx := context.Background()
+	tables := []*common.TableDiff{
+		{Schema: "test", Table: "t0", Range: "TRUE"},
+		{Schema: "test", Table: "t1", Range: "TRUE", UpdateTimeColumn: "updated_at"},
+		{Schema: "test", Table: "t2", Range: "TRUE", UpdateTimeColumn: "updated_at"},
+		{Schema: "test", Table: "t3", Range: "TRUE", UpdateTimeColumn: "updated_at", IgnoreDataCheck: true},
+	}
+	df, downstream := newRecheckDiff(tables, []int64{1})
+	checkTime := time.Date(2021, 12, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	// the check time of the table t2 isn't recorded, so its updated rows aren't checked.
+	require.NoError(t, checkpoints.SaveRecheckState(ctx, df.cpStorage, recheckStateFile, &checkpoints.RecheckState{
+		FailedChunks: []*checkpoints.Node{newChunkNode(0, 1), newChunkNode(3, 0)},
+		CheckTime:    map[string]time.Time{"test:t1": checkTime, "test:t3": checkTime},
+	}))
+	require.NoError(t, df.Recheck(ctx))
+
+	// the failed chunk of t0 and the updated rows of t1 are checked.
+	require.Len(t, downstream.ranges, 2)
+	require.Equal(t, 0, downstream.ranges[0].GetTableIndex())
+	require.Equal(t, 1, downstream.ranges[0].ChunkRange.Index.ChunkIndex)
+	require.Equal(t, 1, downstream.ranges[1].GetTableIndex())
+	require.Equal(t, incrementalBucketIndex, downstream.ranges[1].GetBucketIndexLeft())
+	require.Equal(t, "((TRUE) AND (`updated_at` >= ?))", downstream.ranges[1].ChunkRange.Where)
+	require.Equal(t, []interface{}{"2021-12-01 00:00:00"}, downstream.ranges[1].ChunkRange.Args)
+	require.Equal(t, int64(2), df.status.CheckedChunks)
+	require.Equal(t, int64(0), df.status.FailedChunks)
+
+	// the check time is kept for the next recheck.
+	require.True(t, checkTime.Equal(df.lastCheckTime["test:t1"]))
+}
+
+func TestSaveRecheckState(t *testing.T) {
+	ctx := context.Background()
+	tables := []*common.TableDiff{{Schema: "test", Table: "t", Range: "TRUE"}}
+	df, _ := newRecheckDiff(tables, []int64{1})
+	df.beginTime = time.Now()
+	df.recordFailedChunk(failedRange(newChunkNode(0, 1), tables[0]))
+
+	// the recheck state isn't saved if not all the chunks are checked.
+	df.budgetExpired = true
+	require.NoError(t, df.saveRecheckState(ctx))
+	_, err := checkpoints.LoadRecheckState(ctx, df.cpStorage, recheckStateFile)
+	require.True(t, errors.IsNotFound(err))
+
+	df.budgetExpired = false
+	require.NoError(t, df.saveRecheckState(ctx))
+	state, err := checkpoints.LoadRecheckState(ctx, df.cpStorage, recheckStateFile)
+	require.NoError(t, err)
+	require.Len(t, state.FailedChunks, 1)
+	require.Equal(t, 1, state.FailedChunks[0].GetID().ChunkIndex)
+	require.True(t, df.beginTime.Equal(state.CheckTime["test:t"]))
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"go.uber.org/zap"
+)
+
+// repairChunk applies the fix sql of the chunk to the downstream in one transaction,
+// and then verifies the chunk again. It returns true if the chunk is equal after repairing.
+func (df *Diff) repairChunk(ctx context.Context, rangeInfo *splitter.RangeInfo, dml *ChunkDML) (bool, error) {
+	if len(dml.sqls) == 0 {
+		return false, nil
+	}
+	if df.cp.IsRepaired(dml.node) {
+		// the fix sql has been applied before restarting, but the chunk is still different.
+		// Don't apply it twice, let the user check it manually.
+		log.Warn("the chunk has been repaired before but is still different, skip repairing it again",
+			zap.Any("chunk id", rangeInfo.ChunkRange.Index))
+		return false, nil
+	}
+	if df.repairDryRun {
+		log.Info("[repair dry-run] fix sql will not be applied",
+			zap.Any("chunk id", rangeInfo.ChunkRange.Index),
+			zap.Strings("sql", dml.sqls))
+		return false, nil
+	}
+
+	tx, err := df.downstream.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	for _, sql := range dml.sqls {
+		if _, err = tx.ExecContext(ctx, sql); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Warn("fail to rollback the repair transaction", zap.Error(rbErr))
+			}
+			return false, errors.Annotatef(err, "execute fix sql %s", sql)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return false, errors.Trace(err)
+	}
+	dml.applied = true
+	df.cp.MarkRepaired(dml.node)
+	log.Info("repair chunk", zap.Any("chunk id", rangeInfo.ChunkRange.Index), zap.Int("sql count", len(dml.sqls)))
+
+	isEqual, _, err := df.compareChecksumAndGetCount(ctx, rangeInfo)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if !isEqual {
+		log.Warn("the chunk is still different after repairing", zap.Any("chunk id", rangeInfo.ChunkRange.Index))
+	}
+	return isEqual, nil
+}
@@ -0,0 +1,329 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"go.uber.org/zap"
+)
+
+const (
+	// SummaryFile is the file name of the summary in the output dir.
+	SummaryFile = "summary.txt"
+
+	// checkpointFile is the checkpoint saved in the `checkpoint` dir of the output dir,
+	// it only exists if the check doesn't finish.
+	checkpointFile = "checkpoint/sync_diff_checkpoints.pb"
+
+	summaryEqualTitle = "The table structure and data in following tables are equivalent"
+	summaryDiffTitle  = "The following tables contains inconsistent data"
+)
+
+// LoadJSONReport loads the result of the check in the output dir. It reads `report.json` first,
+// then the report in the checkpoint if the check doesn't finish, and `summary.txt` at last,
+// which only contains the results of the tables but not the chunks.
+func LoadJSONReport(outputDir string) (*JSONReport, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, JSONReportFile))
+	if err == nil {
+		jsonReport := &JSONReport{}
+		if err = json.Unmarshal(data, jsonReport); err != nil {
+			return nil, errors.Annotatef(err, "parse %s", filepath.Join(outputDir, JSONReportFile))
+		}
+		return jsonReport, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(outputDir, checkpointFile))
+	if err == nil {
+		savedState := &struct {
+			Report *Report `json:"report-info"`
+		}{}
+		if err = json.Unmarshal(data, savedState); err != nil {
+			return nil, errors.Annotatef(err, "parse %s", filepath.Join(outputDir, checkpointFile))
+		}
+		if savedState.Report == nil {
+			return nil, errors.Errorf("no report in %s", filepath.Join(outputDir, checkpointFile))
+		}
+		log.Warn("the check doesn't finish, use the report in the checkpoint", zap.String("output dir", outputDir))
+		return savedState.Report.GetJSONReport(savedState.Report.Duration), nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(outputDir, SummaryFile))
+	if err != nil {
+		return nil, errors.Annotatef(err, "no report found in %s", outputDir)
+	}
+	return parseSummary(data)
+}
+
+// parseSummary parses the results of the tables in `summary.txt`.
+func parseSummary(data []byte) (*JSONReport, error) {
+	jsonReport := &JSONReport{
+		Result: Pass,
+		Tables: make([]*JSONTableResult, 0),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == summaryEqualTitle || line == summaryDiffTitle:
+			section = line
+			continue
+		case strings.HasPrefix(line, "The following tables"), strings.HasPrefix(line, "Time Cost"):
+			section = ""
+			continue
+		}
+		switch section {
+		case summaryEqualTitle:
+			if len(line) == 0 {
+				continue
+			}
+			schema, table, err := parseTableName(line)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			jsonReport.PassNum++
+			jsonReport.Tables = append(jsonReport.Tables, &JSONTableResult{
+				Schema:      schema,
+				Table:       table,
+				StructEqual: true,
+				DataEqual:   true,
+			})
+		case summaryDiffTitle:
+			// | `schema`.`table` | true | +1/-2 |
+			cells := strings.Split(strings.Trim(line, "|"), "|")
+			if !strings.HasPrefix(line, "|") || len(cells) != 3 {
+				continue
+			}
+			schema, table, err := parseTableName(strings.TrimSpace(cells[0]))
+			if err != nil {
+				// the header of the table
+				continue
+			}
+			result := &JSONTableResult{
+				Schema:      schema,
+				Table:       table,
+				StructEqual: strings.TrimSpace(cells[1]) == "true",
+			}
+			if _, err = fmt.Sscanf(strings.TrimSpace(cells[2]), "+%d/-%d", &result.RowsAdd, &result.RowsDelete); err != nil {
+				return nil, errors.Annotatef(err, "parse diff rows %s", cells[2])
+			}
+			// the table is inconsistent, so the data isn't equal unless only the structure is different.
+			result.DataEqual = !result.StructEqual && result.RowsAdd == 0 && result.RowsDelete == 0
+			jsonReport.Result = Fail
+			jsonReport.FailedNum++
+			jsonReport.Tables = append(jsonReport.Tables, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return jsonReport, nil
+}
+
+// parseTableName parses the table name quoted by dbutil.TableName.
+func parseTableName(name string) (string, string, error) {
+	if !strings.HasPrefix(name, "`") || !strings.HasSuffix(name, "`") {
+		return "", "", errors.Errorf("invalid table name %s", name)
+	}
+	name = name[1 : len(name)-1]
+	for i := 0; i+2 < len(name); i++ {
+		if name[i] != '`' {
+			continue
+		}
+		if name[i+1] == '`' {
+			// escaped backtick
+			i++
+			continue
+		}
+		if name[i+1] == '.' && name[i+2] == '`' {
+			unescape := func(s string) string { return strings.ReplaceAll(s, "``", "`") }
+			return unescape(name[:i]), unescape(name[i+3:]), nil
+		}
+	}
+	return "", "", errors.Errorf("invalid table name `%s`", name)
+}
+
+// TableDelta is the difference of a table between two checks.
+type TableDelta struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// OldResult and NewResult are the results of the table in the two checks, nil if the table isn't checked.
+	OldResult *JSONTableResult `json:"old-result,omitempty"`
+	NewResult *JSONTableResult `json:"new-result,omitempty"`
+	// the chunks are matched by the chunk id, so they are comparable only if the table is split
+	// in the same way in the two checks.
+	NewlyBrokenChunks []string `json:"newly-broken-chunks,omitempty"`
+	FixedChunks       []string `json:"fixed-chunks,omitempty"`
+	StillBrokenChunks []string `json:"still-broken-chunks,omitempty"`
+}
+
+// ReportDelta is the difference between two checks.
+type ReportDelta struct {
+	// NewlyBroken are the tables equal in the old check but not equal in the new check.
+	NewlyBroken []*TableDelta `json:"newly-broken"`
+	// Fixed are the tables not equal in the old check but equal in the new check.
+	Fixed []*TableDelta `json:"fixed"`
+	// StillBroken are the tables not equal in both checks.
+	StillBroken []*TableDelta `json:"still-broken"`
+	// Added and Removed are the tables only checked in the new or the old check.
+	Added   []*TableDelta `json:"added,omitempty"`
+	Removed []*TableDelta `json:"removed,omitempty"`
+}
+
+// IsBroken returns true if the structure or data of the table is not equal, or the check meets error.
+func (t *JSONTableResult) IsBroken() bool {
+	return !t.StructEqual || !t.DataEqual || len(t.Error) != 0
+}
+
+// CompareJSONReports returns the difference of the tables between the old and new checks.
+func CompareJSONReports(oldReport, newReport *JSONReport) *ReportDelta {
+	delta := &ReportDelta{
+		NewlyBroken: make([]*TableDelta, 0),
+		Fixed:       make([]*TableDelta, 0),
+		StillBroken: make([]*TableDelta, 0),
+	}
+	oldTables := make(map[string]*JSONTableResult, len(oldReport.Tables))
+	for _, table := range oldReport.Tables {
+		oldTables[dbutil.TableName(table.Schema, table.Table)] = table
+	}
+	for _, newTable := range newReport.Tables {
+		name := dbutil.TableName(newTable.Schema, newTable.Table)
+		oldTable, ok := oldTables[name]
+		tableDelta := &TableDelta{
+			Schema:    newTable.Schema,
+			Table:     newTable.Table,
+			OldResult: oldTable,
+			NewResult: newTable,
+		}
+		if !ok {
+			delta.Added = append(delta.Added, tableDelta)
+			continue
+		}
+		delete(oldTables, name)
+		compareChunks(tableDelta, oldTable, newTable)
+		switch {
+		case !oldTable.IsBroken() && newTable.IsBroken():
+			delta.NewlyBroken = append(delta.NewlyBroken, tableDelta)
+		case oldTable.IsBroken() && !newTable.IsBroken():
+			delta.Fixed = append(delta.Fixed, tableDelta)
+		case oldTable.IsBroken() && newTable.IsBroken():
+			delta.StillBroken = append(delta.StillBroken, tableDelta)
+		}
+	}
+	for _, oldTable := range oldTables {
+		delta.Removed = append(delta.Removed, &TableDelta{
+			Schema:    oldTable.Schema,
+			Table:     oldTable.Table,
+			OldResult: oldTable,
+		})
+	}
+	for _, tables := range [][]*TableDelta{delta.NewlyBroken, delta.Fixed, delta.StillBroken, delta.Added, delta.Removed} {
+		sortTableDeltas(tables)
+	}
+	return delta
+}
+
+func compareChunks(tableDelta *TableDelta, oldTable, newTable *JSONTableResult) {
+	oldChunks := make(map[string]struct{}, len(oldTable.FailedChunks))
+	for _, c := range oldTable.FailedChunks {
+		oldChunks[c.ID] = struct{}{}
+	}
+	for _, c := range newTable.FailedChunks {
+		if _, ok := oldChunks[c.ID]; ok {
+			tableDelta.StillBrokenChunks = append(tableDelta.StillBrokenChunks, c.ID)
+			delete(oldChunks, c.ID)
+		} else {
+			tableDelta.NewlyBrokenChunks = append(tableDelta.NewlyBrokenChunks, c.ID)
+		}
+	}
+	for _, c := range oldTable.FailedChunks {
+		if _, ok := oldChunks[c.ID]; ok {
+			tableDelta.FixedChunks = append(tableDelta.FixedChunks, c.ID)
+		}
+	}
+}
+
+func sortTableDeltas(tables []*TableDelta) {
+	sort.Slice(tables, func(i, j int) bool {
+		return dbutil.TableName(tables[i].Schema, tables[i].Table) < dbutil.TableName(tables[j].Schema, tables[j].Table)
+	})
+}
+
+// Print writes the difference as tables.
+func (d *ReportDelta) Print(w io.Writer) error {
+	sections := []struct {
+		title  string
+		tables []*TableDelta
+	}{
+		{"Newly broken tables", d.NewlyBroken},
+		{"Fixed tables", d.Fixed},
+		{"Still broken tables", d.StillBroken},
+		{"Tables only in the new check", d.Added},
+		{"Tables only in the old check", d.Removed},
+	}
+	for _, section := range sections {
+		if _, err := fmt.Fprintf(w, "%s: %d\n", section.title, len(section.tables)); err != nil {
+			return errors.Trace(err)
+		}
+		if len(section.tables) == 0 {
+			continue
+		}
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Table", "Old result", "New result", "Newly broken chunks", "Fixed chunks", "Still broken chunks"})
+		for _, t := range section.tables {
+			table.Append([]string{
+				dbutil.TableName(t.Schema, t.Table),
+				tableResultString(t.OldResult),
+				tableResultString(t.NewResult),
+				fmt.Sprint(len(t.NewlyBrokenChunks)),
+				fmt.Sprint(len(t.FixedChunks)),
+				fmt.Sprint(len(t.StillBrokenChunks)),
+			})
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func tableResultString(result *JSONTableResult) string {
+	switch {
+	case result == nil:
+		return "-"
+	case len(result.Error) != 0:
+		return "error"
+	case !result.StructEqual:
+		return "struct not equal"
+	case !result.DataEqual:
+		return fmt.Sprintf("+%d/-%d", result.RowsAdd, result.RowsDelete)
+	default:
+		return "equal"
+	}
+}
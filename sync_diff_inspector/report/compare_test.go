@@ -0,0 +1,135 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReport returns a report of the tables in schema `test`, the key of failedChunks is the table name.
+func newTestReport(outputDir string, reportFormats []string, failedChunks map[string][]*chunk.ChunkID) *Report {
+	report := NewReport(&config.TaskConfig{
+		OutputDir:     outputDir,
+		ReportFormats: reportFormats,
+	})
+	tableDiffs := make([]*common.TableDiff, 0, len(failedChunks))
+	for table := range failedChunks {
+		tableDiffs = append(tableDiffs, &common.TableDiff{Schema: "test", Table: table})
+	}
+	report.Init(tableDiffs, nil, nil)
+	for table, ids := range failedChunks {
+		report.SetTableStructCheckResult("test", table, true, false)
+		report.SetTableDataCheckResult("test", table, true, 0, 0, &chunk.ChunkID{0, 0, 0, 0, 1})
+		for _, id := range ids {
+			report.SetTableDataCheckResult("test", table, false, 1, 1, id)
+		}
+	}
+	return report
+}
+
+func TestCompareReports(t *testing.T) {
+	id1, id2, id3 := &chunk.ChunkID{0, 0, 0, 1, 3}, &chunk.ChunkID{0, 0, 0, 2, 3}, &chunk.ChunkID{0, 1, 1, 0, 1}
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	oldReport := newTestReport(oldDir, []string{config.ReportFormatJSON}, map[string][]*chunk.ChunkID{
+		"t1": nil,
+		"t2": {id1},
+		"t3": {id1, id2},
+		"t4": nil,
+	})
+	require.NoError(t, oldReport.CommitSummary())
+	newReport := newTestReport(newDir, []string{config.ReportFormatJSON}, map[string][]*chunk.ChunkID{
+		"t1": {id3},
+		"t2": nil,
+		"t3": {id2, id3},
+		"t5": nil,
+	})
+	require.NoError(t, newReport.CommitSummary())
+
+	oldJSONReport, err := LoadJSONReport(oldDir)
+	require.NoError(t, err)
+	newJSONReport, err := LoadJSONReport(newDir)
+	require.NoError(t, err)
+	delta := CompareJSONReports(oldJSONReport, newJSONReport)
+	require.Len(t, delta.NewlyBroken, 1)
+	require.Equal(t, "t1", delta.NewlyBroken[0].Table)
+	require.Equal(t, []string{id3.ToString()}, delta.NewlyBroken[0].NewlyBrokenChunks)
+	require.Len(t, delta.Fixed, 1)
+	require.Equal(t, "t2", delta.Fixed[0].Table)
+	require.Equal(t, []string{id1.ToString()}, delta.Fixed[0].FixedChunks)
+	require.Len(t, delta.StillBroken, 1)
+	require.Equal(t, "t3", delta.StillBroken[0].Table)
+	require.Equal(t, []string{id3.ToString()}, delta.StillBroken[0].NewlyBrokenChunks)
+	require.Equal(t, []string{id1.ToString()}, delta.StillBroken[0].FixedChunks)
+	require.Equal(t, []string{id2.ToString()}, delta.StillBroken[0].StillBrokenChunks)
+	require.Len(t, delta.Added, 1)
+	require.Equal(t, "t5", delta.Added[0].Table)
+	require.Len(t, delta.Removed, 1)
+	require.Equal(t, "t4", delta.Removed[0].Table)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, delta.Print(buf))
+	require.Contains(t, buf.String(), "Newly broken tables: 1\n")
+	require.Contains(t, buf.String(), "`test`.`t1`")
+}
+
+func TestLoadJSONReport(t *testing.T) {
+	failedChunks := map[string][]*chunk.ChunkID{
+		"t1":   nil,
+		"t`2":  {{0, 0, 0, 1, 3}},
+		"t.t3": {{0, 0, 0, 1, 3}, {0, 0, 0, 2, 3}},
+	}
+	// only summary.txt
+	summaryDir := t.TempDir()
+	require.NoError(t, newTestReport(summaryDir, nil, failedChunks).CommitSummary())
+	summaryReport, err := LoadJSONReport(summaryDir)
+	require.NoError(t, err)
+
+	// the checkpoint of the unfinished check
+	checkpointDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(checkpointDir, "checkpoint"), config.LocalDirPerm))
+	data, err := json.Marshal(map[string]interface{}{"report-info": newTestReport(checkpointDir, nil, failedChunks)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, checkpointFile), data, config.LocalFilePerm))
+	checkpointReport, err := LoadJSONReport(checkpointDir)
+	require.NoError(t, err)
+
+	for _, jsonReport := range []*JSONReport{summaryReport, checkpointReport} {
+		results := make(map[string]*JSONTableResult)
+		for _, table := range jsonReport.Tables {
+			require.Equal(t, "test", table.Schema)
+			results[table.Table] = table
+		}
+		require.Len(t, results, 3)
+		require.False(t, results["t1"].IsBroken())
+		require.True(t, results["t`2"].IsBroken())
+		require.Equal(t, 1, results["t`2"].RowsAdd)
+		require.True(t, results["t.t3"].IsBroken())
+		require.Equal(t, 2, results["t.t3"].RowsDelete)
+		if jsonReport == checkpointReport {
+			require.Len(t, results["t.t3"].FailedChunks, 2)
+		}
+	}
+
+	_, err = LoadJSONReport(t.TempDir())
+	require.Error(t, err)
+}
@@ -0,0 +1,295 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+)
+
+const (
+	// JSONReportFile is the file name of the json report in the output dir.
+	JSONReportFile = "report.json"
+	// JUnitReportFile is the file name of the junit report in the output dir.
+	JUnitReportFile = "junit.xml"
+)
+
+// JSONReport is the machine-readable report of the check.
+type JSONReport struct {
+	Result          string             `json:"result"`
+	PassNum         int32              `json:"pass-num"`
+	FailedNum       int32              `json:"failed-num"`
+	StartTime       time.Time          `json:"start-time"`
+	DurationSeconds float64            `json:"duration-seconds"`
+	TotalSize       int64              `json:"total-size"`
+	Tables          []*JSONTableResult `json:"tables"`
+	Sample          *SampleResult      `json:"sample,omitempty"`
+}
+
+// JSONTableResult is the check result of a table in the json report.
+type JSONTableResult struct {
+	Schema           string             `json:"schema"`
+	Table            string             `json:"table"`
+	StructEqual      bool               `json:"struct-equal"`
+	DataSkip         bool               `json:"data-skip"`
+	DataEqual        bool               `json:"data-equal"`
+	RowsAdd          int                `json:"rows-add"`
+	RowsDelete       int                `json:"rows-delete"`
+	Error            string             `json:"error,omitempty"`
+	CheckCostSeconds float64            `json:"check-cost-seconds"`
+	FailedChunks     []*JSONChunkResult `json:"failed-chunks,omitempty"`
+	RepairedChunks   []*JSONChunkResult `json:"repaired-chunks,omitempty"`
+	// the grouped checksum queries to locate the different rows, and the estimated queries saved.
+	LocateQueries      int64 `json:"locate-queries,omitempty"`
+	LocateQueriesSaved int64 `json:"locate-queries-saved,omitempty"`
+	// the differences of the table struct found by the detailed struct check.
+	StructDiffs []*utils.StructDiff `json:"struct-diffs,omitempty"`
+	// the keys existing in multiple upstream shards, and the count of all of them.
+	KeyCollisions     []*utils.KeyCollision `json:"key-collisions,omitempty"`
+	KeyCollisionCount int64                 `json:"key-collision-count,omitempty"`
+	// the data isn't checked because the time budget expired.
+	BudgetSkipped bool `json:"budget-skipped,omitempty"`
+	// none of the chunks is sampled.
+	SampleSkipped bool `json:"sample-skipped,omitempty"`
+	// the data is equal by the table checksums, the chunks aren't checked.
+	TableChecksum bool `json:"table-checksum,omitempty"`
+	// the conditions of the rows excluded from the comparison on both sides, the upstream only and the downstream only.
+	Exclude           string `json:"exclude,omitempty"`
+	UpstreamExclude   string `json:"upstream-exclude,omitempty"`
+	DownstreamExclude string `json:"downstream-exclude,omitempty"`
+}
+
+// JSONChunkResult is the result of a chunk which is not equal in the json report.
+type JSONChunkResult struct {
+	ID         string `json:"id"`
+	Range      string `json:"range,omitempty"`
+	RowsAdd    int    `json:"rows-add"`
+	RowsDelete int    `json:"rows-delete"`
+}
+
+// GetJSONReport converts the report to the json report, the tables are sorted by name.
+func (r *Report) GetJSONReport(duration time.Duration) *JSONReport {
+	r.RLock()
+	defer r.RUnlock()
+	jsonReport := &JSONReport{
+		Result:          r.Result,
+		PassNum:         r.PassNum,
+		FailedNum:       r.FailedNum,
+		StartTime:       r.StartTime,
+		DurationSeconds: duration.Seconds(),
+		TotalSize:       r.TotalSize,
+		Tables:          make([]*JSONTableResult, 0),
+		Sample:          r.Sample,
+	}
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			tableResult := &JSONTableResult{
+				Schema:           schema,
+				Table:            table,
+				StructEqual:      result.StructEqual,
+				DataSkip:         result.DataSkip,
+				DataEqual:        result.DataEqual,
+				CheckCostSeconds: result.CheckCost.Seconds(),
+
+				LocateQueries:      result.LocateQueries,
+				LocateQueriesSaved: result.LocateQueriesSaved,
+				StructDiffs:        result.StructDiffs,
+				KeyCollisions:      result.KeyCollisions,
+				KeyCollisionCount:  result.KeyCollisionCount,
+				BudgetSkipped:      result.BudgetSkipped,
+				SampleSkipped:      result.SampleSkipped,
+				TableChecksum:      result.TableChecksum,
+				Exclude:            result.Exclude,
+				UpstreamExclude:    result.UpstreamExclude,
+				DownstreamExclude:  result.DownstreamExclude,
+			}
+			if result.MeetError != nil {
+				tableResult.Error = result.MeetError.Error()
+			}
+			for id, chunkResult := range result.ChunkMap {
+				jsonChunk := &JSONChunkResult{
+					ID:         id,
+					Range:      chunkResult.Range,
+					RowsAdd:    chunkResult.RowsAdd,
+					RowsDelete: chunkResult.RowsDelete,
+				}
+				if chunkResult.Repaired {
+					tableResult.RepairedChunks = append(tableResult.RepairedChunks, jsonChunk)
+					continue
+				}
+				tableResult.RowsAdd += chunkResult.RowsAdd
+				tableResult.RowsDelete += chunkResult.RowsDelete
+				tableResult.FailedChunks = append(tableResult.FailedChunks, jsonChunk)
+			}
+			sortChunkResults(tableResult.FailedChunks)
+			sortChunkResults(tableResult.RepairedChunks)
+			jsonReport.Tables = append(jsonReport.Tables, tableResult)
+		}
+	}
+	sort.Slice(jsonReport.Tables, func(i, j int) bool {
+		return dbutil.TableName(jsonReport.Tables[i].Schema, jsonReport.Tables[i].Table) <
+			dbutil.TableName(jsonReport.Tables[j].Schema, jsonReport.Tables[j].Table)
+	})
+	return jsonReport
+}
+
+func sortChunkResults(chunks []*JSONChunkResult) {
+	sort.Slice(chunks, func(i, j int) bool {
+		idi, idj := new(chunk.ChunkID), new(chunk.ChunkID)
+		if idi.FromString(chunks[i].ID) != nil || idj.FromString(chunks[j].ID) != nil {
+			return chunks[i].ID < chunks[j].ID
+		}
+		return idi.Compare(idj) < 0
+	})
+}
+
+// JUnitTestSuites is the root element of the junit report.
+type JUnitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuite contains a testcase for each table.
+type JUnitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr,omitempty"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	TestCases []*JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase is the check result of a table.
+type JUnitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitMessage `xml:"failure,omitempty"`
+	Error     *JUnitMessage `xml:"error,omitempty"`
+	Skipped   *JUnitMessage `xml:"skipped,omitempty"`
+}
+
+// JUnitMessage is the failure or error of a testcase.
+type JUnitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+// GetJUnitReport converts the json report to the junit report.
+func GetJUnitReport(jsonReport *JSONReport) *JUnitTestSuites {
+	suite := &JUnitTestSuite{
+		Name:      "sync_diff_inspector",
+		Tests:     len(jsonReport.Tables),
+		Time:      fmt.Sprintf("%.3f", jsonReport.DurationSeconds),
+		Timestamp: jsonReport.StartTime.Format("2006-01-02T15:04:05"),
+		TestCases: make([]*JUnitTestCase, 0, len(jsonReport.Tables)),
+	}
+	for _, table := range jsonReport.Tables {
+		testCase := &JUnitTestCase{
+			ClassName: table.Schema,
+			Name:      table.Table,
+			Time:      fmt.Sprintf("%.3f", table.CheckCostSeconds),
+		}
+		if len(table.Error) != 0 {
+			suite.Errors++
+			testCase.Error = &JUnitMessage{
+				Message: table.Error,
+				Type:    "error",
+			}
+		} else if !table.StructEqual || !table.DataEqual {
+			suite.Failures++
+			var content strings.Builder
+			message := "the data is not equal"
+			if !table.StructEqual {
+				message = "the structure is not equal"
+				if !table.DataEqual {
+					message = "the structure and data are not equal"
+				}
+			}
+			for _, d := range table.StructDiffs {
+				content.WriteString(d.String() + "\n")
+			}
+			for _, c := range table.FailedChunks {
+				content.WriteString(fmt.Sprintf("chunk %s: +%d/-%d %s\n", c.ID, c.RowsAdd, c.RowsDelete, c.Range))
+			}
+			testCase.Failure = &JUnitMessage{
+				Message: fmt.Sprintf("%s, rows +%d/-%d", message, table.RowsAdd, table.RowsDelete),
+				Type:    "fail",
+				Content: content.String(),
+			}
+		} else if table.BudgetSkipped {
+			suite.Skipped++
+			testCase.Skipped = &JUnitMessage{
+				Message: "skipped (budget)",
+				Type:    "skipped",
+			}
+		} else if table.SampleSkipped {
+			suite.Skipped++
+			testCase.Skipped = &JUnitMessage{
+				Message: "skipped (sample)",
+				Type:    "skipped",
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	return &JUnitTestSuites{Suites: []*JUnitTestSuite{suite}}
+}
+
+// exportReports writes the machine-readable reports configured by `report-formats`.
+func (r *Report) exportReports(duration time.Duration) error {
+	if len(r.task.ReportFormats) == 0 {
+		return nil
+	}
+	jsonReport := r.GetJSONReport(duration)
+	for _, format := range r.task.ReportFormats {
+		var (
+			data     []byte
+			err      error
+			fileName string
+		)
+		switch format {
+		case config.ReportFormatJSON:
+			fileName = JSONReportFile
+			data, err = json.MarshalIndent(jsonReport, "", "  ")
+		case config.ReportFormatJUnit:
+			fileName = JUnitReportFile
+			data, err = xml.MarshalIndent(GetJUnitReport(jsonReport), "", "  ")
+			data = append([]byte(xml.Header), data...)
+		default:
+			return errors.Errorf("unsupported report format %s", format)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = os.WriteFile(filepath.Join(r.task.OutputDir, fileName), data, config.LocalFilePerm); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportReports(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewReport(&config.TaskConfig{
+		OutputDir:     outputDir,
+		FixDir:        task.FixDir,
+		ReportFormats: []string{config.ReportFormatJSON, config.ReportFormatJUnit},
+	})
+	tableDiffs := []*common.TableDiff{
+		{Schema: "test", Table: "tbl"},
+		{Schema: "atest", Table: "tbl"},
+		{Schema: "xtest", Table: "tbl"},
+	}
+	report.Init(tableDiffs, nil, nil)
+
+	report.SetTableStructCheckResult("test", "tbl", true, false)
+	report.SetTableDataCheckResult("test", "tbl", true, 0, 0, &chunk.ChunkID{0, 0, 0, 0, 1})
+	report.AddTableCheckCost("test", "tbl", 2*time.Second)
+
+	report.SetTableStructCheckResult("atest", "tbl", true, false)
+	report.SetTableDataCheckResult("atest", "tbl", false, 1, 2, &chunk.ChunkID{1, 0, 0, 2, 10})
+	report.SetTableDataCheckRange("atest", "tbl", &chunk.ChunkID{1, 0, 0, 2, 10}, "range in sequence: (1) < (a) <= (10)")
+	report.SetTableDataCheckResult("atest", "tbl", false, 3, 0, &chunk.ChunkID{1, 0, 0, 1, 10})
+	report.SetTableDataRepairResult("atest", "tbl", 5, 0, &chunk.ChunkID{1, 0, 0, 3, 10})
+
+	report.SetTableStructCheckResult("xtest", "tbl", true, false)
+	report.SetTableMeetError("xtest", "tbl", errors.New("123"))
+
+	require.NoError(t, report.CommitSummary())
+
+	bytes, err := os.ReadFile(path.Join(outputDir, JSONReportFile))
+	require.NoError(t, err)
+	jsonReport := &JSONReport{}
+	require.NoError(t, json.Unmarshal(bytes, jsonReport))
+	require.Equal(t, Error, jsonReport.Result)
+	require.Equal(t, int32(2), jsonReport.PassNum)
+	require.Equal(t, int32(1), jsonReport.FailedNum)
+	require.Len(t, jsonReport.Tables, 3)
+
+	atest := jsonReport.Tables[0]
+	require.Equal(t, "atest", atest.Schema)
+	require.False(t, atest.DataEqual)
+	require.Equal(t, 4, atest.RowsAdd)
+	require.Equal(t, 2, atest.RowsDelete)
+	require.Len(t, atest.FailedChunks, 2)
+	require.Equal(t, "1:0-0:1:10", atest.FailedChunks[0].ID)
+	require.Equal(t, "1:0-0:2:10", atest.FailedChunks[1].ID)
+	require.Equal(t, "range in sequence: (1) < (a) <= (10)", atest.FailedChunks[1].Range)
+	require.Len(t, atest.RepairedChunks, 1)
+	require.Equal(t, 5, atest.RepairedChunks[0].RowsAdd)
+
+	tbl := jsonReport.Tables[1]
+	require.Equal(t, "test", tbl.Schema)
+	require.True(t, tbl.DataEqual)
+	require.Equal(t, 2.0, tbl.CheckCostSeconds)
+	require.Empty(t, tbl.FailedChunks)
+
+	xtest := jsonReport.Tables[2]
+	require.Equal(t, "123", xtest.Error)
+
+	bytes, err = os.ReadFile(path.Join(outputDir, JUnitReportFile))
+	require.NoError(t, err)
+	suites := &JUnitTestSuites{}
+	require.NoError(t, xml.Unmarshal(bytes, suites))
+	require.Len(t, suites.Suites, 1)
+	suite := suites.Suites[0]
+	require.Equal(t, 3, suite.Tests)
+	require.Equal(t, 1, suite.Failures)
+	require.Equal(t, 1, suite.Errors)
+	require.Len(t, suite.TestCases, 3)
+	require.Equal(t, "atest", suite.TestCases[0].ClassName)
+	require.Equal(t, "the data is not equal, rows +4/-2", suite.TestCases[0].Failure.Message)
+	require.Contains(t, suite.TestCases[0].Failure.Content, "chunk 1:0-0:2:10: +1/-2 range in sequence: (1) < (a) <= (10)")
+	require.Nil(t, suite.TestCases[1].Failure)
+	require.Nil(t, suite.TestCases[1].Error)
+	require.Equal(t, "2.000", suite.TestCases[1].Time)
+	require.Equal(t, "123", suite.TestCases[2].Error.Message)
+}
@@ -0,0 +1,41 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+)
+
+// History records the last time the data of each table is verified by the previous checks,
+// it is used to check the least recently verified tables first.
+type History struct {
+	// Tables is the last verified time of the tables, the key is `schema:table`.
+	Tables map[string]time.Time `json:"tables"`
+}
+
+// UpdateHistory records the tables whose data has been checked in the report as verified at the given time.
+func (r *Report) UpdateHistory(history *History, verifiedAt time.Time) {
+	r.RLock()
+	defer r.RUnlock()
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if result.DataSkip || result.BudgetSkipped || result.MeetError != nil {
+				continue
+			}
+			history.Tables[utils.UniqueID(schema, table)] = verifiedAt
+		}
+	}
+}
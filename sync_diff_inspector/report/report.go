@@ -16,11 +16,13 @@ package report
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -42,6 +44,9 @@ const (
 	// Fail means not all data or struct of tables are equal
 	Fail  = "fail"
 	Error = "error"
+
+	// maxTableKeyCollisions limits the collided keys kept for each table, the rest are only counted.
+	maxTableKeyCollisions = 100
 )
 
 // ReportConfig stores the config information for the user
@@ -51,6 +56,7 @@ type ReportConfig struct {
 	User     string `toml:"user"`
 	Snapshot string `toml:"snapshot,omitempty"`
 	SqlMode  string `toml:"sql-mode,omitempty"`
+	Dir      string `toml:"dir,omitempty"`
 }
 
 // TableResult saves the check result for every table.
@@ -61,13 +67,68 @@ type TableResult struct {
 	DataSkip    bool                    `json:"data-skip"`
 	DataEqual   bool                    `json:"data-equal"`
 	MeetError   error                   `json:"-"`
-	ChunkMap    map[string]*ChunkResult `json:"chunk-result"` // `ChunkMap` stores the `ChunkResult` of each chunk of the table
+	ChunkMap    map[string]*ChunkResult `json:"chunk-result"`         // `ChunkMap` stores the `ChunkResult` of each chunk of the table
+	CheckCost   time.Duration           `json:"check-cost,omitempty"` // `CheckCost` is the total time spent on checking the chunks of the table
+	// `LocateQueries` is the number of the grouped checksum queries to locate the different rows,
+	// and `LocateQueriesSaved` is the estimated number of the queries saved compared with binary search.
+	LocateQueries      int64 `json:"locate-queries,omitempty"`
+	LocateQueriesSaved int64 `json:"locate-queries-saved,omitempty"`
+	// `StructDiffs` are the differences of the table struct found by the detailed struct check.
+	StructDiffs []*utils.StructDiff `json:"struct-diffs,omitempty"`
+	// `KeyCollisions` are the keys existing in multiple upstream shards of the table,
+	// and `KeyCollisionCount` counts all of them including the ones not kept.
+	KeyCollisions     []*utils.KeyCollision `json:"key-collisions,omitempty"`
+	KeyCollisionCount int64                 `json:"key-collision-count,omitempty"`
+	// `BudgetSkipped` means the data of the table isn't checked because the time budget expired.
+	BudgetSkipped bool `json:"budget-skipped,omitempty"`
+	// `SampleSkipped` means no chunk of the table is checked in the sampling mode.
+	SampleSkipped bool `json:"sample-skipped,omitempty"`
+	// `TableChecksum` means the data of the table is equal by the table checksums, the chunks aren't checked.
+	TableChecksum bool `json:"table-checksum,omitempty"`
+	// `Exclude` is the condition of the rows excluded from the comparison on both sides, `UpstreamExclude`
+	// and `DownstreamExclude` are the conditions of the rows excluded from one side only.
+	Exclude           string `json:"exclude,omitempty"`
+	UpstreamExclude   string `json:"upstream-exclude,omitempty"`
+	DownstreamExclude string `json:"downstream-exclude,omitempty"`
 }
 
 // ChunkResult save the necessarily information to provide summary information
 type ChunkResult struct {
-	RowsAdd    int `json:"rows-add"`    // `RowAdd` is the number of rows needed to add
-	RowsDelete int `json:"rows-delete"` // `RowDelete` is the number of rows needed to delete
+	RowsAdd    int  `json:"rows-add"`           // `RowAdd` is the number of rows needed to add
+	RowsDelete int  `json:"rows-delete"`        // `RowDelete` is the number of rows needed to delete
+	Repaired   bool `json:"repaired,omitempty"` // `Repaired` means the fix sql has been applied and the chunk is equal now
+
+	Range string `json:"range,omitempty"` // `Range` is the range of the chunk which is not equal
+}
+
+// SampleResult saves the result of the sampling mode, which only checks a random subset of the chunks.
+type SampleResult struct {
+	Rate                 float64 `json:"rate"`
+	Confidence           float64 `json:"confidence"`
+	MaxInconsistencyRate float64 `json:"max-inconsistency-rate,omitempty"`
+	SampledChunks        int64   `json:"sampled-chunks"`
+	FailedChunks         int64   `json:"failed-chunks"`
+	SkippedChunks        int64   `json:"skipped-chunks"`
+	// Reached means the upper bound of the inconsistency rate is below `MaxInconsistencyRate`,
+	// and the check stops early.
+	Reached bool `json:"reached,omitempty"`
+}
+
+// InconsistencyRate returns the estimated inconsistency rate of the chunks and its confidence interval.
+func (s *SampleResult) InconsistencyRate() (float64, float64, float64) {
+	estimate := 0.0
+	if s.SampledChunks > 0 {
+		estimate = float64(s.FailedChunks) / float64(s.SampledChunks)
+	}
+	lower, upper := utils.WilsonInterval(s.FailedChunks, s.SampledChunks, s.Confidence)
+	return estimate, lower, upper
+}
+
+func (s *SampleResult) String() string {
+	estimate, lower, upper := s.InconsistencyRate()
+	return fmt.Sprintf("Sampled %d chunks and skipped %d chunks, %d sampled chunks are not equal.\n"+
+		"The estimated inconsistency rate of the chunks is %.4f%%, in [%.4f%%, %.4f%%] at %.2f%% confidence.\n",
+		s.SampledChunks, s.SkippedChunks, s.FailedChunks, estimate*100, lower*100, upper*100, s.Confidence*100)
 }
 
 // Report saves the check results.
@@ -82,8 +143,11 @@ type Report struct {
 	TotalSize    int64                              `json:"-"` // Total size of the checked tables
 	SourceConfig [][]byte                           `json:"-"`
 	TargetConfig []byte                             `json:"-"`
+	Sample       *SampleResult                      `json:"sample,omitempty"` // Sample is nil unless in the sampling mode
 
 	task *config.TaskConfig `json:"-"`
+	// tableOrder is the index of the tables in the order to check them, set by SetTableOrder.
+	tableOrder map[string]int
 }
 
 // LoadReport loads the report from the checkpoint
@@ -91,6 +155,11 @@ func (r *Report) LoadReport(reportInfo *Report) {
 	r.StartTime = time.Now()
 	r.Duration = reportInfo.Duration
 	r.TotalSize = reportInfo.TotalSize
+	if r.Sample != nil && reportInfo.Sample != nil {
+		r.Sample.SampledChunks = reportInfo.Sample.SampledChunks
+		r.Sample.FailedChunks = reportInfo.Sample.FailedChunks
+		r.Sample.SkippedChunks = reportInfo.Sample.SkippedChunks
+	}
 	for schema, tableMap := range reportInfo.TableResults {
 		if _, ok := r.TableResults[schema]; !ok {
 			r.TableResults[schema] = make(map[string]*TableResult)
@@ -105,7 +174,7 @@ func (r *Report) getSortedTables() []string {
 	equalTables := make([]string, 0)
 	for schema, tableMap := range r.TableResults {
 		for table, result := range tableMap {
-			if result.StructEqual && result.DataEqual {
+			if result.StructEqual && result.DataEqual && !result.BudgetSkipped && !result.SampleSkipped {
 				equalTables = append(equalTables, dbutil.TableName(schema, table))
 			}
 		}
@@ -140,6 +209,149 @@ func (r *Report) getDiffRows() [][]string {
 	return diffRows
 }
 
+// getExcludeRows returns the conditions of the rows excluded from the comparison of the tables.
+func (r *Report) getExcludeRows() [][]string {
+	rows := make([][]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if len(result.Exclude) == 0 && len(result.UpstreamExclude) == 0 && len(result.DownstreamExclude) == 0 {
+				continue
+			}
+			rows = append(rows, []string{dbutil.TableName(schema, table), result.Exclude, result.UpstreamExclude, result.DownstreamExclude})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
+
+// getBudgetSkippedTables returns the tables whose data isn't checked because the time budget expired.
+func (r *Report) getBudgetSkippedTables() []string {
+	tables := make([]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if result.BudgetSkipped {
+				tables = append(tables, dbutil.TableName(schema, table))
+			}
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// getSampleSkippedTables returns the tables whose data isn't checked in the sampling mode.
+func (r *Report) getSampleSkippedTables() []string {
+	tables := make([]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if result.SampleSkipped {
+				tables = append(tables, dbutil.TableName(schema, table))
+			}
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// getTableChecksumTables returns the tables whose data is equal by the table checksums.
+func (r *Report) getTableChecksumTables() []string {
+	tables := make([]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if result.TableChecksum {
+				tables = append(tables, dbutil.TableName(schema, table))
+			}
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// getRepairedRows returns the tables whose data have been repaired and the repaired rows.
+func (r *Report) getRepairedRows() [][]string {
+	repairedRows := make([][]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			repaired := false
+			rowAdd, rowDelete := 0, 0
+			for _, chunkResult := range result.ChunkMap {
+				if chunkResult.Repaired {
+					repaired = true
+					rowAdd += chunkResult.RowsAdd
+					rowDelete += chunkResult.RowsDelete
+				}
+			}
+			if repaired {
+				repairedRows = append(repairedRows, []string{dbutil.TableName(schema, table), fmt.Sprintf("+%d/-%d", rowAdd, rowDelete)})
+			}
+		}
+	}
+	sort.Slice(repairedRows, func(i, j int) bool { return repairedRows[i][0] < repairedRows[j][0] })
+	return repairedRows
+}
+
+// getStructDiffRows returns the differences of the table struct found by the detailed struct check.
+func (r *Report) getStructDiffRows() [][]string {
+	rows := make([][]string, 0)
+	tables := make([]string, 0)
+	diffs := make(map[string][]*utils.StructDiff)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if len(result.StructDiffs) != 0 {
+				name := dbutil.TableName(schema, table)
+				tables = append(tables, name)
+				diffs[name] = result.StructDiffs
+			}
+		}
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, diff := range diffs[table] {
+			rows = append(rows, []string{table, diff.Object, diff.Attribute, diff.Upstream, diff.Downstream})
+		}
+	}
+	return rows
+}
+
+// getLocateRows returns the rows of the tables whose different rows are located by grouped checksums.
+func (r *Report) getLocateRows() [][]string {
+	rows := make([][]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			if result.LocateQueries == 0 {
+				continue
+			}
+			rows = append(rows, []string{dbutil.TableName(schema, table), strconv.FormatInt(result.LocateQueries, 10), strconv.FormatInt(result.LocateQueriesSaved, 10)})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
+
+// getKeyCollisionRows returns the keys existing in multiple upstream shards, and the tables having more
+// collided keys than the kept ones.
+func (r *Report) getKeyCollisionRows() ([][]string, []string) {
+	rows := make([][]string, 0)
+	truncated := make([]string, 0)
+	for schema, tableMap := range r.TableResults {
+		for table, result := range tableMap {
+			name := dbutil.TableName(schema, table)
+			for _, collision := range result.KeyCollisions {
+				key, err := json.Marshal(collision.Key)
+				if err != nil {
+					key = []byte(fmt.Sprintf("%v", collision.Key))
+				}
+				rows = append(rows, []string{name, string(key), strings.Join(collision.Shards, "\n")})
+			}
+			if result.KeyCollisionCount > int64(len(result.KeyCollisions)) {
+				truncated = append(truncated, fmt.Sprintf("%s has %d collided keys, only %d of them are shown", name, result.KeyCollisionCount, len(result.KeyCollisions)))
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	sort.Strings(truncated)
+	return rows, truncated
+}
+
 // CalculateTotalSize calculate the total size of all the checked tables
 // Notice, user should run the analyze table first, when some of tables' size are zero.
 func (r *Report) CalculateTotalSize(ctx context.Context, db *sql.DB) {
@@ -164,6 +376,9 @@ func (r *Report) CommitSummary() error {
 	for _, tableMap := range r.TableResults {
 		for _, result := range tableMap {
 			if result.StructEqual && result.DataEqual {
+				if result.BudgetSkipped || result.SampleSkipped {
+					continue
+				}
 				passNum++
 			} else {
 				failedNum++
@@ -172,7 +387,7 @@ func (r *Report) CommitSummary() error {
 	}
 	r.PassNum = passNum
 	r.FailedNum = failedNum
-	summaryPath := filepath.Join(r.task.OutputDir, "summary.txt")
+	summaryPath := filepath.Join(r.task.OutputDir, SummaryFile)
 	summaryFile, err := os.Create(summaryPath)
 	if err != nil {
 		return errors.Trace(err)
@@ -206,14 +421,108 @@ func (r *Report) CommitSummary() error {
 		table.Render()
 		summaryFile.WriteString(tableString.String())
 	}
+	structDiffRows := r.getStructDiffRows()
+	if len(structDiffRows) > 0 {
+		summaryFile.WriteString("\nThe structure differences of following tables\n\n")
+		tableString := &strings.Builder{}
+		table := tablewriter.NewWriter(tableString)
+		table.SetHeader([]string{"Table", "Object", "Attribute", "Source", "Target"})
+		table.SetAutoWrapText(false)
+		for _, v := range structDiffRows {
+			table.Append(v)
+		}
+		table.Render()
+		summaryFile.WriteString(tableString.String())
+	}
+	locateRows := r.getLocateRows()
+	if len(locateRows) > 0 {
+		summaryFile.WriteString("\nThe different rows in following tables are located by grouped checksums\n\n")
+		tableString := &strings.Builder{}
+		table := tablewriter.NewWriter(tableString)
+		table.SetHeader([]string{"Table", "Queries", "Queries saved"})
+		for _, v := range locateRows {
+			table.Append(v)
+		}
+		table.Render()
+		summaryFile.WriteString(tableString.String())
+	}
+	keyCollisionRows, truncated := r.getKeyCollisionRows()
+	if len(keyCollisionRows) > 0 {
+		summaryFile.WriteString("\nThe following keys exist in multiple source shards\n\n")
+		tableString := &strings.Builder{}
+		table := tablewriter.NewWriter(tableString)
+		table.SetHeader([]string{"Table", "Key", "Source shards"})
+		table.SetAutoWrapText(false)
+		for _, v := range keyCollisionRows {
+			table.Append(v)
+		}
+		table.Render()
+		summaryFile.WriteString(tableString.String())
+		for _, note := range truncated {
+			summaryFile.WriteString(note + "\n")
+		}
+	}
+	excludeRows := r.getExcludeRows()
+	if len(excludeRows) > 0 {
+		summaryFile.WriteString("\nThe rows matching the following conditions are excluded from the comparison\n\n")
+		tableString := &strings.Builder{}
+		table := tablewriter.NewWriter(tableString)
+		table.SetHeader([]string{"Table", "Both sides", "Upstream only", "Downstream only"})
+		table.SetAutoWrapText(false)
+		for _, v := range excludeRows {
+			table.Append(v)
+		}
+		table.Render()
+		summaryFile.WriteString(tableString.String())
+	}
+	budgetSkippedTables := r.getBudgetSkippedTables()
+	if len(budgetSkippedTables) > 0 {
+		summaryFile.WriteString("\nThe following tables are skipped (budget), the time budget expired before checking their data\n\n")
+		for _, table := range budgetSkippedTables {
+			summaryFile.WriteString(table + "\n")
+		}
+	}
+	sampleSkippedTables := r.getSampleSkippedTables()
+	if len(sampleSkippedTables) > 0 {
+		summaryFile.WriteString("\nThe following tables are skipped (sample), none of their chunks is sampled\n\n")
+		for _, table := range sampleSkippedTables {
+			summaryFile.WriteString(table + "\n")
+		}
+	}
+	tableChecksumTables := r.getTableChecksumTables()
+	if len(tableChecksumTables) > 0 {
+		summaryFile.WriteString("\nThe data of the following tables is equal by `ADMIN CHECKSUM TABLE`, their chunks are not checked\n\n")
+		for _, table := range tableChecksumTables {
+			summaryFile.WriteString(table + "\n")
+		}
+	}
+	if r.Sample != nil {
+		summaryFile.WriteString("\nSampling Result\n\n")
+		summaryFile.WriteString(r.Sample.String())
+	}
+	repairedRows := r.getRepairedRows()
+	if len(repairedRows) > 0 {
+		summaryFile.WriteString("\nThe following tables have been repaired\n\n")
+		tableString := &strings.Builder{}
+		table := tablewriter.NewWriter(tableString)
+		table.SetHeader([]string{"Table", "Repaired rows"})
+		for _, v := range repairedRows {
+			table.Append(v)
+		}
+		table.Render()
+		summaryFile.WriteString(tableString.String())
+	}
 	duration := r.Duration + time.Since(r.StartTime)
 	summaryFile.WriteString(fmt.Sprintf("Time Cost: %s\n", duration))
 	summaryFile.WriteString(fmt.Sprintf("Average Speed: %fMB/s\n", float64(r.TotalSize)/(1024.0*1024.0*duration.Seconds())))
-	return nil
+	return errors.Trace(r.exportReports(duration))
 }
 
 func (r *Report) Print(w io.Writer) error {
 	var summary strings.Builder
+	for _, row := range r.getRepairedRows() {
+		summary.WriteString(fmt.Sprintf("The data of %s has been repaired, rows %s\n", row[0], row[1]))
+	}
 	if r.Result == Pass {
 		summary.WriteString(fmt.Sprintf("A total of %d table have been compared and all are equal.\n", r.FailedNum+r.PassNum))
 		summary.WriteString(fmt.Sprintf("You can view the comparision details through '%s/%s'\n", r.task.OutputDir, config.LogFileName))
@@ -245,6 +554,16 @@ func (r *Report) Print(w io.Writer) error {
 		}
 		summary.WriteString(fmt.Sprintf("You can view the comparision details through '%s/%s'\n", r.task.OutputDir, config.LogFileName))
 	}
+	if skipped := r.getBudgetSkippedTables(); len(skipped) > 0 {
+		summary.WriteString(fmt.Sprintf("The time budget expired, the data of %d tables are skipped (budget), "+
+			"they will be checked when the check continues from the checkpoint.\n", len(skipped)))
+	}
+	if skipped := r.getSampleSkippedTables(); len(skipped) > 0 {
+		summary.WriteString(fmt.Sprintf("None of the chunks of %d tables is sampled, their data are skipped (sample).\n", len(skipped)))
+	}
+	if r.Sample != nil {
+		summary.WriteString(r.Sample.String())
+	}
 	fmt.Fprint(w, summary.String())
 	return nil
 }
@@ -274,10 +593,64 @@ func (r *Report) Init(tableDiffs []*common.TableDiff, sourceConfig [][]byte, tar
 			DataEqual:   true,
 			MeetError:   nil,
 			ChunkMap:    make(map[string]*ChunkResult),
+
+			Exclude:           tableDiff.Exclude,
+			UpstreamExclude:   tableDiff.UpstreamExclude,
+			DownstreamExclude: tableDiff.DownstreamExclude,
 		}
 	}
 }
 
+// SetTableOrder records the order to check the tables, so that GetSnapshot keeps the results of the tables
+// checked before a chunk. The tables are assumed to be in the descending order of their names if it isn't set.
+func (r *Report) SetTableOrder(tableDiffs []*common.TableDiff) {
+	r.Lock()
+	defer r.Unlock()
+	r.tableOrder = make(map[string]int, len(tableDiffs))
+	for i, tableDiff := range tableDiffs {
+		r.tableOrder[utils.UniqueID(tableDiff.Schema, tableDiff.Table)] = i
+	}
+}
+
+// InitSample enables the sampling mode, the result of the sampled chunks is recorded by AddSampledChunk.
+func (r *Report) InitSample(rate, confidence, maxInconsistencyRate float64) {
+	r.Sample = &SampleResult{
+		Rate:                 rate,
+		Confidence:           confidence,
+		MaxInconsistencyRate: maxInconsistencyRate,
+	}
+}
+
+// AddSampledChunk records a sampled chunk is checked, and returns whether the upper bound of
+// the inconsistency rate is below the max inconsistency rate.
+func (r *Report) AddSampledChunk(equal bool) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.Sample.SampledChunks++
+	if !equal {
+		r.Sample.FailedChunks++
+	}
+	if r.Sample.MaxInconsistencyRate > 0 && !r.Sample.Reached {
+		_, _, upper := r.Sample.InconsistencyRate()
+		r.Sample.Reached = upper < r.Sample.MaxInconsistencyRate
+	}
+	return r.Sample.Reached
+}
+
+// AddSkippedChunk records a chunk isn't sampled.
+func (r *Report) AddSkippedChunk() {
+	r.Lock()
+	defer r.Unlock()
+	r.Sample.SkippedChunks++
+}
+
+// IsSampleReached returns true if the upper bound of the inconsistency rate is below the max inconsistency rate.
+func (r *Report) IsSampleReached() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Sample != nil && r.Sample.Reached
+}
+
 // SetTableStructCheckResult sets the struct check result for table.
 func (r *Report) SetTableStructCheckResult(schema, table string, equal bool, skip bool) {
 	r.Lock()
@@ -290,6 +663,13 @@ func (r *Report) SetTableStructCheckResult(schema, table string, equal bool, ski
 	}
 }
 
+// SetTableStructDiffs sets the differences of the table struct found by the detailed struct check.
+func (r *Report) SetTableStructDiffs(schema, table string, diffs []*utils.StructDiff) {
+	r.Lock()
+	defer r.Unlock()
+	r.TableResults[schema][table].StructDiffs = diffs
+}
+
 // SetTableDataCheckResult sets the data check result for table.
 func (r *Report) SetTableDataCheckResult(schema, table string, equal bool, rowsAdd, rowsDelete int, id *chunk.ChunkID) {
 	r.Lock()
@@ -314,6 +694,91 @@ func (r *Report) SetTableDataCheckResult(schema, table string, equal bool, rowsA
 	}
 }
 
+// SetTableDataRepairResult records the rows of the chunk that have been repaired,
+// the chunk has been verified equal after repairing so the table's result is not changed.
+func (r *Report) SetTableDataRepairResult(schema, table string, rowsAdd, rowsDelete int, id *chunk.ChunkID) {
+	r.Lock()
+	defer r.Unlock()
+	result := r.TableResults[schema][table]
+	result.ChunkMap[id.ToString()] = &ChunkResult{
+		RowsAdd:    rowsAdd,
+		RowsDelete: rowsDelete,
+		Repaired:   true,
+	}
+}
+
+// SetTableDataCheckRange records the range of the chunk which is not equal.
+func (r *Report) SetTableDataCheckRange(schema, table string, id *chunk.ChunkID, chunkRange string) {
+	r.Lock()
+	defer r.Unlock()
+	result := r.TableResults[schema][table]
+	if chunkResult, ok := result.ChunkMap[id.ToString()]; ok {
+		chunkResult.Range = chunkRange
+	}
+}
+
+// AddTableCheckCost adds the time spent on checking a chunk of the table.
+func (r *Report) AddTableCheckCost(schema, table string, cost time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok {
+		result.CheckCost += cost
+	}
+}
+
+// AddTableLocateQueries adds the grouped checksum queries to locate the different rows of a chunk of the table,
+// and the estimated number of the queries saved compared with binary search.
+func (r *Report) AddTableLocateQueries(schema, table string, queries, saved int64) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok {
+		result.LocateQueries += queries
+		result.LocateQueriesSaved += saved
+	}
+}
+
+// AddTableKeyCollisions adds the keys existing in multiple upstream shards found in a chunk of the table.
+func (r *Report) AddTableKeyCollisions(schema, table string, collisions []*utils.KeyCollision, count int64) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok {
+		result.KeyCollisionCount += count
+		if space := maxTableKeyCollisions - len(result.KeyCollisions); space > 0 {
+			if len(collisions) > space {
+				collisions = collisions[:space]
+			}
+			result.KeyCollisions = append(result.KeyCollisions, collisions...)
+		}
+	}
+}
+
+// SetTableBudgetSkipped marks the data of the table isn't checked because the time budget expired.
+func (r *Report) SetTableBudgetSkipped(schema, table string) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok && !result.DataSkip {
+		result.BudgetSkipped = true
+	}
+}
+
+// SetTableSampleSkipped marks the data of the table isn't checked because none of its chunks is sampled.
+func (r *Report) SetTableSampleSkipped(schema, table string) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok && !result.DataSkip {
+		result.SampleSkipped = true
+	}
+}
+
+// SetTableChecksumMatched marks the data of the table is equal by the table checksums.
+func (r *Report) SetTableChecksumMatched(schema, table string) {
+	r.Lock()
+	defer r.Unlock()
+	if result, ok := r.TableResults[schema][table]; ok {
+		result.TableChecksum = true
+	}
+}
+
 // SetTableMeetError sets meet error when check the table.
 func (r *Report) SetTableMeetError(schema, table string, err error) {
 	r.Lock()
@@ -341,7 +806,11 @@ func (r *Report) GetSnapshot(chunkID *chunk.ChunkID, schema, table string) (*Rep
 		reserveMap[schema] = make(map[string]*TableResult)
 		for table, result := range tableMap {
 			reportID := utils.UniqueID(schema, table)
-			if reportID >= targetID {
+			checked := reportID >= targetID
+			if r.tableOrder != nil {
+				checked = r.tableOrder[reportID] <= r.tableOrder[targetID]
+			}
+			if checked {
 				chunkRes := make(map[string]*ChunkResult)
 				reserveMap[schema][table] = &TableResult{
 					Schema:      result.Schema,
@@ -349,6 +818,17 @@ func (r *Report) GetSnapshot(chunkID *chunk.ChunkID, schema, table string) (*Rep
 					StructEqual: result.StructEqual,
 					DataEqual:   result.DataEqual,
 					MeetError:   result.MeetError,
+					CheckCost:   result.CheckCost,
+
+					LocateQueries:      result.LocateQueries,
+					LocateQueriesSaved: result.LocateQueriesSaved,
+					StructDiffs:        result.StructDiffs,
+					KeyCollisions:      result.KeyCollisions,
+					KeyCollisionCount:  result.KeyCollisionCount,
+					Exclude:            result.Exclude,
+					UpstreamExclude:    result.UpstreamExclude,
+					DownstreamExclude:  result.DownstreamExclude,
+					TableChecksum:      result.TableChecksum,
 				}
 				for id, chunkResult := range result.ChunkMap {
 					sid := new(chunk.ChunkID)
@@ -365,6 +845,11 @@ func (r *Report) GetSnapshot(chunkID *chunk.ChunkID, schema, table string) (*Rep
 		}
 	}
 
+	var sample *SampleResult
+	if r.Sample != nil {
+		s := *r.Sample
+		sample = &s
+	}
 	result := r.Result
 	totalSize := r.TotalSize
 	duration := time.Since(r.StartTime)
@@ -377,6 +862,7 @@ func (r *Report) GetSnapshot(chunkID *chunk.ChunkID, schema, table string) (*Rep
 		StartTime:    r.StartTime,
 		Duration:     duration,
 		TotalSize:    totalSize,
+		Sample:       sample,
 
 		task: task,
 	}, nil
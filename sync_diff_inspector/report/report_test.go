@@ -20,6 +20,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/DATA-DOG/go-sqlmock"
@@ -27,6 +28,7 @@ import (
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/pingcap/tidb/parser"
 	"github.com/stretchr/testify/require"
 )
@@ -246,6 +248,16 @@ func TestPrint(t *testing.T) {
 	require.Equal(t, buf.String(), "A total of 0 table have been compared and all are equal.\n"+
 		"You can view the comparision details through 'output_dir/sync_diff.log'\n")
 
+	// Repaired
+	report.SetTableDataRepairResult("test", "tbl", 3, 1, &chunk.ChunkID{0, 0, 0, 1, 2})
+	require.Equal(t, report.getRepairedRows(), [][]string{{"`test`.`tbl`", "+3/-1"}})
+	buf = new(bytes.Buffer)
+	report.Print(buf)
+	require.Equal(t, buf.String(), "The data of `test`.`tbl` has been repaired, rows +3/-1\n"+
+		"A total of 0 table have been compared and all are equal.\n"+
+		"You can view the comparision details through 'output_dir/sync_diff.log'\n")
+	delete(report.TableResults["test"]["tbl"].ChunkMap, "0:0-0:1:2")
+
 	// Error
 	report.SetTableMeetError("test", "tbl", errors.New("123"))
 	report.SetTableStructCheckResult("test", "tbl", false, false)
@@ -256,6 +268,123 @@ func TestPrint(t *testing.T) {
 		"You can view the comparision details through 'output_dir/sync_diff.log'\n")
 }
 
+func TestSample(t *testing.T) {
+	report := NewReport(task)
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "tbl"}}, nil, nil)
+	report.InitSample(0.5, 0.99, 0.11)
+	require.False(t, report.IsSampleReached())
+
+	report.AddSkippedChunk()
+	require.False(t, report.AddSampledChunk(false))
+	// 1 of 71 chunks is not equal, the upper bound is 0.1096
+	for i := 1; i < 70; i++ {
+		require.False(t, report.AddSampledChunk(true))
+	}
+	require.True(t, report.AddSampledChunk(true))
+	require.True(t, report.IsSampleReached())
+	estimate, lower, upper := report.Sample.InconsistencyRate()
+	require.InDelta(t, 1.0/71, estimate, 1e-9)
+	require.Less(t, lower, estimate)
+	require.Less(t, upper, 0.11)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, report.Print(buf))
+	require.Contains(t, buf.String(), "Sampled 71 chunks and skipped 1 chunks, 1 sampled chunks are not equal.\n")
+
+	// the sampled chunks are saved in the checkpoint
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	newReport := NewReport(task)
+	newReport.InitSample(0.5, 0.99, 0.1)
+	newReport.LoadReport(snapshot)
+	require.Equal(t, int64(71), newReport.Sample.SampledChunks)
+	require.Equal(t, int64(1), newReport.Sample.FailedChunks)
+	require.Equal(t, int64(1), newReport.Sample.SkippedChunks)
+}
+
+func TestLocateQueries(t *testing.T) {
+	report := NewReport(task)
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "tbl"}, {Schema: "test", Table: "tbl2"}}, nil, nil)
+	report.AddTableLocateQueries("test", "tbl", 4, 16)
+	report.AddTableLocateQueries("test", "tbl", 2, 8)
+	// the table doesn't exist
+	report.AddTableLocateQueries("test", "tbl3", 2, 8)
+	require.Equal(t, int64(6), report.TableResults["test"]["tbl"].LocateQueries)
+	require.Equal(t, int64(24), report.TableResults["test"]["tbl"].LocateQueriesSaved)
+	require.Equal(t, [][]string{{"`test`.`tbl`", "6", "24"}}, report.getLocateRows())
+
+	jsonReport := report.GetJSONReport(time.Second)
+	// `test`.`tbl2` is sorted before `test`.`tbl`
+	require.Equal(t, int64(6), jsonReport.Tables[1].LocateQueries)
+	require.Equal(t, int64(24), jsonReport.Tables[1].LocateQueriesSaved)
+	require.Zero(t, jsonReport.Tables[0].LocateQueries)
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	require.Equal(t, int64(6), snapshot.TableResults["test"]["tbl"].LocateQueries)
+	require.Equal(t, int64(24), snapshot.TableResults["test"]["tbl"].LocateQueriesSaved)
+}
+
+func TestStructDiffs(t *testing.T) {
+	report := NewReport(task)
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "tbl"}, {Schema: "test", Table: "tbl2"}}, nil, nil)
+	diffs := []*utils.StructDiff{
+		{Object: "column `a`", Attribute: "type", Upstream: "varchar(20)", Downstream: "varchar(10)"},
+		{Object: "table", Attribute: "collation", Upstream: "utf8mb4_bin", Downstream: "utf8mb4_general_ci"},
+	}
+	report.SetTableStructDiffs("test", "tbl", diffs)
+	report.SetTableStructCheckResult("test", "tbl", false, false)
+	require.Equal(t, [][]string{
+		{"`test`.`tbl`", "column `a`", "type", "varchar(20)", "varchar(10)"},
+		{"`test`.`tbl`", "table", "collation", "utf8mb4_bin", "utf8mb4_general_ci"},
+	}, report.getStructDiffRows())
+
+	jsonReport := report.GetJSONReport(time.Second)
+	require.Equal(t, diffs, jsonReport.Tables[1].StructDiffs)
+	require.Empty(t, jsonReport.Tables[0].StructDiffs)
+	junitReport := GetJUnitReport(jsonReport)
+	require.Contains(t, junitReport.Suites[0].TestCases[1].Failure.Content, "column `a` type: upstream \"varchar(20)\", downstream \"varchar(10)\"\n")
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	require.Equal(t, diffs, snapshot.TableResults["test"]["tbl"].StructDiffs)
+}
+
+func TestKeyCollisions(t *testing.T) {
+	report := NewReport(task)
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "tbl"}, {Schema: "test", Table: "tbl2"}}, nil, nil)
+	id := "1"
+	collisions := []*utils.KeyCollision{
+		{Key: map[string]*string{"id": &id}, Shards: []string{"127.0.0.1:3306/`shard`.`tbl_1`", "127.0.0.1:3307/`shard`.`tbl_2`"}},
+	}
+	report.AddTableKeyCollisions("test", "tbl", collisions, 1)
+	// the collisions beyond the limit are only counted.
+	more := make([]*utils.KeyCollision, maxTableKeyCollisions)
+	for i := range more {
+		more[i] = collisions[0]
+	}
+	report.AddTableKeyCollisions("test", "tbl", more, 120)
+	// the table doesn't exist
+	report.AddTableKeyCollisions("test", "tbl3", collisions, 1)
+	require.Len(t, report.TableResults["test"]["tbl"].KeyCollisions, maxTableKeyCollisions)
+	require.Equal(t, int64(121), report.TableResults["test"]["tbl"].KeyCollisionCount)
+
+	rows, truncated := report.getKeyCollisionRows()
+	require.Len(t, rows, maxTableKeyCollisions)
+	require.Equal(t, []string{"`test`.`tbl`", `{"id":"1"}`, "127.0.0.1:3306/`shard`.`tbl_1`\n127.0.0.1:3307/`shard`.`tbl_2`"}, rows[0])
+	require.Equal(t, []string{"`test`.`tbl` has 121 collided keys, only 100 of them are shown"}, truncated)
+
+	jsonReport := report.GetJSONReport(time.Second)
+	require.Equal(t, collisions[0], jsonReport.Tables[1].KeyCollisions[0])
+	require.Equal(t, int64(121), jsonReport.Tables[1].KeyCollisionCount)
+	require.Empty(t, jsonReport.Tables[0].KeyCollisions)
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	require.Equal(t, int64(121), snapshot.TableResults["test"]["tbl"].KeyCollisionCount)
+	require.Len(t, snapshot.TableResults["test"]["tbl"].KeyCollisions, maxTableKeyCollisions)
+}
+
 func TestGetSnapshot(t *testing.T) {
 	report := NewReport(task)
 	createTableSQL1 := "create table `test`.`tbl`(`a` int, `b` varchar(10), `c` float, `d` datetime, primary key(`a`, `b`))"
@@ -482,3 +611,127 @@ func TestCommitSummary(t *testing.T) {
 	err = os.Remove(filename)
 	require.NoError(t, err)
 }
+
+func TestBudgetSkipped(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewReport(&config.TaskConfig{OutputDir: outputDir, FixDir: task.FixDir, ReportFormats: []string{config.ReportFormatJUnit}})
+	// the tables are checked in the order of the priority instead of the names.
+	tableDiffs := []*common.TableDiff{{Schema: "atest", Table: "tbl"}, {Schema: "test", Table: "tbl"}, {Schema: "xtest", Table: "tbl"}}
+	report.Init(tableDiffs, nil, nil)
+	report.SetTableOrder(tableDiffs)
+	report.SetTableStructCheckResult("xtest", "tbl", false, true)
+	report.SetTableDataCheckResult("atest", "tbl", false, 1, 2, &chunk.ChunkID{0, 0, 0, 0, 1})
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "atest", "tbl")
+	require.NoError(t, err)
+	require.Len(t, snapshot.TableResults["atest"], 1)
+	require.Empty(t, snapshot.TableResults["test"])
+	require.Empty(t, snapshot.TableResults["xtest"])
+
+	report.SetTableBudgetSkipped("test", "tbl")
+	// the data check of the table is skipped because of the struct.
+	report.SetTableBudgetSkipped("xtest", "tbl")
+	require.True(t, report.TableResults["test"]["tbl"].BudgetSkipped)
+	require.False(t, report.TableResults["xtest"]["tbl"].BudgetSkipped)
+	require.Equal(t, []string{"`test`.`tbl`"}, report.getBudgetSkippedTables())
+
+	require.NoError(t, report.CommitSummary())
+	require.Equal(t, int32(0), report.PassNum)
+	require.Equal(t, int32(2), report.FailedNum)
+	summary, err := os.ReadFile(path.Join(outputDir, SummaryFile))
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "The table structure and data in following tables are equivalent\n\n\n")
+	require.Contains(t, string(summary), "The following tables are skipped (budget), the time budget expired before checking their data\n\n`test`.`tbl`\n")
+	junit, err := os.ReadFile(path.Join(outputDir, JUnitReportFile))
+	require.NoError(t, err)
+	require.Contains(t, string(junit), `<skipped message="skipped (budget)" type="skipped"></skipped>`)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, report.Print(buf))
+	require.Contains(t, buf.String(), "The time budget expired, the data of 1 tables are skipped (budget)")
+
+	// the tables skipped are not recorded in the history.
+	verifiedAt := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	history := &History{Tables: map[string]time.Time{"ctest:tbl": verifiedAt}}
+	report.UpdateHistory(history, verifiedAt)
+	require.Equal(t, map[string]time.Time{"atest:tbl": verifiedAt, "ctest:tbl": verifiedAt}, history.Tables)
+
+	report.TableResults["test"]["tbl"].BudgetSkipped = false
+	report.UpdateHistory(history, verifiedAt.Add(time.Hour))
+	require.Equal(t, map[string]time.Time{"atest:tbl": verifiedAt.Add(time.Hour), "ctest:tbl": verifiedAt, "test:tbl": verifiedAt.Add(time.Hour)}, history.Tables)
+}
+
+func TestSampleSkipped(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewReport(&config.TaskConfig{OutputDir: outputDir, FixDir: task.FixDir, ReportFormats: []string{config.ReportFormatJUnit}})
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "t1"}, {Schema: "test", Table: "t2"}}, nil, nil)
+	report.InitSample(0.5, 0.95, 0.01)
+	report.SetTableSampleSkipped("test", "t2")
+	require.Equal(t, []string{"`test`.`t2`"}, report.getSampleSkippedTables())
+
+	// the table skipped isn't reported as equal.
+	require.NoError(t, report.CommitSummary())
+	require.Equal(t, int32(1), report.PassNum)
+	require.Equal(t, int32(0), report.FailedNum)
+	summary, err := os.ReadFile(path.Join(outputDir, SummaryFile))
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "The table structure and data in following tables are equivalent\n\n`test`.`t1`\n")
+	require.Contains(t, string(summary), "The following tables are skipped (sample), none of their chunks is sampled\n\n`test`.`t2`\n")
+	junit, err := os.ReadFile(path.Join(outputDir, JUnitReportFile))
+	require.NoError(t, err)
+	require.Contains(t, string(junit), `<skipped message="skipped (sample)" type="skipped"></skipped>`)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, report.Print(buf))
+	require.Contains(t, buf.String(), "None of the chunks of 1 tables is sampled, their data are skipped (sample).")
+}
+
+func TestExcludeRows(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewReport(&config.TaskConfig{OutputDir: outputDir, FixDir: task.FixDir})
+	report.Init([]*common.TableDiff{
+		{Schema: "test", Table: "tbl", Exclude: "`deleted_at` IS NOT NULL", DownstreamExclude: "`tenant` = 'test'"},
+		{Schema: "test", Table: "tbl2"},
+	}, nil, nil)
+	require.Equal(t, [][]string{{"`test`.`tbl`", "`deleted_at` IS NOT NULL", "", "`tenant` = 'test'"}}, report.getExcludeRows())
+
+	require.NoError(t, report.CommitSummary())
+	summary, err := os.ReadFile(path.Join(outputDir, SummaryFile))
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "The rows matching the following conditions are excluded from the comparison\n\n")
+	require.Contains(t, string(summary), "| `test`.`tbl` | `deleted_at` IS NOT NULL |               | `tenant` = 'test' |")
+
+	jsonReport := report.GetJSONReport(time.Second)
+	// the tables are sorted by the quoted names.
+	require.Equal(t, "`deleted_at` IS NOT NULL", jsonReport.Tables[1].Exclude)
+	require.Equal(t, "`tenant` = 'test'", jsonReport.Tables[1].DownstreamExclude)
+	require.Empty(t, jsonReport.Tables[0].Exclude)
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	require.Equal(t, "`tenant` = 'test'", snapshot.TableResults["test"]["tbl"].DownstreamExclude)
+}
+
+func TestTableChecksumMatched(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewReport(&config.TaskConfig{OutputDir: outputDir, FixDir: task.FixDir})
+	report.Init([]*common.TableDiff{{Schema: "test", Table: "tbl"}, {Schema: "test", Table: "tbl2"}}, nil, nil)
+	report.SetTableStructCheckResult("test", "tbl", true, false)
+	report.SetTableStructCheckResult("test", "tbl2", true, false)
+	report.SetTableChecksumMatched("test", "tbl")
+	require.Equal(t, []string{"`test`.`tbl`"}, report.getTableChecksumTables())
+
+	require.NoError(t, report.CommitSummary())
+	require.Equal(t, int32(2), report.PassNum)
+	summary, err := os.ReadFile(path.Join(outputDir, SummaryFile))
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "The data of the following tables is equal by `ADMIN CHECKSUM TABLE`, their chunks are not checked\n\n`test`.`tbl`\n")
+
+	jsonReport := report.GetJSONReport(time.Second)
+	require.True(t, jsonReport.Tables[1].TableChecksum)
+	require.False(t, jsonReport.Tables[0].TableChecksum)
+
+	snapshot, err := report.GetSnapshot(&chunk.ChunkID{0, 0, 0, 0, 1}, "test", "tbl")
+	require.NoError(t, err)
+	require.True(t, snapshot.TableResults["test"]["tbl"].TableChecksum)
+}
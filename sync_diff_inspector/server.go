@@ -0,0 +1,461 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/utils"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/metrics"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/progress"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	flag "github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// serverCommand is the subcommand running the checks submitted over http.
+const serverCommand = "server"
+
+const (
+	taskPending   = "pending"
+	taskRunning   = "running"
+	taskFinished  = "finished"
+	taskFailed    = "failed"
+	taskCancelled = "cancelled"
+
+	maxTaskConfigSize = 16 << 20
+)
+
+// checkTask is a check submitted to the server.
+type checkTask struct {
+	sync.RWMutex
+	id         string
+	cfg        *config.Config
+	state      string
+	err        error
+	createTime time.Time
+	startTime  time.Time
+	finishTime time.Time
+	// status is the status of the running check, nil before the check starts.
+	status *checkStatus
+	// report is the report of the finished check.
+	report *report.JSONReport
+	cancel context.CancelFunc
+}
+
+// taskInfo is the task served by the api.
+type taskInfo struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	OutputDir  string     `json:"output-dir"`
+	CreateTime time.Time  `json:"create-time"`
+	StartTime  *time.Time `json:"start-time,omitempty"`
+	FinishTime *time.Time `json:"finish-time,omitempty"`
+	// Result is the result of the finished check, pass or fail.
+	Result string `json:"result,omitempty"`
+	// Progress is the status of the check, see checkStatus.
+	Progress json.RawMessage `json:"progress,omitempty"`
+}
+
+func (t *checkTask) info() *taskInfo {
+	t.RLock()
+	defer t.RUnlock()
+	info := &taskInfo{
+		ID:         t.id,
+		State:      t.state,
+		OutputDir:  t.cfg.Task.OutputDir,
+		CreateTime: t.createTime,
+	}
+	if t.err != nil {
+		info.Error = t.err.Error()
+	}
+	if !t.startTime.IsZero() {
+		startTime := t.startTime
+		info.StartTime = &startTime
+	}
+	if !t.finishTime.IsZero() {
+		finishTime := t.finishTime
+		info.FinishTime = &finishTime
+	}
+	if t.report != nil {
+		info.Result = t.report.Result
+	}
+	if t.status != nil {
+		data, err := t.status.marshal()
+		if err != nil {
+			log.Warn("fail to marshal the status", zap.String("task", t.id), zap.Error(err))
+		} else {
+			info.Progress = data
+		}
+	}
+	return info
+}
+
+func (t *checkTask) isActive() bool {
+	t.RLock()
+	defer t.RUnlock()
+	return t.state == taskPending || t.state == taskRunning
+}
+
+func (t *checkTask) start(status *checkStatus) {
+	t.Lock()
+	defer t.Unlock()
+	t.state = taskRunning
+	t.startTime = time.Now()
+	t.status = status
+}
+
+func (t *checkTask) finish(jsonReport *report.JSONReport, err error, cancelled bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.finishTime = time.Now()
+	t.report = jsonReport
+	t.err = err
+	switch {
+	case cancelled:
+		t.state = taskCancelled
+	case err != nil:
+		t.state = taskFailed
+	default:
+		t.state = taskFinished
+	}
+	log.Info("check task finished", zap.String("task", t.id), zap.String("state", t.state), zap.Error(err))
+}
+
+// checkServer runs the checks submitted over http, at most `concurrency` checks run at the same time.
+type checkServer struct {
+	sync.RWMutex
+	tasks map[string]*checkTask
+	// taskIDs are the ids of the tasks in the order of submission.
+	taskIDs []string
+	nextID  int
+
+	concurrency chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func newCheckServer(concurrency int) *checkServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &checkServer{
+		tasks:       make(map[string]*checkTask),
+		concurrency: make(chan struct{}, concurrency),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// handler serves the prometheus metrics on `/metrics` and the api:
+//
+//	GET  /api/v1/tasks               list the tasks
+//	POST /api/v1/tasks               submit a task, the body is the config in json
+//	GET  /api/v1/tasks/{id}          get the state and progress of the task
+//	GET  /api/v1/tasks/{id}/report   get the json report of the finished task
+//	POST /api/v1/tasks/{id}/cancel   cancel the task, the checkpoint is kept to resume it
+func (s *checkServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/api/v1/tasks", s.handleTasks)
+	mux.HandleFunc("/api/v1/tasks/", s.handleTask)
+	return mux
+}
+
+func (s *checkServer) handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.RLock()
+		infos := make([]*taskInfo, 0, len(s.taskIDs))
+		for _, id := range s.taskIDs {
+			infos = append(infos, s.tasks[id].info())
+		}
+		s.RUnlock()
+		writeJSON(w, http.StatusOK, infos)
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxTaskConfigSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		task, code, err := s.submit(data)
+		if err != nil {
+			writeError(w, code, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, task.info())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *checkServer) handleTask(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/"), "/")
+	parts := strings.Split(path, "/")
+	s.RLock()
+	task, ok := s.tasks[parts[0]]
+	s.RUnlock()
+	if !ok || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, errors.NotFoundf("task %s", path))
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, task.info())
+	case action == "report" && r.Method == http.MethodGet:
+		task.RLock()
+		jsonReport := task.report
+		task.RUnlock()
+		if jsonReport == nil {
+			writeError(w, http.StatusNotFound, errors.NotFoundf("report of the unfinished task %s", task.id))
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonReport)
+	case action == "cancel" && r.Method == http.MethodPost:
+		log.Info("cancel check task", zap.String("task", task.id))
+		task.cancel()
+		writeJSON(w, http.StatusAccepted, task.info())
+	case action != "" && action != "report" && action != "cancel":
+		writeError(w, http.StatusNotFound, errors.NotFoundf("action %s", action))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+// parseTaskConfig parses the config of a task in json, the keys are the same as the toml config.
+func parseTaskConfig(data []byte) (*config.Config, error) {
+	cfg := config.NewConfig()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, errors.Annotate(err, "parse the config")
+	}
+	return cfg, nil
+}
+
+// submit parses the config and queues the task, returns the http status code if it fails.
+func (s *checkServer) submit(data []byte) (*checkTask, int, error) {
+	cfg, err := parseTaskConfig(data)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Trace(err)
+	}
+	if len(cfg.StatusAddr) != 0 {
+		return nil, http.StatusBadRequest, errors.New("status-addr is not supported in the server mode, get the status of the task by the api instead")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	outputDir := filepath.Clean(cfg.Task.OutputDir)
+	for _, task := range s.tasks {
+		if task.isActive() && filepath.Clean(task.cfg.Task.OutputDir) == outputDir {
+			return nil, http.StatusConflict, errors.Errorf("the output dir %s is used by task %s", outputDir, task.id)
+		}
+	}
+	if err := cfg.Init(); err != nil {
+		return nil, http.StatusBadRequest, errors.Annotate(err, "fail to initialize config")
+	}
+	if !cfg.CheckConfig() {
+		return nil, http.StatusBadRequest, errors.New("there is something wrong with the config, please check the log of the server")
+	}
+
+	s.nextID++
+	ctx, cancel := context.WithCancel(s.ctx)
+	task := &checkTask{
+		id:         strconv.Itoa(s.nextID),
+		cfg:        cfg,
+		state:      taskPending,
+		createTime: time.Now(),
+		cancel:     cancel,
+	}
+	s.tasks[task.id] = task
+	s.taskIDs = append(s.taskIDs, task.id)
+	log.Info("submit check task", zap.String("task", task.id), zap.Stringer("config", cfg))
+
+	s.wg.Add(1)
+	go s.runTask(ctx, task)
+	return task, http.StatusCreated, nil
+}
+
+func (s *checkServer) runTask(ctx context.Context, task *checkTask) {
+	defer s.wg.Done()
+	defer task.cancel()
+	select {
+	case s.concurrency <- struct{}{}:
+	case <-ctx.Done():
+		task.finish(nil, ctx.Err(), true)
+		return
+	}
+	defer func() { <-s.concurrency }()
+
+	log.Info("start check task", zap.String("task", task.id))
+	jsonReport, err := runCheck(ctx, task.cfg, task.start)
+	task.finish(jsonReport, err, ctx.Err() != nil)
+}
+
+// close cancels all the tasks and waits for them to save the checkpoints.
+func (s *checkServer) close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// runCheck runs the check and returns the json report. The checkpoint is removed only if the check
+// finishes, so the check can be resumed by submitting the same config if it is cancelled or fails.
+func runCheck(ctx context.Context, cfg *config.Config, onStart func(*checkStatus)) (*report.JSONReport, error) {
+	d, err := NewDiff(ctx, cfg)
+	if err != nil {
+		return nil, errors.Annotate(err, "fail to initialize diff")
+	}
+	onStart(d.status)
+
+	if err = d.StructEqual(ctx); err != nil {
+		d.release()
+		return nil, errors.Annotate(err, "fail to check structure difference")
+	}
+	if !d.ignoreDataCheck {
+		if d.recheck {
+			err = d.Recheck(ctx)
+		} else {
+			err = d.Equal(ctx)
+		}
+		if err != nil {
+			d.release()
+			return nil, errors.Annotate(err, "fail to check data difference")
+		}
+		if ctx.Err() != nil {
+			// the iterator stops producing chunks if the context is done.
+			d.release()
+			return nil, errors.Trace(ctx.Err())
+		}
+		if err = d.saveRecheckState(ctx); err != nil {
+			log.Warn("fail to save the recheck state", zap.Error(err))
+		}
+		if err = d.saveCheckHistory(ctx); err != nil {
+			log.Warn("fail to save the check history", zap.Error(err))
+		}
+	}
+	if err = d.commitSummary(ctx); err != nil {
+		d.release()
+		return nil, errors.Annotate(err, "fail to commit report")
+	}
+	if err = d.Close(); err != nil {
+		// the checkpoint is kept, the same task would skip the checked chunks next time.
+		return nil, errors.Trace(err)
+	}
+	return d.report.GetJSONReport(d.report.Duration + time.Since(d.report.StartTime)), nil
+}
+
+// runServer runs the checks submitted over http until it receives SIGINT or SIGTERM,
+// then it cancels the running checks and exits.
+func runServer(args []string) int {
+	fs := flag.NewFlagSet(serverCommand, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sync_diff_inspector %s [flags]\n", serverCommand)
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "127.0.0.1:8341", "the address to serve the api")
+	concurrency := fs.Int("max-concurrent-tasks", 2, "how many tasks can run at the same time, the rest are pending")
+	logLevel := fs.StringP("log-level", "L", "info", "log level: debug, info, warn, error, fatal")
+	logFile := fs.String("log-file", "", "the log file, print to stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		if errors.Cause(err) == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 0 || *concurrency <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	conf := &log.Config{Level: *logLevel}
+	conf.File.Filename = *logFile
+	lg, p, err := log.InitLogger(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: fail to init log, %s\n", err.Error())
+		return 2
+	}
+	log.ReplaceGlobals(lg, p)
+	utils.PrintInfo("sync_diff_inspector")
+
+	// the progress bar of the tasks running at the same time can't be printed.
+	progress.Disable()
+	registerMetricsOnce.Do(func() {
+		metrics.RegisterMetrics(prometheus.DefaultRegisterer)
+	})
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Error("fail to listen", zap.String("address", *addr), zap.Error(err))
+		return 2
+	}
+	s := newCheckServer(*concurrency)
+	httpServer := &http.Server{Handler: s.handler()}
+	go func() {
+		log.Info("start server", zap.String("address", *addr), zap.Int("max concurrent tasks", *concurrency))
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("server exits", zap.Error(err))
+		}
+	}()
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sc
+	log.Info("got signal to exit", zap.Stringer("signal", sig))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Warn("fail to shutdown the server", zap.Error(err))
+	}
+	s.close()
+	return 0
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Warn("fail to marshal the response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
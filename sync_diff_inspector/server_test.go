@@ -0,0 +1,98 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	column "github.com/pingcap/tidb-tools/pkg/column-mapping"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTaskConfig(t *testing.T) {
+	// the keys of the table config are the same as the toml config.
+	cfg, err := parseTaskConfig([]byte(`{
+		"check-thread-count": 2,
+		"table-configs": {
+			"config1": {
+				"target-tables": ["test.t"],
+				"ignore-columns": ["c"],
+				"index-fields": ["id"],
+				"range": "id > 10",
+				"collation": "utf8mb4_bin",
+				"chunk-size": 1000
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, 2, cfg.CheckThreadCount)
+	require.Equal(t, &config.TableConfig{
+		TargetTables:  []string{"test.t"},
+		IgnoreColumns: []string{"c"},
+		Fields:        []string{"id"},
+		Range:         "id > 10",
+		Collation:     "utf8mb4_bin",
+		ChunkSize:     1000,
+	}, cfg.TableConfigs["config1"])
+
+	// every field of the table config round-trips.
+	tableConfig := &config.TableConfig{
+		TargetTables:     []string{"test.t"},
+		IgnoreColumns:    []string{"c"},
+		Fields:           []string{"id"},
+		Range:            "id > 10",
+		Collation:        "utf8mb4_bin",
+		ChunkSize:        1000,
+		UpdateTimeColumn: "updated_at",
+		ColumnTransforms: map[string]string{"name": "TRIM(name)"},
+		ColumnMappings: []*column.Rule{{
+			PatternSchema: "test_*",
+			PatternTable:  "t_*",
+			TargetColumn:  "id",
+			Expression:    column.AddPrefix,
+			Arguments:     []string{"p_"},
+		}},
+		Tolerances:        []*config.ToleranceConfig{{Columns: []string{"price"}, Absolute: 0.01}},
+		LocateStrategy:    config.LocateStrategyGroupedChecksum,
+		ChecksumAlgorithm: "hash64",
+		Priority:          3,
+		Exclude:           "deleted_at IS NOT NULL",
+		UpstreamExclude:   "tenant_id = 0",
+		DownstreamExclude: "tenant_id = 1",
+	}
+	data, err := json.Marshal(map[string]interface{}{"table-configs": map[string]*config.TableConfig{"config1": tableConfig}})
+	require.NoError(t, err)
+	cfg, err = parseTaskConfig(data)
+	require.NoError(t, err)
+	require.Equal(t, tableConfig, cfg.TableConfigs["config1"])
+
+	_, err = parseTaskConfig([]byte(`{"table-configs": {"config1": {"IgnoreColumns": ["c"]}}}`))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown field")
+
+	// the unknown fields are rejected by the api.
+	s := newCheckServer(1)
+	defer s.close()
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/api/v1/tasks", "application/json", strings.NewReader(`{"no-such-field": 1}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
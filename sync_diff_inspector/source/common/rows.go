@@ -83,6 +83,13 @@ func (r RowDatas) Less(i, j int) bool {
 
 	return false
 }
+
+// KeyEqual returns true if the order keys of the two rows are equal.
+func (r RowDatas) KeyEqual(row1, row2 map[string]*dbutil.ColumnData) bool {
+	rows := RowDatas{Rows: []RowData{{Data: row1}, {Data: row2}}, OrderKeyCols: r.OrderKeyCols}
+	return !rows.Less(0, 1) && !rows.Less(1, 0)
+}
+
 func (r RowDatas) Swap(i, j int) { r.Rows[i], r.Rows[j] = r.Rows[j], r.Rows[i] }
 
 // Push implements heap.Interface's Push function
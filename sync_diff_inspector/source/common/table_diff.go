@@ -15,7 +15,10 @@ package common
 
 import (
 	"database/sql"
+	"fmt"
 
+	column "github.com/pingcap/tidb-tools/pkg/column-mapping"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/pingcap/tidb/parser/model"
 )
 
@@ -26,6 +29,8 @@ type TableShardSource struct {
 	// DBConn represents the origin DB connection for this TableSource.
 	// This TableSource may exists in different MySQL shard.
 	DBConn *sql.DB
+	// Instance is the address of the MySQL shard having this TableSource.
+	Instance string
 }
 
 // TableSource represents the origin schema and table before router.
@@ -59,10 +64,66 @@ type TableDiff struct {
 	// ignore check table's data
 	IgnoreDataCheck bool `json:"-"`
 
+	// the data of the table is equal by the table checksums of both sides, so the chunks aren't checked.
+	TableChecksumMatched bool `json:"-"`
+
 	// the table has column timestamp, which need to reset time_zone.
 	NeedUnifiedTimeZone bool `json:"-"`
 
 	Collation string `json:"collation"`
 
 	ChunkSize int64 `json:"chunk-size"`
+
+	// the column records the update time of the row, used by the recheck mode.
+	UpdateTimeColumn string `json:"-"`
+
+	// the sql expressions applied to the upstream columns before comparing.
+	ColumnTransforms map[string]string `json:"-"`
+
+	// the column mapping rules applied to the upstream columns before comparing,
+	// there is a mapping for each rule because DM only applies one rule to a table.
+	ColumnMappings []*column.Mapping `json:"-"`
+
+	// the tolerances of the numeric columns, the key is the column name.
+	Tolerances map[string]*utils.Tolerance `json:"-"`
+
+	// the strategy to locate the different rows in a chunk.
+	LocateStrategy string `json:"-"`
+
+	// the algorithm to calculate the checksum of the chunks.
+	ChecksumAlgorithm string `json:"-"`
+
+	// the tables with higher priority are checked first.
+	Priority int `json:"-"`
+
+	// the conditions of the rows excluded from the comparison. `Exclude` is applied to both the upstream
+	// and the downstream with the range, the others are only applied to the upstream or the downstream.
+	Exclude           string `json:"-"`
+	UpstreamExclude   string `json:"-"`
+	DownstreamExclude string `json:"-"`
+	// SplitExclude is the condition of the rows excluded from the source splitting the chunks, which
+	// is only applied to the queries of the splitter. It's set to the copy of the table by the table analyzer.
+	SplitExclude string `json:"-"`
+}
+
+// GetExclude returns the condition of the rows excluded only from the upstream or the downstream.
+func (t *TableDiff) GetExclude(upstream bool) string {
+	if upstream {
+		return t.UpstreamExclude
+	}
+	return t.DownstreamExclude
+}
+
+// ExcludeRows returns the where clause selecting the rows not excluded by the condition,
+// the rows whose condition is NULL are not excluded.
+func ExcludeRows(where, exclude string) string {
+	if len(exclude) == 0 {
+		return where
+	}
+	return fmt.Sprintf("(%s) AND ((%s) IS NOT TRUE)", where, exclude)
+}
+
+// HasColumnTransforms returns true if the upstream values of the table are transformed before comparing.
+func (t *TableDiff) HasColumnTransforms() bool {
+	return len(t.ColumnTransforms) != 0 || len(t.ColumnMappings) != 0
 }
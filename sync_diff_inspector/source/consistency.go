@@ -0,0 +1,271 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultConsistencyTimeout is the default max duration to wait for a consistent point.
+	DefaultConsistencyTimeout       = 10 * time.Minute
+	defaultConsistencyCheckInterval = 5 * time.Second
+
+	// the table TiCDC writes the syncpoints to, the schema is `tidb_cdc` by default.
+	defaultSyncpointSchema = "tidb_cdc"
+	syncpointTable         = "syncpoint_v1"
+	// the schema DM writes the checkpoints to by default, the table is `<task>_syncer_checkpoint`.
+	defaultDMMetaSchema = "dm_meta"
+)
+
+// binlogPos is a binlog position of MySQL.
+type binlogPos struct {
+	name string
+	pos  uint64
+}
+
+func (p binlogPos) String() string {
+	return fmt.Sprintf("(%s, %d)", p.name, p.pos)
+}
+
+// before returns true if the position is before the other one. The binlog names are compared by their
+// sequence numbers, and the relay suffix of DM like `mysql-bin|000001.000003` is ignored.
+func (p binlogPos) before(other binlogPos) bool {
+	seq, otherSeq := binlogSeq(p.name), binlogSeq(other.name)
+	if seq != otherSeq {
+		return seq < otherSeq
+	}
+	return p.pos < other.pos
+}
+
+func binlogSeq(name string) uint64 {
+	seq, err := strconv.ParseUint(name[strings.LastIndex(name, ".")+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// WaitForConsistency waits for the replication tool to bring the target instance to the same logical point as
+// the source instances, it returns at once if `consistency` isn't configured. The snapshots of the source and target
+// instances are set to the pair of TSOs read from the syncpoint of TiCDC, and the checkpoint of DM is waited to
+// catch up with the binlog positions of the source instances when the wait begins.
+func WaitForConsistency(ctx context.Context, cfg *config.Config) error {
+	consistency := cfg.Consistency
+	if consistency == nil {
+		return nil
+	}
+	timeout, interval := DefaultConsistencyTimeout, defaultConsistencyCheckInterval
+	var err error
+	if len(consistency.Timeout) != 0 {
+		if timeout, err = time.ParseDuration(consistency.Timeout); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(consistency.CheckInterval) != 0 {
+		if interval, err = time.ParseDuration(consistency.CheckInterval); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// the connections read the latest data, the snapshots are set after the wait.
+	targetConn, err := common.CreateDBForCP(ctx, *cfg.Task.TargetInstance.ToDBConfig())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer targetConn.Close()
+	sourceConns := make([]*sql.DB, 0, len(cfg.Task.SourceInstances))
+	defer func() {
+		for _, conn := range sourceConns {
+			conn.Close()
+		}
+	}()
+	for _, source := range cfg.Task.SourceInstances {
+		conn, err := common.CreateDBForCP(ctx, *source.ToDBConfig())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sourceConns = append(sourceConns, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	switch consistency.Type {
+	case config.ConsistencySyncpoint:
+		schema := consistency.Schema
+		if len(schema) == 0 {
+			schema = defaultSyncpointSchema
+		}
+		primaryTS, secondaryTS, err := waitForSyncpoint(ctx, sourceConns[0], targetConn, schema, consistency.Changefeed, interval)
+		if err != nil {
+			return errors.Annotatef(err, "failed to wait for the syncpoint of TiCDC in %s", timeout)
+		}
+		log.Info("read the snapshots from the syncpoint of TiCDC", zap.String("upstream snapshot", primaryTS), zap.String("downstream snapshot", secondaryTS))
+		cfg.Task.SourceInstances[0].Snapshot = primaryTS
+		cfg.Task.TargetInstance.Snapshot = secondaryTS
+	case config.ConsistencyDMCheckpoint:
+		schema, task := consistency.Schema, consistency.DMTask
+		if len(schema) == 0 {
+			schema = defaultDMMetaSchema
+		}
+		if len(task) == 0 {
+			task = cfg.DMTask
+		}
+		if err := waitForDMCheckpoint(ctx, cfg.Task.Source, sourceConns, targetConn, schema, task, interval); err != nil {
+			return errors.Annotatef(err, "failed to wait for the checkpoint of DM in %s", timeout)
+		}
+	default:
+		return errors.Errorf("unknown consistency type %s", consistency.Type)
+	}
+	return nil
+}
+
+// waitForSyncpoint waits for a syncpoint whose upstream TSO is after the current TSO of the upstream,
+// and returns the upstream and downstream TSOs of the syncpoint.
+func waitForSyncpoint(ctx context.Context, upstream, downstream *sql.DB, schema, changefeed string, interval time.Duration) (string, string, error) {
+	startTS, err := dbutil.GetTidbLatestTSO(ctx, upstream)
+	if err != nil {
+		return "", "", errors.Annotate(err, "failed to get the TSO of the upstream")
+	}
+	where, args := "", []interface{}{}
+	if len(changefeed) != 0 {
+		where, args = " WHERE cf = ?", append(args, changefeed)
+	}
+	query := fmt.Sprintf("SELECT primary_ts, secondary_ts FROM %s%s ORDER BY CAST(primary_ts AS UNSIGNED) DESC LIMIT 1",
+		dbutil.TableName(schema, syncpointTable), where)
+	log.Info("wait for the syncpoint of TiCDC", zap.Int64("upstream TSO", startTS), zap.String("changefeed", changefeed))
+
+	var primaryTS, secondaryTS string
+	err = pollConsistency(ctx, interval, func() (bool, error) {
+		err := downstream.QueryRowContext(ctx, query, args...).Scan(&primaryTS, &secondaryTS)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		ts, err := strconv.ParseInt(primaryTS, 10, 64)
+		if err != nil {
+			return false, errors.Annotatef(err, "invalid primary_ts %s of the syncpoint", primaryTS)
+		}
+		log.Debug("read the latest syncpoint", zap.String("primary ts", primaryTS), zap.String("secondary ts", secondaryTS))
+		return ts >= startTS, nil
+	})
+	if err != nil {
+		return "", "", errors.Annotatef(err, "no syncpoint after the upstream TSO %d", startTS)
+	}
+	return primaryTS, secondaryTS, nil
+}
+
+// waitForDMCheckpoint waits for the global checkpoints of the DM sources to reach the binlog positions
+// of the source instances when the wait begins. The source ids are the names of the source instances.
+func waitForDMCheckpoint(ctx context.Context, sourceIDs []string, sources []*sql.DB, target *sql.DB, schema, task string, interval time.Duration) error {
+	targets := make(map[string]binlogPos, len(sources))
+	for i, conn := range sources {
+		pos, err := getMasterPos(ctx, conn)
+		if err != nil {
+			return errors.Annotatef(err, "failed to get the binlog position of source %s", sourceIDs[i])
+		}
+		targets[sourceIDs[i]] = pos
+	}
+	query := fmt.Sprintf("SELECT id, binlog_name, binlog_pos FROM %s WHERE is_global = 1", dbutil.TableName(schema, task+"_syncer_checkpoint"))
+	log.Info("wait for the checkpoint of DM", zap.String("task", task), zap.Reflect("binlog positions", targets))
+
+	return errors.Trace(pollConsistency(ctx, interval, func() (bool, error) {
+		rows, err := target.QueryContext(ctx, query)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		defer rows.Close()
+		checkpoints := make(map[string]binlogPos)
+		for rows.Next() {
+			var id string
+			var pos binlogPos
+			if err := rows.Scan(&id, &pos.name, &pos.pos); err != nil {
+				return false, errors.Trace(err)
+			}
+			checkpoints[id] = pos
+		}
+		if err := rows.Err(); err != nil {
+			return false, errors.Trace(err)
+		}
+		for id, pos := range targets {
+			checkpoint, ok := checkpoints[id]
+			if !ok {
+				return false, errors.NotFoundf("the checkpoint of source %s in task %s", id, task)
+			}
+			if checkpoint.before(pos) {
+				log.Debug("the checkpoint of DM is behind the source", zap.String("source", id), zap.Stringer("checkpoint", checkpoint), zap.Stringer("binlog position", pos))
+				return false, nil
+			}
+		}
+		return true, nil
+	}))
+}
+
+// getMasterPos returns the current binlog position of MySQL.
+func getMasterPos(ctx context.Context, db *sql.DB) (binlogPos, error) {
+	rows, err := db.QueryContext(ctx, "SHOW MASTER STATUS")
+	if err != nil {
+		return binlogPos{}, errors.Trace(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return binlogPos{}, errors.Trace(err)
+		}
+		return binlogPos{}, errors.New("the binlog is not enabled")
+	}
+	fields, err := dbutil.ScanRow(rows)
+	if err != nil {
+		return binlogPos{}, errors.Trace(err)
+	}
+	pos, err := strconv.ParseUint(string(fields["Position"].Data), 10, 64)
+	if err != nil {
+		return binlogPos{}, errors.Trace(err)
+	}
+	return binlogPos{name: string(fields["File"].Data), pos: pos}, nil
+}
+
+// pollConsistency calls check every interval until it returns true or the context is done.
+func pollConsistency(ctx context.Context, interval time.Duration, check func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ok, err := check()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForSyncpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	upstream, upstreamMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer upstream.Close()
+	downstream, downstreamMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer downstream.Close()
+
+	masterStatus := []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}
+	upstreamMock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(sqlmock.NewRows(masterStatus).AddRow("tidb-binlog", "430000000000000100", "", "", ""))
+	// no syncpoint, then a syncpoint before the wait begins, and a fresh syncpoint at last.
+	query := "SELECT primary_ts, secondary_ts FROM `tidb_cdc`.`syncpoint_v1` WHERE cf = \\? ORDER BY .* DESC LIMIT 1"
+	syncpoint := []string{"primary_ts", "secondary_ts"}
+	downstreamMock.ExpectQuery(query).WithArgs("cf1").WillReturnRows(sqlmock.NewRows(syncpoint))
+	downstreamMock.ExpectQuery(query).WithArgs("cf1").WillReturnRows(sqlmock.NewRows(syncpoint).AddRow("430000000000000050", "430000000000000060"))
+	downstreamMock.ExpectQuery(query).WithArgs("cf1").WillReturnRows(sqlmock.NewRows(syncpoint).AddRow("430000000000000200", "430000000000000210"))
+
+	primaryTS, secondaryTS, err := waitForSyncpoint(ctx, upstream, downstream, "tidb_cdc", "cf1", time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, "430000000000000200", primaryTS)
+	require.Equal(t, "430000000000000210", secondaryTS)
+	require.NoError(t, upstreamMock.ExpectationsWereMet())
+	require.NoError(t, downstreamMock.ExpectationsWereMet())
+
+	// no fresh syncpoint before the timeout
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer timeoutCancel()
+	upstreamMock.ExpectQuery("SHOW MASTER STATUS").WillReturnRows(sqlmock.NewRows(masterStatus).AddRow("tidb-binlog", "430000000000000300", "", "", ""))
+	downstreamMock.MatchExpectationsInOrder(false)
+	for i := 0; i < 100; i++ {
+		downstreamMock.ExpectQuery("SELECT primary_ts, secondary_ts FROM `tidb_cdc`.`syncpoint_v1` ORDER BY .* DESC LIMIT 1").
+			WillReturnRows(sqlmock.NewRows(syncpoint).AddRow("430000000000000200", "430000000000000210"))
+	}
+	_, _, err = waitForSyncpoint(timeoutCtx, upstream, downstream, "tidb_cdc", "", 10*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no syncpoint after the upstream TSO 430000000000000300")
+}
+
+func TestWaitForDMCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sources := make([]*sql.DB, 2)
+	sourceMocks := make([]sqlmock.Sqlmock, 2)
+	for i := range sources {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+		sources[i], sourceMocks[i] = db, mock
+	}
+	target, targetMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer target.Close()
+
+	masterStatus := []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}
+	sourceMocks[0].ExpectQuery("SHOW MASTER STATUS").WillReturnRows(sqlmock.NewRows(masterStatus).AddRow("mysql-bin.000003", "1000", "", "", ""))
+	sourceMocks[1].ExpectQuery("SHOW MASTER STATUS").WillReturnRows(sqlmock.NewRows(masterStatus).AddRow("mysql-bin.000010", "200", "", "", ""))
+	query := "SELECT id, binlog_name, binlog_pos FROM `dm_meta`.`task1_syncer_checkpoint` WHERE is_global = 1"
+	checkpoint := []string{"id", "binlog_name", "binlog_pos"}
+	// mysql2 is behind at first.
+	targetMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(checkpoint).
+		AddRow("mysql1", "mysql-bin.000003", 1000).AddRow("mysql2", "mysql-bin.000009", 5000))
+	targetMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(checkpoint).
+		AddRow("mysql1", "mysql-bin|000001.000004", 4).AddRow("mysql2", "mysql-bin.000010", 200))
+
+	err = waitForDMCheckpoint(ctx, []string{"mysql1", "mysql2"}, sources, target, "dm_meta", "task1", time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, targetMock.ExpectationsWereMet())
+
+	// the checkpoint of the source doesn't exist
+	sourceMocks[0].ExpectQuery("SHOW MASTER STATUS").WillReturnRows(sqlmock.NewRows(masterStatus).AddRow("mysql-bin.000003", "1000", "", "", ""))
+	targetMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(checkpoint).AddRow("mysql2", "mysql-bin.000010", 200))
+	err = waitForDMCheckpoint(ctx, []string{"mysql1"}, sources[:1], target, "dm_meta", "task1", time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the checkpoint of source mysql1 in task task1 not found")
+}
+
+func TestBinlogPosBefore(t *testing.T) {
+	pos := binlogPos{name: "mysql-bin.000003", pos: 100}
+	require.True(t, pos.before(binlogPos{name: "mysql-bin.000003", pos: 101}))
+	require.False(t, pos.before(binlogPos{name: "mysql-bin.000003", pos: 100}))
+	require.True(t, pos.before(binlogPos{name: "mysql-bin.000004", pos: 4}))
+	require.False(t, pos.before(binlogPos{name: "mysql-bin.000002", pos: 1000}))
+	// the relay suffix of DM
+	require.True(t, pos.before(binlogPos{name: "mysql-bin|000001.000010", pos: 4}))
+	require.False(t, binlogPos{name: "mysql-bin|000001.000003", pos: 200}.before(pos))
+}
@@ -0,0 +1,609 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"go.uber.org/zap"
+)
+
+const (
+	schemaFileSuffix = "-schema.sql"
+	// fileTableCacheSize is the number of the tables whose rows are kept in memory.
+	// The chunks are compared table by table, so only the tables at the boundary are needed.
+	fileTableCacheSize = 2
+	// defaultFileTableMaxSize is the default max total size of the data files of a table. The parsed rows
+	// take several times the memory of the files, and at most fileTableCacheSize tables are kept in memory.
+	defaultFileTableMaxSize = 1 << 30
+)
+
+// FileTableAnalyzer is the analyzer of the file source.
+// The file source can't split chunks, the chunks are always generated by the database.
+type FileTableAnalyzer struct{}
+
+func (a *FileTableAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.TableDiff, startRange *splitter.RangeInfo) (splitter.ChunkIterator, error) {
+	return nil, errors.Errorf("the file source can't split chunks for table %s", dbutil.TableName(table.Schema, table.Table))
+}
+
+// FileRowsIterator iterates the sorted rows of a chunk in memory.
+type FileRowsIterator struct {
+	rows []map[string]*dbutil.ColumnData
+}
+
+func (s *FileRowsIterator) Close() {}
+
+func (s *FileRowsIterator) Next() (map[string]*dbutil.ColumnData, error) {
+	if len(s.rows) == 0 {
+		return nil, nil
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+// fileTable is a table in the dump files.
+type fileTable struct {
+	OriginSchema string
+	OriginTable  string
+	schemaFile   string
+	dataFiles    []string
+}
+
+// fileTableRows is the sorted rows of a target table loaded from the dump files.
+type fileTableRows struct {
+	once sync.Once
+	rows []map[string]*dbutil.ColumnData
+	// orderKeyCols are the columns the rows are sorted by.
+	orderKeyCols []*model.ColumnInfo
+	err          error
+}
+
+// FileSource reads the schema files and the csv/sql data files exported by dumpling or mydumper.
+// The rows of a table are loaded into memory and sorted by the order key once, the rows of a chunk
+// are located by binary searching its bounds, and the checksum of them is calculated in-process.
+type FileSource struct {
+	tableDiffs      []*common.TableDiff
+	sourceTablesMap map[string][]*fileTable
+	csv             *config.CSVConfig
+
+	mu         sync.Mutex
+	cache      map[int]*fileTableRows
+	cacheOrder []int
+}
+
+func (s *FileSource) GetTableAnalyzer() TableAnalyzer {
+	return &FileTableAnalyzer{}
+}
+
+func (s *FileSource) GetRangeIterator(ctx context.Context, r *splitter.RangeInfo, analyzer TableAnalyzer) (RangeIterator, error) {
+	return NewChunksIterator(ctx, analyzer, s.tableDiffs, r)
+}
+
+func (s *FileSource) Close() {}
+
+func (s *FileSource) GetCountAndCrc32(ctx context.Context, tableRange *splitter.RangeInfo) *ChecksumInfo {
+	beginTime := time.Now()
+	var (
+		count    int64
+		checksum uint64
+	)
+	table := s.tableDiffs[tableRange.GetTableIndex()]
+	rows, err := s.getChunkRows(tableRange)
+	if err == nil {
+		for _, row := range rows {
+			count++
+			switch table.ChecksumAlgorithm {
+			case utils.ChecksumHash64, utils.ChecksumInProcess:
+				checksum += utils.RowHash64(row, table.Info, table.Tolerances)
+			default:
+				checksum ^= uint64(crc32.ChecksumIEEE([]byte(rowChecksumString(row, table.Info, table.Tolerances))))
+			}
+		}
+	}
+	return &ChecksumInfo{
+		Checksum: int64(checksum),
+		Count:    count,
+		Err:      err,
+		Cost:     time.Since(beginTime),
+	}
+}
+
+func (s *FileSource) GetRowsIterator(ctx context.Context, tableRange *splitter.RangeInfo) (RowDataIterator, error) {
+	rows, err := s.getChunkRows(tableRange)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &FileRowsIterator{
+		rows: rows,
+	}, nil
+}
+
+func (s *FileSource) GenerateFixSQL(t DMLType, upstreamData, downstreamData map[string]*dbutil.ColumnData, tableIndex int) string {
+	if t == Insert {
+		return utils.GenerateReplaceDML(upstreamData, s.tableDiffs[tableIndex].Info, s.tableDiffs[tableIndex].Schema)
+	}
+	if t == Delete {
+		return utils.GenerateDeleteDML(downstreamData, s.tableDiffs[tableIndex].Info, s.tableDiffs[tableIndex].Schema)
+	}
+	if t == Replace {
+		return utils.GenerateReplaceDMLWithAnnotation(upstreamData, downstreamData, s.tableDiffs[tableIndex].Info, s.tableDiffs[tableIndex].Schema)
+	}
+	log.Fatal("Don't support this type", zap.Any("dml type", t))
+	return ""
+}
+
+func (s *FileSource) GetTables() []*common.TableDiff {
+	return s.tableDiffs
+}
+
+func (s *FileSource) GetSourceStructInfo(ctx context.Context, tableIndex int) ([]*model.TableInfo, error) {
+	tableDiff := s.GetTables()[tableIndex]
+	fileTables := s.sourceTablesMap[utils.UniqueID(tableDiff.Schema, tableDiff.Table)]
+	tableInfos := make([]*model.TableInfo, 0, len(fileTables))
+	for _, ft := range fileTables {
+		tableInfo, err := parseSchemaFile(ft.schemaFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tableInfo, _ = utils.ResetColumns(tableInfo, tableDiff.IgnoreColumns)
+		tableInfos = append(tableInfos, tableInfo)
+	}
+	return tableInfos, nil
+}
+
+// GetSourceTables returns the names of the origin tables and their schema files.
+func (s *FileSource) GetSourceTables(tableIndex int) []string {
+	tableDiff := s.GetTables()[tableIndex]
+	fileTables := s.sourceTablesMap[utils.UniqueID(tableDiff.Schema, tableDiff.Table)]
+	sourceTables := make([]string, 0, len(fileTables))
+	for _, ft := range fileTables {
+		sourceTables = append(sourceTables, fmt.Sprintf("%s (%s)", dbutil.TableName(ft.OriginSchema, ft.OriginTable), ft.schemaFile))
+	}
+	return sourceTables
+}
+
+// GetDB returns nil because the file source doesn't connect to any database.
+func (s *FileSource) GetDB() *sql.DB {
+	return nil
+}
+
+func (s *FileSource) GetSnapshot() string {
+	return ""
+}
+
+// getChunkRows returns the sorted rows of the table in the chunk.
+func (s *FileSource) getChunkRows(tableRange *splitter.RangeInfo) ([]map[string]*dbutil.ColumnData, error) {
+	table := s.tableDiffs[tableRange.GetTableIndex()]
+	chunkRange := tableRange.GetChunk()
+	if len(table.Range) != 0 && strings.ToUpper(table.Range) != "TRUE" {
+		return nil, errors.Errorf("the file source doesn't support range `%s` of table %s", table.Range, dbutil.TableName(table.Schema, table.Table))
+	}
+	if chunkRange.Type == chunk.Others {
+		return nil, errors.Errorf("the file source doesn't support chunk `%s` of table %s", chunkRange.Where, dbutil.TableName(table.Schema, table.Table))
+	}
+	tableRows, err := s.loadTableRows(tableRange.GetTableIndex())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	columns := make(map[string]*model.ColumnInfo, len(table.Info.Columns))
+	for _, col := range table.Info.Columns {
+		columns[col.Name.L] = col
+	}
+	rows := searchChunkRows(tableRows.rows, chunkRange, tableRows.orderKeyCols)
+	chunkRows := make([]map[string]*dbutil.ColumnData, 0, len(rows))
+	for _, row := range rows {
+		if inChunkRange(row, chunkRange, columns) {
+			chunkRows = append(chunkRows, row)
+		}
+	}
+	return chunkRows, nil
+}
+
+// searchChunkRows returns the sorted rows which may be in the chunk by binary searching the bounds, if the columns
+// of the bounds are a prefix of the order key. The rows between the bounds may still be out of the chunk, e.g. the
+// rows with NULL, so they should be filtered by inChunkRange.
+func searchChunkRows(rows []map[string]*dbutil.ColumnData, chunkRange *chunk.Range, orderKeyCols []*model.ColumnInfo) []map[string]*dbutil.ColumnData {
+	if len(chunkRange.Bounds) > len(orderKeyCols) {
+		return rows
+	}
+	for i, bound := range chunkRange.Bounds {
+		if !strings.EqualFold(bound.Column, orderKeyCols[i].Name.O) {
+			return rows
+		}
+	}
+	// compareBounds compares the row with the values of the leading bounds having the lower or the upper value.
+	compareBounds := func(row map[string]*dbutil.ColumnData, isLower bool) int {
+		for i, bound := range chunkRange.Bounds {
+			hasValue, value := bound.HasUpper, bound.Upper
+			if isLower {
+				hasValue, value = bound.HasLower, bound.Lower
+			}
+			if !hasValue {
+				break
+			}
+			data := row[orderKeyCols[i].Name.O]
+			if data == nil || data.IsNull {
+				return -1
+			}
+			if cmp := compareColumnValue(orderKeyCols[i], data.Data, []byte(value)); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	}
+	// the rows in the chunk are not less than the lower values and not greater than the upper values.
+	start := sort.Search(len(rows), func(i int) bool { return compareBounds(rows[i], true) >= 0 })
+	end := sort.Search(len(rows), func(i int) bool { return compareBounds(rows[i], false) > 0 })
+	if start >= end {
+		return nil
+	}
+	return rows[start:end]
+}
+
+// loadTableRows loads and sorts the rows of the table, the rows of the recently used tables are cached.
+func (s *FileSource) loadTableRows(tableIndex int) (*fileTableRows, error) {
+	s.mu.Lock()
+	tableRows, ok := s.cache[tableIndex]
+	if !ok {
+		tableRows = &fileTableRows{}
+		s.cache[tableIndex] = tableRows
+		s.cacheOrder = append(s.cacheOrder, tableIndex)
+		if len(s.cacheOrder) > fileTableCacheSize {
+			delete(s.cache, s.cacheOrder[0])
+			s.cacheOrder = s.cacheOrder[1:]
+		}
+	}
+	s.mu.Unlock()
+
+	tableRows.once.Do(func() {
+		table := s.tableDiffs[tableIndex]
+		log.Info("load the rows of the table from the dump files", zap.String("table", dbutil.TableName(table.Schema, table.Table)))
+		rows := make([]map[string]*dbutil.ColumnData, 0)
+		for _, ft := range s.sourceTablesMap[utils.UniqueID(table.Schema, table.Table)] {
+			tableRows.err = ft.readRows(table.Info, s.csv, func(row map[string]*dbutil.ColumnData) {
+				rows = append(rows, row)
+			})
+			if tableRows.err != nil {
+				return
+			}
+		}
+		_, orderKeyCols := dbutil.SelectUniqueOrderKey(table.Info)
+		sort.SliceStable(rows, func(i, j int) bool {
+			return compareRowData(rows[i], rows[j], orderKeyCols) < 0
+		})
+		tableRows.rows, tableRows.orderKeyCols = rows, orderKeyCols
+	})
+	return tableRows, tableRows.err
+}
+
+// readRows reads the rows from the data files, the fields are mapped to the columns of tableInfo by name.
+func (ft *fileTable) readRows(tableInfo *model.TableInfo, csvConfig *config.CSVConfig, fn func(map[string]*dbutil.ColumnData)) error {
+	dumpInfo, err := parseSchemaFile(ft.schemaFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dumpColumns := make([]string, 0, len(dumpInfo.Columns))
+	for _, col := range dumpInfo.Columns {
+		dumpColumns = append(dumpColumns, col.Name.O)
+	}
+	targetColumns := make(map[string]string, len(tableInfo.Columns))
+	for _, col := range tableInfo.Columns {
+		targetColumns[col.Name.L] = col.Name.O
+	}
+
+	for _, dataFile := range ft.dataFiles {
+		data, err := os.ReadFile(dataFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var records []*dumpRecord
+		if strings.HasSuffix(dataFile, ".csv") {
+			records, err = parseCSV(data, csvConfig, dumpColumns)
+		} else {
+			records, err = parseInsertSQL(data)
+		}
+		if err != nil {
+			return errors.Annotatef(err, "parse data file %s", dataFile)
+		}
+		for _, record := range records {
+			columns := record.columns
+			if len(columns) == 0 {
+				columns = dumpColumns
+			}
+			if len(columns) != len(record.fields) {
+				return errors.Errorf("the count of fields %d doesn't match the count of columns %d in data file %s", len(record.fields), len(columns), dataFile)
+			}
+			row := make(map[string]*dbutil.ColumnData, len(tableInfo.Columns))
+			for i, name := range columns {
+				if target, ok := targetColumns[strings.ToLower(name)]; ok {
+					row[target] = record.fields[i]
+				}
+			}
+			for _, col := range tableInfo.Columns {
+				if _, ok := row[col.Name.O]; !ok {
+					return errors.Errorf("column %s is not found in data file %s", col.Name.O, dataFile)
+				}
+			}
+			fn(row)
+		}
+	}
+	return nil
+}
+
+// inChunkRange evaluates the conditions generated by `chunk.Range.ToString` in-process.
+func inChunkRange(row map[string]*dbutil.ColumnData, chunkRange *chunk.Range, columns map[string]*model.ColumnInfo) bool {
+	compare := func(column, value string) (int, bool) {
+		col, ok := columns[strings.ToLower(column)]
+		if !ok {
+			return 0, false
+		}
+		data := row[col.Name.O]
+		if data == nil || data.IsNull {
+			// any comparison with NULL is not true
+			return 0, false
+		}
+		return compareColumnValue(col, data.Data, []byte(value)), true
+	}
+
+	bounds := chunkRange.Bounds
+	i := 0
+	for ; i < len(bounds); i++ {
+		bound := bounds[i]
+		if !(bound.HasLower && bound.HasUpper) || bound.Lower != bound.Upper {
+			break
+		}
+		if cmp, ok := compare(bound.Column, bound.Lower); !ok || cmp != 0 {
+			return false
+		}
+	}
+
+	hasLower, hasUpper := false, false
+	lowerMatched, upperMatched := false, false
+	lowerPrefixEqual, upperPrefixEqual := true, true
+	for j := i; j < len(bounds); j++ {
+		bound := bounds[j]
+		if bound.HasLower {
+			hasLower = true
+			cmp, ok := compare(bound.Column, bound.Lower)
+			if ok && lowerPrefixEqual && cmp > 0 {
+				lowerMatched = true
+			}
+			lowerPrefixEqual = lowerPrefixEqual && ok && cmp == 0
+		}
+		if bound.HasUpper {
+			hasUpper = true
+			cmp, ok := compare(bound.Column, bound.Upper)
+			if ok && upperPrefixEqual && (cmp < 0 || (cmp == 0 && j == len(bounds)-1)) {
+				upperMatched = true
+			}
+			upperPrefixEqual = upperPrefixEqual && ok && cmp == 0
+		}
+	}
+	return (!hasLower || lowerMatched) && (!hasUpper || upperMatched)
+}
+
+// compareRowData compares two rows by the order key columns, NULL is the smallest.
+func compareRowData(row1, row2 map[string]*dbutil.ColumnData, orderKeyCols []*model.ColumnInfo) int {
+	for _, col := range orderKeyCols {
+		data1, data2 := row1[col.Name.O], row2[col.Name.O]
+		switch {
+		case data1.IsNull && data2.IsNull:
+			continue
+		case data1.IsNull:
+			return -1
+		case data2.IsNull:
+			return 1
+		}
+		if cmp := compareColumnValue(col, data1.Data, data2.Data); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareColumnValue compares the numbers by value and the others by bytes.
+func compareColumnValue(col *model.ColumnInfo, value1, value2 []byte) int {
+	if !utils.NeedQuotes(col.FieldType.Tp) {
+		num1, ok1 := new(big.Rat).SetString(string(value1))
+		num2, ok2 := new(big.Rat).SetString(string(value2))
+		if ok1 && ok2 {
+			return num1.Cmp(num2)
+		}
+	}
+	return bytes.Compare(value1, value2)
+}
+
+// rowChecksumString returns the string whose crc32 is the checksum of the row,
+// it's the same as `CONCAT_WS(',', col1, col2, ..., CONCAT(ISNULL(col1), ISNULL(col2), ...))`
+// in `utils.GetCountAndCRC32Checksum`.
+func rowChecksumString(row map[string]*dbutil.ColumnData, tableInfo *model.TableInfo, tolerances map[string]*utils.Tolerance) string {
+	values := make([]string, 0, len(tableInfo.Columns)+1)
+	var isNull strings.Builder
+	for _, col := range tableInfo.Columns {
+		data := row[col.Name.O]
+		value, ok := "", data != nil && !data.IsNull
+		if ok {
+			value = string(data.Data)
+			switch col.FieldType.Tp {
+			case mysql.TypeFloat:
+				value, ok = utils.RoundFloatString(value, 5)
+			case mysql.TypeDouble:
+				value, ok = utils.RoundFloatString(value, 14)
+			}
+		}
+		if !ok {
+			// CONCAT_WS skips NULL
+			isNull.WriteString("1")
+			continue
+		}
+		isNull.WriteString("0")
+		if _, ok := tolerances[col.Name.O]; ok {
+			continue
+		}
+		values = append(values, value)
+	}
+	values = append(values, isNull.String())
+	return strings.Join(values, ",")
+}
+
+// listDumpTables finds the schema files and the data files of the tables in the dir.
+func listDumpTables(dir string) ([]*fileTable, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tables := make([]*fileTable, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, schemaFileSuffix) {
+			continue
+		}
+		prefix := strings.TrimSuffix(name, schemaFileSuffix)
+		dot := strings.Index(prefix, ".")
+		if dot <= 0 {
+			continue
+		}
+		tables = append(tables, &fileTable{
+			OriginSchema: prefix[:dot],
+			OriginTable:  prefix[dot+1:],
+			schemaFile:   filepath.Join(dir, name),
+		})
+	}
+	// match the longest prefix, so that `db.t1.0.csv` won't be the data file of `db.t`.
+	sort.Slice(tables, func(i, j int) bool {
+		return len(tables[i].OriginTable) > len(tables[j].OriginTable)
+	})
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.Contains(name, "-schema") || !(strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".sql")) {
+			continue
+		}
+		for _, table := range tables {
+			if strings.HasPrefix(name, table.OriginSchema+"."+table.OriginTable+".") {
+				table.dataFiles = append(table.dataFiles, filepath.Join(dir, name))
+				break
+			}
+		}
+	}
+	return tables, nil
+}
+
+// dataFilesSize returns the total size of the data files of the tables.
+func dataFilesSize(tables []*fileTable) (int64, error) {
+	var size int64
+	for _, table := range tables {
+		for _, dataFile := range table.dataFiles {
+			info, err := os.Stat(dataFile)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+func NewFileSource(ctx context.Context, tableDiffs []*common.TableDiff, ds *config.DataSource) (Source, error) {
+	tables, err := listDumpTables(ds.Dir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "list the dump files in %s", ds.Dir)
+	}
+	uniqueMap := make(map[string]struct{})
+	for _, tableDiff := range tableDiffs {
+		uniqueMap[utils.UniqueID(tableDiff.Schema, tableDiff.Table)] = struct{}{}
+	}
+	sourceTablesMap := make(map[string][]*fileTable)
+	for _, table := range tables {
+		targetSchema, targetTable := table.OriginSchema, table.OriginTable
+		if ds.Router != nil {
+			targetSchema, targetTable, err = ds.Router.Route(table.OriginSchema, table.OriginTable)
+			if err != nil {
+				return nil, errors.Errorf("get route result for %s.%s failed, error %v", table.OriginSchema, table.OriginTable, err)
+			}
+		}
+		uniqueID := utils.UniqueID(targetSchema, targetTable)
+		if _, ok := uniqueMap[uniqueID]; ok {
+			log.Info("find the dump files for the table",
+				zap.String("origin table", dbutil.TableName(table.OriginSchema, table.OriginTable)),
+				zap.String("table", dbutil.TableName(targetSchema, targetTable)),
+				zap.Strings("data files", table.dataFiles))
+			sourceTablesMap[uniqueID] = append(sourceTablesMap[uniqueID], table)
+		}
+	}
+	for _, tableDiff := range tableDiffs {
+		if _, ok := sourceTablesMap[utils.UniqueID(tableDiff.Schema, tableDiff.Table)]; !ok {
+			return nil, errors.Errorf("the dump files have no table to be compared. target-table is `%s`.`%s`", tableDiff.Schema, tableDiff.Table)
+		}
+	}
+	maxTableSize := ds.MaxTableSize
+	if maxTableSize <= 0 {
+		maxTableSize = defaultFileTableMaxSize
+	}
+	for uniqueID, tables := range sourceTablesMap {
+		size, err := dataFilesSize(tables)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if size > maxTableSize {
+			return nil, errors.Errorf("the data files of table %s are %d bytes, larger than max-table-size %d bytes. "+
+				"The rows of a table are loaded into memory to be sorted, please increase max-table-size if there is enough memory, "+
+				"or compare with the database instead of the dump files", uniqueID, size, maxTableSize)
+		}
+	}
+
+	csvConfig := &config.CSVConfig{
+		Separator: ",",
+		Delimiter: "\"",
+		Null:      "\\N",
+	}
+	if ds.CSV != nil {
+		if len(ds.CSV.Separator) != 0 {
+			csvConfig.Separator = ds.CSV.Separator
+		}
+		if len(ds.CSV.Delimiter) != 0 {
+			csvConfig.Delimiter = ds.CSV.Delimiter
+		}
+		if len(ds.CSV.Null) != 0 {
+			csvConfig.Null = ds.CSV.Null
+		}
+	}
+	return &FileSource{
+		tableDiffs:      tableDiffs,
+		sourceTablesMap: sourceTablesMap,
+		csv:             csvConfig,
+		cache:           make(map[int]*fileTableRows),
+	}, nil
+}
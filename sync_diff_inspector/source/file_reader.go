@@ -0,0 +1,244 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// dumpRecord is a row in the data file.
+type dumpRecord struct {
+	// columns is the column names of the fields, it's empty if the data file doesn't specify it.
+	columns []string
+	fields  []*dbutil.ColumnData
+}
+
+// parseSchemaFile parses the `CREATE TABLE` statement in the schema file.
+func parseSchemaFile(schemaFile string) (*model.TableInfo, error) {
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := parser.New()
+	stmts, _, err := p.Parse(string(data), "", "")
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse schema file %s", schemaFile)
+	}
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.CreateTableStmt); ok {
+			return dbutil.GetTableInfoBySQL(stmt.Text(), p)
+		}
+	}
+	return nil, errors.Errorf("not found create table statement in schema file %s", schemaFile)
+}
+
+// parseInsertSQL parses the values of the `INSERT` statements in the sql data file.
+func parseInsertSQL(data []byte) ([]*dumpRecord, error) {
+	stmts, _, err := parser.New().Parse(string(data), "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	records := make([]*dumpRecord, 0)
+	for _, stmt := range stmts {
+		insert, ok := stmt.(*ast.InsertStmt)
+		if !ok {
+			continue
+		}
+		columns := make([]string, 0, len(insert.Columns))
+		for _, col := range insert.Columns {
+			columns = append(columns, col.Name.O)
+		}
+		for _, list := range insert.Lists {
+			record := &dumpRecord{
+				columns: columns,
+				fields:  make([]*dbutil.ColumnData, 0, len(list)),
+			}
+			for _, expr := range list {
+				field, err := exprToColumnData(expr)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				record.fields = append(record.fields, field)
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func exprToColumnData(expr ast.ExprNode) (*dbutil.ColumnData, error) {
+	switch v := expr.(type) {
+	case *driver.ValueExpr:
+		if v.Datum.IsNull() {
+			return &dbutil.ColumnData{IsNull: true}, nil
+		}
+		value, err := v.Datum.ToString()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &dbutil.ColumnData{Data: []byte(value)}, nil
+	case *ast.UnaryOperationExpr:
+		if v.Op == opcode.Minus {
+			data, err := exprToColumnData(v.V)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if strings.HasPrefix(string(data.Data), "-") {
+				data.Data = data.Data[1:]
+			} else {
+				data.Data = append([]byte("-"), data.Data...)
+			}
+			return data, nil
+		}
+	}
+	return nil, errors.Errorf("unsupported value %T in insert statement", expr)
+}
+
+// parseCSV parses the csv data file. The fields are quoted by the delimiter, and
+// escaped by backslash or the doubled delimiter. The unquoted null field is NULL.
+// If the first record is the header, it's used as the column names of the records.
+func parseCSV(data []byte, csvConfig *config.CSVConfig, columns []string) ([]*dumpRecord, error) {
+	sep, delim := []byte(csvConfig.Separator), []byte(csvConfig.Delimiter)
+	records := make([]*dumpRecord, 0)
+	fields := make([]*dbutil.ColumnData, 0, len(columns))
+	i, n := 0, len(data)
+	isLineEnd := func(i int) bool { return i >= n || data[i] == '\n' || data[i] == '\r' }
+	for i < n {
+		if len(fields) == 0 && isLineEnd(i) {
+			// skip the empty lines
+			i++
+			continue
+		}
+		var value []byte
+		quoted := false
+		if len(delim) > 0 && bytes.HasPrefix(data[i:], delim) {
+			quoted = true
+			i += len(delim)
+			for {
+				if i >= n {
+					return nil, errors.Errorf("unterminated quoted field at offset %d", i)
+				}
+				if data[i] == '\\' && i+1 < n {
+					value = append(value, unescapeChar(data[i+1]))
+					i += 2
+					continue
+				}
+				if bytes.HasPrefix(data[i:], delim) {
+					i += len(delim)
+					if !bytes.HasPrefix(data[i:], delim) {
+						break
+					}
+					value = append(value, delim...)
+					i += len(delim)
+					continue
+				}
+				value = append(value, data[i])
+				i++
+			}
+		}
+		start := i
+		for i < n && !isLineEnd(i) && !bytes.HasPrefix(data[i:], sep) {
+			if data[i] == '\\' && i+1 < n {
+				value = append(value, unescapeChar(data[i+1]))
+				i += 2
+				continue
+			}
+			value = append(value, data[i])
+			i++
+		}
+		if !quoted && string(data[start:i]) == csvConfig.Null {
+			fields = append(fields, &dbutil.ColumnData{IsNull: true})
+		} else {
+			fields = append(fields, &dbutil.ColumnData{Data: value})
+		}
+
+		if i < n && bytes.HasPrefix(data[i:], sep) {
+			i += len(sep)
+			if isLineEnd(i) {
+				// the last field is empty
+				fields = append(fields, &dbutil.ColumnData{Data: []byte{}})
+			} else {
+				continue
+			}
+		}
+		// the end of the record
+		if i < n && data[i] == '\r' {
+			i++
+		}
+		if i < n && data[i] == '\n' {
+			i++
+		}
+		records = append(records, &dumpRecord{fields: fields})
+		fields = make([]*dbutil.ColumnData, 0, len(columns))
+	}
+
+	if len(records) > 0 && isHeader(records[0], columns) {
+		header := make([]string, 0, len(records[0].fields))
+		for _, field := range records[0].fields {
+			header = append(header, string(field.Data))
+		}
+		records = records[1:]
+		for _, record := range records {
+			record.columns = header
+		}
+	}
+	return records, nil
+}
+
+// isHeader returns true if all the fields of the record are the column names.
+func isHeader(record *dumpRecord, columns []string) bool {
+	names := make(map[string]struct{}, len(columns))
+	for _, col := range columns {
+		names[strings.ToLower(col)] = struct{}{}
+	}
+	for _, field := range record.fields {
+		if field.IsNull {
+			return false
+		}
+		if _, ok := names[strings.ToLower(string(field.Data))]; !ok {
+			return false
+		}
+	}
+	return len(record.fields) > 0
+}
+
+func unescapeChar(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	default:
+		return c
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCSV(t *testing.T) {
+	csvConfig := &config.CSVConfig{
+		Separator: ",",
+		Delimiter: "\"",
+		Null:      "\\N",
+	}
+	data := []byte("b,a\r\n\"x,\"\"y\",\\N\n\n\"\\\\N\",a\\tb\n")
+	records, err := parseCSV(data, csvConfig, []string{"a", "b"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	require.Equal(t, []string{"b", "a"}, records[0].columns)
+	require.Equal(t, "x,\"y", string(records[0].fields[0].Data))
+	require.True(t, records[0].fields[1].IsNull)
+	// the quoted and escaped null is a string
+	require.False(t, records[1].fields[0].IsNull)
+	require.Equal(t, "\\N", string(records[1].fields[0].Data))
+	require.Equal(t, "a\tb", string(records[1].fields[1].Data))
+
+	// no header, the last field is empty
+	records, err = parseCSV([]byte("1,\n2,3"), csvConfig, []string{"a", "b"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Len(t, records[0].columns, 0)
+	require.Equal(t, "", string(records[0].fields[1].Data))
+	require.Equal(t, "3", string(records[1].fields[1].Data))
+
+	_, err = parseCSV([]byte("\"1,2\n"), csvConfig, []string{"a", "b"})
+	require.Error(t, err)
+}
+
+func TestParseInsertSQL(t *testing.T) {
+	data := []byte("/*!40101 SET NAMES binary*/;\nINSERT INTO `t` VALUES (1,'a''b',NULL,-1.5),(2,'',0x61,-3);\nINSERT INTO `t` (`b`,`a`) VALUES ('x',3);")
+	records, err := parseInsertSQL(data)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	require.Len(t, records[0].columns, 0)
+	require.Equal(t, "1", string(records[0].fields[0].Data))
+	require.Equal(t, "a'b", string(records[0].fields[1].Data))
+	require.True(t, records[0].fields[2].IsNull)
+	require.Equal(t, "-1.5", string(records[0].fields[3].Data))
+	require.Equal(t, "a", string(records[1].fields[2].Data))
+	require.Equal(t, "-3", string(records[1].fields[3].Data))
+	require.Equal(t, []string{"b", "a"}, records[2].columns)
+	require.Equal(t, "x", string(records[2].fields[0].Data))
+}
+
+func TestRowChecksumString(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(a int, b varchar(10), c float, d double)", parser.New())
+	require.NoError(t, err)
+	row := map[string]*dbutil.ColumnData{
+		"a": {Data: []byte("1")},
+		"b": {IsNull: true},
+		"c": {Data: []byte("1.2345678")},
+		"d": {Data: []byte("0")},
+	}
+	// the float is rounded, and zero is NULL after rounding.
+	require.Equal(t, "1,1.23457,0101", rowChecksumString(row, tableInfo, nil))
+
+	require.Equal(t, "1,0101", rowChecksumString(row, tableInfo, map[string]*utils.Tolerance{"c": {Absolute: 0.1}}))
+
+	value, ok := utils.RoundFloatString("123456789", 5)
+	require.True(t, ok)
+	require.Equal(t, "123457000", value)
+	value, ok = utils.RoundFloatString("-0.000123456789", 5)
+	require.True(t, ok)
+	require.Equal(t, "-0.000123457", value)
+	_, ok = utils.RoundFloatString("0.0", 14)
+	require.False(t, ok)
+}
+
+func TestInChunkRange(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(a int, b varchar(10), primary key(a, b))", parser.New())
+	require.NoError(t, err)
+	columns := make(map[string]*model.ColumnInfo)
+	for _, col := range tableInfo.Columns {
+		columns[col.Name.L] = col
+	}
+	chunkRange := chunk.NewChunkRange()
+	chunkRange.Update("a", "1", "3", true, true)
+	chunkRange.Update("b", "x", "y", true, true)
+
+	row := func(a, b string) map[string]*dbutil.ColumnData {
+		return map[string]*dbutil.ColumnData{"a": {Data: []byte(a)}, "b": {Data: []byte(b)}}
+	}
+	// (a, b) > (1, x) AND (a, b) <= (3, y)
+	require.False(t, inChunkRange(row("1", "x"), chunkRange, columns))
+	require.True(t, inChunkRange(row("1", "z"), chunkRange, columns))
+	require.True(t, inChunkRange(row("2", "a"), chunkRange, columns))
+	require.True(t, inChunkRange(row("3", "y"), chunkRange, columns))
+	require.False(t, inChunkRange(row("3", "z"), chunkRange, columns))
+	require.False(t, inChunkRange(row("10", "a"), chunkRange, columns))
+}
+
+func TestSearchChunkRows(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(a int, b varchar(10), c int, primary key(a, b))", parser.New())
+	require.NoError(t, err)
+	columns := make(map[string]*model.ColumnInfo)
+	for _, col := range tableInfo.Columns {
+		columns[col.Name.L] = col
+	}
+	_, orderKeyCols := dbutil.SelectUniqueOrderKey(tableInfo)
+	rows := make([]map[string]*dbutil.ColumnData, 0)
+	for a := 0; a < 12; a++ {
+		for _, b := range []string{"a", "m", "x", "y", "z"} {
+			rows = append(rows, map[string]*dbutil.ColumnData{
+				"a": {Data: []byte(strconv.Itoa(a))}, "b": {Data: []byte(b)}, "c": {Data: []byte(strconv.Itoa(a % 3))},
+			})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareRowData(rows[i], rows[j], orderKeyCols) < 0
+	})
+	filter := func(rows []map[string]*dbutil.ColumnData, chunkRange *chunk.Range) []map[string]*dbutil.ColumnData {
+		chunkRows := make([]map[string]*dbutil.ColumnData, 0)
+		for _, row := range rows {
+			if inChunkRange(row, chunkRange, columns) {
+				chunkRows = append(chunkRows, row)
+			}
+		}
+		return chunkRows
+	}
+
+	newRange := func(update func(*chunk.Range)) *chunk.Range {
+		chunkRange := chunk.NewChunkRange()
+		update(chunkRange)
+		return chunkRange
+	}
+	for _, tc := range []struct {
+		chunkRange *chunk.Range
+		searched   int
+		count      int
+	}{
+		// (a, b) > (1, x) AND (a, b) <= (3, y)
+		{newRange(func(r *chunk.Range) { r.Update("a", "1", "3", true, true); r.Update("b", "x", "y", true, true) }), 12, 11},
+		// a = 3 AND b > m AND b <= y
+		{newRange(func(r *chunk.Range) { r.Update("a", "3", "3", true, true); r.Update("b", "m", "y", true, true) }), 3, 2},
+		// a > 10, the numbers are compared by value
+		{newRange(func(r *chunk.Range) { r.Update("a", "10", "", true, false) }), 10, 5},
+		// a <= 2
+		{newRange(func(r *chunk.Range) { r.Update("a", "", "2", false, true) }), 15, 15},
+		{newRange(func(r *chunk.Range) { r.Update("a", "20", "", true, false) }), 0, 0},
+		// the bounds are not the order key, all the rows are filtered.
+		{newRange(func(r *chunk.Range) { r.Update("c", "0", "1", true, true) }), 60, 20},
+		{chunk.NewChunkRange(), 60, 60},
+	} {
+		searched := searchChunkRows(rows, tc.chunkRange, orderKeyCols)
+		require.Len(t, searched, tc.searched)
+		require.Len(t, filter(searched, tc.chunkRange), tc.count)
+		require.Equal(t, filter(rows, tc.chunkRange), filter(searched, tc.chunkRange))
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"test.t-schema.sql": "CREATE TABLE `t` (`id` int NOT NULL, `name` varchar(20), PRIMARY KEY (`id`));",
+		"test.t.000.csv":    "name,id\nc,3\na,1\n",
+		"test.t.001.sql":    "INSERT INTO `t` VALUES (2,NULL);",
+		"test.t1.000.csv":   "10,x\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(id int, name varchar(20), primary key(id))", parser.New())
+	require.NoError(t, err)
+	tableDiffs := []*common.TableDiff{
+		{
+			Schema: "test",
+			Table:  "t",
+			Info:   tableInfo,
+			Range:  "TRUE",
+		},
+	}
+	_, err = NewFileSource(context.Background(), append(tableDiffs, &common.TableDiff{Schema: "test", Table: "t2", Info: tableInfo}), &config.DataSource{Dir: dir})
+	require.Error(t, err)
+
+	// the data files of the table are larger than max-table-size.
+	_, err = NewFileSource(context.Background(), tableDiffs, &config.DataSource{Dir: dir, MaxTableSize: 10})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "larger than max-table-size")
+
+	fileSource, err := NewFileSource(context.Background(), tableDiffs, &config.DataSource{Dir: dir})
+	require.NoError(t, err)
+	tableInfos, err := fileSource.GetSourceStructInfo(context.Background(), 0)
+	require.NoError(t, err)
+	require.Len(t, tableInfos, 1)
+	require.Len(t, tableInfos[0].Columns, 2)
+	require.Equal(t, []string{"`test`.`t` (" + filepath.Join(dir, "test.t-schema.sql") + ")"}, fileSource.GetSourceTables(0))
+
+	chunkRange := chunk.NewChunkRange()
+	chunkRange.Update("id", "1", "3", true, true)
+	chunkRange.Index = &chunk.ChunkID{}
+	rangeInfo := &splitter.RangeInfo{ChunkRange: chunkRange}
+
+	info := fileSource.GetCountAndCrc32(context.Background(), rangeInfo)
+	require.NoError(t, info.Err)
+	require.Equal(t, int64(2), info.Count)
+	checksum := crc32.ChecksumIEEE([]byte("2,01")) ^ crc32.ChecksumIEEE([]byte("3,c,00"))
+	require.Equal(t, int64(checksum), info.Checksum)
+
+	rows, err := fileSource.GetRowsIterator(context.Background(), &splitter.RangeInfo{ChunkRange: chunk.NewChunkRange()})
+	require.NoError(t, err)
+	for _, id := range []string{"1", "2", "3"} {
+		row, err := rows.Next()
+		require.NoError(t, err)
+		require.Equal(t, id, string(row["id"].Data))
+	}
+	row, err := rows.Next()
+	require.NoError(t, err)
+	require.Nil(t, row)
+}
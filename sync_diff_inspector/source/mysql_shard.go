@@ -34,6 +34,7 @@ import (
 
 type MySQLTableAnalyzer struct {
 	sourceTableMap map[string][]*common.TableShardSource
+	upstream       bool
 }
 
 func (a *MySQLTableAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.TableDiff, startRange *splitter.RangeInfo) (splitter.ChunkIterator, error) {
@@ -47,6 +48,7 @@ func (a *MySQLTableAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.
 	originTable := *table
 	originTable.Schema = matchedSources[0].OriginSchema
 	originTable.Table = matchedSources[0].OriginTable
+	originTable.SplitExclude = table.GetExclude(a.upstream)
 	progressID := dbutil.TableName(table.Schema, table.Table)
 	// use random splitter if we cannot use bucket splitter, then we can simply choose target table to generate chunks.
 	randIter, err := splitter.NewRandomIteratorWithCheckpoint(ctx, progressID, &originTable, matchedSources[0].DBConn, startRange)
@@ -60,6 +62,9 @@ type MySQLSources struct {
 	tableDiffs []*common.TableDiff
 
 	sourceTablesMap map[string][]*common.TableShardSource
+	// upstream is true if the source is the upstream, whose values are transformed and rows are excluded
+	// by the table configs.
+	upstream bool
 }
 
 func getMatchedSourcesForTable(sourceTablesMap map[string][]*common.TableShardSource, table *common.TableDiff) []*common.TableShardSource {
@@ -76,6 +81,7 @@ func getMatchedSourcesForTable(sourceTablesMap map[string][]*common.TableShardSo
 func (s *MySQLSources) GetTableAnalyzer() TableAnalyzer {
 	return &MySQLTableAnalyzer{
 		s.sourceTablesMap,
+		s.upstream,
 	}
 }
 
@@ -91,6 +97,53 @@ func (s *MySQLSources) Close() {
 	}
 }
 
+// GetGroupedCountAndCrc32 implements GroupedChecksumSource interface, the groups of the shards are merged.
+func (s *MySQLSources) GetGroupedCountAndCrc32(ctx context.Context, tableRange *splitter.RangeInfo, groupExpr string) (map[int64]*utils.GroupChecksum, error) {
+	table := s.tableDiffs[tableRange.GetTableIndex()]
+	chunk := tableRange.GetChunk()
+
+	type shardGroups struct {
+		groups map[int64]*utils.GroupChecksum
+		err    error
+	}
+	matchSources := getMatchedSourcesForTable(s.sourceTablesMap, table)
+	groupsCh := make(chan *shardGroups, len(matchSources))
+	for _, ms := range matchSources {
+		go func(ms *common.TableShardSource) {
+			columnExprs, err := s.getColumnExprs(table, ms)
+			if err != nil {
+				groupsCh <- &shardGroups{err: err}
+				return
+			}
+			groups, err := utils.GetGroupedCountAndCRC32Checksum(ctx, ms.DBConn, ms.OriginSchema, ms.OriginTable, table.Info, columnExprs, table.Tolerances, table.ChecksumAlgorithm, groupExpr, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)), chunk.Args)
+			groupsCh <- &shardGroups{groups: groups, err: err}
+		}(ms)
+	}
+
+	var err error
+	totalGroups := make(map[int64]*utils.GroupChecksum)
+	for range matchSources {
+		shard := <-groupsCh
+		// catch the first error
+		if err == nil && shard.err != nil {
+			err = shard.err
+		}
+		for group, checksum := range shard.groups {
+			total, ok := totalGroups[group]
+			if !ok {
+				total = &utils.GroupChecksum{}
+				totalGroups[group] = total
+			}
+			total.Count += checksum.Count
+			total.Checksum = utils.CombineChecksum(table.ChecksumAlgorithm, total.Checksum, checksum.Checksum)
+		}
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return totalGroups, nil
+}
+
 func (s *MySQLSources) GetCountAndCrc32(ctx context.Context, tableRange *splitter.RangeInfo) *ChecksumInfo {
 	beginTime := time.Now()
 	table := s.tableDiffs[tableRange.GetTableIndex()]
@@ -101,7 +154,11 @@ func (s *MySQLSources) GetCountAndCrc32(ctx context.Context, tableRange *splitte
 
 	for _, ms := range matchSources {
 		go func(ms *common.TableShardSource) {
-			count, checksum, err := utils.GetCountAndCRC32Checksum(ctx, ms.DBConn, ms.OriginSchema, ms.OriginTable, table.Info, chunk.Where, chunk.Args)
+			var count, checksum int64
+			columnExprs, err := s.getColumnExprs(table, ms)
+			if err == nil {
+				count, checksum, err = utils.GetCountAndCRC32Checksum(ctx, ms.DBConn, ms.OriginSchema, ms.OriginTable, table.Info, columnExprs, table.Tolerances, table.ChecksumAlgorithm, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)), chunk.Args)
+			}
 			infoCh <- &ChecksumInfo{
 				Checksum: checksum,
 				Count:    count,
@@ -124,7 +181,7 @@ func (s *MySQLSources) GetCountAndCrc32(ctx context.Context, tableRange *splitte
 			err = info.Err
 		}
 		totalCount += info.Count
-		totalChecksum ^= info.Checksum
+		totalChecksum = utils.CombineChecksum(table.ChecksumAlgorithm, totalChecksum, info.Checksum)
 	}
 
 	cost := time.Since(beginTime)
@@ -136,6 +193,14 @@ func (s *MySQLSources) GetCountAndCrc32(ctx context.Context, tableRange *splitte
 	}
 }
 
+// getColumnExprs returns the sql expressions of the transformed columns if the source is the upstream.
+func (s *MySQLSources) getColumnExprs(table *common.TableDiff, ms *common.TableShardSource) (map[string]string, error) {
+	if !s.upstream {
+		return nil, nil
+	}
+	return getColumnExprs(table, ms.OriginSchema, ms.OriginTable)
+}
+
 func (s *MySQLSources) GetTables() []*common.TableDiff {
 	return s.tableDiffs
 }
@@ -165,8 +230,12 @@ func (s *MySQLSources) GetRowsIterator(ctx context.Context, tableRange *splitter
 	var rowsQuery string
 	var orderKeyCols []*model.ColumnInfo
 	for i, ms := range matchSources {
-		rowsQuery, orderKeyCols = utils.GetTableRowsQueryFormat(ms.OriginSchema, ms.OriginTable, table.Info, table.Collation)
-		query := fmt.Sprintf(rowsQuery, chunk.Where)
+		columnExprs, err := s.getColumnExprs(table, ms)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rowsQuery, orderKeyCols = utils.GetTableRowsQueryFormat(ms.OriginSchema, ms.OriginTable, table.Info, columnExprs, table.Collation)
+		query := fmt.Sprintf(rowsQuery, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)))
 		rows, err := ms.DBConn.QueryContext(ctx, query, chunk.Args...)
 		if err != nil {
 			return nil, errors.Trace(err)
@@ -197,10 +266,14 @@ func (s *MySQLSources) GetRowsIterator(ctx context.Context, tableRange *splitter
 		}
 	}
 
-	return &MultiSourceRowsIterator{
+	iter := &MultiSourceRowsIterator{
 		sourceRows:     sourceRows,
 		sourceRowDatas: sourceRowDatas,
-	}, nil
+	}
+	if len(matchSources) > 1 && !utils.IsKeyless(table.Info) {
+		iter.sourceNames = s.GetSourceTables(tableRange.GetTableIndex())
+	}
+	return iter, nil
 }
 
 func (s *MySQLSources) GetDB() *sql.DB {
@@ -235,9 +308,28 @@ func (s *MySQLSources) GetSourceStructInfo(ctx context.Context, tableIndex int)
 	return sourceTableInfos, nil
 }
 
+func (s *MySQLSources) GetSourceTables(tableIndex int) []string {
+	tableSources := getMatchedSourcesForTable(s.sourceTablesMap, s.GetTables()[tableIndex])
+	sourceTables := make([]string, 0, len(tableSources))
+	for _, tableSource := range tableSources {
+		sourceTables = append(sourceTables, fmt.Sprintf("%s/%s", tableSource.Instance, dbutil.TableName(tableSource.OriginSchema, tableSource.OriginTable)))
+	}
+	return sourceTables
+}
+
+// maxKeyCollisions limits the collided keys kept by an iterator, the rest are only counted.
+const maxKeyCollisions = 100
+
 type MultiSourceRowsIterator struct {
 	sourceRows     map[int]*sql.Rows
 	sourceRowDatas *common.RowDatas
+
+	// the names of the sources, the keys existing in multiple sources are detected if they are set.
+	sourceNames       []string
+	lastRow           *common.RowData
+	lastCollision     *utils.KeyCollision
+	keyCollisions     []*utils.KeyCollision
+	keyCollisionCount int64
 }
 
 func getRowData(rows *sql.Rows) (rowData map[string]*dbutil.ColumnData, err error) {
@@ -257,6 +349,9 @@ func (ms *MultiSourceRowsIterator) Next() (map[string]*dbutil.ColumnData, error)
 		return nil, nil
 	}
 	rowData := heap.Pop(ms.sourceRowDatas).(common.RowData)
+	if ms.sourceNames != nil {
+		ms.checkKeyCollision(rowData)
+	}
 	newRowData, err := getRowData(ms.sourceRows[rowData.Source])
 	if err != nil {
 		return nil, err
@@ -274,6 +369,35 @@ func (ms *MultiSourceRowsIterator) Next() (map[string]*dbutil.ColumnData, error)
 	return rowData.Data, nil
 }
 
+// checkKeyCollision records the key of the row if it's equal to the key of the last row, which must be from
+// another source because the key is unique in each source. The NULL keys of the unique index don't collide.
+func (ms *MultiSourceRowsIterator) checkKeyCollision(row common.RowData) {
+	last := ms.lastRow
+	ms.lastRow = &row
+	if last == nil || !ms.sourceRowDatas.KeyEqual(last.Data, row.Data) {
+		ms.lastCollision = nil
+		return
+	}
+	for _, col := range ms.sourceRowDatas.OrderKeyCols {
+		if row.Data[col.Name.O].IsNull {
+			return
+		}
+	}
+	if ms.lastCollision == nil {
+		ms.keyCollisionCount++
+		ms.lastCollision = utils.NewKeyCollision(row.Data, ms.sourceRowDatas.OrderKeyCols, ms.sourceNames[last.Source])
+		// the keys beyond the limit are only counted.
+		if len(ms.keyCollisions) < maxKeyCollisions {
+			ms.keyCollisions = append(ms.keyCollisions, ms.lastCollision)
+		}
+	}
+	ms.lastCollision.Shards = append(ms.lastCollision.Shards, ms.sourceNames[row.Source])
+}
+
+func (ms *MultiSourceRowsIterator) GetKeyCollisions() ([]*utils.KeyCollision, int64) {
+	return ms.keyCollisions, ms.keyCollisionCount
+}
+
 func (ms *MultiSourceRowsIterator) Close() {
 	for _, s := range ms.sourceRows {
 		s.Close()
@@ -327,7 +451,8 @@ func NewMySQLSources(ctx context.Context, tableDiffs []*common.TableDiff, ds []*
 						OriginSchema: schema,
 						OriginTable:  table,
 					},
-					DBConn: sourceDB.Conn,
+					DBConn:   sourceDB.Conn,
+					Instance: fmt.Sprintf("%s:%d", sourceDB.Host, sourceDB.Port),
 				})
 			}
 		}
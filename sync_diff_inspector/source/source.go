@@ -31,6 +31,7 @@ import (
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
 	"go.uber.org/zap"
 )
 
@@ -66,6 +67,30 @@ type TableAnalyzer interface {
 	AnalyzeSplitter(context.Context, *common.TableDiff, *splitter.RangeInfo) (splitter.ChunkIterator, error)
 }
 
+// GroupedChecksumSource is implemented by the sources which compute the checksums of the groups of rows in one query.
+type GroupedChecksumSource interface {
+	// GetGroupedCountAndCrc32 gets the count and the crc32 result of each group of the rows in the given range,
+	// the rows are grouped by the value of groupExpr.
+	GetGroupedCountAndCrc32(ctx context.Context, tableRange *splitter.RangeInfo, groupExpr string) (map[int64]*utils.GroupChecksum, error)
+}
+
+// TableChecksumSource is implemented by the sources which compute the checksum of the whole table in the storage,
+// such as `ADMIN CHECKSUM TABLE` of TiDB.
+type TableChecksumSource interface {
+	// GetTablePhysicalIDs returns the IDs of the table and its partitions, the checksums of the tables are
+	// comparable only if their IDs are the same.
+	GetTablePhysicalIDs(ctx context.Context, tableIndex int) ([]int64, error)
+	// GetTableChecksum returns the checksum of all the key-values of the table.
+	GetTableChecksum(ctx context.Context, tableIndex int) (*utils.TableChecksum, error)
+}
+
+// KeyCollisionIterator is implemented by the iterators merging the rows of multiple shards,
+// they detect the primary keys or unique keys existing in more than one shard.
+type KeyCollisionIterator interface {
+	// GetKeyCollisions returns the first collided keys of the iterated rows and the count of all the collided keys.
+	GetKeyCollisions() ([]*utils.KeyCollision, int64)
+}
+
 type Source interface {
 	// GetTableAnalyzer pick the proper analyzer for different source.
 	// the implement of this function is different in mysql/tidb.
@@ -92,6 +117,9 @@ type Source interface {
 	// GetSourceStructInfo get the source table info from a given target table
 	GetSourceStructInfo(context.Context, int) ([]*model.TableInfo, error)
 
+	// GetSourceTables gets the names of the origin tables matched by a given target table.
+	GetSourceTables(int) []string
+
 	// GetDB represents the db connection.
 	GetDB() *sql.DB
 
@@ -104,7 +132,19 @@ type Source interface {
 	Close()
 }
 
-func NewSources(ctx context.Context, cfg *config.Config) (downstream Source, upstream Source, err error) {
+// TableOrder decides the order to check the tables, see sortTableDiffs.
+type TableOrder struct {
+	// Tables are the unique IDs of the tables in the order saved by the last check, the tables
+	// are checked in the same order when resuming it, because the chunks are identified by it.
+	Tables []string
+	// LastVerified is the last time the tables were verified, the tables verified least recently
+	// are checked first if it isn't nil.
+	LastVerified map[string]time.Time
+}
+
+// NewSources returns the sources of the target tables and the source tables, the tables are sorted
+// by the order, which can be nil.
+func NewSources(ctx context.Context, cfg *config.Config, order *TableOrder) (downstream Source, upstream Source, err error) {
 	// init db connection for upstream / downstream.
 	err = initDBConn(ctx, cfg)
 	if err != nil {
@@ -129,7 +169,24 @@ func NewSources(ctx context.Context, cfg *config.Config) (downstream Source, ups
 			NeedUnifiedTimeZone: needUnifiedTimeZone,
 			Collation:           tableConfig.Collation,
 			ChunkSize:           tableConfig.ChunkSize,
+			LocateStrategy:      tableConfig.LocateStrategy,
+			ChecksumAlgorithm:   tableConfig.ChecksumAlgorithm,
+			Priority:            tableConfig.Priority,
+			Exclude:             tableConfig.Exclude,
+			UpstreamExclude:     tableConfig.UpstreamExclude,
+			DownstreamExclude:   tableConfig.DownstreamExclude,
 		})
+		// the rows excluded from both sides are filtered out by the range, so they are not split into the chunks either.
+		tableDiffs[len(tableDiffs)-1].Range = common.ExcludeRows(tableConfig.Range, tableConfig.Exclude)
+		if err := initColumnTransforms(tableDiffs[len(tableDiffs)-1], tableConfig); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if err := initTolerances(tableDiffs[len(tableDiffs)-1], tableConfig); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if err := initUpdateTimeColumn(tableDiffs[len(tableDiffs)-1], tableConfig); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
 
 		// When the router set case-sensitive false,
 		// that add rule match itself will make table case unsensitive.
@@ -149,17 +206,14 @@ func NewSources(ctx context.Context, cfg *config.Config) (downstream Source, ups
 		return nil, nil, errors.Errorf("no table need to be compared")
 	}
 
-	// Sort TableDiff is important!
-	// because we compare table one by one.
-	sort.Slice(tableDiffs, func(i, j int) bool {
-		ti := utils.UniqueID(tableDiffs[i].Schema, tableDiffs[i].Table)
-		tj := utils.UniqueID(tableDiffs[j].Schema, tableDiffs[j].Table)
-		return strings.Compare(ti, tj) > 0
-	})
+	sortTableDiffs(tableDiffs, order)
 	upstream, err = buildSourceFromCfg(ctx, tableDiffs, cfg.CheckThreadCount, cfg.Task.SourceInstances...)
 	if err != nil {
 		return nil, nil, errors.Annotate(err, "from upstream")
 	}
+	if err = transformUpstream(upstream); err != nil {
+		return nil, nil, errors.Annotate(err, "from upstream")
+	}
 	downstream, err = buildSourceFromCfg(ctx, tableDiffs, cfg.CheckThreadCount, cfg.Task.TargetInstance)
 	if err != nil {
 		return nil, nil, errors.Annotate(err, "from downstream")
@@ -167,10 +221,78 @@ func NewSources(ctx context.Context, cfg *config.Config) (downstream Source, ups
 	return downstream, upstream, nil
 }
 
+// initUpdateTimeColumn checks the update time column of the table config and sets it to the table diff.
+// Only the TIMESTAMP column is supported, because it is compared in the unified time zone of the sessions,
+// while the time zone of the values of a DATETIME column is unknown.
+func initUpdateTimeColumn(tableDiff *common.TableDiff, tableConfig *config.TableConfig) error {
+	if len(tableConfig.UpdateTimeColumn) == 0 {
+		return nil
+	}
+	tableName := dbutil.TableName(tableDiff.Schema, tableDiff.Table)
+	col := findColumn(tableDiff.Info, tableConfig.UpdateTimeColumn)
+	if col == nil {
+		return errors.Errorf("the update time column %s is not found in table %s", tableConfig.UpdateTimeColumn, tableName)
+	}
+	if col.FieldType.Tp != mysql.TypeTimestamp {
+		return errors.Errorf("the update time column %s of table %s is not TIMESTAMP", tableConfig.UpdateTimeColumn, tableName)
+	}
+	tableDiff.UpdateTimeColumn = col.Name.O
+	return nil
+}
+
+// sortTableDiffs sorts the tables in the order to check them. Sort TableDiff is important!
+// because we compare table one by one, and the chunks iterator schedules the tables by this order.
+// The tables with higher priority are checked first, then the tables verified least recently
+// if the last verified time is given, the tables never verified are checked before the others.
+// If the tables of the saved order are given, they are kept in that order before the other tables.
+func sortTableDiffs(tableDiffs []*common.TableDiff, order *TableOrder) {
+	var lastVerified map[string]time.Time
+	if order != nil {
+		lastVerified = order.LastVerified
+	}
+	sort.Slice(tableDiffs, func(i, j int) bool {
+		ti := utils.UniqueID(tableDiffs[i].Schema, tableDiffs[i].Table)
+		tj := utils.UniqueID(tableDiffs[j].Schema, tableDiffs[j].Table)
+		return strings.Compare(ti, tj) > 0
+	})
+	sort.SliceStable(tableDiffs, func(i, j int) bool {
+		if tableDiffs[i].Priority != tableDiffs[j].Priority {
+			return tableDiffs[i].Priority > tableDiffs[j].Priority
+		}
+		if lastVerified == nil {
+			return false
+		}
+		ti := lastVerified[utils.UniqueID(tableDiffs[i].Schema, tableDiffs[i].Table)]
+		tj := lastVerified[utils.UniqueID(tableDiffs[j].Schema, tableDiffs[j].Table)]
+		return ti.Before(tj)
+	})
+	if order == nil || len(order.Tables) == 0 {
+		return
+	}
+	savedIndex := make(map[string]int, len(order.Tables))
+	for i, id := range order.Tables {
+		savedIndex[id] = i
+	}
+	sort.SliceStable(tableDiffs, func(i, j int) bool {
+		ti, iSaved := savedIndex[utils.UniqueID(tableDiffs[i].Schema, tableDiffs[i].Table)]
+		tj, jSaved := savedIndex[utils.UniqueID(tableDiffs[j].Schema, tableDiffs[j].Table)]
+		if iSaved != jSaved {
+			return iSaved
+		}
+		return iSaved && ti < tj
+	})
+}
+
 func buildSourceFromCfg(ctx context.Context, tableDiffs []*common.TableDiff, checkThreadCount int, dbs ...*config.DataSource) (Source, error) {
 	if len(dbs) < 1 {
 		return nil, errors.Errorf("no db config detected")
 	}
+	if len(dbs[0].Dir) != 0 {
+		if len(dbs) > 1 {
+			return nil, errors.Errorf("don't support check dump files with multiple sources")
+		}
+		return NewFileSource(ctx, tableDiffs, dbs[0])
+	}
 	ok, err := dbutil.IsTiDB(ctx, dbs[0].Conn)
 	if err != nil {
 		return nil, errors.Annotatef(err, "connect to db failed")
@@ -179,9 +301,8 @@ func buildSourceFromCfg(ctx context.Context, tableDiffs []*common.TableDiff, che
 	if ok {
 		if len(dbs) == 1 {
 			return NewTiDBSource(ctx, tableDiffs, dbs[0], checkThreadCount)
-		} else {
-			log.Fatal("Don't support check table in multiple tidb instance, please specify one tidb instance.")
 		}
+		return nil, errors.Errorf("don't support check table in multiple tidb instance, please specify one tidb instance")
 	}
 	return NewMySQLSources(ctx, tableDiffs, dbs, checkThreadCount)
 }
@@ -201,6 +322,10 @@ func initDBConn(ctx context.Context, cfg *config.Config) error {
 	cfg.Task.TargetInstance.Conn = targetConn
 
 	for _, source := range cfg.Task.SourceInstances {
+		if len(source.Dir) != 0 {
+			// the dump files are read from the local disk.
+			continue
+		}
 		// connect source db with target db time_zone
 		conn, err := common.CreateDB(ctx, source.ToDBConfig(), vars, cfg.CheckThreadCount+1)
 		if err != nil {
@@ -219,6 +344,8 @@ func initTables(ctx context.Context, cfg *config.Config) (cfgTables []*config.Ta
 		return nil, errors.Annotatef(err, "get schemas from target source")
 	}
 
+	// the checkpoint table is created in the target instance before, it has no upstream table.
+	cpSchema, cpTable, hasCheckpointTable := cfg.CheckpointTable()
 	for _, schema := range targetSchemas {
 		if filter.IsSystemSchema(schema) {
 			continue
@@ -228,6 +355,9 @@ func initTables(ctx context.Context, cfg *config.Config) (cfgTables []*config.Ta
 			return nil, errors.Annotatef(err, "get tables from target source %s", schema)
 		}
 		for _, t := range allTables {
+			if hasCheckpointTable && strings.EqualFold(schema, cpSchema) && strings.EqualFold(t, cpTable) {
+				continue
+			}
 			TargetTablesList = append(TargetTablesList, &common.TableSource{
 				OriginSchema: schema,
 				OriginTable:  t,
@@ -279,6 +409,16 @@ func initTables(ctx context.Context, cfg *config.Config) (cfgTables []*config.Ta
 				cfgTable.Fields = table.Fields
 				cfgTable.Collation = table.Collation
 				cfgTable.ChunkSize = table.ChunkSize
+				cfgTable.UpdateTimeColumn = table.UpdateTimeColumn
+				cfgTable.ColumnTransforms = table.ColumnTransforms
+				cfgTable.ColumnMappings = table.ColumnMappings
+				cfgTable.Tolerances = table.Tolerances
+				cfgTable.LocateStrategy = table.LocateStrategy
+				cfgTable.ChecksumAlgorithm = table.ChecksumAlgorithm
+				cfgTable.Priority = table.Priority
+				cfgTable.Exclude = table.Exclude
+				cfgTable.UpstreamExclude = table.UpstreamExclude
+				cfgTable.DownstreamExclude = table.DownstreamExclude
 				cfgTable.HasMatched = true
 			}
 		}
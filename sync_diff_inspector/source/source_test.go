@@ -19,11 +19,13 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	column "github.com/pingcap/tidb-tools/pkg/column-mapping"
 	"github.com/pingcap/tidb-tools/pkg/dbutil"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	router "github.com/pingcap/tidb-tools/pkg/table-router"
@@ -31,6 +33,7 @@ import (
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
 	"github.com/pingcap/tidb/parser"
 	"github.com/stretchr/testify/require"
 
@@ -366,6 +369,44 @@ func TestMysqlShardSources(t *testing.T) {
 
 		i++
 	}
+	collisions, count := rowIter.(KeyCollisionIterator).GetKeyCollisions()
+	require.Empty(t, collisions)
+	require.Zero(t, count)
+	rowIter.Close()
+
+	// the same keys exist in multiple shards
+	shardRows := [][][]driver.Value{
+		{{"1", "a", "1.2"}, {"2", "b", "2.2"}},
+		{{"2", "b", "2.3"}, {"3", "c", "3.2"}},
+		{{"2", "b", "2.4"}},
+		{{"3", "d", "3.3"}},
+	}
+	for _, rows := range shardRows {
+		dataRows := sqlmock.NewRows(tableCase.rowColumns)
+		for _, row := range rows {
+			dataRows.AddRow(row...)
+		}
+		mock.ExpectQuery(tableCase.rowQuery).WillReturnRows(dataRows)
+	}
+	rowIter, err = shard.GetRowsIterator(ctx, tableCase.rangeInfo)
+	require.NoError(t, err)
+	i = 0
+	for {
+		columns, err := rowIter.Next()
+		require.NoError(t, err)
+		if columns == nil {
+			break
+		}
+		i++
+	}
+	require.Equal(t, 6, i)
+	collisions, count = rowIter.(KeyCollisionIterator).GetKeyCollisions()
+	require.Equal(t, int64(1), count)
+	require.Len(t, collisions, 1)
+	require.Equal(t, "2", *collisions[0].Key["a"])
+	require.Equal(t, "b", *collisions[0].Key["b"])
+	require.Len(t, collisions[0].Shards, 3)
+	require.Equal(t, shard.GetSourceTables(0)[0], collisions[0].Shards[0])
 	rowIter.Close()
 
 	shard.Close()
@@ -543,6 +584,7 @@ func TestTiDBRouter(t *testing.T) {
 	info, err := tidb.GetSourceStructInfo(ctx, 0)
 	require.NoError(t, err)
 	require.Equal(t, info[0].Name.O, "test1")
+	require.Equal(t, []string{"`source_test_t`.`test_t`"}, tidb.GetSourceTables(0))
 }
 
 func prepareTiDBTables(t *testing.T, tableCases []*tableCaseType) []*common.TableDiff {
@@ -668,7 +710,7 @@ func TestSource(t *testing.T) {
 	conn.Exec("CREATE TABLE IF NOT EXISTS `schema1`.`tbl` (`a` int, `b` varchar(24), `c` float, `d` datetime, primary key(`a`, `b`))")
 	// create db connections refused.
 	// TODO unit_test covers source.go
-	_, _, err = NewSources(ctx, cfg)
+	_, _, err = NewSources(ctx, cfg, nil)
 	require.NoError(t, err)
 }
 
@@ -723,4 +765,187 @@ func TestInitTables(t *testing.T) {
 	tablesToBeCheck, err = initTables(ctx, cfg)
 	require.Contains(t, err.Error(), "different config matched to same target table")
 	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Test case 3: the checkpoint table in the target instance isn't matched by the wildcard.
+	cfg = config.NewConfig()
+	cfg.Task.TargetInstance = &config.DataSource{Conn: conn}
+	cfg.Task.TargetCheckTables, err = filter.Parse([]string{"*.*"})
+	require.NoError(t, err)
+	cfg.CheckpointStorage = &config.CheckpointStorageConfig{Type: config.CheckpointStorageDB}
+
+	rows = sqlmock.NewRows([]string{"Database"}).AddRow("sync_diff_inspector").AddRow("test2")
+	mock.ExpectQuery("SHOW DATABASES").WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"col1", "col2"}).AddRow("checkpoint", "BASE TABLE")
+	mock.ExpectQuery("SHOW FULL TABLES*").WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"col1", "col2"}).AddRow("t2", "BASE TABLE")
+	mock.ExpectQuery("SHOW FULL TABLES*").WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"col1", "col2"}).AddRow("t2", "CREATE TABLE `t2` (\n\t\t\t`id` int(11) DEFAULT NULL,\n\t\t  \t`name` varchar(24) DEFAULT NULL\n\t\t\t) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin")
+	mock.ExpectQuery("SHOW CREATE TABLE *").WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"col1", "col2"}).AddRow("", "")
+	mock.ExpectQuery("SHOW VARIABLES LIKE*").WillReturnRows(rows)
+
+	tablesToBeCheck, err = initTables(ctx, cfg)
+	require.NoError(t, err)
+	require.Len(t, tablesToBeCheck, 1)
+	require.Equal(t, "test2", tablesToBeCheck[0].Schema)
+	require.Equal(t, "t2", tablesToBeCheck[0].Table)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestColumnTransforms(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(id bigint, name varchar(20), code varchar(20), primary key(id))", parser.New())
+	require.NoError(t, err)
+	tableDiff := &common.TableDiff{Schema: "test", Table: "t", Info: tableInfo}
+	tableConfig := &config.TableConfig{
+		ColumnTransforms: map[string]string{"Name": "TRIM(`name`)"},
+		ColumnMappings: []*column.Rule{
+			{PatternSchema: "shard_*", TargetColumn: "id", Expression: column.PartitionID, Arguments: []string{"1", "shard_", "t_"}},
+			{PatternSchema: "shard_*", PatternTable: "t_*", TargetColumn: "name", Expression: column.AddPrefix, Arguments: []string{"'p"}},
+		},
+	}
+	require.NoError(t, initColumnTransforms(tableDiff, tableConfig))
+	require.True(t, tableDiff.HasColumnTransforms())
+
+	exprs, err := getColumnExprs(tableDiff, "shard_1", "t_2")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"id":   fmt.Sprintf("(`id` | %d)", int64(1)<<59|int64(1)<<52|int64(2)<<44),
+		"name": "CONCAT('\\'p', TRIM(`name`))",
+	}, exprs)
+	// the mapping rules don't match the table
+	exprs, err = getColumnExprs(tableDiff, "test", "t")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"name": "TRIM(`name`)"}, exprs)
+
+	tableConfig = &config.TableConfig{ColumnTransforms: map[string]string{"age": "age + 1"}}
+	require.Error(t, initColumnTransforms(&common.TableDiff{Schema: "test", Table: "t", Info: tableInfo}, tableConfig))
+}
+
+func TestTolerances(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(id double, a float, b double, c decimal(10, 2), d varchar(10), primary key(id))", parser.New())
+	require.NoError(t, err)
+	tableDiff := &common.TableDiff{Schema: "test", Table: "t", Info: tableInfo}
+	tableConfig := &config.TableConfig{
+		Tolerances: []*config.ToleranceConfig{
+			{Types: []string{"float", "DOUBLE"}, Relative: 1e-6},
+			{Columns: []string{"B", "c"}, Absolute: 0.01},
+		},
+	}
+	require.NoError(t, initTolerances(tableDiff, tableConfig))
+	// the primary key is compared exactly, and the column tolerance overrides the type tolerance.
+	require.Equal(t, map[string]*utils.Tolerance{
+		"a": {Relative: 1e-6},
+		"b": {Absolute: 0.01},
+		"c": {Absolute: 0.01},
+	}, tableDiff.Tolerances)
+
+	for _, tc := range []*config.ToleranceConfig{
+		{Columns: []string{"id"}, Absolute: 0.01},
+		{Columns: []string{"d"}, Absolute: 0.01},
+		{Columns: []string{"e"}, Absolute: 0.01},
+		{Types: []string{"int"}, Absolute: 0.01},
+		{Columns: []string{"a"}},
+	} {
+		tableDiff := &common.TableDiff{Schema: "test", Table: "t", Info: tableInfo}
+		require.Error(t, initTolerances(tableDiff, &config.TableConfig{Tolerances: []*config.ToleranceConfig{tc}}))
+	}
+}
+
+func TestUpdateTimeColumn(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table t(id int primary key, a timestamp, b datetime)", parser.New())
+	require.NoError(t, err)
+	tableDiff := &common.TableDiff{Schema: "test", Table: "t", Info: tableInfo}
+	require.NoError(t, initUpdateTimeColumn(tableDiff, &config.TableConfig{UpdateTimeColumn: "A"}))
+	require.Equal(t, "a", tableDiff.UpdateTimeColumn)
+
+	// the time zone of the datetime values is unknown.
+	err = initUpdateTimeColumn(tableDiff, &config.TableConfig{UpdateTimeColumn: "b"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not TIMESTAMP")
+	require.Error(t, initUpdateTimeColumn(tableDiff, &config.TableConfig{UpdateTimeColumn: "c"}))
+}
+
+func TestSortTableDiffs(t *testing.T) {
+	newTables := func() []*common.TableDiff {
+		return []*common.TableDiff{
+			{Schema: "a", Table: "t"},
+			{Schema: "b", Table: "t", Priority: 1},
+			{Schema: "c", Table: "t"},
+			{Schema: "d", Table: "t"},
+			{Schema: "e", Table: "t", Priority: -1},
+		}
+	}
+	names := func(tableDiffs []*common.TableDiff) []string {
+		schemas := make([]string, 0, len(tableDiffs))
+		for _, tableDiff := range tableDiffs {
+			schemas = append(schemas, tableDiff.Schema)
+		}
+		return schemas
+	}
+	tableDiffs := newTables()
+	sortTableDiffs(tableDiffs, nil)
+	require.Equal(t, []string{"b", "d", "c", "a", "e"}, names(tableDiffs))
+
+	// the tables never verified are checked first, then the least recently verified ones.
+	now := time.Now()
+	tableDiffs = newTables()
+	sortTableDiffs(tableDiffs, &TableOrder{LastVerified: map[string]time.Time{
+		"a:t": now.Add(-time.Hour),
+		"b:t": now,
+		"d:t": now,
+		"e:t": now.Add(-2 * time.Hour),
+	}})
+	require.Equal(t, []string{"b", "c", "a", "d", "e"}, names(tableDiffs))
+
+	// the saved order is kept when resuming, whatever the history is, the other tables are checked at last.
+	tableDiffs = newTables()
+	sortTableDiffs(tableDiffs, &TableOrder{
+		Tables:       []string{"e:t", "a:t", "c:t", "b:t"},
+		LastVerified: map[string]time.Time{"a:t": now, "e:t": now},
+	})
+	require.Equal(t, []string{"e", "a", "c", "b", "d"}, names(tableDiffs))
+}
+
+func TestExcludeRows(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tableCases := []*tableCaseType{
+		{
+			createTableSQL: "CREATE TABLE `source_test`.`test1` (`a` int, `b` varchar(24), `deleted_at` datetime, primary key(`a`))",
+			rangeColumns:   []string{"a"},
+			rangeLeft:      []string{"3"},
+			rangeRight:     []string{"5"},
+		},
+	}
+	tableDiffs := prepareTiDBTables(t, tableCases)
+	tableDiffs[0].UpstreamExclude = "`deleted_at` IS NOT NULL"
+	tableDiffs[0].DownstreamExclude = "`b` = 'test'"
+	rangeInfo := tableCases[0].rangeInfo
+
+	downstream, err := NewTiDBSource(ctx, tableDiffs, &config.DataSource{Conn: conn}, 1)
+	require.NoError(t, err)
+	upstream, err := NewTiDBSource(ctx, tableDiffs, &config.DataSource{Conn: conn}, 1)
+	require.NoError(t, err)
+	require.NoError(t, transformUpstream(upstream))
+
+	mock.ExpectQuery(regexp.QuoteMeta("AND ((`deleted_at` IS NOT NULL) IS NOT TRUE)")).
+		WillReturnRows(sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(1, 2))
+	require.NoError(t, upstream.GetCountAndCrc32(ctx, rangeInfo).Err)
+	mock.ExpectQuery(regexp.QuoteMeta("AND ((`b` = 'test') IS NOT TRUE)")).
+		WillReturnRows(sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(1, 2))
+	require.NoError(t, downstream.GetCountAndCrc32(ctx, rangeInfo).Err)
+	mock.ExpectQuery(regexp.QuoteMeta("AND ((`deleted_at` IS NOT NULL) IS NOT TRUE)")).
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b", "deleted_at"}))
+	rows, err := upstream.GetRowsIterator(ctx, rangeInfo)
+	require.NoError(t, err)
+	rows.Close()
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Equal(t, "(TRUE) AND ((`deleted_at` IS NOT NULL) IS NOT TRUE)", common.ExcludeRows("TRUE", "`deleted_at` IS NOT NULL"))
+	require.Equal(t, "TRUE", common.ExcludeRows("TRUE", ""))
 }
@@ -35,6 +35,7 @@ type TiDBTableAnalyzer struct {
 	dbConn           *sql.DB
 	checkThreadCount int
 	sourceTableMap   map[string]*common.TableSource
+	upstream         bool
 }
 
 func (a *TiDBTableAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.TableDiff, startRange *splitter.RangeInfo) (splitter.ChunkIterator, error) {
@@ -43,6 +44,7 @@ func (a *TiDBTableAnalyzer) AnalyzeSplitter(ctx context.Context, table *common.T
 	originTable := *table
 	originTable.Schema = matchedSource.OriginSchema
 	originTable.Table = matchedSource.OriginTable
+	originTable.SplitExclude = table.GetExclude(a.upstream)
 	progressID := dbutil.TableName(table.Schema, table.Table)
 	// if we decide to use bucket to split chunks
 	// we always use bucksIter even we load from checkpoint is not bucketNode
@@ -86,6 +88,9 @@ type TiDBSource struct {
 	// checkThreadCount is the pool size of produce chunks
 	checkThreadCount int
 	dbConn           *sql.DB
+	// upstream is true if the source is the upstream, whose values are transformed and rows are excluded
+	// by the table configs.
+	upstream bool
 }
 
 func (s *TiDBSource) GetTableAnalyzer() TableAnalyzer {
@@ -93,6 +98,7 @@ func (s *TiDBSource) GetTableAnalyzer() TableAnalyzer {
 		s.dbConn,
 		s.checkThreadCount,
 		s.sourceTableMap,
+		s.upstream,
 	}
 }
 
@@ -121,7 +127,11 @@ func (s *TiDBSource) GetCountAndCrc32(ctx context.Context, tableRange *splitter.
 	chunk := tableRange.GetChunk()
 
 	matchSource := getMatchSource(s.sourceTableMap, table)
-	count, checksum, err := utils.GetCountAndCRC32Checksum(ctx, s.dbConn, matchSource.OriginSchema, matchSource.OriginTable, table.Info, chunk.Where, chunk.Args)
+	var count, checksum int64
+	columnExprs, err := s.getColumnExprs(table, matchSource)
+	if err == nil {
+		count, checksum, err = utils.GetCountAndCRC32Checksum(ctx, s.dbConn, matchSource.OriginSchema, matchSource.OriginTable, table.Info, columnExprs, table.Tolerances, table.ChecksumAlgorithm, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)), chunk.Args)
+	}
 
 	cost := time.Since(beginTime)
 	return &ChecksumInfo{
@@ -132,6 +142,30 @@ func (s *TiDBSource) GetCountAndCrc32(ctx context.Context, tableRange *splitter.
 	}
 }
 
+// GetGroupedCountAndCrc32 implements GroupedChecksumSource interface.
+func (s *TiDBSource) GetGroupedCountAndCrc32(ctx context.Context, tableRange *splitter.RangeInfo, groupExpr string) (map[int64]*utils.GroupChecksum, error) {
+	table := s.tableDiffs[tableRange.GetTableIndex()]
+	chunk := tableRange.GetChunk()
+	matchSource := getMatchSource(s.sourceTableMap, table)
+	columnExprs, err := s.getColumnExprs(table, matchSource)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return utils.GetGroupedCountAndCRC32Checksum(ctx, s.dbConn, matchSource.OriginSchema, matchSource.OriginTable, table.Info, columnExprs, table.Tolerances, table.ChecksumAlgorithm, groupExpr, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)), chunk.Args)
+}
+
+// GetTablePhysicalIDs implements TableChecksumSource interface.
+func (s *TiDBSource) GetTablePhysicalIDs(ctx context.Context, tableIndex int) ([]int64, error) {
+	matchSource := getMatchSource(s.sourceTableMap, s.tableDiffs[tableIndex])
+	return utils.GetTablePhysicalIDs(ctx, s.dbConn, matchSource.OriginSchema, matchSource.OriginTable)
+}
+
+// GetTableChecksum implements TableChecksumSource interface.
+func (s *TiDBSource) GetTableChecksum(ctx context.Context, tableIndex int) (*utils.TableChecksum, error) {
+	matchSource := getMatchSource(s.sourceTableMap, s.tableDiffs[tableIndex])
+	return utils.GetTableChecksum(ctx, s.dbConn, matchSource.OriginSchema, matchSource.OriginTable)
+}
+
 func (s *TiDBSource) GetTables() []*common.TableDiff {
 	return s.tableDiffs
 }
@@ -149,6 +183,11 @@ func (s *TiDBSource) GetSourceStructInfo(ctx context.Context, tableIndex int) ([
 	return tableInfos, nil
 }
 
+func (s *TiDBSource) GetSourceTables(tableIndex int) []string {
+	source := getMatchSource(s.sourceTableMap, s.GetTables()[tableIndex])
+	return []string{dbutil.TableName(source.OriginSchema, source.OriginTable)}
+}
+
 func (s *TiDBSource) GenerateFixSQL(t DMLType, upstreamData, downstreamData map[string]*dbutil.ColumnData, tableIndex int) string {
 	if t == Insert {
 		return utils.GenerateReplaceDML(upstreamData, s.tableDiffs[tableIndex].Info, s.tableDiffs[tableIndex].Schema)
@@ -168,8 +207,12 @@ func (s *TiDBSource) GetRowsIterator(ctx context.Context, tableRange *splitter.R
 
 	table := s.tableDiffs[tableRange.GetTableIndex()]
 	matchedSource := getMatchSource(s.sourceTableMap, table)
-	rowsQuery, _ := utils.GetTableRowsQueryFormat(matchedSource.OriginSchema, matchedSource.OriginTable, table.Info, table.Collation)
-	query := fmt.Sprintf(rowsQuery, chunk.Where)
+	columnExprs, err := s.getColumnExprs(table, matchedSource)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rowsQuery, _ := utils.GetTableRowsQueryFormat(matchedSource.OriginSchema, matchedSource.OriginTable, table.Info, columnExprs, table.Collation)
+	query := fmt.Sprintf(rowsQuery, common.ExcludeRows(chunk.Where, table.GetExclude(s.upstream)))
 
 	log.Debug("select data", zap.String("sql", query), zap.Reflect("args", chunk.Args))
 	rows, err := s.dbConn.QueryContext(ctx, query, chunk.Args...)
@@ -181,6 +224,14 @@ func (s *TiDBSource) GetRowsIterator(ctx context.Context, tableRange *splitter.R
 	}, nil
 }
 
+// getColumnExprs returns the sql expressions of the transformed columns if the source is the upstream.
+func (s *TiDBSource) getColumnExprs(table *common.TableDiff, matchSource *common.TableSource) (map[string]string, error) {
+	if !s.upstream {
+		return nil, nil
+	}
+	return getColumnExprs(table, matchSource.OriginSchema, matchSource.OriginTable)
+}
+
 func (s *TiDBSource) GetDB() *sql.DB {
 	return s.dbConn
 }
@@ -228,7 +279,7 @@ func getSourceTableMap(ctx context.Context, tableDiffs []*common.TableDiff, ds *
 				uniqueId := utils.UniqueID(targetSchema, targetTable)
 				if _, ok := uniqueMap[uniqueId]; ok {
 					if _, ok := sourceTableMap[uniqueId]; ok {
-						log.Fatal("TiDB source don't merge multiple tables into one table")
+						return nil, errors.Errorf("TiDB source don't merge multiple tables into one table, but %s.%s and other tables are routed to %s", schema, table, uniqueId)
 					}
 					sourceTableMap[uniqueId] = &common.TableSource{
 						OriginSchema: schema,
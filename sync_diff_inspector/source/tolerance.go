@@ -0,0 +1,112 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/utils"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+)
+
+var toleranceTypes = map[string]byte{
+	"float":   mysql.TypeFloat,
+	"double":  mysql.TypeDouble,
+	"decimal": mysql.TypeNewDecimal,
+}
+
+// initTolerances checks the tolerances of the table config and sets them to the table diff.
+// The tolerance of a column overrides the tolerance of its type. The columns in the index are
+// always compared exactly, because the chunks are split and the rows are ordered by them.
+func initTolerances(tableDiff *common.TableDiff, tableConfig *config.TableConfig) error {
+	if len(tableConfig.Tolerances) == 0 {
+		return nil
+	}
+	tableName := dbutil.TableName(tableDiff.Schema, tableDiff.Table)
+	exactColumns := make(map[string]struct{})
+	for _, col := range dbutil.FindAllColumnWithIndex(tableDiff.Info) {
+		exactColumns[col.Name.L] = struct{}{}
+	}
+	_, orderKeyCols := dbutil.SelectUniqueOrderKey(tableDiff.Info)
+	for _, col := range orderKeyCols {
+		exactColumns[col.Name.L] = struct{}{}
+	}
+
+	typeTolerances := make(map[byte]*utils.Tolerance)
+	columnTolerances := make(map[string]*utils.Tolerance)
+	for _, tc := range tableConfig.Tolerances {
+		if tc.Absolute < 0 || tc.Relative < 0 || tc.Absolute == 0 && tc.Relative == 0 {
+			return errors.Errorf("invalid tolerance absolute %v relative %v of table %s, should set a positive absolute or relative", tc.Absolute, tc.Relative, tableName)
+		}
+		tolerance := &utils.Tolerance{Absolute: tc.Absolute, Relative: tc.Relative}
+		for _, tp := range tc.Types {
+			fieldType, ok := toleranceTypes[strings.ToLower(tp)]
+			if !ok {
+				return errors.NotSupportedf("tolerance of type %s", tp)
+			}
+			typeTolerances[fieldType] = tolerance
+		}
+		for _, name := range tc.Columns {
+			col := findColumn(tableDiff.Info, name)
+			if col == nil {
+				return errors.Errorf("the tolerance column %s is not found in table %s", name, tableName)
+			}
+			if !isToleranceType(col.FieldType.Tp) {
+				return errors.Errorf("the tolerance column %s of table %s is not numeric", name, tableName)
+			}
+			if _, ok := exactColumns[col.Name.L]; ok {
+				return errors.Errorf("the tolerance column %s of table %s is in the index", name, tableName)
+			}
+			columnTolerances[col.Name.O] = tolerance
+		}
+	}
+
+	tableDiff.Tolerances = make(map[string]*utils.Tolerance)
+	for _, col := range tableDiff.Info.Columns {
+		if tolerance, ok := columnTolerances[col.Name.O]; ok {
+			tableDiff.Tolerances[col.Name.O] = tolerance
+			continue
+		}
+		if _, ok := exactColumns[col.Name.L]; ok {
+			continue
+		}
+		if tolerance, ok := typeTolerances[col.FieldType.Tp]; ok {
+			tableDiff.Tolerances[col.Name.O] = tolerance
+		}
+	}
+	return nil
+}
+
+func isToleranceType(tp byte) bool {
+	for _, fieldType := range toleranceTypes {
+		if tp == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+func findColumn(tableInfo *model.TableInfo, name string) *model.ColumnInfo {
+	for _, col := range tableInfo.Columns {
+		if col.Name.L == strings.ToLower(name) {
+			return col
+		}
+	}
+	return nil
+}
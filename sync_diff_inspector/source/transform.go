@@ -0,0 +1,134 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	column "github.com/pingcap/tidb-tools/pkg/column-mapping"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+)
+
+// initColumnTransforms checks the column transforms of the table config and sets them to the table diff.
+func initColumnTransforms(tableDiff *common.TableDiff, tableConfig *config.TableConfig) error {
+	columns := make(map[string]struct{}, len(tableDiff.Info.Columns))
+	for _, col := range tableDiff.Info.Columns {
+		columns[col.Name.L] = struct{}{}
+	}
+	checkColumn := func(name string) error {
+		if _, ok := columns[strings.ToLower(name)]; !ok {
+			return errors.Errorf("the transformed column %s is not found in table %s", name, dbutil.TableName(tableDiff.Schema, tableDiff.Table))
+		}
+		return nil
+	}
+
+	if len(tableConfig.ColumnTransforms) != 0 {
+		tableDiff.ColumnTransforms = make(map[string]string, len(tableConfig.ColumnTransforms))
+		for name, expr := range tableConfig.ColumnTransforms {
+			if err := checkColumn(name); err != nil {
+				return errors.Trace(err)
+			}
+			tableDiff.ColumnTransforms[strings.ToLower(name)] = expr
+		}
+	}
+	for _, rule := range tableConfig.ColumnMappings {
+		if err := checkColumn(rule.TargetColumn); err != nil {
+			return errors.Trace(err)
+		}
+		if rule.Expression != column.AddPrefix && rule.Expression != column.AddSuffix && rule.Expression != column.PartitionID {
+			return errors.NotSupportedf("column mapping expression %s", rule.Expression)
+		}
+		if len(rule.PatternSchema) == 0 {
+			rule.PatternSchema = "*"
+		}
+		// DM only applies one rule to a table, so each rule is a mapping.
+		mapping, err := column.NewMapping(false, []*column.Rule{rule})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tableDiff.ColumnMappings = append(tableDiff.ColumnMappings, mapping)
+	}
+	return nil
+}
+
+// getColumnExprs returns the sql expressions of the transformed columns of the upstream table,
+// the key is the lower case column name.
+func getColumnExprs(tableDiff *common.TableDiff, originSchema, originTable string) (map[string]string, error) {
+	if !tableDiff.HasColumnTransforms() {
+		return nil, nil
+	}
+	exprs := make(map[string]string, len(tableDiff.ColumnTransforms))
+	for name, expr := range tableDiff.ColumnTransforms {
+		exprs[name] = expr
+	}
+	schemaL, tableL := strings.ToLower(originSchema), strings.ToLower(originTable)
+	for _, mapping := range tableDiff.ColumnMappings {
+		rules := mapping.Match(schemaL, tableL)
+		if len(rules) == 0 {
+			continue
+		}
+		rule, ok := rules[0].(*column.Rule)
+		if !ok {
+			return nil, errors.NotValidf("column mapping rule %+v", rules[0])
+		}
+		name := strings.ToLower(rule.TargetColumn)
+		expr, ok := exprs[name]
+		if !ok {
+			expr = dbutil.ColumnName(rule.TargetColumn)
+		}
+		switch rule.Expression {
+		case column.AddPrefix:
+			expr = fmt.Sprintf("CONCAT(%s, %s)", quoteString(rule.Arguments[0]), expr)
+		case column.AddSuffix:
+			expr = fmt.Sprintf("CONCAT(%s, %s)", expr, quoteString(rule.Arguments[0]))
+		case column.PartitionID:
+			// the partition id is the origin id OR the ids of the instance, schema and table.
+			vals, _, err := mapping.HandleRowValue(originSchema, originTable, []string{rule.TargetColumn}, []interface{}{int64(0)})
+			if err != nil {
+				return nil, errors.Annotatef(err, "compute partition id for %s", dbutil.TableName(originSchema, originTable))
+			}
+			expr = fmt.Sprintf("(%s | %d)", expr, vals[0])
+		}
+		exprs[name] = expr
+	}
+	return exprs, nil
+}
+
+// transformUpstream makes the source apply the column transforms and the upstream exclusions of the tables.
+func transformUpstream(s Source) error {
+	switch s := s.(type) {
+	case *TiDBSource:
+		s.upstream = true
+	case *MySQLSources:
+		s.upstream = true
+	default:
+		for _, table := range s.GetTables() {
+			if table.HasColumnTransforms() {
+				return errors.Errorf("the source doesn't support column transforms of table %s", dbutil.TableName(table.Schema, table.Table))
+			}
+			if len(table.Exclude) != 0 || len(table.UpstreamExclude) != 0 {
+				return errors.Errorf("the source doesn't support excluding the rows of table %s", dbutil.TableName(table.Schema, table.Table))
+			}
+		}
+	}
+	return nil
+}
+
+func quoteString(s string) string {
+	return fmt.Sprintf("'%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s))
+}
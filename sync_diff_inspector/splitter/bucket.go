@@ -87,6 +87,11 @@ func (s *BucketIterator) GetIndexID() int64 {
 	return s.indexID
 }
 
+// GetChunkSize returns the chunk size used to split the buckets.
+func (s *BucketIterator) GetChunkSize() int64 {
+	return s.chunkSize
+}
+
 func (s *BucketIterator) Next() (*chunk.Range, error) {
 	var ok bool
 	if uint(len(s.chunks)) <= s.nextChunk {
@@ -182,7 +187,7 @@ func (s *BucketIterator) Close() {
 
 func (s *BucketIterator) splitChunkForBucket(ctx context.Context, firstBucketID, lastBucketID int, beginIndex int, bucketChunkCnt int, splitChunkCnt int, chunkRange *chunk.Range) {
 	s.chunkPool.Apply(func() {
-		chunks, err := splitRangeByRandom(s.dbConn, chunkRange, splitChunkCnt, s.table.Schema, s.table.Table, s.indexColumns, s.table.Range, s.table.Collation)
+		chunks, err := splitRangeByRandom(s.dbConn, chunkRange, splitChunkCnt, s.table.Schema, s.table.Table, s.indexColumns, common.ExcludeRows(s.table.Range, s.table.SplitExclude), s.table.Collation)
 		if err != nil {
 			select {
 			case <-ctx.Done():
@@ -33,6 +33,7 @@ import (
 
 type RandomIterator struct {
 	table     *common.TableDiff
+	fields    []*model.ColumnInfo
 	chunkSize int64
 	chunks    []*chunk.Range
 	nextChunk uint
@@ -87,7 +88,7 @@ func NewRandomIteratorWithCheckpoint(ctx context.Context, progressID string, tab
 		// For chunk splitted by random splitter, the checkpoint chunk records the tableCnt.
 		chunkCnt = bucketChunkCnt - beginIndex
 	} else {
-		cnt, err := dbutil.GetRowCount(ctx, dbConn, table.Schema, table.Table, table.Range, nil)
+		cnt, err := dbutil.GetRowCount(ctx, dbConn, table.Schema, table.Table, common.ExcludeRows(table.Range, table.SplitExclude), nil)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -115,7 +116,7 @@ func NewRandomIteratorWithCheckpoint(ctx context.Context, progressID string, tab
 		bucketChunkCnt = chunkCnt
 	}
 
-	chunks, err := splitRangeByRandom(dbConn, chunkRange, chunkCnt, table.Schema, table.Table, fields, table.Range, table.Collation)
+	chunks, err := splitRangeByRandom(dbConn, chunkRange, chunkCnt, table.Schema, table.Table, fields, common.ExcludeRows(table.Range, table.SplitExclude), table.Collation)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -132,6 +133,7 @@ func NewRandomIteratorWithCheckpoint(ctx context.Context, progressID string, tab
 	progress.StartTable(progressID, len(chunks), true)
 	return &RandomIterator{
 		table:     table,
+		fields:    fields,
 		chunkSize: chunkSize,
 		chunks:    chunks,
 		nextChunk: 0,
@@ -158,6 +160,16 @@ func (s *RandomIterator) Next() (*chunk.Range, error) {
 	return c, nil
 }
 
+// GetSplitFields returns the columns used to split the chunks.
+func (s *RandomIterator) GetSplitFields() []*model.ColumnInfo {
+	return s.fields
+}
+
+// GetChunkSize returns the chunk size, it's 0 if the iterator is recovered from the checkpoint.
+func (s *RandomIterator) GetChunkSize() int64 {
+	return s.chunkSize
+}
+
 func (s *RandomIterator) Close() {
 
 }
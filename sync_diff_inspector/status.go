@@ -0,0 +1,128 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/metrics"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/report"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var registerMetricsOnce sync.Once
+
+// checkStatus is the current status of the check, served by `/status`.
+type checkStatus struct {
+	sync.RWMutex
+	StartTime    time.Time      `json:"start-time"`
+	CurrentTable string         `json:"current-table,omitempty"`
+	CurrentChunk *chunk.ChunkID `json:"current-chunk,omitempty"`
+	// CheckedChunks and FailedChunks are the numbers of the chunks compared in this run.
+	CheckedChunks int64 `json:"checked-chunks"`
+	FailedChunks  int64 `json:"failed-chunks"`
+	// Checkpoint is the latest state saved by handleCheckpoints.
+	Checkpoint *checkpoints.SavedState `json:"checkpoint,omitempty"`
+}
+
+// setCurrentChunk records the chunk which is going to be compared.
+func (s *checkStatus) setCurrentChunk(rangeInfo *splitter.RangeInfo) {
+	s.Lock()
+	defer s.Unlock()
+	s.CurrentTable = rangeInfo.ProgressID
+	s.CurrentChunk = rangeInfo.ChunkRange.Index
+}
+
+// finishChunk records the chunk has been compared.
+func (s *checkStatus) finishChunk(isEqual bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.CheckedChunks++
+	if !isEqual {
+		s.FailedChunks++
+	}
+}
+
+// setCheckpoint records the checkpoint saved by handleCheckpoints.
+func (s *checkStatus) setCheckpoint(node *checkpoints.Node, r *report.Report) {
+	s.Lock()
+	defer s.Unlock()
+	s.Checkpoint = &checkpoints.SavedState{
+		Chunk:  node,
+		Report: r,
+	}
+}
+
+func (s *checkStatus) marshal() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	data, err := json.Marshal(s)
+	return data, errors.Trace(err)
+}
+
+func (s *checkStatus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	data, err := s.marshal()
+	if err != nil {
+		log.Warn("fail to marshal the status", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// startStatusServer serves the prometheus metrics on `/metrics` and the status on `/status`.
+func (df *Diff) startStatusServer(addr string) error {
+	registerMetricsOnce.Do(func() {
+		metrics.RegisterMetrics(prometheus.DefaultRegisterer)
+	})
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Annotatef(err, "fail to listen on the status address %s", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/status", df.status)
+	df.statusServer = &http.Server{Handler: mux}
+	go func() {
+		log.Info("start status server", zap.String("address", addr))
+		if err := df.statusServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Warn("status server exits", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (df *Diff) stopStatusServer() {
+	if df.statusServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := df.statusServer.Shutdown(ctx); err != nil {
+		log.Warn("fail to shutdown the status server", zap.Error(err))
+	}
+}
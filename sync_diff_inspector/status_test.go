@@ -0,0 +1,73 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/checkpoints"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/metrics"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStatus(t *testing.T) {
+	ctx := context.Background()
+	tables := []*common.TableDiff{{Schema: "test", Table: "t", Range: "TRUE"}}
+	df, downstream := newRecheckDiff(tables, []int64{1})
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	downstream.db = db
+	df.repair = true
+	compared, failed := testutil.ToFloat64(metrics.ChunksCompared), testutil.ToFloat64(metrics.ChunksFailed)
+
+	// the first chunk is equal, the second one is different, and the third one is equal after repairing.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM `test`.`t`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	for i, result := range []*chunkResult{
+		{Equal: true},
+		{Equal: false},
+		{Equal: false, RowsCompared: true, SQLs: []string{"DELETE FROM `test`.`t` WHERE `id` = 1;"}, RowsDelete: 1},
+	} {
+		rangeInfo := failedRange(newChunkNode(0, i), tables[0])
+		df.status.setCurrentChunk(rangeInfo)
+		df.status.finishChunk(df.finishChunk(ctx, rangeInfo, result))
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+	// the repaired chunk is compared but not failed.
+	require.Equal(t, compared+3, testutil.ToFloat64(metrics.ChunksCompared))
+	require.Equal(t, failed+1, testutil.ToFloat64(metrics.ChunksFailed))
+	df.status.setCheckpoint(newChunkNode(0, 2), df.report)
+
+	w := httptest.NewRecorder()
+	df.status.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	status := &checkStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+	require.Equal(t, "`test`.`t`", status.CurrentTable)
+	require.Equal(t, 2, status.CurrentChunk.ChunkIndex)
+	require.Equal(t, int64(3), status.CheckedChunks)
+	require.Equal(t, int64(1), status.FailedChunks)
+	require.Equal(t, 2, status.Checkpoint.Chunk.GetChunkIndex())
+	require.Equal(t, checkpoints.FailedState, status.Checkpoint.Chunk.GetState())
+}
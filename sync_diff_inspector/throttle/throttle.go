@@ -0,0 +1,362 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/metrics"
+	"github.com/pingcap/tidb/errno"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const defaultCheckInterval = 5 * time.Second
+
+// Source is an instance watched by the throttler.
+type Source struct {
+	Name string
+	DB   *sql.DB
+
+	// noReplicaStatus is true if the instance doesn't support `SHOW SLAVE STATUS`, e.g. TiDB,
+	// or the user isn't allowed to run it.
+	noReplicaStatus bool
+}
+
+// Options are the thresholds of the throttler, the thresholds are ignored if they are 0.
+type Options struct {
+	MaxConcurrency    int
+	MaxThreadsRunning int64
+	MaxReplicationLag time.Duration
+	MaxLatency        time.Duration
+	MaxRowsPerSecond  int64
+	MaxBytesPerSecond int64
+	CheckInterval     time.Duration
+}
+
+// Throttler limits the concurrency of the check by the load of the instances. It halves the concurrency
+// when some threshold is exceeded and pauses the check if the concurrency is 1 already, then increases
+// the concurrency by 1 in each interval after the instances recover.
+type Throttler struct {
+	opts    Options
+	sources []*Source
+
+	mu sync.Mutex
+	// limit is the current concurrency, 0 means paused.
+	limit   int
+	running int
+	// changed is closed when the limit or running changes.
+	changed chan struct{}
+	// latency is the max latency of the checksum statements of each source since the last check.
+	latency map[string]time.Duration
+
+	rowLimiter  *rate.Limiter
+	byteLimiter *rate.Limiter
+}
+
+// NewThrottler returns a throttler watching the source and target instances of the config,
+// it returns nil if the throttle isn't configured.
+func NewThrottler(cfg *config.Config) (*Throttler, error) {
+	throttleCfg := cfg.Throttle
+	if throttleCfg == nil {
+		return nil, nil
+	}
+	opts := Options{
+		MaxConcurrency:    cfg.CheckThreadCount,
+		MaxThreadsRunning: throttleCfg.MaxThreadsRunning,
+		MaxRowsPerSecond:  throttleCfg.MaxRowsPerSecond,
+		MaxBytesPerSecond: throttleCfg.MaxBytesPerSecond,
+		CheckInterval:     defaultCheckInterval,
+	}
+	var err error
+	for _, d := range []struct {
+		value    string
+		duration *time.Duration
+	}{
+		{throttleCfg.MaxReplicationLag, &opts.MaxReplicationLag},
+		{throttleCfg.MaxChecksumLatency, &opts.MaxLatency},
+		{throttleCfg.CheckInterval, &opts.CheckInterval},
+	} {
+		if len(d.value) == 0 {
+			continue
+		}
+		if *d.duration, err = time.ParseDuration(d.value); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	sources := make([]*Source, 0, len(cfg.Task.SourceInstances)+1)
+	for i, source := range cfg.Task.SourceInstances {
+		if source.Conn != nil {
+			sources = append(sources, &Source{Name: cfg.Task.Source[i], DB: source.Conn})
+		}
+	}
+	if cfg.Task.TargetInstance != nil && cfg.Task.TargetInstance.Conn != nil {
+		sources = append(sources, &Source{Name: cfg.Task.Target, DB: cfg.Task.TargetInstance.Conn})
+	}
+	return newThrottler(opts, sources), nil
+}
+
+func newThrottler(opts Options, sources []*Source) *Throttler {
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 1
+	}
+	t := &Throttler{
+		opts:    opts,
+		sources: sources,
+		limit:   opts.MaxConcurrency,
+		changed: make(chan struct{}),
+		latency: make(map[string]time.Duration),
+	}
+	if opts.MaxRowsPerSecond > 0 {
+		t.rowLimiter = rate.NewLimiter(rate.Limit(opts.MaxRowsPerSecond), int(opts.MaxRowsPerSecond))
+	}
+	if opts.MaxBytesPerSecond > 0 {
+		t.byteLimiter = rate.NewLimiter(rate.Limit(opts.MaxBytesPerSecond), int(opts.MaxBytesPerSecond))
+	}
+	metrics.ThrottleConcurrency.Set(float64(t.limit))
+	return t
+}
+
+// Run checks the thresholds periodically until the context is done.
+func (t *Throttler) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.adjust(t.check(ctx))
+		}
+	}
+}
+
+// Acquire waits until the concurrency allows another chunk to be checked.
+func (t *Throttler) Acquire(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		if t.running < t.limit {
+			t.running++
+			t.mu.Unlock()
+			return nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// Release releases the concurrency acquired by Acquire.
+func (t *Throttler) Release() {
+	t.mu.Lock()
+	t.running--
+	t.notify()
+	t.mu.Unlock()
+}
+
+// Concurrency returns the current concurrency, 0 means paused.
+func (t *Throttler) Concurrency() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// ObserveLatency records the latency of a checksum statement on the source.
+func (t *Throttler) ObserveLatency(source string, latency time.Duration) {
+	t.mu.Lock()
+	if latency > t.latency[source] {
+		t.latency[source] = latency
+	}
+	t.mu.Unlock()
+}
+
+// LimitsBytes returns true if the bytes checked per second is limited.
+func (t *Throttler) LimitsBytes() bool {
+	return t.byteLimiter != nil
+}
+
+// WaitRows waits until the rows and bytes are allowed by the max rows and bytes per second.
+func (t *Throttler) WaitRows(ctx context.Context, rows, bytes int64) error {
+	if err := waitN(ctx, t.rowLimiter, rows); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(waitN(ctx, t.byteLimiter, bytes))
+}
+
+// waitN waits for n tokens, which may be more than the burst of the limiter.
+func waitN(ctx context.Context, limiter *rate.Limiter, n int64) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		batch := int64(limiter.Burst())
+		if n < batch {
+			batch = n
+		}
+		if err := limiter.WaitN(ctx, int(batch)); err != nil {
+			return err
+		}
+		n -= batch
+	}
+	return nil
+}
+
+// check returns the reason if some threshold is exceeded.
+func (t *Throttler) check(ctx context.Context) string {
+	reason := ""
+	for _, source := range t.sources {
+		if t.opts.MaxThreadsRunning > 0 {
+			threadsRunning, err := getThreadsRunning(ctx, source.DB)
+			if err != nil {
+				log.Warn("fail to get Threads_running", zap.String("instance", source.Name), zap.Error(err))
+			} else if threadsRunning > t.opts.MaxThreadsRunning {
+				reason = fmt.Sprintf("Threads_running of %s is %d", source.Name, threadsRunning)
+			}
+		}
+		if t.opts.MaxReplicationLag > 0 && !source.noReplicaStatus {
+			lag, err := getReplicationLag(ctx, source.DB)
+			if err != nil && isReplicaStatusUnsupported(err) {
+				log.Warn("the instance doesn't support the replica status, ignore the lag of the instance", zap.String("instance", source.Name), zap.Error(err))
+				source.noReplicaStatus = true
+			} else if err != nil {
+				log.Warn("fail to get the replication lag, retry in the next check", zap.String("instance", source.Name), zap.Error(err))
+			} else if lag > t.opts.MaxReplicationLag {
+				reason = fmt.Sprintf("the replication lag of %s is %s", source.Name, lag)
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.opts.MaxLatency > 0 {
+		for source, latency := range t.latency {
+			if latency > t.opts.MaxLatency {
+				reason = fmt.Sprintf("the latency of the checksum on %s is %s", source, latency)
+			}
+		}
+	}
+	t.latency = make(map[string]time.Duration)
+	return reason
+}
+
+// adjust halves the concurrency or pauses the check if the reason isn't empty, otherwise increases the concurrency.
+func (t *Throttler) adjust(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit := t.limit
+	if len(reason) != 0 {
+		limit /= 2
+	} else if limit < t.opts.MaxConcurrency {
+		limit++
+	}
+	if limit == t.limit {
+		return
+	}
+	if len(reason) != 0 {
+		log.Warn("the instances are overloaded, reduce the concurrency of the check",
+			zap.String("reason", reason), zap.Int("concurrency", limit))
+	} else {
+		log.Info("the instances recover, increase the concurrency of the check", zap.Int("concurrency", limit))
+	}
+	t.limit = limit
+	metrics.ThrottleConcurrency.Set(float64(limit))
+	t.notify()
+}
+
+// notify wakes up the goroutines waiting in Acquire, it should be called with the lock.
+func (t *Throttler) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// getThreadsRunning returns the Threads_running of the instance, it returns 0 if the instance doesn't have it.
+func getThreadsRunning(ctx context.Context, db *sql.DB) (int64, error) {
+	var name, value string
+	err := db.QueryRowContext(ctx, "SHOW GLOBAL STATUS LIKE 'Threads_running'").Scan(&name, &value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	threadsRunning, err := strconv.ParseInt(value, 10, 64)
+	return threadsRunning, errors.Trace(err)
+}
+
+// getReplicationLag returns the Seconds_Behind_Master of the instance, it returns 0 if the instance isn't a replica.
+// isReplicaStatusUnsupported returns true if the instance can never show the replica status, such as TiDB
+// or the user without the REPLICATION CLIENT privilege, the other errors may be transient.
+func isReplicaStatusUnsupported(err error) bool {
+	mysqlErr, ok := errors.Cause(err).(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch mysqlErr.Number {
+	case errno.ErrParse, errno.ErrNotSupportedYet,
+		errno.ErrSpecificAccessDenied, errno.ErrDBaccessDenied, errno.ErrTableaccessDenied:
+		return true
+	}
+	return false
+}
+
+func getReplicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var lag time.Duration
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return 0, errors.Trace(err)
+		}
+		for i, column := range columns {
+			// the value is NULL if the replication is stopped, the lag is unknown then.
+			if column != "Seconds_Behind_Master" || values[i] == nil {
+				continue
+			}
+			seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			if channelLag := time.Duration(seconds) * time.Second; channelLag > lag {
+				lag = channelLag
+			}
+		}
+	}
+	return lag, errors.Trace(rows.Err())
+}
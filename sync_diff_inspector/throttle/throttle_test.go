@@ -0,0 +1,129 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/errno"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdjustConcurrency(t *testing.T) {
+	throttler := newThrottler(Options{MaxConcurrency: 4}, nil)
+	require.Equal(t, 4, throttler.Concurrency())
+	throttler.adjust("overloaded")
+	require.Equal(t, 2, throttler.Concurrency())
+	throttler.adjust("overloaded")
+	require.Equal(t, 1, throttler.Concurrency())
+
+	ctx := context.Background()
+	require.NoError(t, throttler.Acquire(ctx))
+	// paused
+	throttler.adjust("overloaded")
+	require.Equal(t, 0, throttler.Concurrency())
+	throttler.Release()
+	acquired := make(chan error)
+	go func() { acquired <- throttler.Acquire(ctx) }()
+	select {
+	case <-acquired:
+		require.FailNow(t, "acquire the concurrency while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// recover
+	throttler.adjust("")
+	require.NoError(t, <-acquired)
+	require.Equal(t, 1, throttler.Concurrency())
+	for i := 0; i < 5; i++ {
+		throttler.adjust("")
+	}
+	require.Equal(t, 4, throttler.Concurrency())
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	for i := 1; i < 4; i++ {
+		require.NoError(t, throttler.Acquire(cancelCtx))
+	}
+	cancel()
+	require.True(t, errors.Cause(throttler.Acquire(cancelCtx)) == context.Canceled)
+}
+
+func TestCheckThresholds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	throttler := newThrottler(Options{
+		MaxConcurrency:    4,
+		MaxThreadsRunning: 10,
+		MaxReplicationLag: 10 * time.Second,
+		MaxLatency:        time.Second,
+	}, []*Source{{Name: "mysql1", DB: db}})
+	ctx := context.Background()
+
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("Threads_running", "5"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("", "3"))
+	throttler.ObserveLatency("upstream", 100*time.Millisecond)
+	require.Empty(t, throttler.check(ctx))
+
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("Threads_running", "20"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("", nil))
+	require.Equal(t, "Threads_running of mysql1 is 20", throttler.check(ctx))
+
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("", "30"))
+	require.Equal(t, "the replication lag of mysql1 is 30s", throttler.check(ctx))
+
+	// the latency is reset after each check
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("Threads_running", "1"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnError(errors.New("read: i/o timeout"))
+	throttler.ObserveLatency("downstream", 2*time.Second)
+	require.Equal(t, "the latency of the checksum on downstream is 2s", throttler.check(ctx))
+
+	// the transient errors don't stop checking the replication lag
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("Threads_running", "1"))
+	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnError(&mysql.MySQLError{Number: errno.ErrSpecificAccessDenied, Message: "Access denied; you need (at least one of) the SUPER, REPLICATION CLIENT privilege(s) for this operation"})
+	require.Empty(t, throttler.check(ctx))
+
+	// the replication lag isn't checked if the instance doesn't support it
+	mock.ExpectQuery("SHOW GLOBAL STATUS LIKE 'Threads_running'").WillReturnRows(
+		sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("Threads_running", "1"))
+	require.Empty(t, throttler.check(ctx))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWaitRows(t *testing.T) {
+	throttler := newThrottler(Options{MaxConcurrency: 1, MaxRowsPerSecond: 100}, nil)
+	require.False(t, throttler.LimitsBytes())
+	ctx := context.Background()
+	begin := time.Now()
+	// the burst is consumed immediately, then wait for the other 50 rows.
+	require.NoError(t, throttler.WaitRows(ctx, 150, 1000))
+	require.GreaterOrEqual(t, time.Since(begin), 400*time.Millisecond)
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(t, throttler.WaitRows(cancelCtx, 1000, 0))
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"go.uber.org/zap"
+)
+
+// the algorithms to calculate the checksum of the rows.
+const (
+	// ChecksumCRC32 xors the crc32 of `CONCAT_WS(',', col1, col2, ...)` of the rows, it's the default algorithm.
+	// It's the fastest, but the rows appearing twice cancel each other out, and the values containing
+	// the separator may collide, such as ('a,b', 'c') and ('a', 'b,c').
+	ChecksumCRC32 = "crc32"
+	// ChecksumHash64 encodes each column with its length, such as `3:abc`, and sums the first 64 bits
+	// of the md5 of the encoded rows modulo 2^64.
+	ChecksumHash64 = "hash64"
+	// ChecksumInProcess reads the rows and calculates the same checksum as ChecksumHash64 in process,
+	// it's for the sources whose SQL dialect doesn't support the functions used by the other algorithms.
+	ChecksumInProcess = "in-process"
+)
+
+// hash64Modulus is 2^64, the sum of the 64-bit hashes is taken modulo it to fit in 64 bits.
+const hash64Modulus = "18446744073709551616"
+
+// checksumColumnName returns the name of the column in the checksum expression,
+// the float and double values are rounded to avoid the precision issue.
+func checksumColumnName(col *model.ColumnInfo) string {
+	name := dbutil.ColumnName(col.Name.O)
+	// When col value is 0, the result is NULL.
+	// But we can use ISNULL to distinguish between null and 0.
+	if col.FieldType.Tp == mysql.TypeFloat {
+		name = fmt.Sprintf("round(%s, 5-floor(log10(abs(%s))))", name, name)
+	} else if col.FieldType.Tp == mysql.TypeDouble {
+		name = fmt.Sprintf("round(%s, 14-floor(log10(abs(%s))))", name, name)
+	}
+	return name
+}
+
+// hash64ChecksumExpr returns the expression of the hash64 checksum of the rows.
+func hash64ChecksumExpr(tbInfo *model.TableInfo, tolerances map[string]*Tolerance) string {
+	columns := make([]string, 0, len(tbInfo.Columns))
+	for _, col := range tbInfo.Columns {
+		raw := dbutil.ColumnName(col.Name.O)
+		name := checksumColumnName(col)
+		// the values with tolerance are compared by rows, only check whether they are NULL.
+		if _, ok := tolerances[col.Name.O]; ok {
+			columns = append(columns, fmt.Sprintf("IF(ISNULL(%s), '-', '+')", raw))
+			continue
+		}
+		if name != raw {
+			// the rounded float is NULL when the value is 0, so NULL is told by the column itself.
+			columns = append(columns, fmt.Sprintf("IF(ISNULL(%s), '-', IFNULL(CONCAT(LENGTH(%s), ':', %s), '1:0'))", raw, name, name))
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("IFNULL(CONCAT(LENGTH(%s), ':', %s), '-')", name, name))
+	}
+	rowHash := fmt.Sprintf("CAST(CONV(LEFT(MD5(CONCAT(%s)), 16), 16, 10) AS UNSIGNED)", strings.Join(columns, ", "))
+	return fmt.Sprintf("CAST(SUM(%s) %% %s AS CHAR)", rowHash, hash64Modulus)
+}
+
+// checksumExpr returns the expression of the checksum of the rows by the algorithm.
+func checksumExpr(algorithm string, tbInfo *model.TableInfo, tolerances map[string]*Tolerance) string {
+	if algorithm == ChecksumHash64 {
+		return hash64ChecksumExpr(tbInfo, tolerances)
+	}
+	return crc32ChecksumExpr(tbInfo, tolerances)
+}
+
+// scanChecksum converts the checksum returned by the checksum expression of the algorithm to int64.
+func scanChecksum(algorithm string, checksum sql.NullString) (int64, error) {
+	if !checksum.Valid {
+		return 0, nil
+	}
+	if algorithm == ChecksumHash64 {
+		value, err := strconv.ParseUint(checksum.String, 10, 64)
+		return int64(value), errors.Trace(err)
+	}
+	value, err := strconv.ParseInt(checksum.String, 10, 64)
+	return value, errors.Trace(err)
+}
+
+// CombineChecksum returns the checksum of the union of two disjoint sets of the rows by the algorithm.
+func CombineChecksum(algorithm string, checksum1, checksum2 int64) int64 {
+	switch algorithm {
+	case ChecksumHash64, ChecksumInProcess:
+		// overflow wraps around, which is the sum modulo 2^64.
+		return checksum1 + checksum2
+	default:
+		return checksum1 ^ checksum2
+	}
+}
+
+// RowHash64 returns the 64-bit hash of the row, it's the same as the hash of the row in the expression of
+// ChecksumHash64, the checksum of the rows is the sum of the hashes.
+func RowHash64(row map[string]*dbutil.ColumnData, tbInfo *model.TableInfo, tolerances map[string]*Tolerance) uint64 {
+	var encoded strings.Builder
+	for _, col := range tbInfo.Columns {
+		data := row[col.Name.O]
+		isNull := data == nil || data.IsNull
+		if _, hasTolerance := tolerances[col.Name.O]; hasTolerance {
+			if isNull {
+				encoded.WriteString("-")
+			} else {
+				encoded.WriteString("+")
+			}
+			continue
+		}
+		if isNull {
+			encoded.WriteString("-")
+			continue
+		}
+		value, ok := string(data.Data), true
+		switch col.FieldType.Tp {
+		case mysql.TypeFloat:
+			value, ok = RoundFloatString(value, 5)
+		case mysql.TypeDouble:
+			value, ok = RoundFloatString(value, 14)
+		}
+		if !ok {
+			// the rounded float is NULL when the value is 0.
+			value = "0"
+		}
+		fmt.Fprintf(&encoded, "%d:%s", len(value), value)
+	}
+	sum := md5.Sum([]byte(encoded.String()))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// RoundFloatString emulates `round(x, digits-floor(log10(abs(x))))`, which is NULL when x is 0.
+func RoundFloatString(value string, digits int) (string, bool) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, true
+	}
+	if f == 0 {
+		return "", false
+	}
+	exp := digits - int(math.Floor(math.Log10(math.Abs(f))))
+	if exp < 0 {
+		pow := math.Pow10(-exp)
+		f = math.Round(f/pow) * pow
+	} else {
+		pow := math.Pow10(exp)
+		f = math.Round(f*pow) / pow
+	}
+	abs := math.Abs(f)
+	if abs >= 1e15 || abs < 1e-15 {
+		return strings.Replace(strconv.FormatFloat(f, 'e', -1, 64), "e+", "e", 1), true
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// getInProcessCountAndChecksum reads the rows by the given condition and calculates the count
+// and the checksum of them in process.
+func getInProcessCountAndChecksum(ctx context.Context, db *sql.DB, schemaName, tableName string, tbInfo *model.TableInfo, columnExprs map[string]string, tolerances map[string]*Tolerance, limitRange string, args []interface{}) (int64, int64, error) {
+	columnNames := make([]string, 0, len(tbInfo.Columns))
+	for _, col := range tbInfo.Columns {
+		columnNames = append(columnNames, dbutil.ColumnName(col.Name.O))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s;",
+		strings.Join(columnNames, ", "), transformedTableName(schemaName, tableName, tbInfo, columnExprs), limitRange)
+	log.Debug("count and checksum in process", zap.String("sql", query), zap.Reflect("args", args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Warn("execute rows query fail", zap.String("query", query), zap.Reflect("args", args), zap.Error(err))
+		return -1, -1, errors.Trace(err)
+	}
+	defer rows.Close()
+	var (
+		count    int64
+		checksum uint64
+	)
+	for rows.Next() {
+		row, err := dbutil.ScanRow(rows)
+		if err != nil {
+			return -1, -1, errors.Trace(err)
+		}
+		count++
+		checksum += RowHash64(row, tbInfo, tolerances)
+	}
+	if err = rows.Err(); err != nil {
+		return -1, -1, errors.Trace(err)
+	}
+	return count, int64(checksum), nil
+}
+
+// TableChecksum is the result of `ADMIN CHECKSUM TABLE` of TiDB, which xors the crc64 of all the key-values of the
+// table and its indices. The keys contain the physical IDs of the table, so the checksums of two tables are
+// comparable only if their IDs are the same. BR and TiCDC usually create the tables with new IDs.
+type TableChecksum struct {
+	Checksum   uint64
+	TotalKvs   uint64
+	TotalBytes uint64
+}
+
+// GetTablePhysicalIDs returns the ID of the TiDB table and the IDs of its partitions.
+func GetTablePhysicalIDs(ctx context.Context, db *sql.DB, schemaName, tableName string) ([]int64, error) {
+	query := "SELECT TIDB_TABLE_ID FROM information_schema.tables WHERE table_schema = ? AND table_name = ? " +
+		"UNION ALL SELECT TIDB_PARTITION_ID FROM information_schema.partitions WHERE table_schema = ? AND table_name = ? AND TIDB_PARTITION_ID IS NOT NULL;"
+	rows, err := db.QueryContext(ctx, query, schemaName, tableName, schemaName, tableName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	ids := make([]int64, 0, 1)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(ids) == 0 {
+		return nil, errors.NotFoundf("table %s", dbutil.TableName(schemaName, tableName))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// GetTableChecksum returns the result of `ADMIN CHECKSUM TABLE` of the TiDB table, it reads the latest data.
+func GetTableChecksum(ctx context.Context, db *sql.DB, schemaName, tableName string) (*TableChecksum, error) {
+	query := fmt.Sprintf("ADMIN CHECKSUM TABLE %s;", dbutil.TableName(schemaName, tableName))
+	log.Debug("table checksum", zap.String("sql", query))
+	var (
+		dbName, table string
+		checksum      TableChecksum
+	)
+	err := db.QueryRowContext(ctx, query).Scan(&dbName, &table, &checksum.Checksum, &checksum.TotalKvs, &checksum.TotalBytes)
+	if err != nil {
+		log.Warn("execute table checksum fail", zap.String("query", query), zap.Error(err))
+		return nil, errors.Trace(err)
+	}
+	return &checksum, nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser/model"
+)
+
+const (
+	multisetUpstream byte = iota
+	multisetDownstream
+
+	multisetNull  byte = 'N'
+	multisetValue byte = 'V'
+)
+
+// IsKeyless returns true if the table has neither the primary key nor any unique key,
+// so the rows can only be identified by all the columns.
+func IsKeyless(table *model.TableInfo) bool {
+	for _, index := range table.Indices {
+		if index.Primary || index.Unique {
+			return false
+		}
+	}
+	return true
+}
+
+// MultisetDiff is a row whose count of the identical rows is different between the upstream and the downstream.
+type MultisetDiff struct {
+	Row             map[string]*dbutil.ColumnData
+	UpstreamCount   int64
+	DownstreamCount int64
+}
+
+// multisetCount is the count of a row in both sides.
+type multisetCount struct {
+	upstream   int64
+	downstream int64
+}
+
+// CompareRowMultisets compares the rows returned by nextUpstream and nextDownstream as multisets, the row iterators
+// return nil at the end. The rows are encoded by all the columns and counted, if partitions is greater than 1,
+// they are spilled to the partition files in dir by their hashes first, so only the rows of one partition are
+// in memory at a time. onDiff is called for every row with different counts, ordered by the encoded rows in each partition.
+func CompareRowMultisets(dir string, partitions int, columns []*model.ColumnInfo,
+	nextUpstream, nextDownstream func() (map[string]*dbutil.ColumnData, error), onDiff func(*MultisetDiff) error) error {
+	if partitions <= 1 {
+		counts := make(map[string]*multisetCount)
+		for side, next := range [...]func() (map[string]*dbutil.ColumnData, error){nextUpstream, nextDownstream} {
+			for {
+				row, err := next()
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if row == nil {
+					break
+				}
+				addMultisetRow(counts, encodeMultisetRow(row, columns), byte(side))
+			}
+		}
+		return errors.Trace(diffMultisetCounts(counts, columns, onDiff))
+	}
+
+	files := make([]*os.File, partitions)
+	defer func() {
+		for _, file := range files {
+			if file != nil {
+				file.Close()
+				os.Remove(file.Name())
+			}
+		}
+	}()
+	writers := make([]*bufio.Writer, partitions)
+	for i := range files {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("multiset-%d", i)))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		files[i], writers[i] = file, bufio.NewWriter(file)
+	}
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for side, next := range [...]func() (map[string]*dbutil.ColumnData, error){nextUpstream, nextDownstream} {
+		for {
+			row, err := next()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if row == nil {
+				break
+			}
+			key := encodeMultisetRow(row, columns)
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			w := writers[h.Sum64()%uint64(partitions)]
+			w.WriteByte(byte(side))
+			w.Write(lenBuf[:binary.PutUvarint(lenBuf, uint64(len(key)))])
+			if _, err := w.WriteString(key); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	for i, file := range files {
+		if err := writers[i].Flush(); err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return errors.Trace(err)
+		}
+		counts := make(map[string]*multisetCount)
+		r := bufio.NewReader(file)
+		for {
+			side, err := r.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			key := make([]byte, length)
+			if _, err := io.ReadFull(r, key); err != nil {
+				return errors.Trace(err)
+			}
+			addMultisetRow(counts, string(key), side)
+		}
+		if err := diffMultisetCounts(counts, columns, onDiff); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func addMultisetRow(counts map[string]*multisetCount, key string, side byte) {
+	count, ok := counts[key]
+	if !ok {
+		count = &multisetCount{}
+		counts[key] = count
+	}
+	if side == multisetUpstream {
+		count.upstream++
+	} else {
+		count.downstream++
+	}
+}
+
+func diffMultisetCounts(counts map[string]*multisetCount, columns []*model.ColumnInfo, onDiff func(*MultisetDiff) error) error {
+	keys := make([]string, 0, len(counts))
+	for key, count := range counts {
+		if count.upstream != count.downstream {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		row, err := decodeMultisetRow(key, columns)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := onDiff(&MultisetDiff{
+			Row:             row,
+			UpstreamCount:   counts[key].upstream,
+			DownstreamCount: counts[key].downstream,
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// encodeMultisetRow encodes the values of all the columns, every value is encoded as
+// `N` for NULL or `V` followed by the length and the data.
+func encodeMultisetRow(row map[string]*dbutil.ColumnData, columns []*model.ColumnInfo) string {
+	buf := make([]byte, 0, 64)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, col := range columns {
+		data := row[col.Name.O]
+		if data == nil || data.IsNull {
+			buf = append(buf, multisetNull)
+			continue
+		}
+		buf = append(buf, multisetValue)
+		buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf, uint64(len(data.Data)))]...)
+		buf = append(buf, data.Data...)
+	}
+	return string(buf)
+}
+
+func decodeMultisetRow(key string, columns []*model.ColumnInfo) (map[string]*dbutil.ColumnData, error) {
+	row := make(map[string]*dbutil.ColumnData, len(columns))
+	buf := []byte(key)
+	for _, col := range columns {
+		if len(buf) == 0 {
+			return nil, errors.Errorf("the encoded row is too short for column %s", col.Name.O)
+		}
+		flag := buf[0]
+		buf = buf[1:]
+		if flag == multisetNull {
+			row[col.Name.O] = &dbutil.ColumnData{IsNull: true}
+			continue
+		}
+		length, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < length {
+			return nil, errors.Errorf("the encoded row is too short for column %s", col.Name.O)
+		}
+		row[col.Name.O] = &dbutil.ColumnData{Data: buf[n : n+int(length)]}
+		buf = buf[n+int(length):]
+	}
+	return row, nil
+}
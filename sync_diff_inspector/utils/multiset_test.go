@@ -0,0 +1,73 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareRowMultisets(t *testing.T) {
+	tableInfo, err := dbutil.GetTableInfoBySQL("create table `test`.`tbl`(`a` int, `b` varchar(10), key(`a`))", parser.New())
+	require.NoError(t, err)
+	require.True(t, IsKeyless(tableInfo))
+	keyTableInfo, err := dbutil.GetTableInfoBySQL("create table `test`.`tbl`(`a` int primary key, `b` varchar(10))", parser.New())
+	require.NoError(t, err)
+	require.False(t, IsKeyless(keyTableInfo))
+
+	row := func(a, b string) map[string]*dbutil.ColumnData {
+		return map[string]*dbutil.ColumnData{
+			"a": {Data: []byte(a)},
+			"b": {Data: []byte(b), IsNull: b == "NULL"},
+		}
+	}
+	iter := func(rows ...map[string]*dbutil.ColumnData) func() (map[string]*dbutil.ColumnData, error) {
+		return func() (map[string]*dbutil.ColumnData, error) {
+			if len(rows) == 0 {
+				return nil, nil
+			}
+			r := rows[0]
+			rows = rows[1:]
+			return r, nil
+		}
+	}
+	upstream := []map[string]*dbutil.ColumnData{row("1", "x"), row("1", "x"), row("1", "x"), row("2", "NULL"), row("3", ""), row("4", "y")}
+	downstream := []map[string]*dbutil.ColumnData{row("4", "y"), row("1", "x"), row("2", "NULL"), row("2", "NULL"), row("2", "NULL"), row("3", "NULL")}
+
+	for _, partitions := range []int{1, 4} {
+		dir := t.TempDir()
+		diffs := make([]string, 0, 4)
+		err = CompareRowMultisets(dir, partitions, tableInfo.Columns, iter(upstream...), iter(downstream...), func(diff *MultisetDiff) error {
+			b := string(diff.Row["b"].Data)
+			if diff.Row["b"].IsNull {
+				b = "NULL"
+			}
+			diffs = append(diffs, fmt.Sprintf("%s,%q: %d/%d", diff.Row["a"].Data, b, diff.UpstreamCount, diff.DownstreamCount))
+			return nil
+		})
+		require.NoError(t, err)
+		sort.Strings(diffs)
+		require.Equal(t, []string{`1,"x": 3/1`, `2,"NULL": 1/3`, `3,"": 1/0`, `3,"NULL": 0/1`}, diffs)
+		// the partition files are removed.
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser/model"
+)
+
+const (
+	// RowDiffMissing means the row is missing in the downstream.
+	RowDiffMissing = "missing"
+	// RowDiffExtra means the row only exists in the downstream.
+	RowDiffExtra = "extra"
+	// RowDiffChanged means some columns of the row are different.
+	RowDiffChanged = "changed"
+
+	// csvNull is the value of NULL in the csv file.
+	csvNull = `\N`
+)
+
+var rowDiffCSVHeader = []string{"schema", "table", "key", "kind", "column", "upstream", "downstream"}
+
+// ColumnDiff is the upstream and downstream values of a column, the value is nil if it's NULL or the row doesn't exist.
+type ColumnDiff struct {
+	Column     string  `json:"column"`
+	Upstream   *string `json:"upstream"`
+	Downstream *string `json:"downstream"`
+}
+
+// RowDiff is a row which is different between the upstream and the downstream.
+type RowDiff struct {
+	Schema string             `json:"schema"`
+	Table  string             `json:"table"`
+	Key    map[string]*string `json:"key"`
+	Kind   string             `json:"kind"`
+	// Columns are the different columns of the changed row, or all the columns except the key of the missing or extra row.
+	Columns []*ColumnDiff `json:"columns"`
+}
+
+// NewRowDiff returns the difference of the row, the upstream data is nil if the row is extra
+// and the downstream data is nil if the row is missing.
+func NewRowDiff(upstream, downstream map[string]*dbutil.ColumnData, table *model.TableInfo, schema string, keyColumns []*model.ColumnInfo, tolerances map[string]*Tolerance) *RowDiff {
+	rowDiff := &RowDiff{
+		Schema: schema,
+		Table:  table.Name.O,
+		Key:    make(map[string]*string, len(keyColumns)),
+	}
+	keyData := upstream
+	switch {
+	case upstream == nil:
+		rowDiff.Kind = RowDiffExtra
+		keyData = downstream
+	case downstream == nil:
+		rowDiff.Kind = RowDiffMissing
+	default:
+		rowDiff.Kind = RowDiffChanged
+	}
+	isKey := make(map[string]struct{}, len(keyColumns))
+	for _, col := range keyColumns {
+		isKey[col.Name.O] = struct{}{}
+		rowDiff.Key[col.Name.O] = columnValue(keyData[col.Name.O])
+	}
+
+	for _, col := range table.Columns {
+		if _, ok := isKey[col.Name.O]; ok || col.IsGenerated() {
+			continue
+		}
+		columnDiff := &ColumnDiff{Column: col.Name.O}
+		if upstream != nil {
+			columnDiff.Upstream = columnValue(upstream[col.Name.O])
+		}
+		if downstream != nil {
+			columnDiff.Downstream = columnValue(downstream[col.Name.O])
+		}
+		if rowDiff.Kind == RowDiffChanged && columnValueEqual(columnDiff.Upstream, columnDiff.Downstream, tolerances[col.Name.O]) {
+			continue
+		}
+		rowDiff.Columns = append(rowDiff.Columns, columnDiff)
+	}
+	return rowDiff
+}
+
+// KeyCollision is a primary key or unique key of the target table existing in multiple upstream shards.
+type KeyCollision struct {
+	Key map[string]*string `json:"key"`
+	// Shards are the upstream tables having the key, in the format of `instance/schema.table`.
+	Shards []string `json:"shards"`
+}
+
+// NewKeyCollision returns the collision of the key of the row in the shards.
+func NewKeyCollision(row map[string]*dbutil.ColumnData, keyColumns []*model.ColumnInfo, shards ...string) *KeyCollision {
+	key := make(map[string]*string, len(keyColumns))
+	for _, col := range keyColumns {
+		key[col.Name.O] = columnValue(row[col.Name.O])
+	}
+	return &KeyCollision{Key: key, Shards: shards}
+}
+
+func columnValue(data *dbutil.ColumnData) *string {
+	if data == nil || data.IsNull {
+		return nil
+	}
+	value := string(data.Data)
+	return &value
+}
+
+func columnValueEqual(value1, value2 *string, tolerance *Tolerance) bool {
+	if value1 == nil || value2 == nil {
+		return value1 == value2
+	}
+	if *value1 == *value2 {
+		return true
+	}
+	if tolerance == nil {
+		return false
+	}
+	num1, err1 := strconv.ParseFloat(*value1, 64)
+	num2, err2 := strconv.ParseFloat(*value2, 64)
+	return err1 == nil && err2 == nil && tolerance.Equal(num1, num2)
+}
+
+// WriteRowDiffsCSV writes the row diffs in csv with the header, one line for each column in the row diffs,
+// the key is encoded in json and NULL is written as `\N`.
+func WriteRowDiffsCSV(w io.Writer, rowDiffs []*RowDiff) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(rowDiffCSVHeader); err != nil {
+		return errors.Trace(err)
+	}
+	for _, rowDiff := range rowDiffs {
+		key, err := json.Marshal(rowDiff.Key)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		columns := rowDiff.Columns
+		if len(columns) == 0 {
+			// the table only has the key columns.
+			columns = []*ColumnDiff{{}}
+		}
+		for _, column := range columns {
+			record := []string{rowDiff.Schema, rowDiff.Table, string(key), rowDiff.Kind, column.Column, csvValue(column.Upstream), csvValue(column.Downstream)}
+			if len(column.Column) == 0 {
+				record[5], record[6] = "", ""
+			}
+			if err := writer.Write(record); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	writer.Flush()
+	return errors.Trace(writer.Error())
+}
+
+func csvValue(value *string) string {
+	if value == nil {
+		return csvNull
+	}
+	return *value
+}
+
+// WriteRowDiffsJSON writes the row diffs in json lines, one line for each row.
+func WriteRowDiffsJSON(w io.Writer, rowDiffs []*RowDiff) error {
+	encoder := json.NewEncoder(w)
+	for _, rowDiff := range rowDiffs {
+		if err := encoder.Encode(rowDiff); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
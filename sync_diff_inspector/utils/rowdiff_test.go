@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowDiff(t *testing.T) {
+	createTableSQL := "create table `test`.`tbl`(`id` int, `name` varchar(10), `price` double, `note` varchar(10), primary key(`id`))"
+	tableInfo, err := dbutil.GetTableInfoBySQL(createTableSQL, parser.New())
+	require.NoError(t, err)
+	_, keyColumns := dbutil.SelectUniqueOrderKey(tableInfo)
+	row := func(id, name, price string) map[string]*dbutil.ColumnData {
+		return map[string]*dbutil.ColumnData{
+			"id":    {Data: []byte(id)},
+			"name":  {Data: []byte(name), IsNull: len(name) == 0},
+			"price": {Data: []byte(price)},
+			"note":  {IsNull: true},
+		}
+	}
+	tolerances := map[string]*Tolerance{"price": {Absolute: 0.01}}
+	value := func(s string) *string { return &s }
+
+	changed := NewRowDiff(row("1", "a,\"b\"", "1.001"), row("1", "", "1.1"), tableInfo, "test", keyColumns, tolerances)
+	require.Equal(t, &RowDiff{
+		Schema: "test",
+		Table:  "tbl",
+		Key:    map[string]*string{"id": value("1")},
+		Kind:   RowDiffChanged,
+		Columns: []*ColumnDiff{
+			{Column: "name", Upstream: value("a,\"b\"")},
+			{Column: "price", Upstream: value("1.001"), Downstream: value("1.1")},
+		},
+	}, changed)
+	// the price is equal within the tolerance
+	changed2 := NewRowDiff(row("2", "a", "1.001"), row("2", "b", "1.005"), tableInfo, "test", keyColumns, tolerances)
+	require.Len(t, changed2.Columns, 1)
+	require.Equal(t, "name", changed2.Columns[0].Column)
+
+	missing := NewRowDiff(row("3", "c", "3"), nil, tableInfo, "test", keyColumns, tolerances)
+	require.Equal(t, RowDiffMissing, missing.Kind)
+	require.Equal(t, []*ColumnDiff{
+		{Column: "name", Upstream: value("c")},
+		{Column: "price", Upstream: value("3")},
+		{Column: "note"},
+	}, missing.Columns)
+	extra := NewRowDiff(nil, row("4", "d", "4"), tableInfo, "test", keyColumns, tolerances)
+	require.Equal(t, RowDiffExtra, extra.Kind)
+	require.Equal(t, value("4"), extra.Key["id"])
+	require.Equal(t, value("d"), extra.Columns[0].Downstream)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteRowDiffsCSV(buf, []*RowDiff{changed, extra}))
+	require.Equal(t, `schema,table,key,kind,column,upstream,downstream
+test,tbl,"{""id"":""1""}",changed,name,"a,""b""",\N
+test,tbl,"{""id"":""1""}",changed,price,1.001,1.1
+test,tbl,"{""id"":""4""}",extra,name,\N,d
+test,tbl,"{""id"":""4""}",extra,price,\N,4
+test,tbl,"{""id"":""4""}",extra,note,\N,\N
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteRowDiffsJSON(buf, []*RowDiff{missing}))
+	require.Equal(t, `{"schema":"test","table":"tbl","key":{"id":"3"},"kind":"missing","columns":[{"column":"name","upstream":"c","downstream":null},{"column":"price","upstream":"3","downstream":null},{"column":"note","upstream":null,"downstream":null}]}
+`, buf.String())
+}
@@ -0,0 +1,384 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/types"
+)
+
+// StructDiff is an attribute of the table, a column, an index or the partitions which is different
+// between the upstream and the downstream table. The value is empty if the object doesn't exist.
+type StructDiff struct {
+	Object     string `json:"object"`
+	Attribute  string `json:"attribute"`
+	Upstream   string `json:"upstream"`
+	Downstream string `json:"downstream"`
+}
+
+func (d *StructDiff) String() string {
+	return fmt.Sprintf("%s %s: upstream %q, downstream %q", d.Object, d.Attribute, d.Upstream, d.Downstream)
+}
+
+// CompareStructDetail compares every attribute of the columns, the indices, the charset, the collation
+// and the partitions of the tables, and returns the differences.
+func CompareStructDetail(upstream, downstream *model.TableInfo) []*StructDiff {
+	diffs := make([]*StructDiff, 0)
+	addDiff := func(object, attribute, upstreamValue, downstreamValue string) {
+		if upstreamValue != downstreamValue {
+			diffs = append(diffs, &StructDiff{Object: object, Attribute: attribute, Upstream: upstreamValue, Downstream: downstreamValue})
+		}
+	}
+
+	addDiff("table", "charset", upstream.Charset, downstream.Charset)
+	addDiff("table", "collation", upstream.Collate, downstream.Collate)
+
+	// the positions are compared among the columns both tables have, so a missing column isn't reported repeatedly.
+	upstreamPositions, downstreamPositions := commonColumnPositions(upstream, downstream), commonColumnPositions(downstream, upstream)
+	for _, upstreamCol := range upstream.Columns {
+		object := "column " + dbutil.ColumnName(upstreamCol.Name.O)
+		downstreamCol := model.FindColumnInfo(downstream.Columns, upstreamCol.Name.L)
+		if downstreamCol == nil {
+			addDiff(object, "definition", columnDefinition(upstreamCol), "")
+			continue
+		}
+		addDiff(object, "position", strconv.Itoa(upstreamPositions[upstreamCol.Name.L]+1), strconv.Itoa(downstreamPositions[upstreamCol.Name.L]+1))
+		for _, attr := range columnAttributes {
+			addDiff(object, attr.name, attr.value(upstreamCol), attr.value(downstreamCol))
+		}
+	}
+	for _, downstreamCol := range downstream.Columns {
+		if model.FindColumnInfo(upstream.Columns, downstreamCol.Name.L) == nil {
+			addDiff("column "+dbutil.ColumnName(downstreamCol.Name.O), "definition", "", columnDefinition(downstreamCol))
+		}
+	}
+
+	for _, upstreamIndex := range upstream.Indices {
+		object := "index " + dbutil.ColumnName(upstreamIndex.Name.O)
+		downstreamIndex := findIndex(downstream, upstreamIndex.Name.L)
+		if downstreamIndex == nil {
+			addDiff(object, "definition", indexDefinition(upstreamIndex), "")
+			continue
+		}
+		for _, attr := range indexAttributes {
+			addDiff(object, attr.name, attr.value(upstreamIndex), attr.value(downstreamIndex))
+		}
+	}
+	for _, downstreamIndex := range downstream.Indices {
+		if findIndex(upstream, downstreamIndex.Name.L) == nil {
+			addDiff("index "+dbutil.ColumnName(downstreamIndex.Name.O), "definition", "", indexDefinition(downstreamIndex))
+		}
+	}
+
+	addDiff("partition", "definition", partitionDefinition(upstream.Partition), partitionDefinition(downstream.Partition))
+	return diffs
+}
+
+// GenerateAlterSQLs returns the ALTER TABLE statements to change the downstream table to the upstream one.
+// Each statement changes one object because TiDB doesn't support multiple changes in one statement.
+func GenerateAlterSQLs(schema string, upstream, downstream *model.TableInfo) []string {
+	tableName := dbutil.TableName(schema, downstream.Name.O)
+	sqls := make([]string, 0)
+	alter := func(format string, args ...interface{}) {
+		sqls = append(sqls, fmt.Sprintf("ALTER TABLE %s %s;", tableName, fmt.Sprintf(format, args...)))
+	}
+
+	if upstream.Charset != downstream.Charset || upstream.Collate != downstream.Collate {
+		alter("DEFAULT CHARACTER SET %s COLLATE %s", upstream.Charset, upstream.Collate)
+	}
+
+	// drop the different indices first, so that the columns in them can be dropped or modified.
+	addIndices := make([]*model.IndexInfo, 0)
+	for _, upstreamIndex := range upstream.Indices {
+		downstreamIndex := findIndex(downstream, upstreamIndex.Name.L)
+		if downstreamIndex != nil && indexDefinition(downstreamIndex) == indexDefinition(upstreamIndex) {
+			continue
+		}
+		if downstreamIndex != nil {
+			alter("%s", dropIndexClause(downstreamIndex))
+		}
+		addIndices = append(addIndices, upstreamIndex)
+	}
+	for _, downstreamIndex := range downstream.Indices {
+		if findIndex(upstream, downstreamIndex.Name.L) == nil {
+			alter("%s", dropIndexClause(downstreamIndex))
+		}
+	}
+
+	for _, downstreamCol := range downstream.Columns {
+		if model.FindColumnInfo(upstream.Columns, downstreamCol.Name.L) == nil {
+			alter("DROP COLUMN %s", dbutil.ColumnName(downstreamCol.Name.O))
+		}
+	}
+	// the positions of the downstream columns after the columns are dropped.
+	positions := commonColumnPositions(downstream, upstream)
+	for i, upstreamCol := range upstream.Columns {
+		position := "FIRST"
+		if i > 0 {
+			position = "AFTER " + dbutil.ColumnName(upstream.Columns[i-1].Name.O)
+		}
+		downstreamCol := model.FindColumnInfo(downstream.Columns, upstreamCol.Name.L)
+		if downstreamCol == nil {
+			alter("ADD COLUMN %s %s", columnDefinition(upstreamCol), position)
+			for name, pos := range positions {
+				if pos >= i {
+					positions[name] = pos + 1
+				}
+			}
+			continue
+		}
+		if columnDefinition(downstreamCol) == columnDefinition(upstreamCol) && positions[upstreamCol.Name.L] == i {
+			continue
+		}
+		alter("MODIFY COLUMN %s %s", columnDefinition(upstreamCol), position)
+		if from := positions[upstreamCol.Name.L]; from != i {
+			for name, pos := range positions {
+				if pos >= i && pos < from {
+					positions[name] = pos + 1
+				}
+			}
+			positions[upstreamCol.Name.L] = i
+		}
+	}
+
+	for _, index := range addIndices {
+		alter("ADD %s", indexDefinition(index))
+	}
+
+	upstreamPartition, downstreamPartition := partitionDefinition(upstream.Partition), partitionDefinition(downstream.Partition)
+	if upstreamPartition != downstreamPartition {
+		if len(upstreamPartition) == 0 {
+			alter("REMOVE PARTITIONING")
+		} else {
+			alter("%s", upstreamPartition)
+		}
+	}
+	return sqls
+}
+
+var columnAttributes = []struct {
+	name  string
+	value func(*model.ColumnInfo) string
+}{
+	{"type", func(col *model.ColumnInfo) string { return col.GetTypeDesc() }},
+	{"charset", func(col *model.ColumnInfo) string { return columnCharset(col) }},
+	{"collation", func(col *model.ColumnInfo) string {
+		if len(columnCharset(col)) == 0 {
+			return ""
+		}
+		return col.Collate
+	}},
+	{"nullable", func(col *model.ColumnInfo) string { return strconv.FormatBool(!mysql.HasNotNullFlag(col.Flag)) }},
+	{"default", columnDefault},
+	{"auto-increment", func(col *model.ColumnInfo) string { return strconv.FormatBool(mysql.HasAutoIncrementFlag(col.Flag)) }},
+	{"on-update", func(col *model.ColumnInfo) string {
+		if mysql.HasOnUpdateNowFlag(col.Flag) {
+			return "CURRENT_TIMESTAMP"
+		}
+		return ""
+	}},
+	{"generated", columnGenerated},
+	{"comment", func(col *model.ColumnInfo) string { return col.Comment }},
+}
+
+var indexAttributes = []struct {
+	name  string
+	value func(*model.IndexInfo) string
+}{
+	{"columns", indexColumns},
+	{"primary", func(index *model.IndexInfo) string { return strconv.FormatBool(index.Primary) }},
+	{"unique", func(index *model.IndexInfo) string { return strconv.FormatBool(index.Unique) }},
+	{"invisible", func(index *model.IndexInfo) string { return strconv.FormatBool(index.Invisible) }},
+}
+
+// commonColumnPositions returns the positions of the columns of the table which the other table also has.
+func commonColumnPositions(table, other *model.TableInfo) map[string]int {
+	positions := make(map[string]int, len(table.Columns))
+	for _, col := range table.Columns {
+		if model.FindColumnInfo(other.Columns, col.Name.L) != nil {
+			positions[col.Name.L] = len(positions)
+		}
+	}
+	return positions
+}
+
+// columnCharset returns the charset of the string column, it's empty for the other columns.
+func columnCharset(col *model.ColumnInfo) string {
+	switch {
+	case col.Charset == "binary":
+		return ""
+	case types.IsTypeChar(col.Tp), types.IsTypeBlob(col.Tp), col.Tp == mysql.TypeVarString, col.Tp == mysql.TypeEnum, col.Tp == mysql.TypeSet:
+	default:
+		return ""
+	}
+	return col.Charset
+}
+
+func columnDefault(col *model.ColumnInfo) string {
+	value := col.GetDefaultValue()
+	if value == nil {
+		if mysql.HasNotNullFlag(col.Flag) || mysql.HasAutoIncrementFlag(col.Flag) || col.IsGenerated() {
+			return ""
+		}
+		return "NULL"
+	}
+	str := fmt.Sprintf("%v", value)
+	if col.DefaultIsExpr || strings.HasPrefix(strings.ToUpper(str), "CURRENT_TIMESTAMP") {
+		return str
+	}
+	return "'" + strings.Replace(str, "'", "''", -1) + "'"
+}
+
+func columnGenerated(col *model.ColumnInfo) string {
+	if !col.IsGenerated() {
+		return ""
+	}
+	if col.GeneratedStored {
+		return fmt.Sprintf("AS (%s) STORED", col.GeneratedExprString)
+	}
+	return fmt.Sprintf("AS (%s) VIRTUAL", col.GeneratedExprString)
+}
+
+// columnDefinition returns the definition of the column used in `ADD COLUMN` and `MODIFY COLUMN`.
+func columnDefinition(col *model.ColumnInfo) string {
+	parts := []string{dbutil.ColumnName(col.Name.O), col.GetTypeDesc()}
+	if charset := columnCharset(col); len(charset) != 0 {
+		parts = append(parts, "CHARACTER SET "+charset)
+		if len(col.Collate) != 0 {
+			parts = append(parts, "COLLATE "+col.Collate)
+		}
+	}
+	if generated := columnGenerated(col); len(generated) != 0 {
+		parts = append(parts, generated)
+	}
+	if mysql.HasNotNullFlag(col.Flag) {
+		parts = append(parts, "NOT NULL")
+	} else {
+		parts = append(parts, "NULL")
+	}
+	if def := columnDefault(col); len(def) != 0 {
+		parts = append(parts, "DEFAULT "+def)
+	}
+	if mysql.HasAutoIncrementFlag(col.Flag) {
+		parts = append(parts, "AUTO_INCREMENT")
+	}
+	if mysql.HasOnUpdateNowFlag(col.Flag) {
+		parts = append(parts, "ON UPDATE CURRENT_TIMESTAMP")
+	}
+	if len(col.Comment) != 0 {
+		parts = append(parts, "COMMENT '"+strings.Replace(col.Comment, "'", "''", -1)+"'")
+	}
+	return strings.Join(parts, " ")
+}
+
+func findIndex(table *model.TableInfo, name string) *model.IndexInfo {
+	for _, index := range table.Indices {
+		if index.Name.L == name {
+			return index
+		}
+	}
+	return nil
+}
+
+func indexColumns(index *model.IndexInfo) string {
+	columns := make([]string, 0, len(index.Columns))
+	for _, col := range index.Columns {
+		if col.Length != types.UnspecifiedLength {
+			columns = append(columns, fmt.Sprintf("%s(%d)", dbutil.ColumnName(col.Name.O), col.Length))
+		} else {
+			columns = append(columns, dbutil.ColumnName(col.Name.O))
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// indexDefinition returns the definition of the index used in `ADD`.
+func indexDefinition(index *model.IndexInfo) string {
+	var definition string
+	switch {
+	case index.Primary:
+		definition = fmt.Sprintf("PRIMARY KEY (%s)", indexColumns(index))
+	case index.Unique:
+		definition = fmt.Sprintf("UNIQUE KEY %s (%s)", dbutil.ColumnName(index.Name.O), indexColumns(index))
+	default:
+		definition = fmt.Sprintf("KEY %s (%s)", dbutil.ColumnName(index.Name.O), indexColumns(index))
+	}
+	if index.Invisible {
+		definition += " INVISIBLE"
+	}
+	return definition
+}
+
+func dropIndexClause(index *model.IndexInfo) string {
+	if index.Primary {
+		return "DROP PRIMARY KEY"
+	}
+	return "DROP INDEX " + dbutil.ColumnName(index.Name.O)
+}
+
+// partitionDefinition returns the `PARTITION BY` clause of the table, it's empty if the table isn't partitioned.
+func partitionDefinition(partition *model.PartitionInfo) string {
+	if partition == nil {
+		return ""
+	}
+	by := partition.Type.String()
+	if len(partition.Columns) != 0 {
+		columns := make([]string, 0, len(partition.Columns))
+		for _, col := range partition.Columns {
+			columns = append(columns, dbutil.ColumnName(col.O))
+		}
+		if partition.Type == model.PartitionTypeKey {
+			by = fmt.Sprintf("%s (%s)", by, strings.Join(columns, ", "))
+		} else {
+			by = fmt.Sprintf("%s COLUMNS(%s)", by, strings.Join(columns, ", "))
+		}
+	} else {
+		by = fmt.Sprintf("%s (%s)", by, partition.Expr)
+	}
+
+	switch partition.Type {
+	case model.PartitionTypeRange, model.PartitionTypeList:
+		definitions := make([]string, 0, len(partition.Definitions))
+		for _, def := range partition.Definitions {
+			var values string
+			if partition.Type == model.PartitionTypeRange {
+				values = fmt.Sprintf("VALUES LESS THAN (%s)", strings.Join(def.LessThan, ", "))
+			} else {
+				inValues := make([]string, 0, len(def.InValues))
+				for _, value := range def.InValues {
+					if len(value) == 1 {
+						inValues = append(inValues, value[0])
+					} else {
+						inValues = append(inValues, "("+strings.Join(value, ", ")+")")
+					}
+				}
+				values = fmt.Sprintf("VALUES IN (%s)", strings.Join(inValues, ", "))
+			}
+			definitions = append(definitions, fmt.Sprintf("PARTITION %s %s", dbutil.ColumnName(def.Name.O), values))
+		}
+		return fmt.Sprintf("PARTITION BY %s (%s)", by, strings.Join(definitions, ", "))
+	default:
+		num := partition.Num
+		if num == 0 {
+			num = uint64(len(partition.Definitions))
+		}
+		return fmt.Sprintf("PARTITION BY %s PARTITIONS %d", by, num)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb-tools/pkg/dbutil"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareStructDetail(t *testing.T) {
+	upstream, err := dbutil.GetTableInfoBySQL("create table `test`.`tbl`("+
+		"`id` int not null auto_increment, "+
+		"`name` varchar(20) character set utf8mb4 collate utf8mb4_bin default 'a', "+
+		"`price` decimal(10, 2) not null default '0.00', "+
+		"`created_at` timestamp default current_timestamp on update current_timestamp, "+
+		"primary key(`id`), key `idx_name`(`name`(10))"+
+		") default charset=utf8mb4 collate=utf8mb4_bin "+
+		"partition by range (`id`) (partition `p0` values less than (100), partition `p1` values less than (maxvalue))", parser.New())
+	require.NoError(t, err)
+	downstream, err := dbutil.GetTableInfoBySQL("create table `test`.`tbl`("+
+		"`id` int not null auto_increment, "+
+		"`extra` int, "+
+		"`price` decimal(12, 2) not null default '0.00', "+
+		"`name` varchar(10) character set utf8mb4 collate utf8mb4_general_ci, "+
+		"primary key(`id`), unique key `idx_name`(`name`), key `idx_price`(`price`)"+
+		") default charset=utf8mb4 collate=utf8mb4_general_ci", parser.New())
+	require.NoError(t, err)
+
+	diffs := CompareStructDetail(upstream, downstream)
+	diffStrs := make([]string, 0, len(diffs))
+	for _, diff := range diffs {
+		diffStrs = append(diffStrs, diff.String())
+	}
+	require.Equal(t, []string{
+		`table collation: upstream "utf8mb4_bin", downstream "utf8mb4_general_ci"`,
+		"column `name` position: upstream \"2\", downstream \"3\"",
+		"column `name` type: upstream \"varchar(20)\", downstream \"varchar(10)\"",
+		"column `name` collation: upstream \"utf8mb4_bin\", downstream \"utf8mb4_general_ci\"",
+		"column `name` default: upstream \"'a'\", downstream \"NULL\"",
+		"column `price` position: upstream \"3\", downstream \"2\"",
+		"column `price` type: upstream \"decimal(10,2)\", downstream \"decimal(12,2)\"",
+		"column `created_at` definition: upstream \"`created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP\", downstream \"\"",
+		"column `extra` definition: upstream \"\", downstream \"`extra` int(11) NULL DEFAULT NULL\"",
+		"index `idx_name` columns: upstream \"`name`(10)\", downstream \"`name`\"",
+		"index `idx_name` unique: upstream \"false\", downstream \"true\"",
+		"index `idx_price` definition: upstream \"\", downstream \"KEY `idx_price` (`price`)\"",
+		"partition definition: upstream \"PARTITION BY RANGE (`id`) (PARTITION `p0` VALUES LESS THAN (100), PARTITION `p1` VALUES LESS THAN (MAXVALUE))\", downstream \"\"",
+	}, diffStrs)
+	require.Empty(t, CompareStructDetail(upstream, upstream))
+
+	require.Equal(t, []string{
+		"ALTER TABLE `test`.`tbl` DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;",
+		"ALTER TABLE `test`.`tbl` DROP INDEX `idx_name`;",
+		"ALTER TABLE `test`.`tbl` DROP INDEX `idx_price`;",
+		"ALTER TABLE `test`.`tbl` DROP COLUMN `extra`;",
+		"ALTER TABLE `test`.`tbl` MODIFY COLUMN `name` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NULL DEFAULT 'a' AFTER `id`;",
+		"ALTER TABLE `test`.`tbl` MODIFY COLUMN `price` decimal(10,2) NOT NULL DEFAULT '0.00' AFTER `name`;",
+		"ALTER TABLE `test`.`tbl` ADD COLUMN `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP AFTER `price`;",
+		"ALTER TABLE `test`.`tbl` ADD KEY `idx_name` (`name`(10));",
+		"ALTER TABLE `test`.`tbl` PARTITION BY RANGE (`id`) (PARTITION `p0` VALUES LESS THAN (100), PARTITION `p1` VALUES LESS THAN (MAXVALUE));",
+	}, GenerateAlterSQLs("test", upstream, downstream))
+	require.Empty(t, GenerateAlterSQLs("test", upstream, upstream))
+	require.Equal(t, []string{"ALTER TABLE `test`.`tbl` REMOVE PARTITIONING;"}, GenerateAlterSQLs("test", downstream, func() *model.TableInfo {
+		table := downstream.Clone()
+		table.Partition = upstream.Partition
+		return table
+	}()))
+}
@@ -118,7 +118,7 @@ func GetColumnsFromIndex(index *model.IndexInfo, tableInfo *model.TableInfo) []*
 
 // GetTableRowsQueryFormat returns a rowsQuerySQL template for the specific table.
 //  e.g. SELECT /*!40001 SQL_NO_CACHE */ `a`, `b` FROM `schema`.`table` WHERE %s ORDER BY `a`.
-func GetTableRowsQueryFormat(schema, table string, tableInfo *model.TableInfo, collation string) (string, []*model.ColumnInfo) {
+func GetTableRowsQueryFormat(schema, table string, tableInfo *model.TableInfo, columnExprs map[string]string, collation string) (string, []*model.ColumnInfo) {
 	orderKeys, orderKeyCols := dbutil.SelectUniqueOrderKey(tableInfo)
 
 	columnNames := make([]string, 0, len(tableInfo.Columns))
@@ -143,13 +143,40 @@ func GetTableRowsQueryFormat(schema, table string, tableInfo *model.TableInfo, c
 	}
 
 	query := fmt.Sprintf("SELECT /*!40001 SQL_NO_CACHE */ %s FROM %s WHERE %%s ORDER BY %s%s",
-		columns, dbutil.TableName(schema, table), strings.Join(orderKeys, ","), collation)
+		columns, transformedTableName(schema, table, tableInfo, columnExprs), strings.Join(orderKeys, ","), collation)
 
 	return query, orderKeyCols
 }
 
+// transformedTableName returns the table in the FROM clause. If some columns are transformed,
+// the table is wrapped by a derived table selecting the sql expressions of the columns,
+// so that the conditions and the order are applied to the transformed values.
+func transformedTableName(schema, table string, tableInfo *model.TableInfo, columnExprs map[string]string) string {
+	if len(columnExprs) == 0 {
+		return dbutil.TableName(schema, table)
+	}
+	columnNames := make([]string, 0, len(tableInfo.Columns))
+	for _, col := range tableInfo.Columns {
+		name := dbutil.ColumnName(col.Name.O)
+		if expr, ok := columnExprs[col.Name.L]; ok {
+			name = fmt.Sprintf("%s AS %s", expr, name)
+		}
+		columnNames = append(columnNames, name)
+	}
+	return fmt.Sprintf("(SELECT %s FROM %s) AS %s", strings.Join(columnNames, ", "), dbutil.TableName(schema, table), dbutil.ColumnName(table))
+}
+
 // GenerateReplaceDML returns the insert SQL for the specific row values.
 func GenerateReplaceDML(data map[string]*dbutil.ColumnData, table *model.TableInfo, schema string) string {
+	return generateInsertDML("REPLACE", data, table, schema)
+}
+
+// GenerateInsertDML returns the insert SQL for the specific row, it's used for the tables without any unique key.
+func GenerateInsertDML(data map[string]*dbutil.ColumnData, table *model.TableInfo, schema string) string {
+	return generateInsertDML("INSERT", data, table, schema)
+}
+
+func generateInsertDML(stmt string, data map[string]*dbutil.ColumnData, table *model.TableInfo, schema string) string {
 	colNames := make([]string, 0, len(table.Columns))
 	values := make([]string, 0, len(table.Columns))
 	for _, col := range table.Columns {
@@ -170,7 +197,7 @@ func GenerateReplaceDML(data map[string]*dbutil.ColumnData, table *model.TableIn
 		}
 	}
 
-	return fmt.Sprintf("REPLACE INTO %s(%s) VALUES (%s);", dbutil.TableName(schema, table.Name.O), strings.Join(colNames, ","), strings.Join(values, ","))
+	return fmt.Sprintf("%s INTO %s(%s) VALUES (%s);", stmt, dbutil.TableName(schema, table.Name.O), strings.Join(colNames, ","), strings.Join(values, ","))
 }
 
 // GerateReplaceDMLWithAnnotation returns the replace SQL for the specific 2 rows.
@@ -242,6 +269,12 @@ func GenerateReplaceDMLWithAnnotation(source, target map[string]*dbutil.ColumnDa
 
 // GerateReplaceDMLWithAnnotation returns the delete SQL for the specific row.
 func GenerateDeleteDML(data map[string]*dbutil.ColumnData, table *model.TableInfo, schema string) string {
+	return GenerateDeleteDMLWithLimit(data, table, schema, 1)
+}
+
+// GenerateDeleteDMLWithLimit returns the delete SQL deleting at most limit identical rows,
+// it's used for the duplicate rows in the tables without any unique key.
+func GenerateDeleteDMLWithLimit(data map[string]*dbutil.ColumnData, table *model.TableInfo, schema string, limit int64) string {
 	kvs := make([]string, 0, len(table.Columns))
 	for _, col := range table.Columns {
 		if col.IsGenerated() {
@@ -259,7 +292,7 @@ func GenerateDeleteDML(data map[string]*dbutil.ColumnData, table *model.TableInf
 			kvs = append(kvs, fmt.Sprintf("%s = %s", dbutil.ColumnName(col.Name.O), string(data[col.Name.O].Data)))
 		}
 	}
-	return fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT 1;", dbutil.TableName(schema, table.Name.O), strings.Join(kvs, " AND "))
+	return fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT %d;", dbutil.TableName(schema, table.Name.O), strings.Join(kvs, " AND "), limit)
 
 }
 
@@ -432,6 +465,24 @@ func NeedQuotes(tp byte) bool {
 //		2. cmp = -1: map1 < map2 (by comparing the orderkeycolumns)
 // 		3. cmp = 1: map1 > map2
 func CompareData(map1, map2 map[string]*dbutil.ColumnData, orderKeyCols, columns []*model.ColumnInfo) (equal bool, cmp int32, err error) {
+	return CompareDataWithTolerance(map1, map2, orderKeyCols, columns, nil)
+}
+
+// Tolerance is the max difference of two numeric values which are considered equal.
+type Tolerance struct {
+	Absolute float64
+	Relative float64
+}
+
+// Equal returns true if |a-b| <= Absolute or |a-b| <= Relative * max(|a|, |b|).
+func (t *Tolerance) Equal(a, b float64) bool {
+	diff := math.Abs(a - b)
+	return diff <= t.Absolute || diff <= t.Relative*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// CompareDataWithTolerance is the same as CompareData, but the values of the columns in tolerances
+// are equal if their difference is within the tolerance. The key of tolerances is the column name.
+func CompareDataWithTolerance(map1, map2 map[string]*dbutil.ColumnData, orderKeyCols, columns []*model.ColumnInfo, tolerances map[string]*Tolerance) (equal bool, cmp int32, err error) {
 	var (
 		data1, data2 *dbutil.ColumnData
 		str1, str2   string
@@ -464,7 +515,23 @@ func CompareData(map1, map2 map[string]*dbutil.ColumnData, orderKeyCols, columns
 		}
 		str1 = string(data1.Data)
 		str2 = string(data2.Data)
-		if column.FieldType.Tp == mysql.TypeFloat || column.FieldType.Tp == mysql.TypeDouble {
+		if tolerance, ok := tolerances[column.Name.O]; ok {
+			if data1.IsNull || data2.IsNull {
+				if data1.IsNull == data2.IsNull {
+					continue
+				}
+			} else {
+				num1, err1 := strconv.ParseFloat(str1, 64)
+				num2, err2 := strconv.ParseFloat(str2, 64)
+				if err1 != nil || err2 != nil {
+					err = errors.Errorf("convert %s, %s to float failed, err1: %v, err2: %v", str1, str2, err1, err2)
+					return
+				}
+				if tolerance.Equal(num1, num2) {
+					continue
+				}
+			}
+		} else if column.FieldType.Tp == mysql.TypeFloat || column.FieldType.Tp == mysql.TypeDouble {
 			if data1.IsNull == data2.IsNull && data1.IsNull {
 				continue
 			}
@@ -649,8 +716,89 @@ func GetTableSize(ctx context.Context, db *sql.DB, schemaName, tableName string)
 	return dataSize.Int64, nil
 }
 
-// GetCountAndCRC32Checksum returns checksum code and count of some data by given condition
-func GetCountAndCRC32Checksum(ctx context.Context, db *sql.DB, schemaName, tableName string, tbInfo *model.TableInfo, limitRange string, args []interface{}) (int64, int64, error) {
+// GetAvgRowLength loads the average length of the rows from `information_schema`.`tables`.
+func GetAvgRowLength(ctx context.Context, db *sql.DB, schemaName, tableName string) (int64, error) {
+	query := "select avg_row_length from `information_schema`.`tables` where table_schema=? and table_name=?;"
+	var avgRowLength sql.NullInt64
+	err := db.QueryRowContext(ctx, query, schemaName, tableName).Scan(&avgRowLength)
+	if err != nil {
+		return int64(0), errors.Trace(err)
+	}
+	return avgRowLength.Int64, nil
+}
+
+// crc32ChecksumExpr returns the expression of the crc32 checksum of the rows.
+func crc32ChecksumExpr(tbInfo *model.TableInfo, tolerances map[string]*Tolerance) string {
+	columnNames := make([]string, 0, len(tbInfo.Columns))
+	columnIsNull := make([]string, 0, len(tbInfo.Columns))
+	for _, col := range tbInfo.Columns {
+		name := checksumColumnName(col)
+		columnIsNull = append(columnIsNull, fmt.Sprintf("ISNULL(%s)", name))
+		// the values with tolerance are compared by rows, only check whether they are NULL.
+		if _, ok := tolerances[col.Name.O]; ok {
+			continue
+		}
+		columnNames = append(columnNames, name)
+	}
+	columnNames = append(columnNames, fmt.Sprintf("CONCAT(%s)", strings.Join(columnIsNull, ", ")))
+
+	return fmt.Sprintf("BIT_XOR(CAST(CRC32(CONCAT_WS(',', %s))AS UNSIGNED))", strings.Join(columnNames, ", "))
+}
+
+// KeyBucketExpr returns the expression hashing the key columns into `modulus` buckets.
+func KeyBucketExpr(columns []*model.ColumnInfo, modulus int64) string {
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, dbutil.ColumnName(col.Name.O))
+	}
+	return fmt.Sprintf("CRC32(CONCAT_WS(',', %s)) %% %d", strings.Join(names, ", "), modulus)
+}
+
+// GroupChecksum is the count and the checksum of a group of rows.
+type GroupChecksum struct {
+	Count    int64
+	Checksum int64
+}
+
+// GetGroupedCountAndCRC32Checksum returns the count and the checksum of each group of the rows by the value
+// of groupExpr, which should be an integer expression such as `CRC32(id) % 16`. The checksum is calculated
+// by the algorithm, ChecksumInProcess is not supported.
+func GetGroupedCountAndCRC32Checksum(ctx context.Context, db *sql.DB, schemaName, tableName string, tbInfo *model.TableInfo, columnExprs map[string]string, tolerances map[string]*Tolerance, algorithm string, groupExpr string, limitRange string, args []interface{}) (map[int64]*GroupChecksum, error) {
+	if algorithm == ChecksumInProcess {
+		return nil, errors.NotSupportedf("grouped checksum with the %s checksum algorithm", algorithm)
+	}
+	query := fmt.Sprintf("SELECT %s as GRP, COUNT(*) as CNT, %s as CHECKSUM FROM %s WHERE %s GROUP BY GRP;",
+		groupExpr, checksumExpr(algorithm, tbInfo, tolerances), transformedTableName(schemaName, tableName, tbInfo, columnExprs), limitRange)
+	log.Debug("grouped count and checksum", zap.String("sql", query), zap.Reflect("args", args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Warn("execute grouped checksum query fail", zap.String("query", query), zap.Reflect("args", args), zap.Error(err))
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	groups := make(map[int64]*GroupChecksum)
+	for rows.Next() {
+		var group, count sql.NullInt64
+		var checksum sql.NullString
+		if err = rows.Scan(&group, &count, &checksum); err != nil {
+			return nil, errors.Trace(err)
+		}
+		value, err := scanChecksum(algorithm, checksum)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		groups[group.Int64] = &GroupChecksum{Count: count.Int64, Checksum: value}
+	}
+	return groups, errors.Trace(rows.Err())
+}
+
+// GetCountAndCRC32Checksum returns checksum code and count of some data by given condition,
+// the checksum is calculated by the algorithm, see ChecksumCRC32, ChecksumHash64 and ChecksumInProcess.
+func GetCountAndCRC32Checksum(ctx context.Context, db *sql.DB, schemaName, tableName string, tbInfo *model.TableInfo, columnExprs map[string]string, tolerances map[string]*Tolerance, algorithm string, limitRange string, args []interface{}) (int64, int64, error) {
+	if algorithm == ChecksumInProcess {
+		return getInProcessCountAndChecksum(ctx, db, schemaName, tableName, tbInfo, columnExprs, tolerances, limitRange, args)
+	}
 	/*
 		calculate CRC32 checksum and count example:
 		mysql> select count(*) as CNT, BIT_XOR(CAST(CRC32(CONCAT_WS(',', id, name, age, CONCAT(ISNULL(id), ISNULL(name), ISNULL(age))))AS UNSIGNED)) as CHECKSUM from test.test where id > 0;
@@ -661,27 +809,12 @@ func GetCountAndCRC32Checksum(ctx context.Context, db *sql.DB, schemaName, table
 		+--------+------------+
 		1 row in set (0.46 sec)
 	*/
-	columnNames := make([]string, 0, len(tbInfo.Columns))
-	columnIsNull := make([]string, 0, len(tbInfo.Columns))
-	for _, col := range tbInfo.Columns {
-		name := dbutil.ColumnName(col.Name.O)
-		// When col value is 0, the result is NULL.
-		// But we can use ISNULL to distinguish between null and 0.
-		if col.FieldType.Tp == mysql.TypeFloat {
-			name = fmt.Sprintf("round(%s, 5-floor(log10(abs(%s))))", name, name)
-		} else if col.FieldType.Tp == mysql.TypeDouble {
-			name = fmt.Sprintf("round(%s, 14-floor(log10(abs(%s))))", name, name)
-		}
-		columnNames = append(columnNames, name)
-		columnIsNull = append(columnIsNull, fmt.Sprintf("ISNULL(%s)", name))
-	}
-
-	query := fmt.Sprintf("SELECT COUNT(*) as CNT, BIT_XOR(CAST(CRC32(CONCAT_WS(',', %s, CONCAT(%s)))AS UNSIGNED)) as CHECKSUM FROM %s WHERE %s;",
-		strings.Join(columnNames, ", "), strings.Join(columnIsNull, ", "), dbutil.TableName(schemaName, tableName), limitRange)
+	query := fmt.Sprintf("SELECT COUNT(*) as CNT, %s as CHECKSUM FROM %s WHERE %s;",
+		checksumExpr(algorithm, tbInfo, tolerances), transformedTableName(schemaName, tableName, tbInfo, columnExprs), limitRange)
 	log.Debug("count and checksum", zap.String("sql", query), zap.Reflect("args", args))
 
 	var count sql.NullInt64
-	var checksum sql.NullInt64
+	var checksum sql.NullString
 	err := db.QueryRowContext(ctx, query, args...).Scan(&count, &checksum)
 	if err != nil {
 		log.Warn("execute checksum query fail", zap.String("query", query), zap.Reflect("args", args), zap.Error(err))
@@ -692,8 +825,12 @@ func GetCountAndCRC32Checksum(ctx context.Context, db *sql.DB, schemaName, table
 		log.Warn("get empty count or checksum", zap.String("sql", query), zap.Reflect("args", args))
 		return 0, 0, nil
 	}
+	value, err := scanChecksum(algorithm, checksum)
+	if err != nil {
+		return -1, -1, errors.Trace(err)
+	}
 
-	return count.Int64, checksum.Int64, nil
+	return count.Int64, value, nil
 }
 
 // ResetColumns removes index from `tableInfo.Indices`, whose columns appear in `columns`.
@@ -845,3 +982,20 @@ func GetChunkIDFromSQLFileName(fileIDStr string) (int, int, int, int, error) {
 	}
 	return tableIndex, bucketIndexLeft, bucketIndexRight, chunkIndex, nil
 }
+
+// WilsonInterval returns the Wilson score interval of the proportion of `failed` in `total` trials
+// at the confidence level, such as 0.99. It works well even if the proportion is close to 0.
+func WilsonInterval(failed, total int64, confidence float64) (float64, float64) {
+	if total <= 0 {
+		return 0, 1
+	}
+	n := float64(total)
+	p := float64(failed) / n
+	// the quantile of the standard normal distribution for the two-sided interval.
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	z2 := z * z
+	denominator := 1 + z2/n
+	center := (p + z2/(2*n)) / denominator
+	halfWidth := z * math.Sqrt(p*(1-p)/n+z2/(4*n*n)) / denominator
+	return math.Max(0, center-halfWidth), math.Min(1, center+halfWidth)
+}
@@ -15,12 +15,17 @@ package utils
 
 import (
 	"context"
+	"crypto/md5"
 	"database/sql/driver"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb-tools/pkg/dbutil"
 	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
 	"github.com/pingcap/tidb/parser"
@@ -83,8 +88,10 @@ func TestBasicTableUtilOperation(t *testing.T) {
 	tableInfo, err := dbutil.GetTableInfoBySQL(createTableSQL, parser.New())
 	require.NoError(t, err)
 
-	query, orderKeyCols := GetTableRowsQueryFormat("test", "test", tableInfo, "123")
+	query, orderKeyCols := GetTableRowsQueryFormat("test", "test", tableInfo, nil, "123")
 	require.Equal(t, query, "SELECT /*!40001 SQL_NO_CACHE */ `a`, `b`, round(`c`, 5-floor(log10(abs(`c`)))) as `c`, `d` FROM `test`.`test` WHERE %s ORDER BY `a`,`b` COLLATE \"123\"")
+	query, _ = GetTableRowsQueryFormat("test", "test", tableInfo, map[string]string{"b": "TRIM(`b`)"}, "")
+	require.Equal(t, query, "SELECT /*!40001 SQL_NO_CACHE */ `a`, `b`, round(`c`, 5-floor(log10(abs(`c`)))) as `c`, `d` FROM (SELECT `a`, TRIM(`b`) AS `b`, `c`, `d` FROM `test`.`test`) AS `test` WHERE %s ORDER BY `a`,`b`")
 	expectName := []string{"a", "b"}
 	for i, col := range orderKeyCols {
 		require.Equal(t, col.Name.O, expectName[i])
@@ -148,6 +155,8 @@ func TestBasicTableUtilOperation(t *testing.T) {
 			"*/\n"+
 			"REPLACE INTO `schema`.`test`(`a`,`b`,`c`,`d`) VALUES (1,'a',1.22,'sdf');")
 	require.Equal(t, GenerateDeleteDML(data1, tableInfo, "schema"), "DELETE FROM `schema`.`test` WHERE `a` = 1 AND `b` = 'a' AND `c` = 1.22 AND `d` = 'sdf' LIMIT 1;")
+	require.Equal(t, GenerateInsertDML(data1, tableInfo, "schema"), "INSERT INTO `schema`.`test`(`a`,`b`,`c`,`d`) VALUES (1,'a',1.22,'sdf');")
+	require.Equal(t, GenerateDeleteDMLWithLimit(data1, tableInfo, "schema", 3), "DELETE FROM `schema`.`test` WHERE `a` = 1 AND `b` = 'a' AND `c` = 1.22 AND `d` = 'sdf' LIMIT 3;")
 
 	// same
 	equal, cmp, err := CompareData(data1, data1, orderKeyCols, columns)
@@ -243,10 +252,189 @@ func TestGetCountAndCRC32Checksum(t *testing.T) {
 
 	mock.ExpectQuery("SELECT COUNT.*FROM `test_schema`\\.`test_table` WHERE \\[23 45\\].*").WithArgs("123", "234").WillReturnRows(sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(123, 456))
 
-	count, checksum, err := GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, "[23 45]", []interface{}{"123", "234"})
+	count, checksum, err := GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, nil, "", "[23 45]", []interface{}{"123", "234"})
 	require.NoError(t, err)
 	require.Equal(t, count, int64(123))
 	require.Equal(t, checksum, int64(456))
+
+	mock.ExpectQuery("SELECT COUNT.*FROM \\(SELECT CONCAT\\('p', `a`\\) AS `a`, `c`, `b`, `d` FROM `test_schema`\\.`test_table`\\) AS `test_table` WHERE \\[23 45\\].*").WithArgs("123", "234").WillReturnRows(sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(12, 34))
+	count, checksum, err = GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, map[string]string{"a": "CONCAT('p', `a`)"}, nil, "", "[23 45]", []interface{}{"123", "234"})
+	require.NoError(t, err)
+	require.Equal(t, count, int64(12))
+	require.Equal(t, checksum, int64(34))
+
+	// the column with tolerance only checks whether it's NULL
+	mock.ExpectQuery("SELECT COUNT.*CONCAT_WS\\(',', `a`, `b`, `d`, CONCAT\\(ISNULL\\(`a`\\), ISNULL\\(round\\(`c`.*FROM `test_schema`\\.`test_table` WHERE").WithArgs("123", "234").WillReturnRows(sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(56, 78))
+	count, checksum, err = GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, map[string]*Tolerance{"c": {Absolute: 0.1}}, "", "[23 45]", []interface{}{"123", "234"})
+	require.NoError(t, err)
+	require.Equal(t, count, int64(56))
+	require.Equal(t, checksum, int64(78))
+}
+
+func TestGetGroupedCountAndCRC32Checksum(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	createTableSQL := "create table `test`.`test`(`a` int, `c` float, `b` varchar(10), `d` datetime, primary key(`a`, `b`), key(`c`, `d`))"
+	tableInfo, err := dbutil.GetTableInfoBySQL(createTableSQL, parser.New())
+	require.NoError(t, err)
+	_, keyColumns := dbutil.SelectUniqueOrderKey(tableInfo)
+	groupExpr := KeyBucketExpr(keyColumns, 16)
+	require.Equal(t, "CRC32(CONCAT_WS(',', `a`, `b`)) % 16", groupExpr)
+
+	mock.ExpectQuery("SELECT CRC32\\(CONCAT_WS\\(',', `a`, `b`\\)\\) % 16 as GRP, COUNT.*FROM `test_schema`\\.`test_table` WHERE \\[23 45\\] GROUP BY GRP").WithArgs("123", "234").WillReturnRows(
+		sqlmock.NewRows([]string{"GRP", "CNT", "CHECKSUM"}).AddRow(1, 10, 100).AddRow(15, 20, 200))
+	groups, err := GetGroupedCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, nil, "", groupExpr, "[23 45]", []interface{}{"123", "234"})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]*GroupChecksum{1: {Count: 10, Checksum: 100}, 15: {Count: 20, Checksum: 200}}, groups)
+
+	mock.ExpectQuery("SELECT .* GROUP BY GRP").WillReturnError(fmt.Errorf("timeout"))
+	_, err = GetGroupedCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, nil, "", groupExpr, "[23 45]", []interface{}{"123", "234"})
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	createTableSQL := "create table `test`.`test`(`a` int, `b` varchar(10), `c` varchar(10), primary key(`a`))"
+	tableInfo, err := dbutil.GetTableInfoBySQL(createTableSQL, parser.New())
+	require.NoError(t, err)
+
+	// the columns are encoded with their lengths, the sum of the hashes is modulo 2^64
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) as CNT, CAST(SUM(CAST(CONV(LEFT(MD5(CONCAT(IFNULL(CONCAT(LENGTH(`a`), ':', `a`), '-'), IFNULL(CONCAT(LENGTH(`b`), ':', `b`), '-'), IF(ISNULL(`c`), '-', '+'))), 16), 16, 10) AS UNSIGNED)) % 18446744073709551616 AS CHAR) as CHECKSUM FROM `test_schema`.`test_table` WHERE [23 45];")).WithArgs("123", "234").WillReturnRows(
+		sqlmock.NewRows([]string{"CNT", "CHECKSUM"}).AddRow(2, "18446744073709551615"))
+	count, checksum, err := GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, map[string]*Tolerance{"c": {Absolute: 0.1}}, ChecksumHash64, "[23 45]", []interface{}{"123", "234"})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+	require.Equal(t, int64(-1), checksum)
+
+	// the in-process checksum is the same as the hash64 checksum
+	row := func(a, b, c interface{}) map[string]*dbutil.ColumnData {
+		data := make(map[string]*dbutil.ColumnData)
+		for name, value := range map[string]interface{}{"a": a, "b": b, "c": c} {
+			if value == nil {
+				data[name] = &dbutil.ColumnData{IsNull: true}
+			} else {
+				data[name] = &dbutil.ColumnData{Data: []byte(value.(string))}
+			}
+		}
+		return data
+	}
+	hash := md5.Sum([]byte("1:1" + "3:a,b" + "1:c"))
+	require.Equal(t, binary.BigEndian.Uint64(hash[:8]), RowHash64(row("1", "a,b", "c"), tableInfo, nil))
+	hash = md5.Sum([]byte("1:2" + "-" + "0:"))
+	require.Equal(t, binary.BigEndian.Uint64(hash[:8]), RowHash64(row("2", nil, ""), tableInfo, nil))
+	// the separators in the values and the NULLs don't collide
+	require.NotEqual(t, RowHash64(row("1", "a,b", "c"), tableInfo, nil), RowHash64(row("1", "a", "b,c"), tableInfo, nil))
+	require.NotEqual(t, RowHash64(row("1", nil, ""), tableInfo, nil), RowHash64(row("1", "", nil), tableInfo, nil))
+	require.Equal(t, RowHash64(row("1", "a", "b"), tableInfo, map[string]*Tolerance{"c": {}}), RowHash64(row("1", "a", "c"), tableInfo, map[string]*Tolerance{"c": {}}))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `a`, `b`, `c` FROM `test_schema`.`test_table` WHERE [23 45];")).WithArgs("123", "234").WillReturnRows(
+		sqlmock.NewRows([]string{"a", "b", "c"}).AddRow("1", "a,b", "c").AddRow("2", nil, ""))
+	count, checksum, err = GetCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, nil, ChecksumInProcess, "[23 45]", []interface{}{"123", "234"})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+	require.Equal(t, int64(RowHash64(row("1", "a,b", "c"), tableInfo, nil)+RowHash64(row("2", nil, ""), tableInfo, nil)), checksum)
+
+	_, err = GetGroupedCountAndCRC32Checksum(ctx, conn, "test_schema", "test_table", tableInfo, nil, nil, ChecksumInProcess, "`a` % 16", "[23 45]", []interface{}{"123", "234"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not supported")
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the rounded float is NULL when the value is 0, so 0 and NULL are encoded by the column itself.
+	tableInfo, err = dbutil.GetTableInfoBySQL("create table `test`.`test`(`a` int, `d` double, primary key(`a`))", parser.New())
+	require.NoError(t, err)
+	require.Equal(t, "CAST(SUM(CAST(CONV(LEFT(MD5(CONCAT(IFNULL(CONCAT(LENGTH(`a`), ':', `a`), '-'), "+
+		"IF(ISNULL(`d`), '-', IFNULL(CONCAT(LENGTH(round(`d`, 14-floor(log10(abs(`d`))))), ':', round(`d`, 14-floor(log10(abs(`d`))))), '1:0')))), 16), 16, 10) AS UNSIGNED)) % 18446744073709551616 AS CHAR)",
+		hash64ChecksumExpr(tableInfo, nil))
+	doubleRow := func(d interface{}) map[string]*dbutil.ColumnData {
+		if d == nil {
+			return map[string]*dbutil.ColumnData{"a": {Data: []byte("1")}, "d": {IsNull: true}}
+		}
+		return map[string]*dbutil.ColumnData{"a": {Data: []byte("1")}, "d": {Data: []byte(d.(string))}}
+	}
+	hash = md5.Sum([]byte("1:1" + "1:0"))
+	require.Equal(t, binary.BigEndian.Uint64(hash[:8]), RowHash64(doubleRow("0.0"), tableInfo, nil))
+	require.NotEqual(t, RowHash64(doubleRow("0.0"), tableInfo, nil), RowHash64(doubleRow(nil), tableInfo, nil))
+	require.NotEqual(t, RowHash64(doubleRow("0.0"), tableInfo, map[string]*Tolerance{"d": {}}), RowHash64(doubleRow(nil), tableInfo, map[string]*Tolerance{"d": {}}))
+
+	// the duplicate rows don't cancel each other out
+	require.Equal(t, int64(0), CombineChecksum(ChecksumCRC32, 123, 123))
+	require.Equal(t, int64(246), CombineChecksum(ChecksumHash64, 123, 123))
+	require.Equal(t, int64(math.MinInt64), CombineChecksum(ChecksumInProcess, math.MaxInt64, 1))
+}
+
+func TestTableChecksum(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	mock.ExpectQuery("SELECT TIDB_TABLE_ID FROM information_schema.tables .* UNION ALL SELECT TIDB_PARTITION_ID FROM information_schema.partitions").WithArgs("test", "t", "test", "t").WillReturnRows(
+		sqlmock.NewRows([]string{"TIDB_TABLE_ID"}).AddRow(102).AddRow(100).AddRow(101))
+	ids, err := GetTablePhysicalIDs(ctx, conn, "test", "t")
+	require.NoError(t, err)
+	require.Equal(t, []int64{100, 101, 102}, ids)
+
+	mock.ExpectQuery("SELECT TIDB_TABLE_ID FROM information_schema.tables").WithArgs("test", "t", "test", "t").WillReturnRows(sqlmock.NewRows([]string{"TIDB_TABLE_ID"}))
+	_, err = GetTablePhysicalIDs(ctx, conn, "test", "t")
+	require.True(t, errors.IsNotFound(err))
+
+	mock.ExpectQuery(regexp.QuoteMeta("ADMIN CHECKSUM TABLE `test`.`t`;")).WillReturnRows(
+		sqlmock.NewRows([]string{"Db_name", "Table_name", "Checksum_crc64_xor", "Total_kvs", "Total_bytes"}).AddRow("test", "t", "18446744073709551615", 20, 300))
+	checksum, err := GetTableChecksum(ctx, conn, "test", "t")
+	require.NoError(t, err)
+	require.Equal(t, &TableChecksum{Checksum: math.MaxUint64, TotalKvs: 20, TotalBytes: 300}, checksum)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompareDataWithTolerance(t *testing.T) {
+	createTableSQL := "create table `test`.`test`(`a` int, `b` decimal(20, 6), `c` double, primary key(`a`))"
+	tableInfo, err := dbutil.GetTableInfoBySQL(createTableSQL, parser.New())
+	require.NoError(t, err)
+	_, orderKeyCols := dbutil.SelectUniqueOrderKey(tableInfo)
+	tolerances := map[string]*Tolerance{
+		"b": {Absolute: 0.01},
+		"c": {Relative: 1e-3},
+	}
+	row := func(b, c string) map[string]*dbutil.ColumnData {
+		return map[string]*dbutil.ColumnData{
+			"a": {Data: []byte("1")},
+			"b": {Data: []byte(b), IsNull: len(b) == 0},
+			"c": {Data: []byte(c), IsNull: len(c) == 0},
+		}
+	}
+	cases := []struct {
+		b1, c1, b2, c2 string
+		equal          bool
+	}{
+		{"1.000000", "1000", "1.005000", "1000.9", true},
+		{"1.000000", "1000", "1.020000", "1000", false},
+		{"1.000000", "1000", "1.000000", "1002", false},
+		{"", "1000", "", "1000", true},
+		{"", "1000", "0.000000", "1000", false},
+	}
+	for _, cs := range cases {
+		equal, cmp, err := CompareDataWithTolerance(row(cs.b1, cs.c1), row(cs.b2, cs.c2), orderKeyCols, tableInfo.Columns, tolerances)
+		require.NoError(t, err)
+		require.Equal(t, cs.equal, equal, "%+v", cs)
+		require.Equal(t, int32(0), cmp)
+	}
+	equal, _, err := CompareData(row("1.000000", "1000"), row("1.005000", "1000"), orderKeyCols, tableInfo.Columns)
+	require.NoError(t, err)
+	require.False(t, equal)
 }
 
 func TestGetApproximateMid(t *testing.T) {
@@ -569,3 +757,27 @@ func TestCompareStruct(t *testing.T) {
 	require.Equal(t, tableInfo.Indices[0].Name.O, "c")
 
 }
+
+func TestWilsonInterval(t *testing.T) {
+	lower, upper := WilsonInterval(0, 0, 0.99)
+	require.Equal(t, 0.0, lower)
+	require.Equal(t, 1.0, upper)
+
+	// no failed chunk, the upper bound is z^2/(n+z^2)
+	lower, upper = WilsonInterval(0, 100000, 0.99)
+	require.Equal(t, 0.0, lower)
+	require.InDelta(t, 6.6345e-5, upper, 1e-8)
+
+	lower, upper = WilsonInterval(10, 100, 0.95)
+	require.InDelta(t, 0.0552, lower, 1e-4)
+	require.InDelta(t, 0.1744, upper, 1e-4)
+
+	// a higher confidence makes a wider interval
+	lower2, upper2 := WilsonInterval(10, 100, 0.99)
+	require.Less(t, lower2, lower)
+	require.Greater(t, upper2, upper)
+
+	lower, upper = WilsonInterval(100, 100, 0.95)
+	require.Less(t, lower, 1.0)
+	require.InDelta(t, 1.0, upper, 1e-9)
+}
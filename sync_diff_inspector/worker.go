@@ -0,0 +1,412 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb-tools/pkg/utils"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/chunk"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/config"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/source"
+	"github.com/pingcap/tidb-tools/sync_diff_inspector/splitter"
+	flag "github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const (
+	// workerCommand is the subcommand comparing the chunks handed by the coordinator.
+	workerCommand = "worker"
+
+	// workerRetryTimeout is the max time the worker retries to connect the coordinator.
+	workerRetryTimeout  = time.Minute
+	workerRetryInterval = 2 * time.Second
+)
+
+// worker compares the chunks leased from the coordinator and sends the results back.
+type worker struct {
+	name        string
+	coordinator string
+	configHash  string
+	df          *Diff
+	client      *http.Client
+
+	mu       sync.Mutex
+	leaseIDs map[int64]struct{}
+}
+
+// runWorker compares the chunks handed by the coordinator until all the chunks are finished.
+// The worker uses the same config as the coordinator to connect the sources.
+func runWorker(args []string) int {
+	cfg := config.NewConfig()
+	fs := cfg.FlagSet
+	coordinatorAddr := fs.String("coordinator", "", "the address of the coordinator, e.g. http://127.0.0.1:8342")
+	hostname, _ := os.Hostname()
+	name := fs.String("name", fmt.Sprintf("%s-%d", hostname, os.Getpid()), "the name of the worker")
+	logFile := fs.String("log-file", "", "the log file, print to stdout if empty")
+	err := cfg.Parse(args)
+	switch errors.Cause(err) {
+	case nil:
+	case flag.ErrHelp:
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		fs.PrintDefaults()
+		return 2
+	}
+	if len(*coordinatorAddr) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: argument --coordinator is required")
+		return 2
+	}
+
+	conf := &log.Config{Level: cfg.LogLevel}
+	conf.File.Filename = *logFile
+	lg, p, err := log.InitLogger(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: fail to init log, %s\n", err.Error())
+		return 2
+	}
+	log.ReplaceGlobals(lg, p)
+	utils.PrintInfo("sync_diff_inspector")
+
+	// the worker doesn't write the output dir, use a temporary one to init the config.
+	outputDir, err := os.MkdirTemp("", "sync_diff_worker")
+	if err != nil {
+		log.Error("fail to create the output dir", zap.Error(err))
+		return 2
+	}
+	defer os.RemoveAll(outputDir)
+	cfg.Task.OutputDir = outputDir
+	if err = cfg.Init(); err != nil {
+		log.Error("fail to initialize config", zap.Error(err))
+		return 2
+	}
+	if !cfg.CheckConfig() {
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sc:
+			log.Info("got signal to exit, the leased chunks will be reassigned", zap.Stringer("signal", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	w, err := newWorker(ctx, cfg, *coordinatorAddr, *name)
+	if err != nil {
+		log.Error("fail to initialize worker", zap.Error(err))
+		return 2
+	}
+	defer w.df.release()
+	if err = w.run(ctx, cfg.CheckThreadCount); err != nil {
+		log.Error("worker exits", zap.Error(err))
+		return 1
+	}
+	log.Info("all the chunks are finished, worker exits")
+	return 0
+}
+
+func newWorker(ctx context.Context, cfg *config.Config, coordinatorAddr, name string) (*worker, error) {
+	if !strings.Contains(coordinatorAddr, "://") {
+		coordinatorAddr = "http://" + coordinatorAddr
+	}
+	w := &worker{
+		name:        name,
+		coordinator: strings.TrimSuffix(coordinatorAddr, "/"),
+		client:      &http.Client{Timeout: leasePollTimeout + time.Minute},
+		leaseIDs:    make(map[int64]struct{}),
+	}
+	if cfg.Consistency != nil {
+		// the snapshots read from the syncpoint change over time, so use the ones read by the coordinator.
+		if err := w.setSnapshots(ctx, cfg); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	setTiDBCfg()
+	df := &Diff{
+		exportFixSQL:   cfg.ExportFixSQL,
+		diffRowsFormat: cfg.DiffRowsFormat,
+		outputDir:      cfg.Task.OutputDir,
+		status:         &checkStatus{StartTime: time.Now()},
+	}
+	// the order of the tables may be restored from the checkpoint or depend on the history of the coordinator.
+	order, err := w.getTableOrder(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	df.downstream, df.upstream, err = source.NewSources(ctx, cfg, order)
+	if err != nil {
+		df.release()
+		return nil, errors.Trace(err)
+	}
+	if w.configHash, err = leaseConfigHash(cfg, df.downstream.GetTables()); err != nil {
+		df.release()
+		return nil, errors.Trace(err)
+	}
+	df.workSource = df.pickSource(ctx)
+	if err = df.initThrottler(ctx, cfg); err != nil {
+		df.release()
+		return nil, errors.Trace(err)
+	}
+	w.df = df
+	return w, nil
+}
+
+// setSnapshots sets the snapshots of the instances to the ones read from the syncpoint by the coordinator.
+// The coordinator serves the workers after the wait, so it's retried until the wait times out.
+func (w *worker) setSnapshots(ctx context.Context, cfg *config.Config) error {
+	timeout := source.DefaultConsistencyTimeout
+	if len(cfg.Consistency.Timeout) != 0 {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Consistency.Timeout); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	snapshots := &snapshotsResponse{}
+	if err := w.fetch(ctx, "snapshots", &snapshotsRequest{Worker: w.name}, snapshots, timeout+workerRetryTimeout); err != nil {
+		return errors.Annotate(err, "fail to get the snapshots from the coordinator")
+	}
+	if len(snapshots.SourceSnapshots) != len(cfg.Task.SourceInstances) {
+		return errors.Errorf("the coordinator has %d source instances, but the worker has %d", len(snapshots.SourceSnapshots), len(cfg.Task.SourceInstances))
+	}
+	for i, source := range cfg.Task.SourceInstances {
+		source.Snapshot = snapshots.SourceSnapshots[i]
+	}
+	cfg.Task.TargetInstance.Snapshot = snapshots.TargetSnapshot
+	log.Info("use the snapshots of the coordinator", zap.Strings("source snapshots", snapshots.SourceSnapshots), zap.String("target snapshot", snapshots.TargetSnapshot))
+	return nil
+}
+
+// getTableOrder returns the order of the tables checked by the coordinator.
+func (w *worker) getTableOrder(ctx context.Context) (*source.TableOrder, error) {
+	tables := &tablesResponse{}
+	if err := w.fetch(ctx, "tables", &tablesRequest{Worker: w.name}, tables, workerRetryTimeout); err != nil {
+		return nil, errors.Annotate(err, "fail to get the order of the tables from the coordinator")
+	}
+	return &source.TableOrder{Tables: tables.Tables}, nil
+}
+
+// fetch posts the request to the api of the coordinator, it retries until the timeout,
+// because the coordinator may be initializing.
+func (w *worker) fetch(ctx context.Context, api string, req, resp interface{}, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := w.post(ctx, api, req, resp)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Trace(err)
+		}
+		log.Warn("fail to request the coordinator, retry later", zap.String("api", api), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(workerRetryInterval):
+		}
+	}
+}
+
+// run compares the chunks in `threads` goroutines.
+func (w *worker) run(ctx context.Context, threads int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.heartbeat(ctx)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, threads)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.work(ctx); err != nil {
+				errCh <- err
+				// stop the other goroutines.
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
+
+func (w *worker) work(ctx context.Context) error {
+	var failedSince time.Time
+	for {
+		lease, finished, err := w.lease(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if failedSince.IsZero() {
+				failedSince = time.Now()
+			} else if time.Since(failedSince) > workerRetryTimeout {
+				return errors.Annotate(err, "fail to connect the coordinator")
+			}
+			log.Warn("fail to lease a chunk, retry later", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(workerRetryInterval):
+			}
+			continue
+		}
+		failedSince = time.Time{}
+		if finished {
+			return nil
+		}
+		if lease == nil {
+			continue
+		}
+
+		w.track(lease.ID, true)
+		log.Info("compare chunk", zap.Int64("lease", lease.ID), zap.Any("chunk id", lease.Range.ChunkRange.Index))
+		result := w.df.compareChunk(ctx, restoreRange(lease.Range))
+		if ctx.Err() != nil {
+			// the chunk isn't compared completely, the coordinator will reassign it after the lease expires.
+			return nil
+		}
+		if result.err != nil {
+			result.ErrMsg = result.err.Error()
+		}
+		req := &resultRequest{
+			Worker:  w.name,
+			LeaseID: lease.ID,
+			Result:  result,
+		}
+		if _, err := w.post(ctx, "result", req, nil); err != nil {
+			log.Warn("fail to send the result, the chunk will be compared by other workers",
+				zap.Int64("lease", lease.ID), zap.Error(err))
+		}
+		w.track(lease.ID, false)
+	}
+}
+
+// lease gets a chunk from the coordinator, returns finished if all the chunks are finished.
+func (w *worker) lease(ctx context.Context) (lease *chunkLease, finished bool, err error) {
+	lease = &chunkLease{}
+	code, err := w.post(ctx, "lease", &leaseRequest{Worker: w.name, ConfigHash: w.configHash}, lease)
+	switch {
+	case err != nil:
+		return nil, false, errors.Trace(err)
+	case code == http.StatusGone:
+		return nil, true, nil
+	case code == http.StatusNoContent:
+		return nil, false, nil
+	}
+	return lease, false, nil
+}
+
+func (w *worker) track(leaseID int64, add bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if add {
+		w.leaseIDs[leaseID] = struct{}{}
+	} else {
+		delete(w.leaseIDs, leaseID)
+	}
+}
+
+// heartbeat extends the leases of the chunks being compared.
+func (w *worker) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		req := &heartbeatRequest{Worker: w.name}
+		w.mu.Lock()
+		for id := range w.leaseIDs {
+			req.LeaseIDs = append(req.LeaseIDs, id)
+		}
+		w.mu.Unlock()
+		if len(req.LeaseIDs) == 0 {
+			continue
+		}
+		if _, err := w.post(ctx, "heartbeat", req, nil); err != nil {
+			log.Warn("fail to send the heartbeat", zap.Error(err))
+		}
+	}
+}
+
+// post sends the request to the coordinator api, and decodes the response to resp if the status is OK.
+func (w *worker) post(ctx context.Context, api string, req, resp interface{}) (int, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.coordinator+coordinatorAPIPrefix+api, bytes.NewReader(data))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	res, err := w.client.Do(httpReq)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusOK && resp != nil:
+		if err = json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return res.StatusCode, errors.Annotatef(err, "parse the response of %s", api)
+		}
+	case res.StatusCode >= http.StatusBadRequest && res.StatusCode != http.StatusGone:
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, errors.Errorf("%s %s: %s", api, res.Status, strings.TrimSpace(string(body)))
+	}
+	return res.StatusCode, nil
+}
+
+// restoreRange rebuilds the chunk received from the coordinator, because the offsets of
+// the bounds aren't serialized but used to update the chunk in BinGenerate.
+func restoreRange(rangeInfo *splitter.RangeInfo) *splitter.RangeInfo {
+	chunkRange := chunk.NewChunkRange()
+	for _, bound := range rangeInfo.ChunkRange.Bounds {
+		chunkRange.Update(bound.Column, bound.Lower, bound.Upper, bound.HasLower, bound.HasUpper)
+	}
+	chunkRange.Type = rangeInfo.ChunkRange.Type
+	chunkRange.Index = rangeInfo.ChunkRange.Index
+	chunkRange.IsFirst = rangeInfo.ChunkRange.IsFirst
+	chunkRange.IsLast = rangeInfo.ChunkRange.IsLast
+	chunkRange.Where = rangeInfo.ChunkRange.Where
+	chunkRange.Args = rangeInfo.ChunkRange.Args
+	return &splitter.RangeInfo{
+		ChunkRange: chunkRange,
+		IndexID:    rangeInfo.IndexID,
+		ProgressID: rangeInfo.ProgressID,
+	}
+}